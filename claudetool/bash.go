@@ -84,10 +84,22 @@ func (b *BashTool) Tool() *llm.Tool {
 		Name:        bashName,
 		Description: fmt.Sprintf(strings.TrimSpace(bashDescription), b.getWorkingDir()),
 		InputSchema: llm.MustSchema(bashInputSchema),
+		SerialFor:   bashWrites,
 		Run:         b.Run,
 	}
 }
 
+// bashWrites reports whether a bash call might change anything, so that it runs
+// alone rather than alongside other tool calls. Only commands bashkit knows to be
+// read-only run concurrently.
+func bashWrites(m json.RawMessage) bool {
+	var req bashInput
+	if err := json.Unmarshal(m, &req); err != nil {
+		return true
+	}
+	return !bashkit.IsReadOnly(req.Command)
+}
+
 // getWorkingDir returns the current working directory.
 func (b *BashTool) getWorkingDir() string {
 	return b.WorkingDir.Get()
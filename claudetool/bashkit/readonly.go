@@ -0,0 +1,179 @@
+package bashkit
+
+import (
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// readOnlyCommands are commands that only read, unless given one of the listed arguments.
+var readOnlyCommands = map[string][]string{
+	"basename": nil,
+	"cat":      nil,
+	"cut":      nil,
+	"date":     nil,
+	"df":       nil,
+	"diff":     nil,
+	"dirname":  nil,
+	"du":       nil,
+	"echo":     nil,
+	"false":    nil,
+	"file":     nil,
+	"find":     {"-delete", "-exec", "-execdir", "-ok", "-okdir", "-fls", "-fprint", "-fprint0", "-fprintf"},
+	"grep":     nil,
+	"head":     nil,
+	"jq":       nil,
+	"ls":       nil,
+	"printf":   nil,
+	"pwd":      nil,
+	"readlink": nil,
+	"realpath": nil,
+	"rg":       {"--pre"},
+	"sort":     {"-o", "--output"},
+	"stat":     nil,
+	"tail":     nil,
+	"test":     nil,
+	"tr":       nil,
+	"tree":     {"-o"},
+	"true":     nil,
+	"type":     nil,
+	"wc":       nil,
+	"which":    nil,
+	"[":        nil,
+}
+
+// readOnlyGitCommands are git subcommands that only read the repository.
+var readOnlyGitCommands = []string{
+	"blame", "cat-file", "describe", "diff", "grep", "log", "ls-files",
+	"ls-tree", "rev-parse", "shortlog", "show", "status",
+}
+
+// IsReadOnly reports whether bashScript is known not to change anything: every command
+// it runs is on a list of read-only commands, and no output is redirected to a file.
+// Anything it cannot tell is assumed to write. Like Check, it is not a security barrier;
+// it decides which commands may safely run at the same time as each other.
+func IsReadOnly(bashScript string) bool {
+	file, err := syntax.NewParser().Parse(strings.NewReader(bashScript), "")
+	if err != nil {
+		return false
+	}
+
+	readOnly := true
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if !readOnly {
+			return false
+		}
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			readOnly = len(n.Args) == 0 || isReadOnlyCall(n)
+		case *syntax.Redirect:
+			readOnly = !writesFile(n)
+		case *syntax.FuncDecl, *syntax.DeclClause, *syntax.LetClause, *syntax.CoprocClause:
+			readOnly = false
+		}
+		return readOnly
+	})
+	return readOnly
+}
+
+// isReadOnlyCall reports whether cmd runs a read-only command with literal arguments.
+func isReadOnlyCall(cmd *syntax.CallExpr) bool {
+	var args []string
+	for _, word := range cmd.Args {
+		arg, ok := literal(word)
+		if !ok {
+			// Expansions such as $cmd could be anything
+			return false
+		}
+		args = append(args, arg)
+	}
+	if args[0] == "git" {
+		return isReadOnlyGit(args[1:])
+	}
+	writeArgs, ok := readOnlyCommands[args[0]]
+	if !ok {
+		return false
+	}
+	return !slices.ContainsFunc(args[1:], func(arg string) bool { return isWriteArg(arg, writeArgs) })
+}
+
+// isWriteArg reports whether arg passes one of writeArgs, in any of the forms getopt accepts:
+// a short option such as -o may be grouped with others and have its value attached (-rofile),
+// and a long option such as --output may be abbreviated and take its value after "=" (--out=file).
+func isWriteArg(arg string, writeArgs []string) bool {
+	name, _, _ := strings.Cut(arg, "=")
+	for _, w := range writeArgs {
+		switch {
+		case name == w:
+			return true
+		case strings.HasPrefix(w, "--") && len(name) > 2 && strings.HasPrefix(w, name):
+			return true
+		case len(w) == 2 && len(arg) > 2 && arg[0] == '-' && arg[1] != '-' && strings.Contains(arg[1:], w[1:]):
+			// Conservatively, any group of short options containing the letter, even in another option's value
+			return true
+		}
+	}
+	return false
+}
+
+// isReadOnlyGit reports whether git with args only reads the repository.
+func isReadOnlyGit(args []string) bool {
+	for len(args) > 0 {
+		switch {
+		case args[0] == "-C" && len(args) > 1:
+			args = args[2:]
+		case args[0] == "--no-pager":
+			args = args[1:]
+		default:
+			if slices.ContainsFunc(args, func(arg string) bool { return isWriteArg(arg, []string{"--output"}) }) {
+				return false
+			}
+			return slices.Contains(readOnlyGitCommands, args[0])
+		}
+	}
+	return false
+}
+
+// literal returns the value of word if it has no expansions, quoted or not.
+func literal(word *syntax.Word) (string, bool) {
+	var sb strings.Builder
+	for _, part := range word.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			sb.WriteString(p.Value)
+		case *syntax.SglQuoted:
+			if p.Dollar {
+				return "", false
+			}
+			sb.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, inner := range p.Parts {
+				lit, ok := inner.(*syntax.Lit)
+				if !ok {
+					return "", false
+				}
+				sb.WriteString(lit.Value)
+			}
+		default:
+			return "", false
+		}
+	}
+	return sb.String(), true
+}
+
+// writesFile reports whether r sends output to a file other than /dev/null.
+func writesFile(r *syntax.Redirect) bool {
+	target, ok := "", false
+	if r.Word != nil {
+		target, ok = literal(r.Word)
+	}
+	switch r.Op {
+	case syntax.RdrOut, syntax.AppOut, syntax.RdrAll, syntax.AppAll, syntax.ClbOut, syntax.RdrInOut:
+		return !ok || target != "/dev/null"
+	case syntax.DplOut:
+		// >&2 duplicates a file descriptor, but >&file is the same as &>file
+		return !ok || strings.Trim(target, "0123456789") != "" && target != "-"
+	}
+	return false
+}
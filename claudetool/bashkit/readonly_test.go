@@ -0,0 +1,53 @@
+package bashkit
+
+import "testing"
+
+func TestIsReadOnly(t *testing.T) {
+	tests := []struct {
+		script string
+		want   bool
+	}{
+		{"ls -la", true},
+		{`rg "func main" --type go`, true},
+		{"grep -rn 'TODO' . | head -20", true},
+		{"cat go.mod && git status", true},
+		{"git -C sub --no-pager log --oneline -5", true},
+		{"git diff HEAD~1 2>&1", true},
+		{"find . -name '*.go' 2>/dev/null | wc -l", true},
+		{"if [ -f go.mod ]; then cat go.mod; fi", true},
+		{"ls >&2", true},
+		{"sort -r -- in.txt", true},
+		{"FOO=bar ls", true},
+		{"", true},
+
+		{"rm -rf build", false},
+		{"git commit -m 'wip'", false},
+		{"git diff --output=patch.txt", false},
+		{"git", false},
+		{"go test ./...", false},
+		{"ls > files.txt", false},
+		{"ls >> files.txt", false},
+		{"ls &> files.txt", false},
+		{"ls >&files.txt", false},
+		{"echo $(touch x)", false},
+		{"cat $FILE", false},
+		{`grep "$pattern" file`, false},
+		{"find . -name '*.tmp' -delete", false},
+		{"find . -exec rm {} ;", false},
+		{"sort -o out.txt in.txt", false},
+		{"sort --output=out.txt in.txt", false},
+		{"sort -oout.txt in.txt", false},
+		{"sort -ro out.txt in.txt", false},
+		{"sort --out=out.txt in.txt", false},
+		{"git log --outp=log.txt", false},
+		{"f() { ls; }; f", false},
+		{"export FOO=bar", false},
+		{"ls; make", false},
+		{"ls &&", false},
+	}
+	for _, tt := range tests {
+		if got := IsReadOnly(tt.script); got != tt.want {
+			t.Errorf("IsReadOnly(%q) = %v, want %v", tt.script, got, tt.want)
+		}
+	}
+}
@@ -210,7 +210,8 @@ func (b *BrowseTools) NewNavigateTool() *llm.Tool {
 			},
 			"required": ["url"]
 		}`),
-		Run: b.navigateRun,
+		Serial: true, // all browser tools share one page
+		Run:    b.navigateRun,
 	}
 }
 
@@ -274,7 +275,8 @@ func (b *BrowseTools) NewResizeTool() *llm.Tool {
 			},
 			"required": ["width", "height"]
 		}`),
-		Run: b.resizeRun,
+		Serial: true, // all browser tools share one page
+		Run:    b.resizeRun,
 	}
 }
 
@@ -337,7 +339,8 @@ Your go-to tool for interacting with content: clicking buttons, typing, getting
 			},
 			"required": ["expression"]
 		}`),
-		Run: b.evalRun,
+		Serial: true, // all browser tools share one page
+		Run:    b.evalRun,
 	}
 }
 
@@ -409,7 +412,8 @@ func (b *BrowseTools) NewScreenshotTool() *llm.Tool {
 				}
 			}
 		}`),
-		Run: b.screenshotRun,
+		Serial: true, // all browser tools share one page
+		Run:    b.screenshotRun,
 	}
 }
 
@@ -667,7 +671,8 @@ func (b *BrowseTools) NewRecentConsoleLogsTool() *llm.Tool {
 				}
 			}
 		}`),
-		Run: b.recentConsoleLogsRun,
+		Serial: true, // all browser tools share one page
+		Run:    b.recentConsoleLogsRun,
 	}
 }
 
@@ -728,6 +733,7 @@ func (b *BrowseTools) NewClearConsoleLogsTool() *llm.Tool {
 		Name:        "browser_clear_console_logs",
 		Description: "Clear all captured browser console logs",
 		InputSchema: llm.EmptySchema(),
+		Serial:      true, // all browser tools share one page
 		Run:         b.clearConsoleLogsRun,
 	}
 }
@@ -52,6 +52,7 @@ func (c *ChangeDirTool) Tool() *llm.Tool {
 		Name:        changeDirName,
 		Description: changeDirDescription,
 		InputSchema: llm.MustSchema(changeDirInputSchema),
+		Serial:      true, // later calls should see the new working directory
 		Run:         c.Run,
 	}
 }
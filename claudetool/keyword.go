@@ -170,19 +170,16 @@ func (k *KeywordTool) keywordRun(ctx context.Context, m json.RawMessage) llm.Too
 	}
 
 	req := &llm.Request{
-		Messages: []llm.Message{initialMessage},
-		System:   system,
+		Messages:       []llm.Message{initialMessage},
+		System:         system,
+		ResponseFormat: &llm.ResponseFormat{Name: "relevant_files", Schema: keywordResultSchema},
 	}
 
-	resp, err := llmService.Do(ctx, req)
-	if err != nil {
-		return llm.ErrorfToolOut("failed to send relevance filtering message: %w", err)
-	}
-	if len(resp.Content) != 1 {
-		return llm.ErrorfToolOut("unexpected number of messages (%d) in relevance filtering response: %v", len(resp.Content), resp.Content)
+	var result keywordResult
+	if err := llm.DoJSON(ctx, llmService, req, &result); err != nil {
+		return llm.ErrorfToolOut("failed to filter search results by relevance: %w", err)
 	}
-
-	filtered := resp.Content[0].Text
+	filtered := result.String()
 
 	slog.InfoContext(ctx, "keyword search results processed",
 		"bytes", len(out),
@@ -192,7 +189,46 @@ func (k *KeywordTool) keywordRun(ctx context.Context, m json.RawMessage) llm.Too
 		"filtered", filtered,
 	)
 
-	return llm.ToolOut{LLMContent: llm.TextContent(resp.Content[0].Text)}
+	return llm.ToolOut{LLMContent: llm.TextContent(filtered)}
+}
+
+var keywordResultSchema = llm.MustSchema(`{
+  "type": "object",
+  "required": ["files"],
+  "properties": {
+    "files": {
+      "type": "array",
+      "description": "The relevant files in decreasing order of relevance. Empty if no files are truly relevant.",
+      "items": {
+        "type": "object",
+        "required": ["path", "reason"],
+        "properties": {
+          "path": {"type": "string", "description": "Absolute file path"},
+          "reason": {"type": "string", "description": "Concise relevance explanation"}
+        }
+      }
+    }
+  }
+}`)
+
+// keywordResult is the relevance filtering model's structured response
+type keywordResult struct {
+	Files []struct {
+		Path   string `json:"path"`
+		Reason string `json:"reason"`
+	} `json:"files"`
+}
+
+// String formats the result for the agent, one file per line
+func (r *keywordResult) String() string {
+	if len(r.Files) == 0 {
+		return "No relevant files found"
+	}
+	var b strings.Builder
+	for _, f := range r.Files {
+		fmt.Fprintf(&b, "%s: %s\n", f.Path, f.Reason)
+	}
+	return b.String()
 }
 
 func ripgrep(ctx context.Context, wd string, terms []string) (string, error) {
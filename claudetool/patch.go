@@ -63,6 +63,7 @@ func (p *PatchTool) Tool() *llm.Tool {
 		Name:        PatchName,
 		Description: strings.TrimSpace(description),
 		InputSchema: llm.MustSchema(schema),
+		Serial:      true, // edits to the same file must apply in order
 		Run:         p.Run,
 	}
 }
@@ -54,6 +54,8 @@ func TS() *go2ts.Go2TS {
 			db.MessageTypeError,
 			db.MessageTypeSystem,
 			db.MessageTypeGitInfo,
+			db.MessageTypeCompaction,
+			db.MessageTypeModelChange,
 		},
 	)
 
@@ -91,4 +93,14 @@ type streamResponseForTS struct {
 	Messages     []apiMessageForTS      `json:"messages"`
 	Conversation generated.Conversation `json:"conversation"`
 	AgentWorking bool                   `json:"agent_working"`
+	Delta        *streamDeltaForTS      `json:"delta,omitempty"`
+	HiddenFrom   *int64                 `json:"hidden_from,omitempty"`
+}
+
+type streamDeltaForTS struct {
+	Seq      int    `json:"seq"`
+	Index    int    `json:"index"`
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ToolName string `json:"tool_name,omitempty"`
 }
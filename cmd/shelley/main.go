@@ -8,11 +8,17 @@ import (
 	"log/slog"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"shelley.exe.dev/claudetool"
 	"shelley.exe.dev/db"
+	"shelley.exe.dev/llm/llmrr"
+	"shelley.exe.dev/loop"
 	"shelley.exe.dev/models"
 	"shelley.exe.dev/server"
 	"shelley.exe.dev/templates"
@@ -20,13 +26,14 @@ import (
 )
 
 type GlobalConfig struct {
-	DBPath          string
-	Debug           bool
-	Model           string
-	PredictableOnly bool
-	ConfigPath      string
-	TerminalURL     string
-	DefaultModel    string
+	DBPath            string
+	Debug             bool
+	Model             string
+	PredictableOnly   bool
+	PredictableScript string
+	ConfigPath        string
+	TerminalURL       string
+	DefaultModel      string
 }
 
 func main() {
@@ -37,6 +44,7 @@ func main() {
 	flag.BoolVar(&global.Debug, "debug", false, "Enable debug logging")
 	flag.StringVar(&global.Model, "model", defaultModelID, "LLM model to use (use 'predictable' for testing)")
 	flag.BoolVar(&global.PredictableOnly, "predictable-only", false, "Use only the predictable service, ignoring all other models")
+	flag.StringVar(&global.PredictableScript, "predictable-script", "", "YAML or JSON scenario file scripting the predictable model's responses")
 	flag.StringVar(&global.ConfigPath, "config", "", "Path to shelley.json configuration file (optional)")
 	flag.StringVar(&global.DefaultModel, "default-model", defaultModelID, "Default model for web UI")
 
@@ -47,6 +55,8 @@ func main() {
 		flag.PrintDefaults()
 		fmt.Fprintf(flag.CommandLine.Output(), "\nCommands:\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  serve [flags]                 Start the web server\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  run [flags] <prompt>          Run one agent turn and print the answer\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  token create|list|revoke      Manage API tokens for scripts\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  unpack-template <name> <dir>  Unpack a project template to a directory\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "  version                       Print version information as JSON\n")
 		fmt.Fprintf(flag.CommandLine.Output(), "\nUse '%s <command> -h' for command-specific help\n", os.Args[0])
@@ -72,6 +82,10 @@ func main() {
 	switch command {
 	case "serve":
 		runServe(global, args[1:])
+	case "run":
+		runRun(global, args[1:])
+	case "token":
+		runToken(global, args[1:])
 	case "unpack-template":
 		runUnpackTemplate(args[1:])
 	case "version":
@@ -88,6 +102,10 @@ func runServe(global GlobalConfig, args []string) {
 	port := fs.String("port", "9000", "Port to listen on")
 	systemdActivation := fs.Bool("systemd-activation", false, "Use systemd socket activation (listen on fd from systemd)")
 	requireHeader := fs.String("require-header", "", "Require this header on all API requests (e.g., X-Exedev-Userid)")
+	requireToken := fs.Bool("require-token", false, "Require an API token (see 'shelley token') on all API requests; the web UI asks for one at /login")
+	recordDir := fs.String("record", "", "Record each conversation's LLM traffic and messages to this directory")
+	replayPath := fs.String("replay", "", "Replay a conversation recorded with -record, given its .httprr file")
+	debugLLMRequests := fs.Bool("debug-llm-requests", false, "Store the full body of every LLM request and response for /debug/llm (same as llm_requests.enabled in shelley.json)")
 	fs.Parse(args)
 
 	logger := setupLogging(global.Debug)
@@ -98,23 +116,70 @@ func runServe(global GlobalConfig, args []string) {
 	// Set the database path for system prompt generation
 	server.DBPath = global.DBPath
 
-	// Build LLM configuration
-	llmConfig := buildLLMConfig(logger, global.ConfigPath, global.TerminalURL, global.DefaultModel)
+	llmConfig := loadLLMConfig(global, logger)
+	if *debugLLMRequests {
+		llmConfig.RecordLLMRequests = true
+	}
 
-	// Create request history for debugging
-	llmHistory := models.NewLLMRequestHistory(10)
+	var replayer *llmrr.Replayer
+	var recording *server.Recording
+	switch {
+	case *recordDir != "" && *replayPath != "":
+		logger.Error("-record and -replay cannot be used together")
+		os.Exit(1)
+	case *recordDir != "":
+		recorder, err := llmrr.NewRecorder(*recordDir, nil)
+		if err != nil {
+			logger.Error("Failed to set up recording", "error", err)
+			os.Exit(1)
+		}
+		llmConfig.HTTPClient = recorder.Client()
+	case *replayPath != "":
+		var err error
+		replayer, err = llmrr.OpenReplay(*replayPath)
+		if err != nil {
+			logger.Error("Failed to open replay trace", "error", err)
+			os.Exit(1)
+		}
+		recording, err = server.LoadRecording(strings.TrimSuffix(*replayPath, llmrr.TraceExt) + server.RecordingExt)
+		if err != nil {
+			logger.Error("Failed to load replay recording", "error", err)
+			os.Exit(1)
+		}
+		llmConfig.HTTPClient = replayer.Client()
+	}
 
-	// Initialize LLM service manager
-	llmManager := server.NewLLMServiceManager(llmConfig, llmHistory)
+	svr, llmManager := newServer(global, database, llmConfig, logger, *requireHeader)
+	svr.SetRequireToken(*requireToken)
+
+	// Reload API keys without restarting, which would stop running conversations:
+	// on SIGHUP, and when the files they are read from change
+	go reloadCredentialsOnSignal(llmManager, logger)
+	go llmManager.WatchCredentials(context.Background(), llmConfig.Credentials.Files(), credentialsWatchInterval)
 
 	// Log available models
 	availableModels := llmManager.GetAvailableModels()
 	logger.Info("Available models", "models", strings.Join(availableModels, ", "))
 
-	toolSetConfig := setupToolSetConfig(llmManager)
-
-	// Create server
-	svr := server.NewServer(database, llmManager, toolSetConfig, logger, global.PredictableOnly, llmConfig.TerminalURL, llmConfig.DefaultModel, *requireHeader, llmConfig.Links)
+	if err := svr.RecoverInterruptedTurns(context.Background(), llmConfig.InterruptedTurns); err != nil {
+		logger.Error("Failed to recover interrupted turns", "error", err)
+	}
+	if *recordDir != "" {
+		if err := svr.SetRecordDir(*recordDir); err != nil {
+			logger.Error("Failed to set up recording", "error", err)
+			os.Exit(1)
+		}
+	}
+	if recording != nil {
+		go func() {
+			conversationID, err := svr.Replay(context.Background(), recording)
+			if err != nil {
+				logger.Error("Replay failed", "conversationID", conversationID, "error", err)
+				return
+			}
+			logger.Info("Replay finished", "conversationID", conversationID, "unusedExchanges", replayer.Remaining())
+		}()
+	}
 
 	var err error
 	if *systemdActivation {
@@ -135,6 +200,66 @@ func runServe(global GlobalConfig, args []string) {
 	}
 }
 
+// loadLLMConfig builds the LLM configuration from the config file and the predictable script, if any.
+func loadLLMConfig(global GlobalConfig, logger *slog.Logger) *server.LLMConfig {
+	llmConfig := buildLLMConfig(logger, global.ConfigPath, global.TerminalURL, global.DefaultModel)
+	if global.PredictableScript != "" {
+		script, err := loop.LoadPredictableScript(global.PredictableScript)
+		if err != nil {
+			logger.Error("Failed to load predictable script", "error", err)
+			os.Exit(1)
+		}
+		llmConfig.PredictableScript = script
+	}
+	return llmConfig
+}
+
+// newServer creates the server and the LLM services it uses as llmConfig describes.
+func newServer(global GlobalConfig, database *db.DB, llmConfig *server.LLMConfig, logger *slog.Logger, requireHeader string) (*server.Server, *models.Manager) {
+	// Keep recent LLM requests for /debug/llm, and all of them in the database if enabled
+	var llmRequestStore models.LLMRequestStore
+	if llmConfig.RecordLLMRequests {
+		llmRequestStore = server.NewLLMRequestStore(database)
+	}
+	llmHistory := models.NewLLMRequestHistory(10, llmRequestStore)
+
+	// Initialize LLM service manager
+	llmManager := server.NewLLMServiceManager(llmConfig, llmHistory)
+
+	toolSetConfig := setupToolSetConfig(llmManager)
+
+	svr := server.NewServer(database, llmManager, toolSetConfig, logger, global.PredictableOnly, llmConfig.TerminalURL, llmConfig.DefaultModel, requireHeader, llmConfig.Links)
+	if err := svr.SetCompaction(llmConfig.Compaction); err != nil {
+		logger.Error("Failed to configure compaction", "error", err)
+		os.Exit(1)
+	}
+	svr.SetDefaultBudget(llmConfig.Budget)
+	svr.SetServerBudget(llmConfig.ServerBudget)
+	svr.SetLLMRequestRetention(llmConfig.LLMRequestRetention)
+	svr.SetAllowPrivateWebhooks(llmConfig.AllowPrivateWebhooks)
+	if err := svr.SetWebhooks(llmConfig.Webhooks); err != nil {
+		logger.Error("Invalid webhook in config", "error", err)
+		os.Exit(1)
+	}
+	return svr, llmManager
+}
+
+// credentialsWatchInterval is how often the files API keys are read from are checked for changes
+const credentialsWatchInterval = 10 * time.Second
+
+// reloadCredentialsOnSignal reloads the manager's API keys whenever the process receives SIGHUP.
+func reloadCredentialsOnSignal(manager *models.Manager, logger *slog.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := manager.ReloadCredentials(); err != nil {
+			logger.Warn("Failed to reload credentials", "error", err)
+			continue
+		}
+		logger.Info("Reloaded credentials", "models", manager.GetAvailableModels())
+	}
+}
+
 func setupLogging(debug bool) *slog.Logger {
 	logLevel := slog.LevelInfo
 	if debug {
@@ -225,6 +350,80 @@ func runUnpackTemplate(args []string) {
 	fmt.Printf("Template %q unpacked to %s\n", templateName, destDir)
 }
 
+// runToken manages the API tokens scripts use to call the server as "Authorization: Bearer <token>"
+func runToken(global GlobalConfig, args []string) {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: shelley token create <name>\n")
+		fmt.Fprintf(fs.Output(), "       shelley token list\n")
+		fmt.Fprintf(fs.Output(), "       shelley token revoke <id>\n\n")
+		fmt.Fprintf(fs.Output(), "Creates, lists and revokes API tokens. A token is only shown when it is created.\n")
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	database := setupDatabase(global.DBPath, slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	defer database.Close()
+	ctx := context.Background()
+
+	switch fs.Arg(0) {
+	case "create":
+		if fs.NArg() != 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		token, record, err := database.CreateAPIToken(ctx, fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating token: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Created token %d (%s). Store it now, it won't be shown again.\n", record.TokenID, record.Name)
+		fmt.Println(token)
+	case "list":
+		tokens, err := database.ListAPITokens(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listing tokens: %v\n", err)
+			os.Exit(1)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tTOKEN\tCREATED\tLAST USED\tREVOKED")
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%d\t%s\t%s...\t%s\t%s\t%s\n", t.TokenID, t.Name, t.Prefix,
+				t.CreatedAt.Format(time.DateTime), formatOptionalTime(t.LastUsedAt), formatOptionalTime(t.RevokedAt))
+		}
+		w.Flush()
+	case "revoke":
+		if fs.NArg() != 2 {
+			fs.Usage()
+			os.Exit(1)
+		}
+		id, err := strconv.ParseInt(fs.Arg(1), 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: token ID must be a number, got %q\n", fs.Arg(1))
+			os.Exit(1)
+		}
+		if err := database.RevokeAPIToken(ctx, id); err != nil {
+			fmt.Fprintf(os.Stderr, "Error revoking token %d: %v\n", id, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Revoked token %d\n", id)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown token command: %s\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.DateTime)
+}
+
 // runVersion prints version information as JSON
 func runVersion() {
 	info := version.GetInfo()
@@ -250,16 +449,16 @@ func setupToolSetConfig(llmProvider claudetool.LLMServiceProvider) claudetool.To
 	}
 }
 
-// buildLLMConfig constructs LLMConfig from environment variables and optional config file
+// buildLLMConfig constructs LLMConfig from the optional config file.
+// API keys are looked up by the models manager, from the environment unless "credentials" says otherwise.
 func buildLLMConfig(logger *slog.Logger, configPath, terminalURL, defaultModel string) *server.LLMConfig {
 	llmCfg := &server.LLMConfig{
-		AnthropicAPIKey: os.Getenv("ANTHROPIC_API_KEY"),
-		OpenAIAPIKey:    os.Getenv("OPENAI_API_KEY"),
-		GeminiAPIKey:    os.Getenv("GEMINI_API_KEY"),
-		FireworksAPIKey: os.Getenv("FIREWORKS_API_KEY"),
-		TerminalURL:     terminalURL,
-		DefaultModel:    defaultModel,
-		Logger:          logger,
+		Credentials:         &models.CredentialsConfig{},
+		TerminalURL:         terminalURL,
+		DefaultModel:        defaultModel,
+		InterruptedTurns:    server.InterruptedTurnsMark,
+		LLMRequestRetention: server.DefaultLLMRequestRetention,
+		Logger:              logger,
 	}
 
 	if configPath != "" {
@@ -272,10 +471,26 @@ func buildLLMConfig(logger *slog.Logger, configPath, terminalURL, defaultModel s
 		}
 
 		var cfg struct {
-			LLMGateway   string        `json:"llm_gateway"`
-			TerminalURL  string        `json:"terminal_url"`
-			DefaultModel string        `json:"default_model"`
-			Links        []server.Link `json:"links"`
+			LLMGateway   string                    `json:"llm_gateway"`
+			TerminalURL  string                    `json:"terminal_url"`
+			DefaultModel string                    `json:"default_model"`
+			Links        []server.Link             `json:"links"`
+			Models       []models.ModelConfig      `json:"models"`
+			Fallbacks    map[string][]string       `json:"fallbacks"`
+			Credentials  *models.CredentialsConfig `json:"credentials"`
+			Compaction   struct {
+				Threshold *float64 `json:"threshold"`
+				Model     string   `json:"model"`
+			} `json:"compaction"`
+			Budget           loop.Budget                  `json:"budget"`
+			ServerBudget     loop.Budget                  `json:"server_budget"`
+			InterruptedTurns server.InterruptedTurnPolicy `json:"interrupted_turns"`
+			LLMRequests      struct {
+				Enabled       bool     `json:"enabled"`
+				RetentionDays *float64 `json:"retention_days"`
+			} `json:"llm_requests"`
+			Webhooks             []server.WebhookConfig `json:"webhooks"`
+			AllowPrivateWebhooks bool                   `json:"allow_private_webhooks"`
 		}
 		if err := json.Unmarshal(data, &cfg); err != nil {
 			logger.Warn("Failed to parse config file", "path", configPath, "error", err)
@@ -286,20 +501,15 @@ func buildLLMConfig(logger *slog.Logger, configPath, terminalURL, defaultModel s
 			gateway := strings.TrimSuffix(cfg.LLMGateway, "/")
 			llmCfg.Gateway = gateway
 			logger.Info("Using LLM gateway", "gateway", gateway)
+		}
 
-			// When using a gateway, default all API keys to "implicit" unless otherwise set
-			if llmCfg.AnthropicAPIKey == "" {
-				llmCfg.AnthropicAPIKey = "implicit"
-			}
-			if llmCfg.OpenAIAPIKey == "" {
-				llmCfg.OpenAIAPIKey = "implicit"
-			}
-			if llmCfg.GeminiAPIKey == "" {
-				llmCfg.GeminiAPIKey = "implicit"
-			}
-			if llmCfg.FireworksAPIKey == "" {
-				llmCfg.FireworksAPIKey = "implicit"
+		// Where to look up API keys, e.g. {"keys": {"ANTHROPIC_API_KEY": "file:/etc/shelley/anthropic"}}
+		if cfg.Credentials != nil {
+			if err := cfg.Credentials.Validate(); err != nil {
+				logger.Error("Invalid credentials in config", "error", err)
+				os.Exit(1)
 			}
+			llmCfg.Credentials = cfg.Credentials
 		}
 
 		// Override terminal URL from config file if present and not already set via flag
@@ -319,6 +529,58 @@ func buildLLMConfig(logger *slog.Logger, configPath, terminalURL, defaultModel s
 			llmCfg.Links = cfg.Links
 			logger.Info("Loaded links from config", "count", len(cfg.Links))
 		}
+
+		// User-defined models, merged with the built-in ones by the models manager
+		if len(cfg.Models) > 0 {
+			llmCfg.Models = cfg.Models
+			logger.Info("Loaded models from config", "count", len(cfg.Models))
+		}
+
+		// Models to fall back to when a model's provider is failing, e.g.
+		// {"claude-opus-4.5": ["gpt-5", "local-qwen"]}
+		if len(cfg.Fallbacks) > 0 {
+			llmCfg.Fallbacks = cfg.Fallbacks
+			logger.Info("Loaded model fallbacks from config", "count", len(cfg.Fallbacks))
+		}
+
+		// Compaction settings; compaction is off unless a threshold is set
+		if cfg.Compaction.Threshold != nil {
+			llmCfg.Compaction.Threshold = *cfg.Compaction.Threshold
+		}
+		if cfg.Compaction.Model != "" {
+			llmCfg.Compaction.Model = cfg.Compaction.Model
+			logger.Info("Using compaction model from config", "model", cfg.Compaction.Model)
+		}
+
+		// Default budget for conversations that don't set their own, and the budget of all of them together
+		llmCfg.Budget = cfg.Budget
+		llmCfg.ServerBudget = cfg.ServerBudget
+
+		// What to do with turns left unfinished by a restart: "mark" (default) or "resume"
+		switch cfg.InterruptedTurns {
+		case "":
+		case server.InterruptedTurnsMark, server.InterruptedTurnsResume:
+			llmCfg.InterruptedTurns = cfg.InterruptedTurns
+		default:
+			logger.Warn("Unknown interrupted_turns policy, marking interrupted turns", "policy", cfg.InterruptedTurns)
+		}
+
+		// Whether raw LLM requests are recorded for debugging, and for how many days; 0 keeps them forever
+		if cfg.LLMRequests.Enabled {
+			llmCfg.RecordLLMRequests = true
+		}
+		if days := cfg.LLMRequests.RetentionDays; days != nil {
+			llmCfg.LLMRequestRetention = time.Duration(*days * float64(24*time.Hour))
+		}
+
+		// Webhooks sent conversation events, e.g.
+		// [{"url": "https://chat.example.com/hook", "secret": "...", "events": ["end_of_turn"]}]
+		if len(cfg.Webhooks) > 0 {
+			llmCfg.Webhooks = cfg.Webhooks
+			logger.Info("Loaded webhooks from config", "count", len(cfg.Webhooks))
+		}
+		// Whether webhooks added through the API may target addresses such as localhost
+		llmCfg.AllowPrivateWebhooks = cfg.AllowPrivateWebhooks
 	}
 
 	return llmCfg
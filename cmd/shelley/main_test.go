@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"shelley.exe.dev/server"
 	"shelley.exe.dev/slug"
 )
 
@@ -86,6 +92,107 @@ func TestCLICommands(t *testing.T) {
 		// If no error or different error, that's also fine for this basic test
 		t.Logf("Serve command output: %s", string(output))
 	})
+
+	t.Run("token", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "tokens.db")
+		out, err := exec.Command(binary, "-db", dbPath, "token", "create", "ci").Output()
+		if err != nil {
+			t.Fatalf("token create failed: %v", err)
+		}
+		token := strings.TrimSpace(string(out))
+		if !strings.HasPrefix(token, "shelley_") {
+			t.Errorf("token create printed %q, want a token", token)
+		}
+
+		out, err = exec.Command(binary, "-db", dbPath, "token", "list").Output()
+		if err != nil {
+			t.Fatalf("token list failed: %v", err)
+		}
+		if !strings.Contains(string(out), "ci") || strings.Contains(string(out), token) {
+			t.Errorf("token list printed %q, want the token's name but not the token", out)
+		}
+
+		if out, err := exec.Command(binary, "-db", dbPath, "token", "revoke", "1").CombinedOutput(); err != nil {
+			t.Fatalf("token revoke failed: %v: %s", err, out)
+		}
+		if err := exec.Command(binary, "-db", dbPath, "token", "revoke", "1").Run(); err == nil {
+			t.Error("revoking a revoked token succeeded")
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "run.db")
+		cmd := exec.Command(binary, "-db", dbPath, "run", "-model", "predictable", "-cwd", t.TempDir(), "echo: hello from cron")
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("run failed: %v: %s", err, stderr.String())
+		}
+		if strings.TrimSpace(string(out)) != "hello from cron" {
+			t.Errorf("run printed %q, want the answer", out)
+		}
+
+		out, err = exec.Command(binary, "-db", dbPath, "run", "-model", "predictable", "-json", "error: overloaded").Output()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			t.Errorf("run of a failing request exited with %v, want status 1", err)
+		}
+		if !strings.Contains(string(out), `"outcome": "error"`) {
+			t.Errorf("run -json printed %s, want the error outcome", out)
+		}
+
+		err = exec.Command(binary, "-db", dbPath, "run", "-model", "predictable", "-max-dollars", "0.0005", "bash: echo hi").Run()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != exitBudgetExceeded {
+			t.Errorf("run over budget exited with %v, want status %d", err, exitBudgetExceeded)
+		}
+	})
+
+	t.Run("run sends webhooks before exiting", func(t *testing.T) {
+		var mu sync.Mutex
+		var events []server.WebhookEvent
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload server.WebhookPayload
+			json.NewDecoder(r.Body).Decode(&payload)
+			mu.Lock()
+			events = append(events, payload.Event)
+			mu.Unlock()
+		}))
+		defer receiver.Close()
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "shelley.json")
+		config := fmt.Sprintf(`{"webhooks": [{"url": %q, "secret": "s"}]}`, receiver.URL)
+		if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		dbPath := filepath.Join(dir, "run.db")
+
+		// A failed turn
+		exec.Command(binary, "-db", dbPath, "-config", configPath, "run", "-model", "predictable", "error: overloaded").Run()
+
+		// A turn cancelled once its tool is running
+		cmd := exec.Command(binary, "-db", dbPath, "-config", configPath, "run", "-model", "predictable", "-cwd", dir, "bash: sleep 30")
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() && !strings.HasPrefix(scanner.Text(), "→ bash") {
+		}
+		cmd.Process.Signal(os.Interrupt)
+		go io.Copy(io.Discard, stderr)
+		if err := cmd.Wait(); err == nil {
+			t.Error("cancelled run exited with status 0")
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if want := []server.WebhookEvent{server.WebhookError, server.WebhookCancelled}; !slices.Equal(events, want) {
+			t.Errorf("webhooks got events %v, want %v", events, want)
+		}
+	})
 }
 
 func TestSystemdListenerErrors(t *testing.T) {
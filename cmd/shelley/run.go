@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/server"
+)
+
+// exitBudgetExceeded is the exit status of 'shelley run' when the conversation's budget ran out.
+const exitBudgetExceeded = 3
+
+// webhookWaitTimeout bounds how long 'shelley run' waits for webhooks before exiting.
+// Deliveries still failing by then are given up on and logged.
+const webhookWaitTimeout = 15 * time.Second
+
+// runRun runs one agent turn without the web server, for cron jobs and git hooks.
+// The conversation is stored in the database like any other, so 'shelley serve' shows it afterwards.
+func runRun(global GlobalConfig, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	model := fs.String("model", global.Model, "LLM model to use")
+	cwd := fs.String("cwd", "", "Working directory for the agent (default: the current directory)")
+	jsonOutput := fs.Bool("json", false, "Print the result as JSON instead of the final answer")
+	maxDollars := fs.Float64("max-dollars", 0, "Stop the turn once it costs this much (default: the configured budget)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [global-flags] run [flags] <prompt>\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Runs one agent turn, printing progress to stderr and the final answer to stdout.\n")
+		fmt.Fprintf(fs.Output(), "Exits with status 1 on error and %d when the budget is exceeded.\n\n", exitBudgetExceeded)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	prompt := strings.Join(fs.Args(), " ")
+	if prompt == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	dir := *cwd
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(dir); err == nil && !info.IsDir() {
+			err = fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -cwd: %v\n", err)
+		os.Exit(1)
+	}
+
+	// stdout is for the answer, so log to stderr, and only what needs attention unless debugging
+	logLevel := slog.LevelWarn
+	if global.Debug {
+		logLevel = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	slog.SetDefault(logger)
+
+	// Not deferred: the database is closed explicitly before os.Exit
+	database := setupDatabase(global.DBPath, logger)
+	server.DBPath = global.DBPath
+
+	llmConfig := loadLLMConfig(global, logger)
+	if *maxDollars > 0 {
+		llmConfig.Budget.MaxDollars = *maxDollars
+	}
+	svr, _ := newServer(global, database, llmConfig, logger, "")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	status := runTurn(ctx, svr, server.ChatRequest{Message: prompt, Model: *model, Cwd: dir}, *jsonOutput)
+	stop()
+
+	// Let webhooks announce how the turn ended, cancelled and failed turns included, before exiting,
+	// but don't wait on one that is down
+	webhookCtx, cancel := context.WithTimeout(context.Background(), webhookWaitTimeout)
+	svr.CloseWebhooks(webhookCtx)
+	cancel()
+	database.Close()
+	os.Exit(status)
+}
+
+// runTurn runs the turn of 'shelley run' and prints its result, returning the exit status.
+func runTurn(ctx context.Context, svr *server.Server, req server.ChatRequest, jsonOutput bool) int {
+	result, err := svr.Run(ctx, req, func(msg server.APIMessage) {
+		printProgress(os.Stderr, msg)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Run failed: %v\n", err)
+		return 1
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		fmt.Println(result.Answer)
+	}
+
+	switch result.Outcome {
+	case server.RunError:
+		return 1
+	case server.RunBudgetExceeded:
+		return exitBudgetExceeded
+	}
+	return 0
+}
+
+// printProgress writes a line for each text and tool call of msg.
+func printProgress(w io.Writer, msg server.APIMessage) {
+	if msg.LlmData == nil {
+		return
+	}
+	var llmMsg llm.Message
+	if err := json.Unmarshal([]byte(*msg.LlmData), &llmMsg); err != nil {
+		return
+	}
+	for _, c := range llmMsg.Content {
+		switch c.Type {
+		case llm.ContentTypeText:
+			if c.Text != "" {
+				fmt.Fprintf(w, "%s\n", c.Text)
+			}
+		case llm.ContentTypeToolUse:
+			fmt.Fprintf(w, "→ %s %s\n", c.ToolName, truncate(string(c.ToolInput), 200))
+		case llm.ContentTypeToolResult:
+			if c.ToolError {
+				var texts []string
+				for _, r := range c.ToolResult {
+					texts = append(texts, r.Text)
+				}
+				fmt.Fprintf(w, "✗ %s\n", truncate(strings.Join(texts, " "), 200))
+			}
+		}
+	}
+}
+
+// truncate shortens s to at most n bytes, on one line.
+func truncate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > n {
+		return s[:n] + "…"
+	}
+	return s
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, record, err := db.CreateAPIToken(ctx, "ci")
+	if err != nil {
+		t.Fatalf("CreateAPIToken failed: %v", err)
+	}
+	if !strings.HasPrefix(token, APITokenPrefix) || !strings.HasPrefix(token, record.Prefix) || record.Name != "ci" {
+		t.Errorf("token %q, record %+v: want a prefixed token whose record has its prefix", token, record)
+	}
+	if strings.Contains(record.TokenHash, token) {
+		t.Error("the token is stored in plain text")
+	}
+
+	authenticated, err := db.AuthenticateAPIToken(ctx, token)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIToken failed: %v", err)
+	}
+	if authenticated.TokenID != record.TokenID {
+		t.Errorf("authenticated token %d, want %d", authenticated.TokenID, record.TokenID)
+	}
+	if _, err := db.AuthenticateAPIToken(ctx, token+"x"); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("AuthenticateAPIToken(wrong token) = %v, want ErrAPITokenNotFound", err)
+	}
+
+	tokens, err := db.ListAPITokens(ctx)
+	if err != nil {
+		t.Fatalf("ListAPITokens failed: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+		t.Errorf("tokens = %+v, want the token, marked as used", tokens)
+	}
+
+	if err := db.RevokeAPIToken(ctx, record.TokenID); err != nil {
+		t.Fatalf("RevokeAPIToken failed: %v", err)
+	}
+	if _, err := db.AuthenticateAPIToken(ctx, token); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("AuthenticateAPIToken(revoked token) = %v, want ErrAPITokenNotFound", err)
+	}
+	if err := db.RevokeAPIToken(ctx, record.TokenID); !errors.Is(err, ErrAPITokenNotFound) {
+		t.Errorf("revoking twice = %v, want ErrAPITokenNotFound", err)
+	}
+	tokens, _ = db.ListAPITokens(ctx)
+	if len(tokens) != 1 || tokens[0].RevokedAt == nil {
+		t.Errorf("tokens = %+v, want the token, marked as revoked", tokens)
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
 	"time"
@@ -166,6 +167,147 @@ func TestConversationService_UpdateSlug(t *testing.T) {
 	}
 }
 
+func TestConversationService_UpdateBudget(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	created, err := db.CreateConversation(ctx, nil, true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test conversation: %v", err)
+	}
+	if created.Budget != nil {
+		t.Fatalf("Expected new conversation to have no budget, got %q", *created.Budget)
+	}
+
+	budget := `{"max_dollars":5}`
+	updated, err := db.UpdateConversationBudget(ctx, created.ConversationID, &budget)
+	if err != nil {
+		t.Fatalf("UpdateConversationBudget() error = %v", err)
+	}
+	if updated.Budget == nil || *updated.Budget != budget {
+		t.Errorf("Expected budget %s, got %v", budget, updated.Budget)
+	}
+
+	cleared, err := db.UpdateConversationBudget(ctx, created.ConversationID, nil)
+	if err != nil {
+		t.Fatalf("UpdateConversationBudget(nil) error = %v", err)
+	}
+	if cleared.Budget != nil {
+		t.Errorf("Expected budget to be cleared, got %q", *cleared.Budget)
+	}
+}
+
+func TestConversationService_Fork(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	parent, err := db.CreateConversation(ctx, stringPtr("parent"), true, stringPtr("/parent"))
+	if err != nil {
+		t.Fatalf("Failed to create test conversation: %v", err)
+	}
+	for _, typ := range []MessageType{MessageTypeSystem, MessageTypeUser, MessageTypeAgent, MessageTypeUser, MessageTypeAgent} {
+		params := CreateMessageParams{
+			ConversationID: parent.ConversationID,
+			Type:           typ,
+			LLMData:        map[string]string{"type": string(typ)},
+		}
+		if typ == MessageTypeAgent {
+			params.UsageData = map[string]int{"output_tokens": 10}
+		}
+		if _, err := db.CreateMessage(ctx, params); err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+	}
+
+	fork, err := db.ForkConversation(ctx, parent.ConversationID, 3, nil)
+	if err != nil {
+		t.Fatalf("ForkConversation() error = %v", err)
+	}
+	if fork.ParentConversationID == nil || *fork.ParentConversationID != parent.ConversationID {
+		t.Errorf("Expected parent %s, got %v", parent.ConversationID, fork.ParentConversationID)
+	}
+	if fork.Cwd == nil || *fork.Cwd != "/parent" {
+		t.Errorf("Expected fork to inherit cwd /parent, got %v", fork.Cwd)
+	}
+	if fork.Slug != nil {
+		t.Errorf("Expected fork to have no slug, got %q", *fork.Slug)
+	}
+
+	var messages []generated.Message
+	err = db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(ctx, fork.ConversationID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to list messages: %v", err)
+	}
+	wantTypes := []string{"system", "user", "agent"}
+	if len(messages) != len(wantTypes) {
+		t.Fatalf("Expected %d copied messages, got %d", len(wantTypes), len(messages))
+	}
+	for i, m := range messages {
+		if m.Type != wantTypes[i] || m.SequenceID != int64(i+1) {
+			t.Errorf("Message %d: got type %s sequence %d", i, m.Type, m.SequenceID)
+		}
+	}
+	if messages[2].UsageData == nil {
+		t.Error("Expected the copied agent message to keep its usage")
+	}
+
+	// What the copied messages cost was spent by the parent, so only the fork's own messages count
+	if _, err := db.CreateMessage(ctx, CreateMessageParams{
+		ConversationID: fork.ConversationID,
+		Type:           MessageTypeAgent,
+		LLMData:        map[string]string{"type": "agent"},
+		UsageData:      map[string]int{"output_tokens": 5},
+	}); err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	var usage []*string
+	err = db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		usage, err = q.ListMessageUsage(ctx, fork.ConversationID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ListMessageUsage() error = %v", err)
+	}
+	if len(usage) != 1 || *usage[0] != `{"output_tokens":5}` {
+		t.Errorf("ListMessageUsage() = %v, want only the fork's own message", usage)
+	}
+
+	otherCwd, err := db.ForkConversation(ctx, parent.ConversationID, 1, stringPtr("/other"))
+	if err != nil {
+		t.Fatalf("ForkConversation() with cwd error = %v", err)
+	}
+	if otherCwd.Cwd == nil || *otherCwd.Cwd != "/other" {
+		t.Errorf("Expected cwd /other, got %v", otherCwd.Cwd)
+	}
+
+	if _, err := db.ForkConversation(ctx, parent.ConversationID, 99, nil); !errors.Is(err, ErrForkPointNotFound) {
+		t.Errorf("Expected ErrForkPointNotFound, got %v", err)
+	}
+
+	// Deleting the parent keeps the fork.
+	if err := db.DeleteConversation(ctx, parent.ConversationID); err != nil {
+		t.Fatalf("DeleteConversation() error = %v", err)
+	}
+	orphan, err := db.GetConversationByID(ctx, fork.ConversationID)
+	if err != nil {
+		t.Fatalf("Fork was deleted with its parent: %v", err)
+	}
+	if orphan.ParentConversationID != nil {
+		t.Errorf("Expected parent link to be cleared, got %q", *orphan.ParentConversationID)
+	}
+}
+
 func TestConversationService_List(t *testing.T) {
 	db := setupTestDB(t)
 	defer db.Close()
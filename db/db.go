@@ -6,8 +6,10 @@ package db
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,9 +17,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"shelley.exe.dev/db/generated"
@@ -315,6 +319,34 @@ func (db *DB) UpdateConversationSlug(ctx context.Context, conversationID, slug s
 	return &conversation, err
 }
 
+// UpdateConversationBudget sets the JSON-encoded budget of a conversation.
+// A nil budget makes the conversation use the server default.
+func (db *DB) UpdateConversationBudget(ctx context.Context, conversationID string, budget *string) (*generated.Conversation, error) {
+	var conversation generated.Conversation
+	err := db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		var err error
+		conversation, err = q.UpdateConversationBudget(ctx, generated.UpdateConversationBudgetParams{
+			Budget:         budget,
+			ConversationID: conversationID,
+		})
+		return err
+	})
+	return &conversation, err
+}
+
+// UpdateConversationModel records the model a conversation currently uses
+func (db *DB) UpdateConversationModel(ctx context.Context, conversationID, model string) error {
+	return db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		_, err := q.UpdateConversationModel(ctx, generated.UpdateConversationModelParams{
+			Model:          &model,
+			ConversationID: conversationID,
+		})
+		return err
+	})
+}
+
 // UpdateConversationCwd updates the working directory for a conversation
 func (db *DB) UpdateConversationCwd(ctx context.Context, conversationID, cwd string) error {
 	return db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
@@ -327,18 +359,89 @@ func (db *DB) UpdateConversationCwd(ctx context.Context, conversationID, cwd str
 	})
 }
 
+// ErrForkPointNotFound is returned by ForkConversation when the parent has no message at the fork point.
+var ErrForkPointNotFound = errors.New("no message at fork point")
+
+// ForkConversation creates a conversation whose parent is parentID, holding copies
+// of the parent's messages up to and including atSequenceID.
+// If cwd is nil, the fork uses the parent's working directory.
+func (db *DB) ForkConversation(ctx context.Context, parentID string, atSequenceID int64, cwd *string) (*generated.Conversation, error) {
+	conversationID, err := generateConversationID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate conversation ID: %w", err)
+	}
+	var conversation generated.Conversation
+	err = db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		parent, err := q.GetConversation(ctx, parentID)
+		if err != nil {
+			return err
+		}
+		messages, err := q.ListMessages(ctx, parentID)
+		if err != nil {
+			return fmt.Errorf("failed to list messages: %w", err)
+		}
+		if !slices.ContainsFunc(messages, func(m generated.Message) bool { return m.SequenceID == atSequenceID }) {
+			return fmt.Errorf("%w: %d", ErrForkPointNotFound, atSequenceID)
+		}
+
+		if cwd == nil {
+			cwd = parent.Cwd
+		}
+		conversation, err = q.CreateForkedConversation(ctx, generated.CreateForkedConversationParams{
+			ConversationID:       conversationID,
+			UserInitiated:        parent.UserInitiated,
+			Cwd:                  cwd,
+			ParentConversationID: &parentID,
+			ForkedAtSequenceID:   &atSequenceID,
+			Model:                parent.Model,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, m := range messages {
+			if m.SequenceID > atSequenceID {
+				break
+			}
+			if _, err := q.CreateMessage(ctx, generated.CreateMessageParams{
+				MessageID:      uuid.New().String(),
+				ConversationID: conversationID,
+				SequenceID:     m.SequenceID,
+				Type:           m.Type,
+				LlmData:        m.LlmData,
+				UserData:       m.UserData,
+				UsageData:      m.UsageData,
+				DisplayData:    m.DisplayData,
+			}); err != nil {
+				return fmt.Errorf("failed to copy message: %w", err)
+			}
+		}
+		return nil
+	})
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("conversation not found: %s", parentID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
 // Message methods (moved from MessageService)
 
 // MessageType represents the type of message
 type MessageType string
 
 const (
-	MessageTypeUser    MessageType = "user"
-	MessageTypeAgent   MessageType = "agent"
-	MessageTypeTool    MessageType = "tool"
-	MessageTypeSystem  MessageType = "system"
-	MessageTypeError   MessageType = "error"
-	MessageTypeGitInfo MessageType = "gitinfo" // user-visible only, not sent to LLM
+	MessageTypeUser        MessageType = "user"
+	MessageTypeAgent       MessageType = "agent"
+	MessageTypeTool        MessageType = "tool"
+	MessageTypeSystem      MessageType = "system"
+	MessageTypeError       MessageType = "error"
+	MessageTypeGitInfo     MessageType = "gitinfo"      // user-visible only, not sent to LLM
+	MessageTypeCompaction  MessageType = "compaction"   // summary that replaces earlier messages in the LLM history
+	MessageTypeModelChange MessageType = "model_change" // user-visible only, not sent to LLM
 )
 
 // CreateMessageParams contains parameters for creating a message
@@ -419,6 +522,18 @@ func (db *DB) CreateMessage(ctx context.Context, params CreateMessageParams) (*g
 	return &message, err
 }
 
+// SoftDeleteMessagesFrom hides the message at sequenceID and every later message in a conversation.
+// Hidden messages stay in the database but are left out of message listings and counts.
+func (db *DB) SoftDeleteMessagesFrom(ctx context.Context, conversationID string, sequenceID int64) error {
+	return db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		return q.SoftDeleteMessagesFrom(ctx, generated.SoftDeleteMessagesFromParams{
+			ConversationID: conversationID,
+			SequenceID:     sequenceID,
+		})
+	})
+}
+
 // GetMessageByID retrieves a message by its ID
 func (db *DB) GetMessageByID(ctx context.Context, messageID string) (*generated.Message, error) {
 	var message generated.Message
@@ -475,7 +590,7 @@ func (db *DB) GetLatestMessage(ctx context.Context, conversationID string) (*gen
 		return err
 	})
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("no messages found in conversation: %s", conversationID)
+		return nil, fmt.Errorf("no messages found in conversation %s: %w", conversationID, err)
 	}
 	return &message, err
 }
@@ -543,6 +658,42 @@ func (db *DB) SearchArchivedConversations(ctx context.Context, query string, lim
 	return conversations, err
 }
 
+// SearchMessages searches the text of messages, tool inputs and tool outputs for all the words
+// of query, the last of which may be a prefix. It returns matches in archived conversations
+// if archived is true, and otherwise in the others, best first.
+func (db *DB) SearchMessages(ctx context.Context, query string, archived bool, limit, offset int64) ([]generated.SearchMessagesRow, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return []generated.SearchMessagesRow{}, nil
+	}
+	var results []generated.SearchMessagesRow
+	err := db.pool.Rx(ctx, func(ctx context.Context, rx *Rx) error {
+		q := generated.New(rx.Conn())
+		var err error
+		results, err = q.SearchMessages(ctx, generated.SearchMessagesParams{
+			Query:    match,
+			Archived: archived,
+			Limit:    limit,
+			Offset:   offset,
+		})
+		return err
+	})
+	return results, err
+}
+
+// ftsQuery turns what a user typed into an FTS5 query matching all its words, quoting each
+// so that FTS5 syntax such as AND, NEAR or a stray quote is searched for rather than interpreted.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
 // ArchiveConversation archives a conversation
 func (db *DB) ArchiveConversation(ctx context.Context, conversationID string) (*generated.Conversation, error) {
 	var conversation generated.Conversation
@@ -578,3 +729,275 @@ func (db *DB) DeleteConversation(ctx context.Context, conversationID string) err
 		return q.DeleteConversation(ctx, conversationID)
 	})
 }
+
+// CreateLLMRequest records an exchange with an LLM provider and returns its ID
+func (db *DB) CreateLLMRequest(ctx context.Context, params generated.CreateLLMRequestParams) (int64, error) {
+	var requestID int64
+	err := db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		var err error
+		requestID, err = q.CreateLLMRequest(ctx, params)
+		return err
+	})
+	return requestID, err
+}
+
+// SetLLMRequestMessage links a recorded LLM request to the message its response became
+func (db *DB) SetLLMRequestMessage(ctx context.Context, requestID int64, messageID string) error {
+	return db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		return q.SetLLMRequestMessage(ctx, generated.SetLLMRequestMessageParams{
+			MessageID: &messageID,
+			RequestID: requestID,
+		})
+	})
+}
+
+// GetLLMRequest retrieves a recorded LLM request by its ID
+func (db *DB) GetLLMRequest(ctx context.Context, requestID int64) (*generated.LlmRequest, error) {
+	var request generated.LlmRequest
+	err := db.pool.Rx(ctx, func(ctx context.Context, rx *Rx) error {
+		q := generated.New(rx.Conn())
+		var err error
+		request, err = q.GetLLMRequest(ctx, requestID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("LLM request not found: %d", requestID)
+	}
+	return &request, err
+}
+
+// GetLLMRequestByMessage retrieves the recorded LLM request that produced a message
+func (db *DB) GetLLMRequestByMessage(ctx context.Context, messageID string) (*generated.LlmRequest, error) {
+	var request generated.LlmRequest
+	err := db.pool.Rx(ctx, func(ctx context.Context, rx *Rx) error {
+		q := generated.New(rx.Conn())
+		var err error
+		request, err = q.GetLLMRequestByMessage(ctx, &messageID)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("LLM request not found for message: %s", messageID)
+	}
+	return &request, err
+}
+
+// ListLLMRequests lists the most recent recorded LLM requests, without their bodies, newest first.
+// If conversationID is not empty, only that conversation's requests are listed.
+func (db *DB) ListLLMRequests(ctx context.Context, conversationID string, limit int64) ([]generated.ListLLMRequestsRow, error) {
+	var requests []generated.ListLLMRequestsRow
+	err := db.pool.Rx(ctx, func(ctx context.Context, rx *Rx) error {
+		q := generated.New(rx.Conn())
+		if conversationID == "" {
+			var err error
+			requests, err = q.ListLLMRequests(ctx, limit)
+			return err
+		}
+		rows, err := q.ListConversationLLMRequests(ctx, generated.ListConversationLLMRequestsParams{
+			ConversationID: &conversationID,
+			Limit:          limit,
+		})
+		for _, row := range rows {
+			requests = append(requests, generated.ListLLMRequestsRow(row))
+		}
+		return err
+	})
+	return requests, err
+}
+
+// DeleteLLMRequestsBefore deletes the LLM requests recorded before t and returns how many were deleted
+func (db *DB) DeleteLLMRequestsBefore(ctx context.Context, t time.Time) (int64, error) {
+	var deleted int64
+	err := db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		var err error
+		// created_at holds UTC timestamps
+		deleted, err = q.DeleteLLMRequestsBefore(ctx, t.UTC())
+		return err
+	})
+	return deleted, err
+}
+
+// APITokenPrefix starts every API token, which makes leaked tokens easy to recognize
+const APITokenPrefix = "shelley_"
+
+// apiTokenTouchInterval is how stale an API token's last_used_at may get before a request updates it
+const apiTokenTouchInterval = time.Minute
+
+// ErrAPITokenNotFound is returned for API tokens that don't exist or were revoked
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+// hashAPIToken returns the hash stored for an API token. Tokens are random, so a fast hash suffices.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken creates an API token and returns it along with its record.
+// The token can't be recovered later: only its hash is stored.
+func (db *DB) CreateAPIToken(ctx context.Context, name string) (string, *generated.ApiToken, error) {
+	token := APITokenPrefix + rand.Text()
+	var record generated.ApiToken
+	err := db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		var err error
+		record, err = q.CreateAPIToken(ctx, generated.CreateAPITokenParams{
+			Name:      name,
+			TokenHash: hashAPIToken(token),
+			Prefix:    token[:len(APITokenPrefix)+6],
+		})
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &record, nil
+}
+
+// AuthenticateAPIToken returns the record of an unrevoked API token and notes that it was used.
+func (db *DB) AuthenticateAPIToken(ctx context.Context, token string) (*generated.ApiToken, error) {
+	var record generated.ApiToken
+	err := db.pool.Rx(ctx, func(ctx context.Context, rx *Rx) error {
+		q := generated.New(rx.Conn())
+		var err error
+		record, err = q.GetAPITokenByHash(ctx, hashAPIToken(token))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.LastUsedAt == nil || time.Since(*record.LastUsedAt) > apiTokenTouchInterval {
+		err = db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+			return generated.New(tx.Conn()).TouchAPIToken(ctx, record.TokenID)
+		})
+	}
+	return &record, err
+}
+
+// ListAPITokens lists all API tokens, revoked ones included, oldest first
+func (db *DB) ListAPITokens(ctx context.Context) ([]generated.ApiToken, error) {
+	var tokens []generated.ApiToken
+	err := db.pool.Rx(ctx, func(ctx context.Context, rx *Rx) error {
+		q := generated.New(rx.Conn())
+		var err error
+		tokens, err = q.ListAPITokens(ctx)
+		return err
+	})
+	return tokens, err
+}
+
+// RevokeAPIToken revokes an API token, which stops it from authenticating
+func (db *DB) RevokeAPIToken(ctx context.Context, tokenID int64) error {
+	return db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		revoked, err := q.RevokeAPIToken(ctx, tokenID)
+		if err == nil && revoked == 0 {
+			err = ErrAPITokenNotFound
+		}
+		return err
+	})
+}
+
+// ErrWebhookNotFound is returned for webhooks that don't exist
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// CreateWebhook stores a webhook added through the API
+func (db *DB) CreateWebhook(ctx context.Context, params generated.CreateWebhookParams) (*generated.Webhook, error) {
+	var webhook generated.Webhook
+	err := db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		var err error
+		webhook, err = q.CreateWebhook(ctx, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks lists the webhooks added through the API, oldest first
+func (db *DB) ListWebhooks(ctx context.Context) ([]generated.Webhook, error) {
+	var webhooks []generated.Webhook
+	err := db.pool.Rx(ctx, func(ctx context.Context, rx *Rx) error {
+		q := generated.New(rx.Conn())
+		var err error
+		webhooks, err = q.ListWebhooks(ctx)
+		return err
+	})
+	return webhooks, err
+}
+
+// DeleteWebhook deletes a webhook added through the API. Its deliveries stay in the log.
+func (db *DB) DeleteWebhook(ctx context.Context, webhookID int64) error {
+	return db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		deleted, err := q.DeleteWebhook(ctx, webhookID)
+		if err == nil && deleted == 0 {
+			err = ErrWebhookNotFound
+		}
+		return err
+	})
+}
+
+// CreateWebhookDelivery logs an event about to be sent to a webhook and returns the delivery's ID
+func (db *DB) CreateWebhookDelivery(ctx context.Context, params generated.CreateWebhookDeliveryParams) (int64, error) {
+	var deliveryID int64
+	err := db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		var err error
+		deliveryID, err = q.CreateWebhookDelivery(ctx, params)
+		return err
+	})
+	return deliveryID, err
+}
+
+// RecordWebhookDeliveryAttempt logs the outcome of an attempt to deliver an event.
+// deliveredAt is nil if the attempt failed.
+func (db *DB) RecordWebhookDeliveryAttempt(ctx context.Context, deliveryID int64, statusCode int, attemptErr error, deliveredAt *time.Time) error {
+	params := generated.RecordWebhookDeliveryAttemptParams{
+		StatusCode: int64(statusCode),
+		DeliveryID: deliveryID,
+	}
+	if attemptErr != nil {
+		msg := attemptErr.Error()
+		params.Error = &msg
+	}
+	if deliveredAt != nil {
+		// created_at holds UTC timestamps, and so should delivered_at
+		t := deliveredAt.UTC()
+		params.DeliveredAt = &t
+	}
+	return db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		return generated.New(tx.Conn()).RecordWebhookDeliveryAttempt(ctx, params)
+	})
+}
+
+// ListWebhookDeliveries lists the most recent webhook deliveries, newest first
+func (db *DB) ListWebhookDeliveries(ctx context.Context, limit int64) ([]generated.WebhookDelivery, error) {
+	var deliveries []generated.WebhookDelivery
+	err := db.pool.Rx(ctx, func(ctx context.Context, rx *Rx) error {
+		q := generated.New(rx.Conn())
+		var err error
+		deliveries, err = q.ListWebhookDeliveries(ctx, limit)
+		return err
+	})
+	return deliveries, err
+}
+
+// DeleteWebhookDeliveriesBefore deletes the webhook deliveries logged before t and returns how many were deleted
+func (db *DB) DeleteWebhookDeliveriesBefore(ctx context.Context, t time.Time) (int64, error) {
+	var deleted int64
+	err := db.pool.Tx(ctx, func(ctx context.Context, tx *Tx) error {
+		q := generated.New(tx.Conn())
+		var err error
+		// created_at holds UTC timestamps
+		deleted, err = q.DeleteWebhookDeliveriesBefore(ctx, t.UTC())
+		return err
+	})
+	return deleted, err
+}
@@ -13,7 +13,7 @@ const archiveConversation = `-- name: ArchiveConversation :one
 UPDATE conversations
 SET archived = TRUE, updated_at = CURRENT_TIMESTAMP
 WHERE conversation_id = ?
-RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived
+RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid
 `
 
 func (q *Queries) ArchiveConversation(ctx context.Context, conversationID string) (Conversation, error) {
@@ -27,6 +27,11 @@ func (q *Queries) ArchiveConversation(ctx context.Context, conversationID string
 		&i.UpdatedAt,
 		&i.Cwd,
 		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
 	)
 	return i, err
 }
@@ -56,7 +61,7 @@ func (q *Queries) CountConversations(ctx context.Context) (int64, error) {
 const createConversation = `-- name: CreateConversation :one
 INSERT INTO conversations (conversation_id, slug, user_initiated, cwd)
 VALUES (?, ?, ?, ?)
-RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived
+RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid
 `
 
 type CreateConversationParams struct {
@@ -82,6 +87,53 @@ func (q *Queries) CreateConversation(ctx context.Context, arg CreateConversation
 		&i.UpdatedAt,
 		&i.Cwd,
 		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
+	)
+	return i, err
+}
+
+const createForkedConversation = `-- name: CreateForkedConversation :one
+INSERT INTO conversations (conversation_id, user_initiated, cwd, parent_conversation_id, forked_at_sequence_id, model)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid
+`
+
+type CreateForkedConversationParams struct {
+	ConversationID       string  `json:"conversation_id"`
+	UserInitiated        bool    `json:"user_initiated"`
+	Cwd                  *string `json:"cwd"`
+	ParentConversationID *string `json:"parent_conversation_id"`
+	ForkedAtSequenceID   *int64  `json:"forked_at_sequence_id"`
+	Model                *string `json:"model"`
+}
+
+func (q *Queries) CreateForkedConversation(ctx context.Context, arg CreateForkedConversationParams) (Conversation, error) {
+	row := q.db.QueryRowContext(ctx, createForkedConversation,
+		arg.ConversationID,
+		arg.UserInitiated,
+		arg.Cwd,
+		arg.ParentConversationID,
+		arg.ForkedAtSequenceID,
+		arg.Model,
+	)
+	var i Conversation
+	err := row.Scan(
+		&i.ConversationID,
+		&i.Slug,
+		&i.UserInitiated,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Cwd,
+		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
 	)
 	return i, err
 }
@@ -97,7 +149,7 @@ func (q *Queries) DeleteConversation(ctx context.Context, conversationID string)
 }
 
 const getConversation = `-- name: GetConversation :one
-SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived FROM conversations
+SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid FROM conversations
 WHERE conversation_id = ?
 `
 
@@ -112,12 +164,17 @@ func (q *Queries) GetConversation(ctx context.Context, conversationID string) (C
 		&i.UpdatedAt,
 		&i.Cwd,
 		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
 	)
 	return i, err
 }
 
 const getConversationBySlug = `-- name: GetConversationBySlug :one
-SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived FROM conversations
+SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid FROM conversations
 WHERE slug = ?
 `
 
@@ -132,12 +189,17 @@ func (q *Queries) GetConversationBySlug(ctx context.Context, slug *string) (Conv
 		&i.UpdatedAt,
 		&i.Cwd,
 		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
 	)
 	return i, err
 }
 
 const listArchivedConversations = `-- name: ListArchivedConversations :many
-SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived FROM conversations
+SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid FROM conversations
 WHERE archived = TRUE
 ORDER BY updated_at DESC
 LIMIT ? OFFSET ?
@@ -165,6 +227,11 @@ func (q *Queries) ListArchivedConversations(ctx context.Context, arg ListArchive
 			&i.UpdatedAt,
 			&i.Cwd,
 			&i.Archived,
+			&i.Budget,
+			&i.ParentConversationID,
+			&i.ForkedAtSequenceID,
+			&i.Model,
+			&i.TurnOwnerPid,
 		); err != nil {
 			return nil, err
 		}
@@ -180,7 +247,7 @@ func (q *Queries) ListArchivedConversations(ctx context.Context, arg ListArchive
 }
 
 const listConversations = `-- name: ListConversations :many
-SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived FROM conversations
+SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid FROM conversations
 WHERE archived = FALSE
 ORDER BY updated_at DESC
 LIMIT ? OFFSET ?
@@ -208,6 +275,11 @@ func (q *Queries) ListConversations(ctx context.Context, arg ListConversationsPa
 			&i.UpdatedAt,
 			&i.Cwd,
 			&i.Archived,
+			&i.Budget,
+			&i.ParentConversationID,
+			&i.ForkedAtSequenceID,
+			&i.Model,
+			&i.TurnOwnerPid,
 		); err != nil {
 			return nil, err
 		}
@@ -223,7 +295,7 @@ func (q *Queries) ListConversations(ctx context.Context, arg ListConversationsPa
 }
 
 const searchArchivedConversations = `-- name: SearchArchivedConversations :many
-SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived FROM conversations
+SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid FROM conversations
 WHERE slug LIKE '%' || ? || '%' AND archived = TRUE
 ORDER BY updated_at DESC
 LIMIT ? OFFSET ?
@@ -252,6 +324,11 @@ func (q *Queries) SearchArchivedConversations(ctx context.Context, arg SearchArc
 			&i.UpdatedAt,
 			&i.Cwd,
 			&i.Archived,
+			&i.Budget,
+			&i.ParentConversationID,
+			&i.ForkedAtSequenceID,
+			&i.Model,
+			&i.TurnOwnerPid,
 		); err != nil {
 			return nil, err
 		}
@@ -267,7 +344,7 @@ func (q *Queries) SearchArchivedConversations(ctx context.Context, arg SearchArc
 }
 
 const searchConversations = `-- name: SearchConversations :many
-SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived FROM conversations
+SELECT conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid FROM conversations
 WHERE slug LIKE '%' || ? || '%' AND archived = FALSE
 ORDER BY updated_at DESC
 LIMIT ? OFFSET ?
@@ -296,6 +373,11 @@ func (q *Queries) SearchConversations(ctx context.Context, arg SearchConversatio
 			&i.UpdatedAt,
 			&i.Cwd,
 			&i.Archived,
+			&i.Budget,
+			&i.ParentConversationID,
+			&i.ForkedAtSequenceID,
+			&i.Model,
+			&i.TurnOwnerPid,
 		); err != nil {
 			return nil, err
 		}
@@ -310,11 +392,27 @@ func (q *Queries) SearchConversations(ctx context.Context, arg SearchConversatio
 	return items, nil
 }
 
+const setConversationTurnOwner = `-- name: SetConversationTurnOwner :exec
+UPDATE conversations
+SET turn_owner_pid = ?
+WHERE conversation_id = ?
+`
+
+type SetConversationTurnOwnerParams struct {
+	TurnOwnerPid   *int64 `json:"turn_owner_pid"`
+	ConversationID string `json:"conversation_id"`
+}
+
+func (q *Queries) SetConversationTurnOwner(ctx context.Context, arg SetConversationTurnOwnerParams) error {
+	_, err := q.db.ExecContext(ctx, setConversationTurnOwner, arg.TurnOwnerPid, arg.ConversationID)
+	return err
+}
+
 const unarchiveConversation = `-- name: UnarchiveConversation :one
 UPDATE conversations
 SET archived = FALSE, updated_at = CURRENT_TIMESTAMP
 WHERE conversation_id = ?
-RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived
+RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid
 `
 
 func (q *Queries) UnarchiveConversation(ctx context.Context, conversationID string) (Conversation, error) {
@@ -328,6 +426,43 @@ func (q *Queries) UnarchiveConversation(ctx context.Context, conversationID stri
 		&i.UpdatedAt,
 		&i.Cwd,
 		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
+	)
+	return i, err
+}
+
+const updateConversationBudget = `-- name: UpdateConversationBudget :one
+UPDATE conversations
+SET budget = ?
+WHERE conversation_id = ?
+RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid
+`
+
+type UpdateConversationBudgetParams struct {
+	Budget         *string `json:"budget"`
+	ConversationID string  `json:"conversation_id"`
+}
+
+func (q *Queries) UpdateConversationBudget(ctx context.Context, arg UpdateConversationBudgetParams) (Conversation, error) {
+	row := q.db.QueryRowContext(ctx, updateConversationBudget, arg.Budget, arg.ConversationID)
+	var i Conversation
+	err := row.Scan(
+		&i.ConversationID,
+		&i.Slug,
+		&i.UserInitiated,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Cwd,
+		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
 	)
 	return i, err
 }
@@ -336,7 +471,7 @@ const updateConversationCwd = `-- name: UpdateConversationCwd :one
 UPDATE conversations
 SET cwd = ?, updated_at = CURRENT_TIMESTAMP
 WHERE conversation_id = ?
-RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived
+RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid
 `
 
 type UpdateConversationCwdParams struct {
@@ -355,6 +490,43 @@ func (q *Queries) UpdateConversationCwd(ctx context.Context, arg UpdateConversat
 		&i.UpdatedAt,
 		&i.Cwd,
 		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
+	)
+	return i, err
+}
+
+const updateConversationModel = `-- name: UpdateConversationModel :one
+UPDATE conversations
+SET model = ?
+WHERE conversation_id = ?
+RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid
+`
+
+type UpdateConversationModelParams struct {
+	Model          *string `json:"model"`
+	ConversationID string  `json:"conversation_id"`
+}
+
+func (q *Queries) UpdateConversationModel(ctx context.Context, arg UpdateConversationModelParams) (Conversation, error) {
+	row := q.db.QueryRowContext(ctx, updateConversationModel, arg.Model, arg.ConversationID)
+	var i Conversation
+	err := row.Scan(
+		&i.ConversationID,
+		&i.Slug,
+		&i.UserInitiated,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Cwd,
+		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
 	)
 	return i, err
 }
@@ -363,7 +535,7 @@ const updateConversationSlug = `-- name: UpdateConversationSlug :one
 UPDATE conversations
 SET slug = ?, updated_at = CURRENT_TIMESTAMP
 WHERE conversation_id = ?
-RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived
+RETURNING conversation_id, slug, user_initiated, created_at, updated_at, cwd, archived, budget, parent_conversation_id, forked_at_sequence_id, model, turn_owner_pid
 `
 
 type UpdateConversationSlugParams struct {
@@ -382,6 +554,11 @@ func (q *Queries) UpdateConversationSlug(ctx context.Context, arg UpdateConversa
 		&i.UpdatedAt,
 		&i.Cwd,
 		&i.Archived,
+		&i.Budget,
+		&i.ParentConversationID,
+		&i.ForkedAtSequenceID,
+		&i.Model,
+		&i.TurnOwnerPid,
 	)
 	return i, err
 }
@@ -7,11 +7,12 @@ package generated
 
 import (
 	"context"
+	"time"
 )
 
 const countMessagesByType = `-- name: CountMessagesByType :one
 SELECT COUNT(*) FROM messages
-WHERE conversation_id = ? AND type = ?
+WHERE conversation_id = ? AND type = ? AND deleted_at IS NULL
 `
 
 type CountMessagesByTypeParams struct {
@@ -28,7 +29,7 @@ func (q *Queries) CountMessagesByType(ctx context.Context, arg CountMessagesByTy
 
 const countMessagesInConversation = `-- name: CountMessagesInConversation :one
 SELECT COUNT(*) FROM messages
-WHERE conversation_id = ?
+WHERE conversation_id = ? AND deleted_at IS NULL
 `
 
 func (q *Queries) CountMessagesInConversation(ctx context.Context, conversationID string) (int64, error) {
@@ -41,7 +42,7 @@ func (q *Queries) CountMessagesInConversation(ctx context.Context, conversationI
 const createMessage = `-- name: CreateMessage :one
 INSERT INTO messages (message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, display_data)
 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-RETURNING message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data
+RETURNING message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data, deleted_at
 `
 
 type CreateMessageParams struct {
@@ -77,6 +78,7 @@ func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (M
 		&i.UsageData,
 		&i.CreatedAt,
 		&i.DisplayData,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -102,8 +104,8 @@ func (q *Queries) DeleteMessage(ctx context.Context, messageID string) error {
 }
 
 const getLatestMessage = `-- name: GetLatestMessage :one
-SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data FROM messages
-WHERE conversation_id = ?
+SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data, deleted_at FROM messages
+WHERE conversation_id = ? AND deleted_at IS NULL
 ORDER BY sequence_id DESC
 LIMIT 1
 `
@@ -121,12 +123,13 @@ func (q *Queries) GetLatestMessage(ctx context.Context, conversationID string) (
 		&i.UsageData,
 		&i.CreatedAt,
 		&i.DisplayData,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
 const getMessage = `-- name: GetMessage :one
-SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data FROM messages
+SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data, deleted_at FROM messages
 WHERE message_id = ?
 `
 
@@ -143,6 +146,7 @@ func (q *Queries) GetMessage(ctx context.Context, messageID string) (Message, er
 		&i.UsageData,
 		&i.CreatedAt,
 		&i.DisplayData,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -160,9 +164,91 @@ func (q *Queries) GetNextSequenceID(ctx context.Context, conversationID string)
 	return column_1, err
 }
 
+const listLatestMessagesOfOpenConversations = `-- name: ListLatestMessagesOfOpenConversations :many
+SELECT m.message_id, m.conversation_id, m.sequence_id, m.type, m.llm_data, m.user_data, m.usage_data, m.created_at, m.display_data, m.deleted_at FROM messages m
+WHERE m.deleted_at IS NULL
+  AND m.sequence_id = (
+    SELECT MAX(sequence_id) FROM messages
+    WHERE conversation_id = m.conversation_id AND deleted_at IS NULL
+  )
+  AND m.conversation_id IN (
+    SELECT conversation_id FROM conversations
+    WHERE archived = FALSE AND user_initiated = TRUE
+  )
+ORDER BY m.created_at ASC
+`
+
+// Returns the latest visible message of every unarchived, user-initiated conversation.
+func (q *Queries) ListLatestMessagesOfOpenConversations(ctx context.Context) ([]Message, error) {
+	rows, err := q.db.QueryContext(ctx, listLatestMessagesOfOpenConversations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Message{}
+	for rows.Next() {
+		var i Message
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.ConversationID,
+			&i.SequenceID,
+			&i.Type,
+			&i.LlmData,
+			&i.UserData,
+			&i.UsageData,
+			&i.CreatedAt,
+			&i.DisplayData,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessageUsage = `-- name: ListMessageUsage :many
+SELECT m.usage_data FROM messages m
+JOIN conversations c ON c.conversation_id = m.conversation_id
+WHERE m.conversation_id = ? AND m.usage_data IS NOT NULL
+    AND (c.forked_at_sequence_id IS NULL OR m.sequence_id > c.forked_at_sequence_id)
+ORDER BY m.sequence_id ASC
+`
+
+// Includes hidden messages: what they cost was spent all the same. Leaves out the messages
+// a fork copied from its parent, whose cost the parent spent.
+func (q *Queries) ListMessageUsage(ctx context.Context, conversationID string) ([]*string, error) {
+	rows, err := q.db.QueryContext(ctx, listMessageUsage, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*string{}
+	for rows.Next() {
+		var usage_data *string
+		if err := rows.Scan(&usage_data); err != nil {
+			return nil, err
+		}
+		items = append(items, usage_data)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listMessages = `-- name: ListMessages :many
-SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data FROM messages
-WHERE conversation_id = ?
+SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data, deleted_at FROM messages
+WHERE conversation_id = ? AND deleted_at IS NULL
 ORDER BY sequence_id ASC
 `
 
@@ -185,6 +271,7 @@ func (q *Queries) ListMessages(ctx context.Context, conversationID string) ([]Me
 			&i.UsageData,
 			&i.CreatedAt,
 			&i.DisplayData,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -200,8 +287,8 @@ func (q *Queries) ListMessages(ctx context.Context, conversationID string) ([]Me
 }
 
 const listMessagesByType = `-- name: ListMessagesByType :many
-SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data FROM messages
-WHERE conversation_id = ? AND type = ?
+SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data, deleted_at FROM messages
+WHERE conversation_id = ? AND type = ? AND deleted_at IS NULL
 ORDER BY sequence_id ASC
 `
 
@@ -229,6 +316,7 @@ func (q *Queries) ListMessagesByType(ctx context.Context, arg ListMessagesByType
 			&i.UsageData,
 			&i.CreatedAt,
 			&i.DisplayData,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -244,8 +332,8 @@ func (q *Queries) ListMessagesByType(ctx context.Context, arg ListMessagesByType
 }
 
 const listMessagesPaginated = `-- name: ListMessagesPaginated :many
-SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data FROM messages
-WHERE conversation_id = ?
+SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data, deleted_at FROM messages
+WHERE conversation_id = ? AND deleted_at IS NULL
 ORDER BY sequence_id ASC
 LIMIT ? OFFSET ?
 `
@@ -275,6 +363,7 @@ func (q *Queries) ListMessagesPaginated(ctx context.Context, arg ListMessagesPag
 			&i.UsageData,
 			&i.CreatedAt,
 			&i.DisplayData,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -290,8 +379,8 @@ func (q *Queries) ListMessagesPaginated(ctx context.Context, arg ListMessagesPag
 }
 
 const listMessagesSince = `-- name: ListMessagesSince :many
-SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data FROM messages
-WHERE conversation_id = ? AND sequence_id > ?
+SELECT message_id, conversation_id, sequence_id, type, llm_data, user_data, usage_data, created_at, display_data, deleted_at FROM messages
+WHERE conversation_id = ? AND sequence_id > ? AND deleted_at IS NULL
 ORDER BY sequence_id ASC
 `
 
@@ -319,6 +408,74 @@ func (q *Queries) ListMessagesSince(ctx context.Context, arg ListMessagesSincePa
 			&i.UsageData,
 			&i.CreatedAt,
 			&i.DisplayData,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchMessages = `-- name: SearchMessages :many
+SELECT m.conversation_id, c.slug, m.message_id, m.sequence_id, m.type, m.created_at,
+    CAST(snippet(messages_fts, 0, '**', '**', '...', 16) AS TEXT) AS snippet
+FROM messages_fts
+JOIN messages_fts_ids i ON i.fts_rowid = messages_fts.rowid
+JOIN messages m ON m.message_id = i.message_id
+JOIN conversations c ON c.conversation_id = m.conversation_id
+WHERE messages_fts.body MATCH ?1 AND m.deleted_at IS NULL AND c.archived = ?2
+ORDER BY messages_fts.rank
+LIMIT ?4 OFFSET ?3
+`
+
+type SearchMessagesParams struct {
+	Query    string `json:"query"`
+	Archived bool   `json:"archived"`
+	Offset   int64  `json:"offset"`
+	Limit    int64  `json:"limit"`
+}
+
+type SearchMessagesRow struct {
+	ConversationID string    `json:"conversation_id"`
+	Slug           *string   `json:"slug"`
+	MessageID      string    `json:"message_id"`
+	SequenceID     int64     `json:"sequence_id"`
+	Type           string    `json:"type"`
+	CreatedAt      time.Time `json:"created_at"`
+	Snippet        string    `json:"snippet"`
+}
+
+// Returns the visible messages matching an FTS5 query, best matches first, with a snippet
+// of the matching text in which the matches are marked with ** as in markdown.
+func (q *Queries) SearchMessages(ctx context.Context, arg SearchMessagesParams) ([]SearchMessagesRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchMessages,
+		arg.Query,
+		arg.Archived,
+		arg.Offset,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []SearchMessagesRow{}
+	for rows.Next() {
+		var i SearchMessagesRow
+		if err := rows.Scan(
+			&i.ConversationID,
+			&i.Slug,
+			&i.MessageID,
+			&i.SequenceID,
+			&i.Type,
+			&i.CreatedAt,
+			&i.Snippet,
 		); err != nil {
 			return nil, err
 		}
@@ -332,3 +489,19 @@ func (q *Queries) ListMessagesSince(ctx context.Context, arg ListMessagesSincePa
 	}
 	return items, nil
 }
+
+const softDeleteMessagesFrom = `-- name: SoftDeleteMessagesFrom :exec
+UPDATE messages
+SET deleted_at = CURRENT_TIMESTAMP
+WHERE conversation_id = ? AND sequence_id >= ? AND deleted_at IS NULL
+`
+
+type SoftDeleteMessagesFromParams struct {
+	ConversationID string `json:"conversation_id"`
+	SequenceID     int64  `json:"sequence_id"`
+}
+
+func (q *Queries) SoftDeleteMessagesFrom(ctx context.Context, arg SoftDeleteMessagesFromParams) error {
+	_, err := q.db.ExecContext(ctx, softDeleteMessagesFrom, arg.ConversationID, arg.SequenceID)
+	return err
+}
@@ -8,26 +8,71 @@ import (
 	"time"
 )
 
+type ApiToken struct {
+	TokenID    int64      `json:"token_id"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"token_hash"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
 type Conversation struct {
-	ConversationID string    `json:"conversation_id"`
-	Slug           *string   `json:"slug"`
-	UserInitiated  bool      `json:"user_initiated"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	Cwd            *string   `json:"cwd"`
-	Archived       bool      `json:"archived"`
+	ConversationID       string    `json:"conversation_id"`
+	Slug                 *string   `json:"slug"`
+	UserInitiated        bool      `json:"user_initiated"`
+	CreatedAt            time.Time `json:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at"`
+	Cwd                  *string   `json:"cwd"`
+	Archived             bool      `json:"archived"`
+	Budget               *string   `json:"budget"`
+	ParentConversationID *string   `json:"parent_conversation_id"`
+	ForkedAtSequenceID   *int64    `json:"forked_at_sequence_id"`
+	Model                *string   `json:"model"`
+	TurnOwnerPid         *int64    `json:"turn_owner_pid"`
+}
+
+type LlmRequest struct {
+	RequestID       int64     `json:"request_id"`
+	ConversationID  *string   `json:"conversation_id"`
+	MessageID       *string   `json:"message_id"`
+	ModelID         string    `json:"model_id"`
+	Url             string    `json:"url"`
+	RequestBody     []byte    `json:"request_body"`
+	ResponseBody    []byte    `json:"response_body"`
+	StatusCode      int64     `json:"status_code"`
+	Error           *string   `json:"error"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Attempt         int64     `json:"attempt"`
+	CreatedAt       time.Time `json:"created_at"`
 }
 
 type Message struct {
-	MessageID      string    `json:"message_id"`
-	ConversationID string    `json:"conversation_id"`
-	SequenceID     int64     `json:"sequence_id"`
-	Type           string    `json:"type"`
-	LlmData        *string   `json:"llm_data"`
-	UserData       *string   `json:"user_data"`
-	UsageData      *string   `json:"usage_data"`
-	CreatedAt      time.Time `json:"created_at"`
-	DisplayData    *string   `json:"display_data"`
+	MessageID      string     `json:"message_id"`
+	ConversationID string     `json:"conversation_id"`
+	SequenceID     int64      `json:"sequence_id"`
+	Type           string     `json:"type"`
+	LlmData        *string    `json:"llm_data"`
+	UserData       *string    `json:"user_data"`
+	UsageData      *string    `json:"usage_data"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DisplayData    *string    `json:"display_data"`
+	DeletedAt      *time.Time `json:"deleted_at"`
+}
+
+type MessageSearchText struct {
+	MessageID string `json:"message_id"`
+	Body      string `json:"body"`
+}
+
+type MessagesFt struct {
+	Body string `json:"body"`
+}
+
+type MessagesFtsID struct {
+	FtsRowid  int64  `json:"fts_rowid"`
+	MessageID string `json:"message_id"`
 }
 
 type Migration struct {
@@ -35,3 +80,25 @@ type Migration struct {
 	MigrationName   string     `json:"migration_name"`
 	ExecutedAt      *time.Time `json:"executed_at"`
 }
+
+type Webhook struct {
+	WebhookID int64     `json:"webhook_id"`
+	Url       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    string    `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	DeliveryID     int64      `json:"delivery_id"`
+	WebhookID      *int64     `json:"webhook_id"`
+	Url            string     `json:"url"`
+	Event          string     `json:"event"`
+	ConversationID string     `json:"conversation_id"`
+	Payload        string     `json:"payload"`
+	Attempts       int64      `json:"attempts"`
+	StatusCode     int64      `json:"status_code"`
+	Error          *string    `json:"error"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
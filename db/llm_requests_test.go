@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"shelley.exe.dev/db/generated"
+)
+
+func TestLLMRequests(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conv, err := db.CreateConversation(ctx, stringPtr("llm-requests"), true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test conversation: %v", err)
+	}
+	msg, err := db.CreateMessage(ctx, CreateMessageParams{
+		ConversationID: conv.ConversationID,
+		Type:           MessageTypeAgent,
+		LLMData:        map[string]string{"text": "hi"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+
+	requestID, err := db.CreateLLMRequest(ctx, generated.CreateLLMRequestParams{
+		ConversationID:  &conv.ConversationID,
+		ModelID:         "claude-haiku-4.5",
+		Url:             "https://api.anthropic.com/v1/messages",
+		RequestBody:     []byte(`{"messages":[]}`),
+		ResponseBody:    []byte(`{"content":[]}`),
+		StatusCode:      200,
+		DurationSeconds: 1.5,
+	})
+	if err != nil {
+		t.Fatalf("CreateLLMRequest() error = %v", err)
+	}
+	errText := "connection reset"
+	if _, err := db.CreateLLMRequest(ctx, generated.CreateLLMRequestParams{
+		ModelID: "gpt-5",
+		Url:     "https://api.openai.com/v1/chat/completions",
+		Error:   &errText,
+	}); err != nil {
+		t.Fatalf("CreateLLMRequest() without a conversation error = %v", err)
+	}
+
+	if err := db.SetLLMRequestMessage(ctx, requestID, msg.MessageID); err != nil {
+		t.Fatalf("SetLLMRequestMessage() error = %v", err)
+	}
+	request, err := db.GetLLMRequestByMessage(ctx, msg.MessageID)
+	if err != nil {
+		t.Fatalf("GetLLMRequestByMessage() error = %v", err)
+	}
+	if request.RequestID != requestID || string(request.RequestBody) != `{"messages":[]}` || string(request.ResponseBody) != `{"content":[]}` {
+		t.Errorf("GetLLMRequestByMessage() = %+v", request)
+	}
+	if _, err := db.GetLLMRequest(ctx, requestID+100); err == nil {
+		t.Error("Expected error when getting a missing LLM request")
+	}
+
+	all, err := db.ListLLMRequests(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("ListLLMRequests() error = %v", err)
+	}
+	if len(all) != 2 || all[0].RequestID == requestID {
+		t.Errorf("ListLLMRequests() = %+v, want both requests, newest first", all)
+	}
+	inConversation, err := db.ListLLMRequests(ctx, conv.ConversationID, 10)
+	if err != nil {
+		t.Fatalf("ListLLMRequests() for a conversation error = %v", err)
+	}
+	if len(inConversation) != 1 || inConversation[0].RequestID != requestID {
+		t.Errorf("ListLLMRequests() for a conversation = %+v", inConversation)
+	}
+
+	deleted, err := db.DeleteLLMRequestsBefore(ctx, time.Now().Add(-time.Hour))
+	if err != nil || deleted != 0 {
+		t.Errorf("DeleteLLMRequestsBefore() an hour ago = %d, %v; want 0", deleted, err)
+	}
+	deleted, err = db.DeleteLLMRequestsBefore(ctx, time.Now().Add(time.Hour))
+	if err != nil || deleted != 2 {
+		t.Errorf("DeleteLLMRequestsBefore() an hour from now = %d, %v; want 2", deleted, err)
+	}
+}
+
+func TestLLMRequestsDeletedWithConversation(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conv, err := db.CreateConversation(ctx, stringPtr("llm-requests-delete"), true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test conversation: %v", err)
+	}
+	requestID, err := db.CreateLLMRequest(ctx, generated.CreateLLMRequestParams{
+		ConversationID: &conv.ConversationID,
+		ModelID:        "predictable",
+		Url:            "http://localhost",
+	})
+	if err != nil {
+		t.Fatalf("CreateLLMRequest() error = %v", err)
+	}
+	if err := db.DeleteConversation(ctx, conv.ConversationID); err != nil {
+		t.Fatalf("DeleteConversation() error = %v", err)
+	}
+	if _, err := db.GetLLMRequest(ctx, requestID); err == nil {
+		t.Error("LLM request outlived its conversation")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
 )
 
 func TestMessageService_Create(t *testing.T) {
@@ -455,3 +456,166 @@ func TestMessageService_CountByType(t *testing.T) {
 		t.Errorf("Expected 1 tool message, got %d", toolCount)
 	}
 }
+
+func TestMessageService_SoftDeleteFrom(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conv, err := db.CreateConversation(ctx, stringPtr("test-conversation"), true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test conversation: %v", err)
+	}
+
+	var created []*generated.Message
+	for i := 0; i < 4; i++ {
+		msg, err := db.CreateMessage(ctx, CreateMessageParams{
+			ConversationID: conv.ConversationID,
+			Type:           MessageTypeUser,
+			LLMData:        map[string]interface{}{"index": i},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test message %d: %v", i, err)
+		}
+		created = append(created, msg)
+	}
+
+	if err := db.SoftDeleteMessagesFrom(ctx, conv.ConversationID, created[2].SequenceID); err != nil {
+		t.Fatalf("SoftDeleteMessagesFrom() error = %v", err)
+	}
+
+	var messages []generated.Message
+	err = db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(ctx, conv.ConversationID)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("ListMessages() error = %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 visible messages, got %d", len(messages))
+	}
+
+	// Hidden messages are kept
+	hidden, err := db.GetMessageByID(ctx, created[3].MessageID)
+	if err != nil {
+		t.Fatalf("GetMessageByID() error = %v", err)
+	}
+	if hidden.DeletedAt == nil {
+		t.Error("Expected hidden message to have deleted_at set")
+	}
+
+	// New messages continue the sequence after the hidden ones
+	next, err := db.CreateMessage(ctx, CreateMessageParams{
+		ConversationID: conv.ConversationID,
+		Type:           MessageTypeUser,
+		LLMData:        map[string]interface{}{"index": 4},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create message after soft delete: %v", err)
+	}
+	if next.SequenceID != created[3].SequenceID+1 {
+		t.Errorf("Expected sequence ID %d, got %d", created[3].SequenceID+1, next.SequenceID)
+	}
+	latest, err := db.GetLatestMessage(ctx, conv.ConversationID)
+	if err != nil {
+		t.Fatalf("GetLatestMessage() error = %v", err)
+	}
+	if latest.MessageID != next.MessageID {
+		t.Errorf("Expected latest message %s, got %s", next.MessageID, latest.MessageID)
+	}
+}
+
+func TestMessageService_Search(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conv, err := db.CreateConversation(ctx, stringPtr("exporter-fix"), true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test conversation: %v", err)
+	}
+	archivedConv, err := db.CreateConversation(ctx, stringPtr("old-exporter"), true, nil)
+	if err != nil {
+		t.Fatalf("Failed to create test conversation: %v", err)
+	}
+	create := func(conversationID string, msgType MessageType, msg llm.Message) *generated.Message {
+		t.Helper()
+		created, err := db.CreateMessage(ctx, CreateMessageParams{ConversationID: conversationID, Type: msgType, LLMData: msg})
+		if err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		return created
+	}
+	create(conv.ConversationID, MessageTypeUser, llm.UserStringMessage("Please fix that nil pointer in the exporter"))
+	toolUse := create(conv.ConversationID, MessageTypeAgent, llm.Message{Role: llm.MessageRoleAssistant, Content: []llm.Content{
+		{Type: llm.ContentTypeText, Text: "Let me look."},
+		{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "bash", ToolInput: json.RawMessage(`{"command":"grep -rn Flusher ./metrics"}`)},
+	}})
+	toolResult := create(conv.ConversationID, MessageTypeUser, llm.Message{Role: llm.MessageRoleUser, Content: []llm.Content{
+		{Type: llm.ContentTypeToolResult, ToolUseID: "t1", ToolResult: []llm.Content{llm.StringContent("metrics/sink.go:42: type Flusher interface")}},
+	}})
+	create(conv.ConversationID, MessageTypeSystem, llm.UserStringMessage("You are an agent that knows about exporters"))
+	create(archivedConv.ConversationID, MessageTypeUser, llm.UserStringMessage("The exporter drops spans"))
+	if _, err := db.ArchiveConversation(ctx, archivedConv.ConversationID); err != nil {
+		t.Fatalf("ArchiveConversation() error = %v", err)
+	}
+
+	search := func(query string, archived bool) []generated.SearchMessagesRow {
+		t.Helper()
+		results, err := db.SearchMessages(ctx, query, archived, 10, 0)
+		if err != nil {
+			t.Fatalf("SearchMessages(%q) error = %v", query, err)
+		}
+		return results
+	}
+
+	results := search("nil pointer exporter", false)
+	if len(results) != 1 || results[0].SequenceID != 1 || *results[0].Slug != "exporter-fix" {
+		t.Fatalf("Expected the user message, got %+v", results)
+	}
+	if !strings.Contains(results[0].Snippet, "**pointer**") {
+		t.Errorf("Expected the match marked in the snippet, got %q", results[0].Snippet)
+	}
+
+	// Tool inputs and outputs are searched, and the last word may be a prefix
+	results = search("flush", false)
+	if len(results) != 2 {
+		t.Fatalf("Expected the tool use and its result, got %+v", results)
+	}
+	for _, result := range results {
+		if result.MessageID != toolUse.MessageID && result.MessageID != toolResult.MessageID {
+			t.Errorf("Unexpected result %+v", result)
+		}
+	}
+
+	// System prompts aren't searched, and archived conversations only when asked for
+	if results := search("exporter", false); len(results) != 1 {
+		t.Errorf("Expected only the unarchived user message, got %+v", results)
+	}
+	// Words are stemmed, so other forms of a word match too
+	if results := search("exporters", false); len(results) != 1 || results[0].SequenceID != 1 {
+		t.Errorf("Expected the user message, got %+v", results)
+	}
+	if results := search("exporter", true); len(results) != 1 || results[0].ConversationID != archivedConv.ConversationID {
+		t.Errorf("Expected the archived message, got %+v", results)
+	}
+
+	// Query syntax is searched for rather than interpreted
+	if results := search(`"nil AND NEAR(`, false); len(results) != 0 {
+		t.Errorf("Expected no results, got %+v", results)
+	}
+
+	// Hidden messages aren't found
+	if err := db.SoftDeleteMessagesFrom(ctx, conv.ConversationID, toolResult.SequenceID); err != nil {
+		t.Fatalf("SoftDeleteMessagesFrom() error = %v", err)
+	}
+	if results := search("flusher", false); len(results) != 1 || results[0].MessageID != toolUse.MessageID {
+		t.Errorf("Expected only the tool use, got %+v", results)
+	}
+}
@@ -0,0 +1,22 @@
+-- name: CreateAPIToken :one
+INSERT INTO api_tokens (name, token_hash, prefix)
+VALUES (?, ?, ?)
+RETURNING *;
+
+-- name: GetAPITokenByHash :one
+SELECT * FROM api_tokens
+WHERE token_hash = ? AND revoked_at IS NULL;
+
+-- name: ListAPITokens :many
+SELECT * FROM api_tokens
+ORDER BY token_id;
+
+-- name: RevokeAPIToken :execrows
+UPDATE api_tokens
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE token_id = ? AND revoked_at IS NULL;
+
+-- name: TouchAPIToken :exec
+UPDATE api_tokens
+SET last_used_at = CURRENT_TIMESTAMP
+WHERE token_id = ?;
@@ -3,6 +3,11 @@ INSERT INTO conversations (conversation_id, slug, user_initiated, cwd)
 VALUES (?, ?, ?, ?)
 RETURNING *;
 
+-- name: CreateForkedConversation :one
+INSERT INTO conversations (conversation_id, user_initiated, cwd, parent_conversation_id, forked_at_sequence_id, model)
+VALUES (?, ?, ?, ?, ?, ?)
+RETURNING *;
+
 -- name: GetConversation :one
 SELECT * FROM conversations
 WHERE conversation_id = ?;
@@ -46,6 +51,11 @@ UPDATE conversations
 SET updated_at = CURRENT_TIMESTAMP
 WHERE conversation_id = ?;
 
+-- name: SetConversationTurnOwner :exec
+UPDATE conversations
+SET turn_owner_pid = ?
+WHERE conversation_id = ?;
+
 -- name: DeleteConversation :exec
 DELETE FROM conversations
 WHERE conversation_id = ?;
@@ -73,3 +83,15 @@ UPDATE conversations
 SET cwd = ?, updated_at = CURRENT_TIMESTAMP
 WHERE conversation_id = ?
 RETURNING *;
+
+-- name: UpdateConversationBudget :one
+UPDATE conversations
+SET budget = ?
+WHERE conversation_id = ?
+RETURNING *;
+
+-- name: UpdateConversationModel :one
+UPDATE conversations
+SET model = ?
+WHERE conversation_id = ?
+RETURNING *;
@@ -0,0 +1,36 @@
+-- name: CreateLLMRequest :one
+INSERT INTO llm_requests (conversation_id, model_id, url, request_body, response_body, status_code, error, duration_seconds, attempt)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+RETURNING request_id;
+
+-- name: SetLLMRequestMessage :exec
+UPDATE llm_requests
+SET message_id = ?
+WHERE request_id = ?;
+
+-- name: GetLLMRequest :one
+SELECT * FROM llm_requests
+WHERE request_id = ?;
+
+-- name: GetLLMRequestByMessage :one
+SELECT * FROM llm_requests
+WHERE message_id = ?
+ORDER BY request_id DESC
+LIMIT 1;
+
+-- name: ListLLMRequests :many
+SELECT request_id, conversation_id, message_id, model_id, url, status_code, error, duration_seconds, created_at, attempt
+FROM llm_requests
+ORDER BY request_id DESC
+LIMIT ?;
+
+-- name: ListConversationLLMRequests :many
+SELECT request_id, conversation_id, message_id, model_id, url, status_code, error, duration_seconds, created_at, attempt
+FROM llm_requests
+WHERE conversation_id = ?
+ORDER BY request_id DESC
+LIMIT ?;
+
+-- name: DeleteLLMRequestsBefore :execrows
+DELETE FROM llm_requests
+WHERE created_at < ?;
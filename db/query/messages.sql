@@ -14,26 +14,49 @@ WHERE message_id = ?;
 
 -- name: ListMessages :many
 SELECT * FROM messages
-WHERE conversation_id = ?
+WHERE conversation_id = ? AND deleted_at IS NULL
 ORDER BY sequence_id ASC;
 
+-- name: ListMessageUsage :many
+-- Includes hidden messages: what they cost was spent all the same. Leaves out the messages
+-- a fork copied from its parent, whose cost the parent spent.
+SELECT m.usage_data FROM messages m
+JOIN conversations c ON c.conversation_id = m.conversation_id
+WHERE m.conversation_id = ? AND m.usage_data IS NOT NULL
+    AND (c.forked_at_sequence_id IS NULL OR m.sequence_id > c.forked_at_sequence_id)
+ORDER BY m.sequence_id ASC;
+
 -- name: ListMessagesPaginated :many
 SELECT * FROM messages
-WHERE conversation_id = ?
+WHERE conversation_id = ? AND deleted_at IS NULL
 ORDER BY sequence_id ASC
 LIMIT ? OFFSET ?;
 
 -- name: ListMessagesByType :many
 SELECT * FROM messages
-WHERE conversation_id = ? AND type = ?
+WHERE conversation_id = ? AND type = ? AND deleted_at IS NULL
 ORDER BY sequence_id ASC;
 
 -- name: GetLatestMessage :one
 SELECT * FROM messages
-WHERE conversation_id = ?
+WHERE conversation_id = ? AND deleted_at IS NULL
 ORDER BY sequence_id DESC
 LIMIT 1;
 
+-- name: ListLatestMessagesOfOpenConversations :many
+-- Returns the latest visible message of every unarchived, user-initiated conversation.
+SELECT m.* FROM messages m
+WHERE m.deleted_at IS NULL
+  AND m.sequence_id = (
+    SELECT MAX(sequence_id) FROM messages
+    WHERE conversation_id = m.conversation_id AND deleted_at IS NULL
+  )
+  AND m.conversation_id IN (
+    SELECT conversation_id FROM conversations
+    WHERE archived = FALSE AND user_initiated = TRUE
+  )
+ORDER BY m.created_at ASC;
+
 -- name: DeleteMessage :exec
 DELETE FROM messages
 WHERE message_id = ?;
@@ -44,13 +67,31 @@ WHERE conversation_id = ?;
 
 -- name: CountMessagesInConversation :one
 SELECT COUNT(*) FROM messages
-WHERE conversation_id = ?;
+WHERE conversation_id = ? AND deleted_at IS NULL;
 
 -- name: CountMessagesByType :one
 SELECT COUNT(*) FROM messages
-WHERE conversation_id = ? AND type = ?;
+WHERE conversation_id = ? AND type = ? AND deleted_at IS NULL;
 
 -- name: ListMessagesSince :many
 SELECT * FROM messages
-WHERE conversation_id = ? AND sequence_id > ?
+WHERE conversation_id = ? AND sequence_id > ? AND deleted_at IS NULL
 ORDER BY sequence_id ASC;
+
+-- name: SoftDeleteMessagesFrom :exec
+UPDATE messages
+SET deleted_at = CURRENT_TIMESTAMP
+WHERE conversation_id = ? AND sequence_id >= ? AND deleted_at IS NULL;
+
+-- name: SearchMessages :many
+-- Returns the visible messages matching an FTS5 query, best matches first, with a snippet
+-- of the matching text in which the matches are marked with ** as in markdown.
+SELECT m.conversation_id, c.slug, m.message_id, m.sequence_id, m.type, m.created_at,
+    CAST(snippet(messages_fts, 0, '**', '**', '...', 16) AS TEXT) AS snippet
+FROM messages_fts
+JOIN messages_fts_ids i ON i.fts_rowid = messages_fts.rowid
+JOIN messages m ON m.message_id = i.message_id
+JOIN conversations c ON c.conversation_id = m.conversation_id
+WHERE messages_fts.body MATCH sqlc.arg(query) AND m.deleted_at IS NULL AND c.archived = sqlc.arg(archived)
+ORDER BY messages_fts.rank
+LIMIT sqlc.arg(limit) OFFSET sqlc.arg(offset);
@@ -0,0 +1,31 @@
+-- name: CreateWebhook :one
+INSERT INTO webhooks (url, secret, events)
+VALUES (?, ?, ?)
+RETURNING *;
+
+-- name: ListWebhooks :many
+SELECT * FROM webhooks
+ORDER BY webhook_id;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks
+WHERE webhook_id = ?;
+
+-- name: CreateWebhookDelivery :one
+INSERT INTO webhook_deliveries (webhook_id, url, event, conversation_id, payload)
+VALUES (?, ?, ?, ?, ?)
+RETURNING delivery_id;
+
+-- name: RecordWebhookDeliveryAttempt :exec
+UPDATE webhook_deliveries
+SET attempts = attempts + 1, status_code = ?, error = ?, delivered_at = ?
+WHERE delivery_id = ?;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+ORDER BY delivery_id DESC
+LIMIT ?;
+
+-- name: DeleteWebhookDeliveriesBefore :execrows
+DELETE FROM webhook_deliveries
+WHERE created_at < ?;
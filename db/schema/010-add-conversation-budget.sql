@@ -0,0 +1,4 @@
+-- Add budget column to conversations
+-- JSON-encoded loop budget that overrides the server default for this conversation
+
+ALTER TABLE conversations ADD COLUMN budget TEXT;
@@ -0,0 +1,6 @@
+-- Add parent_conversation_id and forked_at_sequence_id columns to conversations
+-- Set on conversations forked from another conversation: the parent, cleared if the parent is deleted,
+-- and the sequence ID of the last message copied from it
+
+ALTER TABLE conversations ADD COLUMN parent_conversation_id TEXT REFERENCES conversations(conversation_id) ON DELETE SET NULL;
+ALTER TABLE conversations ADD COLUMN forked_at_sequence_id INTEGER;
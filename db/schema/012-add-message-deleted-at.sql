@@ -0,0 +1,4 @@
+-- Add deleted_at column to messages
+-- Messages removed by editing an earlier user message are kept but hidden from listings
+
+ALTER TABLE messages ADD COLUMN deleted_at DATETIME;
@@ -0,0 +1,4 @@
+-- Add model column to conversations
+-- The model the conversation currently uses, so it survives the conversation manager being evicted
+
+ALTER TABLE conversations ADD COLUMN model TEXT;
@@ -0,0 +1,25 @@
+-- LLM requests table
+-- The raw HTTP exchanges with LLM providers, for debugging; pruned after a retention period
+CREATE TABLE llm_requests (
+    request_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id TEXT, -- NULL for requests made outside a conversation
+    message_id TEXT, -- the agent message the response became, once recorded
+    model_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    request_body BLOB,
+    response_body BLOB,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    duration_seconds REAL NOT NULL DEFAULT 0,
+    attempt INTEGER NOT NULL DEFAULT 1, -- 1 for the first attempt at a request, 2 for its first retry, and so on
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(conversation_id) ON DELETE CASCADE,
+    FOREIGN KEY (message_id) REFERENCES messages(message_id) ON DELETE SET NULL
+);
+
+-- Index on conversation_id for listing a conversation's requests
+CREATE INDEX idx_llm_requests_conversation_id ON llm_requests(conversation_id, request_id);
+-- Index on message_id for finding the request behind a message
+CREATE INDEX idx_llm_requests_message_id ON llm_requests(message_id);
+-- Index on created_at for pruning
+CREATE INDEX idx_llm_requests_created_at ON llm_requests(created_at);
@@ -0,0 +1,56 @@
+-- Full-text search over message contents
+-- Indexes the text of user and agent messages and the inputs and outputs of tools, taken from llm_data
+
+-- The text to index for each message: the Text and ToolInput of each content item and the
+-- Text of each tool result. json_tree walks the content in document order.
+CREATE VIEW message_search_text AS
+SELECT
+    m.message_id,
+    (
+        SELECT CAST(group_concat(t.value, char(10)) AS TEXT)
+        FROM json_tree(m.llm_data, '$.Content') AS t
+        WHERE t.value IS NOT NULL AND t.value != ''
+            AND ((t.key IN ('Text', 'ToolInput') AND t.path GLOB '$.Content[[]*]' AND t.path NOT GLOB '*.*.*')
+                OR (t.key = 'Text' AND t.path GLOB '$.Content[[]*].ToolResult[[]*]' AND t.path NOT GLOB '*.*.*.*'))
+    ) AS body
+FROM messages m
+WHERE m.type IN ('user', 'agent', 'tool') AND m.llm_data IS NOT NULL;
+
+CREATE VIRTUAL TABLE messages_fts USING fts5(
+    body,
+    tokenize = 'porter unicode61'
+);
+
+-- The messages_fts row of each message. Messages have no integer key of their own
+-- (their rowids can change on VACUUM), so this table gives them one.
+CREATE TABLE messages_fts_ids (
+    fts_rowid INTEGER PRIMARY KEY,
+    message_id TEXT NOT NULL UNIQUE
+);
+
+CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts_ids (message_id) VALUES (NEW.message_id);
+    INSERT INTO messages_fts (rowid, body)
+    SELECT i.fts_rowid, t.body FROM message_search_text t JOIN messages_fts_ids i USING (message_id)
+    WHERE t.message_id = NEW.message_id AND t.body IS NOT NULL;
+END;
+
+CREATE TRIGGER messages_fts_update AFTER UPDATE OF llm_data ON messages BEGIN
+    DELETE FROM messages_fts WHERE rowid = (SELECT fts_rowid FROM messages_fts_ids WHERE message_id = OLD.message_id);
+    INSERT INTO messages_fts (rowid, body)
+    SELECT i.fts_rowid, t.body FROM message_search_text t JOIN messages_fts_ids i USING (message_id)
+    WHERE t.message_id = NEW.message_id AND t.body IS NOT NULL;
+END;
+
+CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+    DELETE FROM messages_fts WHERE rowid = (SELECT fts_rowid FROM messages_fts_ids WHERE message_id = OLD.message_id);
+    DELETE FROM messages_fts_ids WHERE message_id = OLD.message_id;
+END;
+
+-- Backfill the messages written before the index existed
+INSERT INTO messages_fts_ids (message_id)
+SELECT message_id FROM messages ORDER BY conversation_id, sequence_id;
+
+INSERT INTO messages_fts (rowid, body)
+SELECT i.fts_rowid, t.body FROM message_search_text t JOIN messages_fts_ids i USING (message_id)
+WHERE t.body IS NOT NULL;
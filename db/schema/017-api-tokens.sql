@@ -0,0 +1,11 @@
+-- API tokens table
+-- Tokens that scripts send as "Authorization: Bearer <token>". Only a SHA-256 hash of each is kept.
+CREATE TABLE api_tokens (
+    token_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    prefix TEXT NOT NULL, -- the start of the token, to tell tokens apart without storing them
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,
+    revoked_at DATETIME
+);
@@ -0,0 +1,30 @@
+-- Webhooks table
+-- Webhooks added through the API; those in shelley.json are not stored
+CREATE TABLE webhooks (
+    webhook_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL, -- signs each payload with HMAC-SHA256
+    events TEXT NOT NULL, -- JSON array of the event types delivered; empty for all of them
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Webhook deliveries table
+-- Every event sent to a webhook, with the outcome of its latest attempt
+CREATE TABLE webhook_deliveries (
+    delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER, -- NULL for webhooks in shelley.json
+    url TEXT NOT NULL,
+    event TEXT NOT NULL,
+    conversation_id TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    delivered_at DATETIME,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(webhook_id) ON DELETE SET NULL,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(conversation_id) ON DELETE CASCADE
+);
+
+-- Index on webhook_id for listing a webhook's deliveries
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, delivery_id);
@@ -0,0 +1,17 @@
+-- Record OpenAI usage the way other providers' usage is recorded
+-- It used to count cached tokens in input_tokens as well as cache_read_input_tokens, and repeat
+-- input_tokens as cache_creation_input_tokens, so its context window and budget sums were too large.
+-- Now input_tokens excludes cached tokens, and nothing is written to a cache, as OpenAI charges none.
+-- Old rows are the ones whose cache_creation_input_tokens equals input_tokens, from models not known
+-- to be Anthropic's or Google's; their usage never matches that otherwise.
+
+UPDATE messages
+SET usage_data = json_set(usage_data,
+    '$.input_tokens', json_extract(usage_data, '$.input_tokens') - min(json_extract(usage_data, '$.cache_read_input_tokens'), json_extract(usage_data, '$.input_tokens')),
+    '$.cache_creation_input_tokens', 0)
+WHERE usage_data IS NOT NULL
+  AND json_valid(usage_data)
+  AND json_extract(usage_data, '$.input_tokens') > 0
+  AND json_extract(usage_data, '$.cache_creation_input_tokens') = json_extract(usage_data, '$.input_tokens')
+  AND coalesce(json_extract(usage_data, '$.model'), '') NOT LIKE 'claude%'
+  AND coalesce(json_extract(usage_data, '$.model'), '') NOT LIKE 'gemini%';
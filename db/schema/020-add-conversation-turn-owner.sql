@@ -0,0 +1,5 @@
+-- Add turn_owner_pid column to conversations
+-- The process ID of the shelley process running the conversation's unfinished turn, cleared when the
+-- turn ends, so that a server starting meanwhile does not take the turn for an interrupted one
+
+ALTER TABLE conversations ADD COLUMN turn_owner_pid INTEGER;
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"shelley.exe.dev/db/generated"
+)
+
+func TestWebhooks(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	webhook, err := db.CreateWebhook(ctx, generated.CreateWebhookParams{
+		Url:    "https://chat.example.com/hook",
+		Secret: "s3cret",
+		Events: `["end_of_turn"]`,
+	})
+	if err != nil {
+		t.Fatalf("CreateWebhook failed: %v", err)
+	}
+	conversation, err := db.CreateConversation(ctx, nil, true, nil)
+	if err != nil {
+		t.Fatalf("CreateConversation failed: %v", err)
+	}
+
+	deliveryID, err := db.CreateWebhookDelivery(ctx, generated.CreateWebhookDeliveryParams{
+		WebhookID:      &webhook.WebhookID,
+		Url:            webhook.Url,
+		Event:          "end_of_turn",
+		ConversationID: conversation.ConversationID,
+		Payload:        `{}`,
+	})
+	if err != nil {
+		t.Fatalf("CreateWebhookDelivery failed: %v", err)
+	}
+	if err := db.RecordWebhookDeliveryAttempt(ctx, deliveryID, 503, errors.New("503 Service Unavailable"), nil); err != nil {
+		t.Fatalf("RecordWebhookDeliveryAttempt failed: %v", err)
+	}
+	now := time.Now()
+	if err := db.RecordWebhookDeliveryAttempt(ctx, deliveryID, 200, nil, &now); err != nil {
+		t.Fatalf("RecordWebhookDeliveryAttempt failed: %v", err)
+	}
+
+	// Deleting the webhook keeps its deliveries in the log
+	if err := db.DeleteWebhook(ctx, webhook.WebhookID); err != nil {
+		t.Fatalf("DeleteWebhook failed: %v", err)
+	}
+	if err := db.DeleteWebhook(ctx, webhook.WebhookID); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("deleting a deleted webhook = %v, want ErrWebhookNotFound", err)
+	}
+	if webhooks, err := db.ListWebhooks(ctx); err != nil || len(webhooks) != 0 {
+		t.Errorf("ListWebhooks = %v, %v, want none", webhooks, err)
+	}
+
+	deliveries, err := db.ListWebhookDeliveries(ctx, 10)
+	if err != nil {
+		t.Fatalf("ListWebhookDeliveries failed: %v", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Attempts != 2 || d.StatusCode != 200 || d.Error != nil || d.DeliveredAt == nil || d.WebhookID != nil {
+		t.Errorf("delivery = %+v, want delivered on the second attempt, detached from the deleted webhook", d)
+	}
+
+	deleted, err := db.DeleteWebhookDeliveriesBefore(ctx, time.Now().Add(-time.Hour))
+	if err != nil || deleted != 0 {
+		t.Errorf("DeleteWebhookDeliveriesBefore() an hour ago = %d, %v; want 0", deleted, err)
+	}
+	deleted, err = db.DeleteWebhookDeliveriesBefore(ctx, time.Now().Add(time.Hour))
+	if err != nil || deleted != 1 {
+		t.Errorf("DeleteWebhookDeliveriesBefore() an hour from now = %d, %v; want 1", deleted, err)
+	}
+}
@@ -54,8 +54,19 @@ func ClaudeModelName(userName string) string {
 	}
 }
 
+// apiKey returns the API key to send with a request.
+func (s *Service) apiKey() string {
+	if s.APIKeyFunc != nil {
+		return s.APIKeyFunc()
+	}
+	return s.APIKey
+}
+
 // TokenContextWindow returns the maximum token context window size for this service
 func (s *Service) TokenContextWindow() int {
+	if s.ContextWindow > 0 {
+		return s.ContextWindow
+	}
 	model := s.Model
 	if model == "" {
 		model = DefaultModel
@@ -80,22 +91,25 @@ func (s *Service) MaxImageDimension() int {
 	return 2000
 }
 
-// HTTPRecorder is a callback for recording HTTP request/response data for debugging
-type HTTPRecorder func(url string, requestBody, responseBody []byte, statusCode int, err error, duration time.Duration)
-
 // Service provides Claude completions.
 // Fields should not be altered concurrently with calling any method on Service.
 type Service struct {
-	HTTPC        *http.Client // defaults to http.DefaultClient if nil
-	URL          string       // defaults to DefaultURL if empty
-	APIKey       string       // must be non-empty
-	Model        string       // defaults to DefaultModel if empty
-	MaxTokens    int          // defaults to DefaultMaxTokens if zero
-	DumpLLM      bool         // whether to dump request/response text to files for debugging; defaults to false
-	HTTPRecorder HTTPRecorder // optional callback for recording HTTP requests/responses
+	HTTPC     *http.Client // defaults to http.DefaultClient if nil
+	URL       string       // defaults to DefaultURL if empty
+	APIKey    string       // must be non-empty
+	Model     string       // defaults to DefaultModel if empty
+	MaxTokens int          // defaults to DefaultMaxTokens if zero
+	DumpLLM   bool         // whether to dump request/response text to files for debugging; defaults to false
+	// ContextWindow overrides the context window size known for Model, if non-zero
+	ContextWindow int
+	// APIKeyFunc, if set, returns the key for each request in place of APIKey, so that it can be rotated
+	APIKeyFunc func() string
 }
 
-var _ llm.Service = (*Service)(nil)
+var (
+	_ llm.Service          = (*Service)(nil)
+	_ llm.StreamingService = (*Service)(nil)
+)
 
 type content struct {
 	// https://docs.anthropic.com/en/api/messages
@@ -113,7 +127,8 @@ type content struct {
 	// is somewhat acceptable but hard to read.
 	Text      *string         `json:"text,omitempty"`
 	MediaType string          `json:"media_type,omitempty"` // for image
-	Source    json.RawMessage `json:"source,omitempty"`     // for image
+	Source    json.RawMessage `json:"source,omitempty"`     // for image or document
+	Title     string          `json:"title,omitempty"`      // for document
 
 	// for thinking
 	Thinking  string `json:"thinking,omitempty"`
@@ -235,8 +250,18 @@ type request struct {
 	TopK          int             `json:"top_k,omitempty"`
 	TopP          float64         `json:"top_p,omitempty"`
 	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Thinking      *thinking       `json:"thinking,omitempty"`
 }
 
+// thinking enables extended thinking; see https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking
+type thinking struct {
+	Type         string `json:"type"` // "enabled"
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+// minThinkingBudget is the smallest thinking budget Anthropic accepts.
+const minThinkingBudget = 1024
+
 func mapped[Slice ~[]E, E, T any](s Slice, f func(E) T) []T {
 	out := make([]T, len(s))
 	for i, v := range s {
@@ -322,9 +347,13 @@ func fromLLMContent(c llm.Content) content {
 	// Set fields based on content type to avoid sending invalid fields
 	switch c.Type {
 	case llm.ContentTypeText:
-		// Images are represented as text with MediaType and Data
+		// Images and documents are represented as text with MediaType and Data
 		if c.MediaType != "" {
 			d.Type = "image"
+			if c.MediaType == "application/pdf" {
+				d.Type = "document"
+				d.Title = c.Filename
+			}
 			d.Source = json.RawMessage(fmt.Sprintf(`{"type":"base64","media_type":"%s","data":"%s"}`,
 				c.MediaType, c.Data))
 		} else {
@@ -396,7 +425,7 @@ func fromLLMSystem(s llm.SystemContent) systemContent {
 }
 
 func (s *Service) fromLLMRequest(r *llm.Request) *request {
-	return &request{
+	req := &request{
 		Model:      cmp.Or(s.Model, DefaultModel),
 		Messages:   mapped(r.Messages, fromLLMMessage),
 		MaxTokens:  cmp.Or(s.MaxTokens, DefaultMaxTokens),
@@ -404,6 +433,23 @@ func (s *Service) fromLLMRequest(r *llm.Request) *request {
 		Tools:      mapped(r.Tools, fromLLMTool),
 		System:     mapped(r.System, fromLLMSystem),
 	}
+	// Structured output is a forced call to a tool whose input schema is the response schema.
+	if rf := r.ResponseFormat; rf != nil {
+		req.Tools = append(req.Tools, &tool{
+			Name:        rf.Name,
+			Description: cmp.Or(rf.Description, "Respond by calling this tool with the result."),
+			InputSchema: rf.Schema,
+		})
+		req.ToolChoice = &toolChoice{Type: "tool", Name: rf.Name}
+	}
+	// Extended thinking can't be combined with forcing a tool call.
+	if r.Thinking != nil && r.ResponseFormat == nil && (r.ToolChoice == nil || r.ToolChoice.Type == llm.ToolChoiceTypeAuto) {
+		budget := max(r.Thinking.Budget(), minThinkingBudget)
+		req.Thinking = &thinking{Type: "enabled", BudgetTokens: budget}
+		// max_tokens includes the thinking budget, so leave room for the answer too.
+		req.MaxTokens += budget
+	}
+	return req
 }
 
 func toLLMUsage(u usage) llm.Usage {
@@ -445,6 +491,21 @@ func toLLMContent(c content) llm.Content {
 	return ret
 }
 
+// structuredResponse turns the forced tool call that carries a structured output into the JSON text of resp.
+func structuredResponse(ir *llm.Request, resp *llm.Response) *llm.Response {
+	if ir.ResponseFormat == nil {
+		return resp
+	}
+	for _, c := range resp.Content {
+		if c.Type == llm.ContentTypeToolUse && c.ToolName == ir.ResponseFormat.Name {
+			resp.Content = []llm.Content{llm.StringContent(string(c.ToolInput))}
+			resp.StopReason = llm.StopReasonEndTurn
+			break
+		}
+	}
+	return resp
+}
+
 func toLLMResponse(r *response) *llm.Response {
 	return &llm.Response{
 		ID:           r.ID,
@@ -460,8 +521,18 @@ func toLLMResponse(r *response) *llm.Response {
 
 // Do sends a request to Anthropic.
 func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error) {
+	return s.do(ctx, ir, nil)
+}
+
+// DoStream sends a streaming request to Anthropic, calling onDelta as content arrives.
+func (s *Service) DoStream(ctx context.Context, ir *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	return s.do(ctx, ir, onDelta)
+}
+
+func (s *Service) do(ctx context.Context, ir *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
 	startTime := time.Now()
 	request := s.fromLLMRequest(ir)
+	request.Stream = onDelta != nil
 	var payload []byte
 	var err error
 	if s.DumpLLM || testing.Testing() {
@@ -483,16 +554,6 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 	url := cmp.Or(s.URL, DefaultURL)
 	httpc := cmp.Or(s.HTTPC, http.DefaultClient)
 
-	// For recording the last attempt's response
-	var lastResponseBody []byte
-	var lastStatusCode int
-	var finalErr error
-	defer func() {
-		if s.HTTPRecorder != nil {
-			s.HTTPRecorder(url, payload, lastResponseBody, lastStatusCode, finalErr, time.Since(startTime))
-		}
-	}()
-
 	// retry loop
 	var errs error // accumulated errors across all attempts
 	for attempts := 0; ; attempts++ {
@@ -509,13 +570,13 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 				slog.WarnContext(ctx, "failed to dump request to file", "error", err)
 			}
 		}
-		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+		req, err := http.NewRequestWithContext(llm.WithAttempt(ctx, attempts+1), "POST", url, bytes.NewReader(payload))
 		if err != nil {
 			return nil, errors.Join(errs, err)
 		}
 
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-API-Key", s.APIKey)
+		req.Header.Set("X-API-Key", s.apiKey())
 		req.Header.Set("Anthropic-Version", "2023-06-01")
 
 		resp, err := httpc.Do(req)
@@ -527,6 +588,29 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 			errs = errors.Join(errs, err)
 			continue
 		}
+
+		if onDelta != nil && resp.StatusCode == http.StatusOK {
+			// Deltas have been delivered once the stream starts, so stream failures are not retried.
+			var raw bytes.Buffer
+			response, err := readStream(io.TeeReader(resp.Body, &raw), onDelta)
+			resp.Body.Close()
+			if s.DumpLLM {
+				if err := llm.DumpToFile("response", "", raw.Bytes()); err != nil {
+					slog.WarnContext(ctx, "failed to dump response to file", "error", err)
+				}
+			}
+			if err != nil {
+				return nil, errors.Join(errs, err)
+			}
+			response.Usage.CostUSD = llm.CostUSDFromResponse(resp.Header)
+
+			endTime := time.Now()
+			result := toLLMResponse(response)
+			result.StartTime = &startTime
+			result.EndTime = &endTime
+			return structuredResponse(ir, result), nil
+		}
+
 		buf, err := io.ReadAll(resp.Body)
 		resp.Body.Close()
 		if err != nil {
@@ -534,10 +618,6 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 			continue
 		}
 
-		// Record response for HTTPRecorder callback
-		lastResponseBody = buf
-		lastStatusCode = resp.StatusCode
-
 		switch {
 		case resp.StatusCode == http.StatusOK:
 			if s.DumpLLM {
@@ -557,33 +637,95 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 			result := toLLMResponse(&response)
 			result.StartTime = &startTime
 			result.EndTime = &endTime
-			return result, nil
+			return structuredResponse(ir, result), nil
 		case resp.StatusCode >= 500 && resp.StatusCode < 600:
 			// server error, retry
 			slog.WarnContext(ctx, "anthropic_request_failed", "response", string(buf), "status_code", resp.StatusCode, "url", url, "model", s.Model)
-			errs = errors.Join(errs, fmt.Errorf("status %v (url=%s, model=%s): %s", resp.Status, url, cmp.Or(s.Model, DefaultModel), buf))
-			finalErr = errs
+			errs = errors.Join(errs, llm.StatusErrorf(resp.StatusCode, "status %v (url=%s, model=%s): %s", resp.Status, url, cmp.Or(s.Model, DefaultModel), buf))
 			continue
 		case resp.StatusCode == 429:
 			// rate limited, retry
 			slog.WarnContext(ctx, "anthropic_request_rate_limited", "response", string(buf), "url", url, "model", s.Model)
-			errs = errors.Join(errs, fmt.Errorf("status %v (url=%s, model=%s): %s", resp.Status, url, cmp.Or(s.Model, DefaultModel), buf))
-			finalErr = errs
+			errs = errors.Join(errs, llm.StatusErrorf(resp.StatusCode, "status %v (url=%s, model=%s): %s", resp.Status, url, cmp.Or(s.Model, DefaultModel), buf))
 			continue
 		case resp.StatusCode >= 400 && resp.StatusCode < 500:
 			// some other 400, probably unrecoverable
 			slog.WarnContext(ctx, "anthropic_request_failed", "response", string(buf), "status_code", resp.StatusCode, "url", url, "model", s.Model)
-			return nil, errors.Join(errs, fmt.Errorf("status %v (url=%s, model=%s): %s", resp.Status, url, cmp.Or(s.Model, DefaultModel), buf))
+			return nil, errors.Join(errs, llm.StatusErrorf(resp.StatusCode, "status %v (url=%s, model=%s): %s", resp.Status, url, cmp.Or(s.Model, DefaultModel), buf))
 		default:
 			// ...retry, I guess?
 			slog.WarnContext(ctx, "anthropic_request_failed", "response", string(buf), "status_code", resp.StatusCode, "url", url, "model", s.Model)
-			errs = errors.Join(errs, fmt.Errorf("status %v (url=%s, model=%s): %s", resp.Status, url, cmp.Or(s.Model, DefaultModel), buf))
-			finalErr = errs
+			errs = errors.Join(errs, llm.StatusErrorf(resp.StatusCode, "status %v (url=%s, model=%s): %s", resp.Status, url, cmp.Or(s.Model, DefaultModel), buf))
 			continue
 		}
 	}
 }
 
+// countTokensRequest is the subset of request accepted by the count_tokens endpoint; see
+// https://docs.anthropic.com/en/docs/build-with-claude/token-counting
+type countTokensRequest struct {
+	Model      string          `json:"model"`
+	Messages   []message       `json:"messages"`
+	ToolChoice *toolChoice     `json:"tool_choice,omitempty"`
+	Tools      []*tool         `json:"tools,omitempty"`
+	System     []systemContent `json:"system,omitempty"`
+	Thinking   *thinking       `json:"thinking,omitempty"`
+}
+
+// countTokensURL returns the count_tokens endpoint alongside the messages endpoint,
+// so that counting goes through the same gateway or base URL as requests do.
+func (s *Service) countTokensURL() string {
+	return strings.TrimSuffix(cmp.Or(s.URL, DefaultURL), "/") + "/count_tokens"
+}
+
+// CountTokens returns the number of input tokens ir would use, as counted by Anthropic.
+// Counting is free but rate limited, and it is not retried; callers should fall back
+// to an earlier figure when it fails.
+func (s *Service) CountTokens(ctx context.Context, ir *llm.Request) (uint64, error) {
+	r := s.fromLLMRequest(ir)
+	payload, err := json.Marshal(countTokensRequest{
+		Model:      r.Model,
+		Messages:   r.Messages,
+		ToolChoice: r.ToolChoice,
+		Tools:      r.Tools,
+		System:     r.System,
+		Thinking:   r.Thinking,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	url := s.countTokensURL()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", s.apiKey())
+	req.Header.Set("Anthropic-Version", "2023-06-01")
+
+	resp, err := cmp.Or(s.HTTPC, http.DefaultClient).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, llm.StatusErrorf(resp.StatusCode, "count_tokens status %v (url=%s, model=%s): %s", resp.Status, url, r.Model, buf)
+	}
+
+	var counted struct {
+		InputTokens uint64 `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(buf, &counted); err != nil {
+		return 0, fmt.Errorf("decoding count_tokens response: %w", err)
+	}
+	return counted.InputTokens, nil
+}
+
 // For debugging only, Claude can definitely handle the full patch tool.
 // func (s *Service) UseSimplifiedPatch() bool {
 // 	return true
@@ -596,6 +738,6 @@ func (s *Service) ConfigDetails() map[string]string {
 	return map[string]string{
 		"url":             url,
 		"model":           model,
-		"has_api_key_set": fmt.Sprintf("%v", s.APIKey != ""),
+		"has_api_key_set": fmt.Sprintf("%v", s.apiKey() != ""),
 	}
 }
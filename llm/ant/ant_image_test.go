@@ -76,3 +76,32 @@ func TestAnthropicImageToolResult(t *testing.T) {
 		t.Errorf("Expected data to be '/9j/4AAQSkZJRg...', got '%s'", source["data"])
 	}
 }
+
+func TestAnthropicAttachments(t *testing.T) {
+	msg := fromLLMMessage(llm.Message{
+		Role: llm.MessageRoleUser,
+		Content: []llm.Content{
+			{Type: llm.ContentTypeText, Text: "what changed?"},
+			{Type: llm.ContentTypeText, MediaType: "image/png", Data: "iVBORw0KGgo=", Filename: "before.png"},
+			{Type: llm.ContentTypeText, MediaType: "application/pdf", Data: "JVBERi0xLjQ=", Filename: "spec.pdf"},
+		},
+	})
+
+	if len(msg.Content) != 3 {
+		t.Fatalf("got %d contents, want 3", len(msg.Content))
+	}
+	if msg.Content[1].Type != "image" || msg.Content[1].Title != "" {
+		t.Errorf("image content = %s %q, want an untitled image", msg.Content[1].Type, msg.Content[1].Title)
+	}
+	document := msg.Content[2]
+	if document.Type != "document" || document.Title != "spec.pdf" || document.Text != nil {
+		t.Errorf("pdf content = %+v, want a document titled spec.pdf", document)
+	}
+	var source map[string]any
+	if err := json.Unmarshal(document.Source, &source); err != nil {
+		t.Fatal(err)
+	}
+	if source["type"] != "base64" || source["media_type"] != "application/pdf" || source["data"] != "JVBERi0xLjQ=" {
+		t.Errorf("document source = %v, want the base64 PDF", source)
+	}
+}
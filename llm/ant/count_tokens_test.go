@@ -0,0 +1,84 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+func TestCountTokens(t *testing.T) {
+	var gotURL, gotKey string
+	var gotBody map[string]any
+	svc := &Service{
+		APIKey: "test",
+		URL:    "https://gateway.example/_/gateway/anthropic/v1/messages",
+		HTTPC: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			gotURL = req.URL.String()
+			gotKey = req.Header.Get("X-API-Key")
+			if err := json.NewDecoder(req.Body).Decode(&gotBody); err != nil {
+				t.Errorf("decoding request body: %v", err)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`{"input_tokens":1234}`)),
+			}, nil
+		})},
+	}
+
+	n, err := svc.CountTokens(context.Background(), &llm.Request{
+		System:   []llm.SystemContent{{Type: "text", Text: "be brief"}},
+		Messages: []llm.Message{llm.UserStringMessage("hi")},
+		Tools:    []*llm.Tool{{Name: "bash", Description: "run", InputSchema: llm.EmptySchema()}},
+	})
+	if err != nil {
+		t.Fatalf("CountTokens: %v", err)
+	}
+	if n != 1234 {
+		t.Errorf("CountTokens = %d, want 1234", n)
+	}
+	if gotURL != "https://gateway.example/_/gateway/anthropic/v1/messages/count_tokens" {
+		t.Errorf("URL = %q", gotURL)
+	}
+	if gotKey != "test" {
+		t.Errorf("X-API-Key = %q", gotKey)
+	}
+	for _, field := range []string{"model", "messages", "system", "tools"} {
+		if _, ok := gotBody[field]; !ok {
+			t.Errorf("request body lacks %q: %v", field, gotBody)
+		}
+	}
+	// count_tokens rejects the generation parameters of the messages endpoint
+	for _, field := range []string{"max_tokens", "stream"} {
+		if _, ok := gotBody[field]; ok {
+			t.Errorf("request body has %q: %v", field, gotBody)
+		}
+	}
+}
+
+func TestCountTokensStatusError(t *testing.T) {
+	svc := &Service{
+		APIKey: "test",
+		HTTPC: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if !strings.HasSuffix(req.URL.Path, "/v1/messages/count_tokens") {
+				t.Errorf("unexpected path %q", req.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Status:     "429 Too Many Requests",
+				Body:       io.NopCloser(strings.NewReader(`{"type":"error"}`)),
+			}, nil
+		})},
+	}
+
+	_, err := svc.CountTokens(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}})
+	var statusErr *llm.HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("error = %v, want an *llm.HTTPStatusError with status 429", err)
+	}
+}
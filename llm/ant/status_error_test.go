@@ -0,0 +1,34 @@
+package ant
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+func TestDoReturnsStatusError(t *testing.T) {
+	svc := &Service{
+		APIKey: "test",
+		HTTPC: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Status:     "400 Bad Request",
+				Body:       io.NopCloser(strings.NewReader(`{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}`)),
+			}, nil
+		})},
+	}
+
+	_, err := svc.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}})
+	var statusErr *llm.HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("error %v is not an *llm.HTTPStatusError", err)
+	}
+	if statusErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("StatusCode = %d, want 400", statusErr.StatusCode)
+	}
+}
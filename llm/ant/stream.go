@@ -0,0 +1,130 @@
+package ant
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"shelley.exe.dev/llm"
+)
+
+// streamEvent is a server-sent event from the streaming messages API.
+// See https://docs.anthropic.com/en/docs/build-with-claude/streaming
+type streamEvent struct {
+	Type         string    `json:"type"`
+	Message      *response `json:"message,omitempty"`       // message_start
+	Index        int       `json:"index"`                   // content_block_*
+	ContentBlock *content  `json:"content_block,omitempty"` // content_block_start
+	Delta        struct {
+		Type         string  `json:"type"`
+		Text         string  `json:"text"`
+		Thinking     string  `json:"thinking"`
+		Signature    string  `json:"signature"`
+		PartialJSON  string  `json:"partial_json"`
+		StopReason   string  `json:"stop_reason"`
+		StopSequence *string `json:"stop_sequence"`
+	} `json:"delta"`
+	Usage *usage `json:"usage,omitempty"` // message_delta
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// readStream assembles a response from a streaming messages API body,
+// calling onDelta for each piece of text, thinking, and tool input as it arrives.
+func readStream(r io.Reader, onDelta func(llm.StreamDelta)) (*response, error) {
+	var resp *response
+	bufs := make(map[int]*strings.Builder) // accumulated text, thinking, or tool input by block index
+	err := llm.ReadSSE(r, func(_ string, data []byte) error {
+		var ev streamEvent
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return fmt.Errorf("decoding stream event: %w", err)
+		}
+		if ev.Type == "error" {
+			if ev.Error == nil {
+				return fmt.Errorf("stream error: %s", data)
+			}
+			return fmt.Errorf("stream error: %s: %s", ev.Error.Type, ev.Error.Message)
+		}
+		if ev.Type == "ping" {
+			return nil
+		}
+		if ev.Type == "message_start" {
+			if ev.Message == nil {
+				return fmt.Errorf("message_start without message")
+			}
+			resp = ev.Message
+			return nil
+		}
+		if resp == nil {
+			return fmt.Errorf("stream event %q before message_start", ev.Type)
+		}
+		switch ev.Type {
+		case "content_block_start":
+			if ev.ContentBlock == nil || ev.Index != len(resp.Content) {
+				return fmt.Errorf("unexpected content_block_start at index %d", ev.Index)
+			}
+			resp.Content = append(resp.Content, *ev.ContentBlock)
+			bufs[ev.Index] = new(strings.Builder)
+			if ev.ContentBlock.Type == "tool_use" {
+				onDelta(llm.StreamDelta{
+					Index:    ev.Index,
+					Type:     llm.ContentTypeToolUse,
+					ID:       ev.ContentBlock.ID,
+					ToolName: ev.ContentBlock.ToolName,
+				})
+			}
+		case "content_block_delta":
+			buf := bufs[ev.Index]
+			if buf == nil {
+				return fmt.Errorf("content_block_delta for unknown index %d", ev.Index)
+			}
+			switch ev.Delta.Type {
+			case "text_delta":
+				buf.WriteString(ev.Delta.Text)
+				onDelta(llm.StreamDelta{Index: ev.Index, Type: llm.ContentTypeText, Text: ev.Delta.Text})
+			case "thinking_delta":
+				buf.WriteString(ev.Delta.Thinking)
+				onDelta(llm.StreamDelta{Index: ev.Index, Type: llm.ContentTypeThinking, Text: ev.Delta.Thinking})
+			case "input_json_delta":
+				buf.WriteString(ev.Delta.PartialJSON)
+				onDelta(llm.StreamDelta{Index: ev.Index, Type: llm.ContentTypeToolUse, Text: ev.Delta.PartialJSON})
+			case "signature_delta":
+				resp.Content[ev.Index].Signature += ev.Delta.Signature
+			}
+		case "content_block_stop":
+			buf := bufs[ev.Index]
+			if buf == nil {
+				return fmt.Errorf("content_block_stop for unknown index %d", ev.Index)
+			}
+			c := &resp.Content[ev.Index]
+			switch c.Type {
+			case "text":
+				text := buf.String()
+				c.Text = &text
+			case "thinking":
+				c.Thinking = buf.String()
+			case "tool_use":
+				// An empty input streams no deltas; the API expects an object.
+				c.ToolInput = json.RawMessage(cmp.Or(buf.String(), "{}"))
+			}
+		case "message_delta":
+			resp.StopReason = ev.Delta.StopReason
+			resp.StopSequence = ev.Delta.StopSequence
+			if ev.Usage != nil {
+				resp.Usage.OutputTokens = ev.Usage.OutputTokens
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.StopReason == "" {
+		return nil, fmt.Errorf("stream ended before message completed")
+	}
+	return resp, nil
+}
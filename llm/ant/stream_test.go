@@ -0,0 +1,121 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+const testStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-test","content":[],"stop_reason":null,"usage":{"input_tokens":12,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"check."}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"bash","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"command\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"ls\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":42}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestDoStream(t *testing.T) {
+	var sentStream bool
+	svc := &Service{
+		APIKey: "test",
+		HTTPC: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			var body struct {
+				Stream bool `json:"stream"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return nil, err
+			}
+			sentStream = body.Stream
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       io.NopCloser(strings.NewReader(testStream)),
+			}, nil
+		})},
+	}
+
+	var deltas []llm.StreamDelta
+	resp, err := svc.DoStream(context.Background(), &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("list files")},
+	}, func(d llm.StreamDelta) {
+		deltas = append(deltas, d)
+	})
+	if err != nil {
+		t.Fatalf("DoStream: %v", err)
+	}
+	if !sentStream {
+		t.Error("request did not set stream: true")
+	}
+
+	if len(deltas) != 5 {
+		t.Fatalf("got %d deltas, want 5: %+v", len(deltas), deltas)
+	}
+	if deltas[0].Type != llm.ContentTypeText || deltas[0].Text != "Let me " {
+		t.Errorf("unexpected first delta: %+v", deltas[0])
+	}
+	if deltas[2].Type != llm.ContentTypeToolUse || deltas[2].ToolName != "bash" || deltas[2].Index != 1 {
+		t.Errorf("unexpected tool start delta: %+v", deltas[2])
+	}
+
+	if resp.StopReason != llm.StopReasonToolUse {
+		t.Errorf("StopReason = %v, want tool use", resp.StopReason)
+	}
+	if resp.Usage.InputTokens != 12 || resp.Usage.OutputTokens != 42 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+	if len(resp.Content) != 2 {
+		t.Fatalf("got %d content blocks, want 2", len(resp.Content))
+	}
+	if resp.Content[0].Text != "Let me check." {
+		t.Errorf("text = %q", resp.Content[0].Text)
+	}
+	if resp.Content[1].ID != "toolu_1" || string(resp.Content[1].ToolInput) != `{"command":"ls"}` {
+		t.Errorf("unexpected tool use: %+v", resp.Content[1])
+	}
+}
+
+func TestReadStreamIncomplete(t *testing.T) {
+	truncated := testStream[:strings.Index(testStream, "event: message_delta")]
+	if _, err := readStream(strings.NewReader(truncated), func(llm.StreamDelta) {}); err == nil {
+		t.Fatal("expected error for truncated stream")
+	}
+}
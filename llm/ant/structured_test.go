@@ -0,0 +1,62 @@
+package ant
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+var testResponseFormat = &llm.ResponseFormat{
+	Name:   "conversation_slug",
+	Schema: llm.MustSchema(`{"type": "object", "properties": {"slug": {"type": "string"}}}`),
+}
+
+func TestFromLLMRequestResponseFormat(t *testing.T) {
+	svc := &Service{}
+	req := svc.fromLLMRequest(&llm.Request{
+		Messages:       []llm.Message{llm.UserStringMessage("hi")},
+		Thinking:       &llm.Thinking{Effort: llm.ThinkingEffortLow},
+		ResponseFormat: testResponseFormat,
+	})
+	if len(req.Tools) != 1 || req.Tools[0].Name != "conversation_slug" || string(req.Tools[0].InputSchema) != string(testResponseFormat.Schema) {
+		t.Fatalf("tools = %+v, want the response schema as a tool", req.Tools)
+	}
+	if req.ToolChoice == nil || req.ToolChoice.Type != "tool" || req.ToolChoice.Name != "conversation_slug" {
+		t.Errorf("tool_choice = %+v, want the response tool forced", req.ToolChoice)
+	}
+	if req.Thinking != nil {
+		t.Error("thinking was enabled together with structured output")
+	}
+}
+
+func TestDoResponseFormat(t *testing.T) {
+	svc := &Service{
+		APIKey: "test",
+		HTTPC: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body: io.NopCloser(strings.NewReader(`{"id":"msg_1","type":"message","role":"assistant","model":"claude","stop_reason":"tool_use",` +
+					`"content":[{"type":"tool_use","id":"toolu_1","name":"conversation_slug","input":{"slug":"fix-login"}}],"usage":{"input_tokens":10,"output_tokens":5}}`)),
+			}, nil
+		})},
+	}
+
+	resp, err := svc.Do(context.Background(), &llm.Request{
+		Messages:       []llm.Message{llm.UserStringMessage("hi")},
+		ResponseFormat: testResponseFormat,
+	})
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Type != llm.ContentTypeText || resp.Content[0].Text != `{"slug":"fix-login"}` {
+		t.Errorf("content = %+v, want the tool input as JSON text", resp.Content)
+	}
+	if resp.StopReason != llm.StopReasonEndTurn {
+		t.Errorf("stop reason = %v, want end of turn", resp.StopReason)
+	}
+}
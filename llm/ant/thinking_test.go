@@ -0,0 +1,39 @@
+package ant
+
+import (
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+func TestFromLLMRequestThinking(t *testing.T) {
+	svc := &Service{}
+	messages := []llm.Message{llm.UserStringMessage("hi")}
+
+	req := svc.fromLLMRequest(&llm.Request{Messages: messages, Thinking: &llm.Thinking{Effort: llm.ThinkingEffortMedium}})
+	if req.Thinking == nil || req.Thinking.Type != "enabled" || req.Thinking.BudgetTokens != 16384 {
+		t.Fatalf("thinking = %+v, want enabled with the medium budget", req.Thinking)
+	}
+	if req.MaxTokens != DefaultMaxTokens+16384 {
+		t.Errorf("MaxTokens = %d, want room for the answer after the thinking budget", req.MaxTokens)
+	}
+
+	req = svc.fromLLMRequest(&llm.Request{Messages: messages, Thinking: &llm.Thinking{BudgetTokens: 100}})
+	if req.Thinking == nil || req.Thinking.BudgetTokens != minThinkingBudget {
+		t.Errorf("thinking = %+v, want the budget raised to the minimum", req.Thinking)
+	}
+
+	req = svc.fromLLMRequest(&llm.Request{
+		Messages:   messages,
+		Thinking:   &llm.Thinking{Effort: llm.ThinkingEffortHigh},
+		ToolChoice: &llm.ToolChoice{Type: llm.ToolChoiceTypeTool, Name: "bash"},
+	})
+	if req.Thinking != nil {
+		t.Error("thinking was enabled together with a forced tool choice")
+	}
+
+	req = svc.fromLLMRequest(&llm.Request{Messages: messages})
+	if req.Thinking != nil || req.MaxTokens != DefaultMaxTokens {
+		t.Errorf("request without thinking got thinking %+v, max tokens %d", req.Thinking, req.MaxTokens)
+	}
+}
@@ -0,0 +1,44 @@
+package llm
+
+import "testing"
+
+func TestCachePrefix(t *testing.T) {
+	text := func(s string, cache bool) Message {
+		return Message{Role: MessageRoleUser, Content: []Content{{Type: ContentTypeText, Text: s, Cache: cache}}}
+	}
+	tests := []struct {
+		name     string
+		req      Request
+		messages int
+		ok       bool
+	}{
+		{
+			name: "nothing marked",
+			req:  Request{Messages: []Message{text("a", false)}, System: []SystemContent{{Text: "sys"}}},
+		},
+		{
+			name:     "last marked message",
+			req:      Request{Messages: []Message{text("a", true), text("b", false), text("c", true), text("d", false)}},
+			messages: 3,
+			ok:       true,
+		},
+		{
+			name: "only the system prompt",
+			req:  Request{Messages: []Message{text("a", false)}, System: []SystemContent{{Text: "sys", Cache: true}}},
+			ok:   true,
+		},
+		{
+			name: "only a tool",
+			req:  Request{Tools: []*Tool{{Name: "bash"}, {Name: "patch", Cache: true}}},
+			ok:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, ok := tt.req.CachePrefix()
+			if messages != tt.messages || ok != tt.ok {
+				t.Errorf("CachePrefix() = %d, %v; want %d, %v", messages, ok, tt.messages, tt.ok)
+			}
+		})
+	}
+}
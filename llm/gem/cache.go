@@ -0,0 +1,166 @@
+package gem
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/llm/gem/gemini"
+)
+
+// Gemini only gives a guaranteed discount for the context it is asked to cache, in context caches
+// created ahead of the requests that use them. The Service keeps the caches it created, and sends
+// the part of a request that the llm.Request marks with Cache through the longest one that fits.
+// See https://ai.google.dev/gemini-api/docs/caching
+
+const (
+	contextCacheTTL = 5 * time.Minute
+	// contextCacheMargin is how long before it expires a context cache stops being used,
+	// so that it does not expire on the way to the API
+	contextCacheMargin = 30 * time.Second
+)
+
+// contextCache is a context cache created for an earlier request
+type contextCache struct {
+	name     string // format: "cachedContents/{id}"
+	prefix   string // hash of the request prefix it holds, see prefixHashes
+	contents int    // how many of the request's contents it holds
+	tokens   uint64
+	expires  time.Time
+}
+
+// minContextCacheTokens returns the fewest tokens the model will put in a context cache.
+func (s *Service) minContextCacheTokens() uint64 {
+	if strings.Contains(cmp.Or(s.Model, DefaultModel), "flash") {
+		return 1024
+	}
+	return 4096
+}
+
+// prefixHashes returns, for each k up to n, a hash of everything a context cache holding the first
+// k contents of req would hold, and a rough estimate of its tokens.
+func prefixHashes(model string, req *gemini.Request, n int) (hashes []string, tokens []uint64) {
+	h := sha256.New()
+	size := 0
+	write := func(v any) {
+		data, _ := json.Marshal(v)
+		h.Write(data)
+		size += len(data)
+	}
+	write(model)
+	write(req.SystemInstruction)
+	write(req.Tools)
+	for k := 0; ; k++ {
+		hashes = append(hashes, hex.EncodeToString(h.Sum(nil)))
+		// Very rough estimation: 1 token per 4 characters
+		tokens = append(tokens, uint64(size)/4)
+		if k == n {
+			return hashes, tokens
+		}
+		write(req.Contents[k])
+	}
+}
+
+// withContextCache returns req, or a copy of it that sends the part ir marks with Cache through a
+// context cache, creating one when that pays off. created is the size of a cache created for this
+// request, whose tokens the response reports as read from the cache.
+func (s *Service) withContextCache(ctx context.Context, model gemini.Model, ir *llm.Request, req *gemini.Request) (_ *gemini.Request, created uint64) {
+	n, ok := ir.CachePrefix()
+	// The request must keep some contents of its own
+	n = min(n, len(req.Contents)-1)
+	if !ok || n < 0 {
+		return req, 0
+	}
+	hashes, tokens := prefixHashes(model.Model, req, n)
+
+	now := time.Now()
+	s.cacheMu.Lock()
+	s.caches = slices.DeleteFunc(s.caches, func(c contextCache) bool {
+		return now.Add(contextCacheMargin).After(c.expires)
+	})
+	var best *contextCache
+	for _, c := range s.caches {
+		if c.contents <= n && c.prefix == hashes[c.contents] && (best == nil || c.contents > best.contents) {
+			best = &c
+		}
+	}
+	canCreate := now.After(s.cacheRetryAt)
+	s.cacheMu.Unlock()
+
+	// Cache a longer prefix once the uncached part is as long as the cached one,
+	// so that a growing conversation only needs a few caches
+	var cachedTokens uint64
+	if best != nil {
+		cachedTokens = tokens[best.contents]
+	}
+	if canCreate && tokens[n]-cachedTokens >= max(cachedTokens, s.minContextCacheTokens()) {
+		c, err := s.createContextCache(ctx, model, req, n, hashes[n], tokens[n])
+		if err != nil {
+			// The model may not support caching, or the estimate was too rough; don't keep trying
+			slog.WarnContext(ctx, "gemini_context_cache_failed", "error", err, "contents", n)
+			s.cacheMu.Lock()
+			s.cacheRetryAt = now.Add(contextCacheTTL)
+			s.cacheMu.Unlock()
+		} else {
+			best = c
+			created = c.tokens
+		}
+	}
+	if best == nil {
+		return req, 0
+	}
+
+	// The cache holds the system instruction and tools, which the request must then leave out
+	cached := *req
+	cached.SystemInstruction = nil
+	cached.Tools = nil
+	cached.Contents = req.Contents[best.contents:]
+	cached.CachedContent = best.name
+	return &cached, created
+}
+
+// createContextCache caches the first n contents of req, along with its system instruction and tools.
+func (s *Service) createContextCache(ctx context.Context, model gemini.Model, req *gemini.Request, n int, prefix string, estimate uint64) (*contextCache, error) {
+	res, err := model.CreateCachedContent(ctx, &gemini.CachedContent{
+		Contents:          req.Contents[:n],
+		Tools:             req.Tools,
+		SystemInstruction: req.SystemInstruction,
+		TTL:               fmt.Sprintf("%ds", int(contextCacheTTL.Seconds())),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := contextCache{
+		name:     res.Name,
+		prefix:   prefix,
+		contents: n,
+		tokens:   estimate,
+		expires:  res.ExpireTime,
+	}
+	if res.UsageMetadata != nil && res.UsageMetadata.TotalTokenCount > 0 {
+		c.tokens = res.UsageMetadata.TotalTokenCount
+	}
+	if c.expires.IsZero() {
+		c.expires = time.Now().Add(contextCacheTTL)
+	}
+	slog.DebugContext(ctx, "gemini_context_cache_created", "name", c.name, "contents", n, "tokens", c.tokens)
+	s.cacheMu.Lock()
+	s.caches = append(s.caches, c)
+	s.cacheMu.Unlock()
+	return &c, nil
+}
+
+// forgetContextCache stops using the named context cache, after a request using it failed.
+func (s *Service) forgetContextCache(name string) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	s.caches = slices.DeleteFunc(s.caches, func(c contextCache) bool { return c.name == name })
+}
@@ -0,0 +1,152 @@
+package gem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/llm/gem/gemini"
+)
+
+// fakeCachingAPI is enough of the Gemini API to create context caches and use them
+type fakeCachingAPI struct {
+	mu       sync.Mutex
+	caches   map[string]gemini.CachedContent
+	requests []gemini.Request
+}
+
+func (f *fakeCachingAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/cachedContents":
+		var cc gemini.CachedContent
+		json.NewDecoder(r.Body).Decode(&cc)
+		cc.Name = fmt.Sprintf("cachedContents/c%d", len(f.caches)+1)
+		cc.ExpireTime = time.Now().Add(5 * time.Minute)
+		cc.UsageMetadata = &gemini.CachedContentUsage{TotalTokenCount: 5000}
+		f.caches[cc.Name] = cc
+		json.NewEncoder(w).Encode(cc)
+	case strings.HasSuffix(r.URL.Path, ":generateContent"):
+		var req gemini.Request
+		json.NewDecoder(r.Body).Decode(&req)
+		f.requests = append(f.requests, req)
+		usage := &gemini.UsageMetadata{PromptTokenCount: 100, CandidatesTokenCount: 5}
+		if req.CachedContent != "" {
+			if _, ok := f.caches[req.CachedContent]; !ok {
+				http.Error(w, `{"error":{"code":403,"message":"CachedContent not found"}}`, http.StatusForbidden)
+				return
+			}
+			if req.SystemInstruction != nil || len(req.Tools) > 0 {
+				http.Error(w, `{"error":{"code":400,"message":"CachedContent can not be used with system_instruction or tools"}}`, http.StatusBadRequest)
+				return
+			}
+			usage.PromptTokenCount += 5000
+			usage.CachedContentTokenCount = 5000
+		}
+		json.NewEncoder(w).Encode(gemini.Response{
+			Candidates:    []gemini.Candidate{{Content: gemini.Content{Role: "model", Parts: []gemini.Part{{Text: "ok"}}}}},
+			UsageMetadata: usage,
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestContextCaching(t *testing.T) {
+	api := &fakeCachingAPI{caches: make(map[string]gemini.CachedContent)}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	svc := &Service{APIKey: "test-key", Model: Gemini25Flash, URL: server.URL}
+
+	// Requests are built like the loop builds them, with the last user message marked
+	system := []llm.SystemContent{{Type: "text", Text: strings.Repeat("Follow the rules. ", 1000)}}
+	var history []llm.Message
+	send := func(text string) *llm.Response {
+		t.Helper()
+		history = append(history, llm.UserStringMessage(text))
+		messages := append([]llm.Message(nil), history...)
+		last := messages[len(messages)-1]
+		last.Content = []llm.Content{last.Content[0]}
+		last.Content[0].Cache = true
+		messages[len(messages)-1] = last
+		resp, err := svc.Do(context.Background(), &llm.Request{System: system, Messages: messages})
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		history = append(history, llm.Message{Role: llm.MessageRoleAssistant, Content: resp.Content})
+		return resp
+	}
+
+	// The first request has nothing before its own message to cache but the system prompt
+	resp := send("hello")
+	if len(api.caches) != 1 {
+		t.Fatalf("created %d context caches, want 1", len(api.caches))
+	}
+	first := api.requests[len(api.requests)-1]
+	if first.CachedContent != "cachedContents/c1" || first.SystemInstruction != nil || len(first.Contents) != 1 {
+		t.Errorf("first request = %+v, want it to use the new cache for its system prompt", first)
+	}
+	if resp.Usage.CacheCreationInputTokens != 5000 || resp.Usage.CacheReadInputTokens != 0 || resp.Usage.InputTokens != 100 {
+		t.Errorf("first usage = %+v, want the cached tokens counted as written", resp.Usage)
+	}
+
+	// Later requests reuse the cache while what follows it is small
+	resp = send("and again")
+	if len(api.caches) != 1 {
+		t.Errorf("created %d context caches, want the first one reused", len(api.caches))
+	}
+	second := api.requests[len(api.requests)-1]
+	if second.CachedContent != "cachedContents/c1" || len(second.Contents) != 3 {
+		t.Errorf("second request uses %q with %d contents, want c1 with 3", second.CachedContent, len(second.Contents))
+	}
+	if resp.Usage.CacheReadInputTokens != 5000 || resp.Usage.CacheCreationInputTokens != 0 {
+		t.Errorf("second usage = %+v, want the cached tokens counted as read", resp.Usage)
+	}
+
+	// A cache that is gone is dropped, and the whole request sent instead
+	api.mu.Lock()
+	delete(api.caches, "cachedContents/c1")
+	api.mu.Unlock()
+	send("once more")
+	last := api.requests[len(api.requests)-1]
+	if last.CachedContent != "" || last.SystemInstruction == nil || len(last.Contents) != 5 {
+		t.Errorf("request after the cache was deleted = %+v, want the whole request", last)
+	}
+	if len(svc.caches) != 0 {
+		t.Errorf("service still has %d context caches, want the deleted one forgotten", len(svc.caches))
+	}
+
+	// Requests that ask for no caching don't use caches
+	before := len(api.requests)
+	if _, err := svc.Do(context.Background(), &llm.Request{System: system, Messages: []llm.Message{llm.UserStringMessage("hi")}}); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if req := api.requests[before]; req.CachedContent != "" {
+		t.Errorf("uncached request used %q", req.CachedContent)
+	}
+}
+
+func TestContextCachingTooSmall(t *testing.T) {
+	api := &fakeCachingAPI{caches: make(map[string]gemini.CachedContent)}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	svc := &Service{APIKey: "test-key", Model: Gemini25Pro, URL: server.URL}
+
+	msg := llm.UserStringMessage("hi")
+	msg.Content[0].Cache = true
+	req := &llm.Request{System: []llm.SystemContent{{Type: "text", Text: "Be brief.", Cache: true}}, Messages: []llm.Message{msg}}
+	if _, err := svc.Do(context.Background(), req); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if len(api.caches) != 0 || api.requests[0].CachedContent != "" {
+		t.Errorf("cached a prefix below the model's minimum")
+	}
+}
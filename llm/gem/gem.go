@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"shelley.exe.dev/llm"
@@ -16,7 +17,10 @@ import (
 )
 
 const (
-	DefaultModel    = "gemini-2.5-pro-preview-03-25"
+	Gemini25Pro   = "gemini-2.5-pro"
+	Gemini25Flash = "gemini-2.5-flash"
+
+	DefaultModel    = Gemini25Pro
 	GeminiAPIKeyEnv = "GEMINI_API_KEY"
 )
 
@@ -28,9 +32,20 @@ type Service struct {
 	APIKey  string       // must be non-empty
 	Model   string       // defaults to DefaultModel if empty
 	DumpLLM bool         // whether to dump request/response text to files for debugging; defaults to false
+	// ContextWindow overrides the context window size known for Model, if non-zero
+	ContextWindow int
+	// APIKeyFunc, if set, returns the key for each request in place of APIKey, so that it can be rotated
+	APIKeyFunc func() string
+
+	cacheMu      sync.Mutex
+	caches       []contextCache // live context caches, see withContextCache
+	cacheRetryAt time.Time      // when to try creating context caches again after failing to
 }
 
-var _ llm.Service = (*Service)(nil)
+var (
+	_ llm.Service          = (*Service)(nil)
+	_ llm.StreamingService = (*Service)(nil)
+)
 
 // These maps convert between Sketch's llm package and Gemini API formats
 var fromLLMRole = map[llm.MessageRole]string{
@@ -141,6 +156,14 @@ func convertJSONSchemaToGeminiSchema(schemaJSON map[string]any) gemini.Schema {
 	return schema
 }
 
+// maxThinkingBudget returns the largest thinking budget the model accepts.
+func (s *Service) maxThinkingBudget() int {
+	if strings.Contains(cmp.Or(s.Model, DefaultModel), "flash") {
+		return 24576
+	}
+	return 32768
+}
+
 // buildGeminiRequest converts Sketch's llm.Request to Gemini's request format
 func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error) {
 	gemReq := &gemini.Request{}
@@ -163,6 +186,17 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 		}
 	}
 
+	// Function responses are matched to calls by name, and tool results usually
+	// only carry the ID of their tool use, which is in an earlier message.
+	toolNames := make(map[string]string) // tool use ID -> tool name
+	for _, msg := range req.Messages {
+		for _, c := range msg.Content {
+			if c.Type == llm.ContentTypeToolUse && c.ID != "" {
+				toolNames[c.ID] = c.ToolName
+			}
+		}
+	}
+
 	// Convert messages to Gemini content format
 	for _, msg := range req.Messages {
 		// Set the role based on the message role
@@ -175,20 +209,17 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 			Role: role,
 		}
 
-		// Store tool usage information to correlate tool uses with responses
-		toolNameToID := make(map[string]string)
-
-		// First pass: collect tool use IDs for correlation
-		for _, c := range msg.Content {
-			if c.Type == llm.ContentTypeToolUse && c.ID != "" {
-				toolNameToID[c.ToolName] = c.ID
-			}
-		}
-
 		// Map each content item to Gemini's format
 		for _, c := range msg.Content {
 			switch c.Type {
 			case llm.ContentTypeText, llm.ContentTypeThinking, llm.ContentTypeRedactedThinking:
+				// Images are represented as text with MediaType and Data
+				if c.MediaType != "" {
+					content.Parts = append(content.Parts, gemini.Part{
+						InlineData: &gemini.Blob{MimeType: c.MediaType, Data: c.Data},
+					})
+					continue
+				}
 				// Simple text content
 				content.Parts = append(content.Parts, gemini.Part{
 					Text: c.Text,
@@ -200,14 +231,6 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 					return nil, fmt.Errorf("failed to unmarshal tool input: %w", err)
 				}
 
-				// Make sure we have a valid ID for this tool use
-				if c.ID == "" {
-					c.ID = fmt.Sprintf("gemini_tool_%s_%d", c.ToolName, time.Now().UnixNano())
-				}
-
-				// Save the ID for this tool name for future correlation
-				toolNameToID[c.ToolName] = c.ID
-
 				slog.DebugContext(context.Background(), "gemini_preparing_tool_use",
 					"tool_name", c.ToolName,
 					"tool_id", c.ID,
@@ -228,12 +251,18 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 
 				// Handle tool results: Gemini only supports string results
 				// Combine all text content into a single string
+				// Images can't go in a function response, so they follow it as inline data
 				var resultText string
+				var images []gemini.Part
 				if len(c.ToolResult) > 0 {
 					// Collect all text from content objects
 					texts := make([]string, 0, len(c.ToolResult))
 					for _, result := range c.ToolResult {
-						if result.Text != "" {
+						if result.MediaType != "" {
+							images = append(images, gemini.Part{
+								InlineData: &gemini.Blob{MimeType: result.MediaType, Data: result.Data},
+							})
+						} else if result.Text != "" {
 							texts = append(texts, result.Text)
 						}
 					}
@@ -242,18 +271,7 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 				response["result"] = resultText
 
 				// Determine the function name to use - this is critical
-				funcName := ""
-
-				// First try to find the function name from a stored toolUseID if we have one
-				if c.ToolUseID != "" {
-					// Try to derive the tool name from the previous tools we've seen
-					for name, id := range toolNameToID {
-						if id == c.ToolUseID {
-							funcName = name
-							break
-						}
-					}
-				}
+				funcName := toolNames[c.ToolUseID]
 
 				// Fallback options if we couldn't find the tool name
 				if funcName == "" {
@@ -277,6 +295,7 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 						Response: response,
 					},
 				})
+				content.Parts = append(content.Parts, images...)
 			}
 		}
 
@@ -295,6 +314,25 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 		}
 	}
 
+	if req.Thinking != nil {
+		gemReq.GenerationConfig = &gemini.GenerationConfig{
+			ThinkingConfig: &gemini.ThinkingConfig{ThinkingBudget: min(req.Thinking.Budget(), s.maxThinkingBudget())},
+		}
+	}
+
+	if rf := req.ResponseFormat; rf != nil {
+		var schemaJSON map[string]any
+		if err := json.Unmarshal(rf.Schema, &schemaJSON); err != nil {
+			return nil, fmt.Errorf("invalid response schema %s: %w", rf.Name, err)
+		}
+		responseSchema := convertJSONSchemaToGeminiSchema(schemaJSON)
+		if gemReq.GenerationConfig == nil {
+			gemReq.GenerationConfig = &gemini.GenerationConfig{}
+		}
+		gemReq.GenerationConfig.ResponseMimeType = "application/json"
+		gemReq.GenerationConfig.ResponseSchema = &responseSchema
+	}
+
 	return gemReq, nil
 }
 
@@ -373,7 +411,6 @@ func convertGeminiResponseToContent(res *gemini.Response) []llm.Content {
 	return contents
 }
 
-// Gemini doesn't provide usage info directly, so we need to estimate it
 // ensureToolIDs makes sure all tool uses have proper IDs
 func ensureToolIDs(contents []llm.Content) {
 	for i, content := range contents {
@@ -388,7 +425,16 @@ func ensureToolIDs(contents []llm.Content) {
 }
 
 func calculateUsage(req *gemini.Request, res *gemini.Response) llm.Usage {
-	// Very rough estimation of token counts
+	if res != nil && res.UsageMetadata != nil {
+		um := res.UsageMetadata
+		return llm.Usage{
+			InputTokens:          um.PromptTokenCount - min(um.CachedContentTokenCount, um.PromptTokenCount),
+			CacheReadInputTokens: um.CachedContentTokenCount,
+			OutputTokens:         um.CandidatesTokenCount + um.ThoughtsTokenCount,
+		}
+	}
+
+	// Very rough estimation of token counts, for responses without usage metadata
 	var inputTokens uint64
 	var outputTokens uint64
 
@@ -438,8 +484,19 @@ func calculateUsage(req *gemini.Request, res *gemini.Response) llm.Usage {
 	}
 }
 
+// apiKey returns the API key to send with a request.
+func (s *Service) apiKey() string {
+	if s.APIKeyFunc != nil {
+		return s.APIKeyFunc()
+	}
+	return s.APIKey
+}
+
 // TokenContextWindow returns the maximum token context window size for this service
 func (s *Service) TokenContextWindow() int {
+	if s.ContextWindow > 0 {
+		return s.ContextWindow
+	}
 	model := s.Model
 	if model == "" {
 		model = DefaultModel
@@ -447,6 +504,8 @@ func (s *Service) TokenContextWindow() int {
 
 	// Gemini models generally have large context windows
 	switch model {
+	case Gemini25Pro, Gemini25Flash:
+		return 1048576 // 1M tokens for Gemini 2.5 Pro and Flash
 	case "gemini-2.5-pro-preview-03-25":
 		return 1000000 // 1M tokens for Gemini 2.5 Pro
 	case "gemini-2.0-flash-exp":
@@ -462,13 +521,55 @@ func (s *Service) TokenContextWindow() int {
 }
 
 // MaxImageDimension returns the maximum allowed image dimension.
-// TODO: determine actual Gemini image dimension limits
+// Gemini documents no pixel limit for images. What it does limit is the size of a
+// request with inline data, such as our images, to 20MB (see "Image understanding"
+// in the Gemini API docs), and it bills large images per 768x768 tile. 3072 pixels,
+// four tiles, is our own choice, which keeps screenshots well under that size.
 func (s *Service) MaxImageDimension() int {
-	return 0 // No known limit
+	return 3072
 }
 
 // Do sends a request to Gemini.
 func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error) {
+	return s.do(ctx, ir, nil)
+}
+
+// DoStream sends a streaming request to Gemini, calling onDelta as content arrives.
+func (s *Service) DoStream(ctx context.Context, ir *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	return s.do(ctx, ir, onDelta)
+}
+
+// streamDeltas returns a chunk callback that converts streamed parts to deltas.
+// Indexes match the positions of the parts in the merged response.
+func streamDeltas(onDelta func(llm.StreamDelta)) func(*gemini.Response) {
+	parts := 0
+	lastText := false
+	return func(chunk *gemini.Response) {
+		if len(chunk.Candidates) == 0 {
+			return
+		}
+		for _, part := range chunk.Candidates[0].Content.Parts {
+			switch {
+			case part.Text != "" && part.FunctionCall == nil:
+				if !lastText {
+					parts++
+				}
+				lastText = true
+				onDelta(llm.StreamDelta{Index: parts - 1, Type: llm.ContentTypeText, Text: part.Text})
+			case part.FunctionCall != nil:
+				args, _ := json.Marshal(part.FunctionCall.Args)
+				onDelta(llm.StreamDelta{Index: parts, Type: llm.ContentTypeToolUse, ToolName: part.FunctionCall.Name, Text: string(args)})
+				parts++
+				lastText = false
+			default:
+				parts++
+				lastText = false
+			}
+		}
+	}
+}
+
+func (s *Service) do(ctx context.Context, ir *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
 	// Log the incoming request for debugging
 	slog.DebugContext(ctx, "gemini_request",
 		"message_count", len(ir.Messages),
@@ -523,7 +624,7 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 		if s.DumpLLM {
 			// Construct the same URL that the Gemini client will use
 			endpoint := cmp.Or(s.URL, "https://generativelanguage.googleapis.com/v1beta")
-			url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", endpoint, cmp.Or(s.Model, DefaultModel), s.APIKey)
+			url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", endpoint, cmp.Or(s.Model, DefaultModel), s.apiKey())
 			if err := llm.DumpToFile("request", url, reqJSON); err != nil {
 				slog.WarnContext(ctx, "failed to dump gemini request to file", "error", err)
 			}
@@ -534,10 +635,14 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 	model := gemini.Model{
 		Model:    "models/" + cmp.Or(s.Model, DefaultModel),
 		Endpoint: s.URL,
-		APIKey:   s.APIKey,
+		APIKey:   s.apiKey(),
 		HTTPC:    cmp.Or(s.HTTPC, http.DefaultClient),
 	}
 
+	// Send the cacheable prefix of the request through a context cache if possible
+	fullReq := gemReq
+	gemReq, cacheCreated := s.withContextCache(ctx, model, ir, gemReq)
+
 	// Send the request to Gemini with retry logic
 	startTime := time.Now()
 	endTime := startTime // Initialize endTime
@@ -547,9 +652,32 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 	backoff := []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second, 10 * time.Second}
 	for attempts := 0; attempts <= len(backoff); attempts++ {
 		gemApiErr := error(nil)
-		gemRes, gemApiErr = model.GenerateContent(ctx, gemReq)
+		streamed := false
+		attemptCtx := llm.WithAttempt(ctx, attempts+1)
+		if onDelta != nil {
+			deltas := streamDeltas(onDelta)
+			gemRes, gemApiErr = model.StreamGenerateContent(attemptCtx, gemReq, func(chunk *gemini.Response) {
+				streamed = true
+				deltas(chunk)
+			})
+		} else {
+			gemRes, gemApiErr = model.GenerateContent(attemptCtx, gemReq)
+		}
 		endTime = time.Now()
 
+		if gemApiErr != nil && streamed {
+			// Deltas have already been delivered, so a failed stream is not retried.
+			return nil, fmt.Errorf("gemini: stream error: %w", gemApiErr)
+		}
+
+		if gemApiErr != nil && gemReq != fullReq {
+			// The context cache may have expired or been deleted, so send the whole request instead
+			slog.WarnContext(ctx, "gemini_context_cache_request_failed", "cache", gemReq.CachedContent, "error", gemApiErr.Error())
+			s.forgetContextCache(gemReq.CachedContent)
+			gemReq, cacheCreated = fullReq, 0
+			continue
+		}
+
 		if gemApiErr == nil {
 			// Successful response
 			// Log the structured Gemini response
@@ -589,6 +717,12 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 
 	usage := calculateUsage(gemReq, gemRes)
 	usage.CostUSD = llm.CostUSDFromResponse(gemRes.Header())
+	if cacheCreated > 0 {
+		// What this request cached was written, not read, as far as the conversation is concerned
+		written := min(cacheCreated, usage.CacheReadInputTokens)
+		usage.CacheReadInputTokens -= written
+		usage.CacheCreationInputTokens += written
+	}
 
 	stopReason := llm.StopReasonEndTurn
 	for _, part := range content {
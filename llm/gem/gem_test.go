@@ -2,14 +2,20 @@ package gem
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"io/fs"
 	"net/http"
+	"os"
+	"strings"
 	"testing"
 
 	"shelley.exe.dev/llm"
 	"shelley.exe.dev/llm/gem/gemini"
+	"sketch.dev/httprr"
 )
 
 func TestBuildGeminiRequest(t *testing.T) {
@@ -364,3 +370,229 @@ func TestHeaderCostIntegration(t *testing.T) {
 		t.Fatalf("Expected output tokens to be estimated, got 0")
 	}
 }
+
+func TestDoStream(t *testing.T) {
+	body := `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Let me "}]}}]}
+
+data: {"candidates":[{"content":{"role":"model","parts":[{"text":"look."}]}}]}
+
+data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"bash","args":{"command":"ls"}}}]}}]}
+
+`
+	service := &Service{
+		Model:  "gemini-test",
+		APIKey: "test-key",
+		URL:    "https://test.googleapis.com",
+		HTTPC: &http.Client{
+			Transport: &mockRoundTripper{
+				response: &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+					Body:       io.NopCloser(bytes.NewBufferString(body)),
+				},
+			},
+		},
+	}
+
+	var deltas []llm.StreamDelta
+	res, err := service.DoStream(context.Background(), &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("list files")},
+	}, func(d llm.StreamDelta) {
+		deltas = append(deltas, d)
+	})
+	if err != nil {
+		t.Fatalf("DoStream: %v", err)
+	}
+
+	if len(deltas) != 3 {
+		t.Fatalf("Expected 3 deltas, got %d: %+v", len(deltas), deltas)
+	}
+	if deltas[0].Index != 0 || deltas[1].Index != 0 || deltas[2].Index != 1 {
+		t.Fatalf("Unexpected delta indexes: %+v", deltas)
+	}
+	if deltas[2].Type != llm.ContentTypeToolUse || deltas[2].ToolName != "bash" {
+		t.Fatalf("Unexpected tool delta: %+v", deltas[2])
+	}
+
+	if len(res.Content) != 2 {
+		t.Fatalf("Expected 2 content blocks, got %d", len(res.Content))
+	}
+	if res.Content[0].Text != "Let me look." {
+		t.Fatalf("Expected merged text, got %q", res.Content[0].Text)
+	}
+	if res.StopReason != llm.StopReasonToolUse {
+		t.Fatalf("Expected tool use stop reason, got %v", res.StopReason)
+	}
+}
+
+func TestBuildGeminiRequestImages(t *testing.T) {
+	service := &Service{Model: Gemini25Flash, APIKey: "test-key"}
+	gemReq, err := service.buildGeminiRequest(&llm.Request{
+		Messages: []llm.Message{
+			{Role: llm.MessageRoleUser, Content: []llm.Content{
+				{Type: llm.ContentTypeText, Text: "what is this?"},
+				{Type: llm.ContentTypeText, MediaType: "image/png", Data: "aW1hZ2U="},
+			}},
+			{Role: llm.MessageRoleAssistant, Content: []llm.Content{
+				{Type: llm.ContentTypeToolUse, ID: "call_1", ToolName: "browser_take_screenshot", ToolInput: json.RawMessage(`{}`)},
+			}},
+			{Role: llm.MessageRoleUser, Content: []llm.Content{
+				{Type: llm.ContentTypeToolResult, ToolUseID: "call_1", ToolName: "browser_take_screenshot", ToolResult: []llm.Content{
+					{Type: llm.ContentTypeText, Text: "Took a screenshot"},
+					{Type: llm.ContentTypeText, MediaType: "image/jpeg", Data: "c2hvdA=="},
+				}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("buildGeminiRequest: %v", err)
+	}
+
+	parts := gemReq.Contents[0].Parts
+	if len(parts) != 2 || parts[1].InlineData == nil || parts[1].InlineData.MimeType != "image/png" || parts[1].Text != "" {
+		t.Fatalf("Expected text and inline image parts, got %+v", parts)
+	}
+	parts = gemReq.Contents[2].Parts
+	if len(parts) != 2 || parts[0].FunctionResponse == nil || parts[1].InlineData == nil || parts[1].InlineData.Data != "c2hvdA==" {
+		t.Fatalf("Expected a function response followed by the screenshot, got %+v", parts)
+	}
+	if got := parts[0].FunctionResponse.Response["result"]; got != "Took a screenshot" {
+		t.Fatalf("Expected only text in the function response, got %q", got)
+	}
+}
+
+func TestCalculateUsageFromMetadata(t *testing.T) {
+	usage := calculateUsage(&gemini.Request{}, &gemini.Response{
+		UsageMetadata: &gemini.UsageMetadata{
+			PromptTokenCount:        1200,
+			CachedContentTokenCount: 1000,
+			CandidatesTokenCount:    50,
+			ThoughtsTokenCount:      30,
+		},
+	})
+	want := llm.Usage{InputTokens: 200, CacheReadInputTokens: 1000, OutputTokens: 80}
+	if usage != want {
+		t.Fatalf("calculateUsage() = %+v, want %+v", usage, want)
+	}
+}
+
+// TestServiceRecorded replays a tool call round trip with each Gemini model.
+// To record it, run with GEMINI_API_KEY set and -httprecord=testdata/basic.httprr.
+func TestServiceRecorded(t *testing.T) {
+	const trace = "testdata/basic.httprr"
+	recording, err := httprr.Recording(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(trace); !recording && errors.Is(err, fs.ErrNotExist) {
+		t.Skipf("%s has not been recorded yet; run with GEMINI_API_KEY set and -httprecord=%s", trace, trace)
+	}
+	rr, err := httprr.Open(trace, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	rr.ScrubReq(func(req *http.Request) error {
+		q := req.URL.Query()
+		q.Del("key")
+		req.URL.RawQuery = q.Encode()
+		return nil
+	})
+
+	weatherTool := &llm.Tool{
+		Name:        "get_weather",
+		Description: "Get the current weather for a city.",
+		InputSchema: llm.MustSchema(`{"type":"object","properties":{"city":{"type":"string","description":"City name"}},"required":["city"]}`),
+	}
+
+	for _, model := range []string{Gemini25Pro, Gemini25Flash} {
+		t.Run(model, func(t *testing.T) {
+			service := &Service{
+				APIKey: cmp.Or(os.Getenv(GeminiAPIKeyEnv), "test-key"),
+				Model:  model,
+				HTTPC:  rr.Client(),
+			}
+			req := &llm.Request{
+				System:   []llm.SystemContent{{Type: "text", Text: "You are a helpful assistant. Use tools when they help."}},
+				Messages: []llm.Message{llm.UserStringMessage("What is the weather in Paris right now?")},
+				Tools:    []*llm.Tool{weatherTool},
+			}
+
+			res, err := service.Do(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			if res.StopReason != llm.StopReasonToolUse {
+				t.Fatalf("Expected a tool call, got %+v", res.Content)
+			}
+			var call llm.Content
+			for _, c := range res.Content {
+				if c.Type == llm.ContentTypeToolUse {
+					call = c
+				}
+			}
+			if call.ToolName != "get_weather" || !strings.Contains(string(call.ToolInput), "Paris") {
+				t.Fatalf("Unexpected tool call %+v", call)
+			}
+			if res.Usage.InputTokens == 0 || res.Usage.OutputTokens == 0 {
+				t.Fatalf("Expected usage from the response, got %+v", res.Usage)
+			}
+
+			req.Messages = append(req.Messages, res.ToMessage(), llm.Message{
+				Role: llm.MessageRoleUser,
+				Content: []llm.Content{{
+					Type:       llm.ContentTypeToolResult,
+					ToolUseID:  call.ID,
+					ToolResult: []llm.Content{{Type: llm.ContentTypeText, Text: "18°C and sunny"}},
+				}},
+			})
+			res, err = service.Do(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Do with tool result: %v", err)
+			}
+			if res.StopReason != llm.StopReasonEndTurn || !strings.Contains(res.Content[0].Text, "18") {
+				t.Fatalf("Expected an answer using the tool result, got %+v", res.Content)
+			}
+		})
+	}
+}
+
+func TestBuildGeminiRequestThinking(t *testing.T) {
+	req := &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("hi")},
+		Thinking: &llm.Thinking{Effort: llm.ThinkingEffortHigh},
+	}
+	for model, want := range map[string]int{Gemini25Pro: 32768, Gemini25Flash: 24576} {
+		gemReq, err := (&Service{Model: model}).buildGeminiRequest(req)
+		if err != nil {
+			t.Fatalf("buildGeminiRequest: %v", err)
+		}
+		if gemReq.GenerationConfig == nil || gemReq.GenerationConfig.ThinkingConfig == nil {
+			t.Fatalf("%s: no thinking config", model)
+		}
+		if got := gemReq.GenerationConfig.ThinkingConfig.ThinkingBudget; got != want {
+			t.Errorf("%s: thinking budget = %d, want %d", model, got, want)
+		}
+	}
+}
+
+func TestBuildGeminiRequestResponseFormat(t *testing.T) {
+	req := &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("hi")},
+		ResponseFormat: &llm.ResponseFormat{
+			Name:   "conversation_slug",
+			Schema: llm.MustSchema(`{"type": "object", "required": ["slug"], "properties": {"slug": {"type": "string"}}}`),
+		},
+	}
+	gemReq, err := (&Service{}).buildGeminiRequest(req)
+	if err != nil {
+		t.Fatalf("buildGeminiRequest: %v", err)
+	}
+	config := gemReq.GenerationConfig
+	if config == nil || config.ResponseMimeType != "application/json" || config.ResponseSchema == nil {
+		t.Fatalf("generation config = %+v, want a JSON response schema", config)
+	}
+	if _, ok := config.ResponseSchema.Properties["slug"]; !ok || len(config.ResponseSchema.Required) != 1 {
+		t.Errorf("response schema = %+v", config.ResponseSchema)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
+
+	"shelley.exe.dev/llm"
 )
 
 // https://ai.google.dev/api/generate-content#request-body
@@ -19,10 +22,39 @@ type Request struct {
 	// ToolConfig has been left out because it does not appear to be useful.
 }
 
+// CachedContent is a prefix of requests, preprocessed so that requests naming it in their
+// CachedContent field are cheaper. Requests using it must leave out its system instruction and tools.
+// https://ai.google.dev/api/caching#CachedContent
+type CachedContent struct {
+	Name              string              `json:"name,omitempty"`  // format: "cachedContents/{id}", set by the API
+	Model             string              `json:"model,omitempty"` // format: "models/{model}"
+	Contents          []Content           `json:"contents,omitempty"`
+	Tools             []Tool              `json:"tools,omitempty"`
+	SystemInstruction *Content            `json:"systemInstruction,omitempty"`
+	TTL               string              `json:"ttl,omitempty"` // e.g. "300s"
+	ExpireTime        time.Time           `json:"expireTime,omitzero"`
+	UsageMetadata     *CachedContentUsage `json:"usageMetadata,omitempty"`
+}
+
+// https://ai.google.dev/api/caching#UsageMetadata
+type CachedContentUsage struct {
+	TotalTokenCount uint64 `json:"totalTokenCount"`
+}
+
 // https://ai.google.dev/api/generate-content#response-body
 type Response struct {
-	Candidates []Candidate `json:"candidates"`
-	headers    http.Header // captured HTTP response headers
+	Candidates    []Candidate    `json:"candidates"`
+	UsageMetadata *UsageMetadata `json:"usageMetadata,omitempty"`
+	headers       http.Header    // captured HTTP response headers
+}
+
+// https://ai.google.dev/api/generate-content#UsageMetadata
+type UsageMetadata struct {
+	PromptTokenCount        uint64 `json:"promptTokenCount"`
+	CachedContentTokenCount uint64 `json:"cachedContentTokenCount,omitempty"` // included in PromptTokenCount
+	CandidatesTokenCount    uint64 `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      uint64 `json:"thoughtsTokenCount,omitempty"`
+	TotalTokenCount         uint64 `json:"totalTokenCount"`
 }
 
 // Header returns the HTTP response headers.
@@ -47,10 +79,16 @@ type Part struct {
 	FunctionResponse    *FunctionResponse    `json:"functionResponse,omitempty"`
 	ExecutableCode      *ExecutableCode      `json:"executableCode,omitempty"`
 	CodeExecutionResult *CodeExecutionResult `json:"codeExecutionResult,omitempty"`
-	// TODO inlineData
+	InlineData          *Blob                `json:"inlineData,omitempty"`
 	// TODO fileData
 }
 
+// Blob is inline media, such as an image.
+type Blob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"` // base64-encoded
+}
+
 type FunctionCall struct {
 	Name string         `json:"name"`
 	Args map[string]any `json:"args"`
@@ -89,8 +127,14 @@ const (
 
 // https://ai.google.dev/api/generate-content#v1beta.GenerationConfig
 type GenerationConfig struct {
-	ResponseMimeType string  `json:"responseMimeType,omitempty"` // text/plain, application/json, or text/x.enum
-	ResponseSchema   *Schema `json:"responseSchema,omitempty"`   // for JSON
+	ResponseMimeType string          `json:"responseMimeType,omitempty"` // text/plain, application/json, or text/x.enum
+	ResponseSchema   *Schema         `json:"responseSchema,omitempty"`   // for JSON
+	ThinkingConfig   *ThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+// https://ai.google.dev/api/generate-content#ThinkingConfig
+type ThinkingConfig struct {
+	ThinkingBudget int `json:"thinkingBudget"` // tokens; -1 lets the model decide
 }
 
 // https://ai.google.dev/api/caching#Tool
@@ -162,7 +206,7 @@ func (m Model) GenerateContent(ctx context.Context, req *Request) (*Response, er
 		return nil, fmt.Errorf("GenerateContent: reading response body: %w", err)
 	}
 	if httpResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GenerateContent: HTTP status: %d, %s", httpResp.StatusCode, string(body))
+		return nil, llm.StatusErrorf(httpResp.StatusCode, "GenerateContent: HTTP status: %d, %s", httpResp.StatusCode, string(body))
 	}
 	var res Response
 	if err := json.Unmarshal(body, &res); err != nil {
@@ -172,6 +216,109 @@ func (m Model) GenerateContent(ctx context.Context, req *Request) (*Response, er
 	return &res, nil
 }
 
+// CreateCachedContent caches cc for the model, returning it with its name and expiry set.
+// The model in cc defaults to m's.
+func (m Model) CreateCachedContent(ctx context.Context, cc *CachedContent) (*CachedContent, error) {
+	if cc.Model == "" {
+		withModel := *cc
+		withModel.Model = m.Model
+		cc = &withModel
+	}
+	reqBytes, err := json.Marshal(cc)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling cached content: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/cachedContents?key=%s", m.endpoint(), m.APIKey), bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	httpReq.Header.Add("Content-Type", "application/json")
+	httpResp, err := m.httpc().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("CreateCachedContent: do: %w", err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("CreateCachedContent: reading response body: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, llm.StatusErrorf(httpResp.StatusCode, "CreateCachedContent: HTTP status: %d, %s", httpResp.StatusCode, string(body))
+	}
+	var res CachedContent
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("CreateCachedContent: unmarshaling response: %w, %s", err, string(body))
+	}
+	if res.Name == "" {
+		return nil, fmt.Errorf("CreateCachedContent: response has no name: %s", string(body))
+	}
+	return &res, nil
+}
+
+// StreamGenerateContent is like GenerateContent, but streams the response,
+// calling onChunk with each partial response as it arrives.
+// The returned Response merges all chunks, joining consecutive text parts.
+func (m Model) StreamGenerateContent(ctx context.Context, req *Request, onChunk func(*Response)) (*Response, error) {
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/%s:streamGenerateContent?alt=sse&key=%s", m.endpoint(), m.Model, m.APIKey), bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP request: %w", err)
+	}
+	httpReq.Header.Add("Content-Type", "application/json")
+	httpResp, err := m.httpc().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("StreamGenerateContent: do: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(httpResp.Body)
+		return nil, llm.StatusErrorf(httpResp.StatusCode, "StreamGenerateContent: HTTP status: %d, %s", httpResp.StatusCode, string(body))
+	}
+	merged := &Response{headers: httpResp.Header}
+	err = llm.ReadSSE(httpResp.Body, func(_ string, data []byte) error {
+		var chunk Response
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("unmarshaling chunk: %w, %s", err, string(data))
+		}
+		onChunk(&chunk)
+		merged.merge(&chunk)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("StreamGenerateContent: %w", err)
+	}
+	return merged, nil
+}
+
+// merge appends the parts of the first candidate of chunk to r.
+// Each chunk reports usage so far, so the latest usage replaces any earlier one.
+func (r *Response) merge(chunk *Response) {
+	if chunk.UsageMetadata != nil {
+		r.UsageMetadata = chunk.UsageMetadata
+	}
+	if len(chunk.Candidates) == 0 {
+		return
+	}
+	if len(r.Candidates) == 0 {
+		r.Candidates = []Candidate{{Content: Content{Role: chunk.Candidates[0].Content.Role}}}
+	}
+	content := &r.Candidates[0].Content
+	for _, part := range chunk.Candidates[0].Content.Parts {
+		if n := len(content.Parts); n > 0 && isText(part) && isText(content.Parts[n-1]) {
+			content.Parts[n-1].Text += part.Text
+			continue
+		}
+		content.Parts = append(content.Parts, part)
+	}
+}
+
+func isText(p Part) bool {
+	return p.Text != "" && p.FunctionCall == nil && p.FunctionResponse == nil && p.ExecutableCode == nil && p.CodeExecutionResult == nil && p.InlineData == nil
+}
+
 func (m Model) endpoint() string {
 	if m.Endpoint != "" {
 		return m.Endpoint
@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPExchange is an HTTP request to an LLM provider and its response, as seen by a RecordingTransport.
+type HTTPExchange struct {
+	URL          string // with API keys removed
+	RequestBody  []byte
+	ResponseBody []byte // as much of it as was read
+	StatusCode   int    // zero if no response was received
+	Err          error  // why the request failed or its response could not be read
+	Start        time.Time
+	Duration     time.Duration // until the response body was read or closed
+	Attempt      int           // 1 for the first attempt at a request, 2 for its first retry, and so on
+}
+
+// HTTPRecorder receives the exchanges of a RecordingTransport, with the context of their request.
+type HTTPRecorder func(ctx context.Context, ex HTTPExchange)
+
+// RecordingTransport is an http.RoundTripper that passes every exchange it sends to Record.
+// Services record their HTTP traffic by sending it through a client using it, see RecordingClient.
+//
+// An exchange is recorded once its response body has been read to the end or closed, so streaming is
+// unaffected. Since services are done with the body by the time they return, the exchange is always
+// recorded before the service's Do or DoStream returns.
+type RecordingTransport struct {
+	Transport http.RoundTripper // defaults to http.DefaultTransport if nil
+	Record    HTTPRecorder
+}
+
+// RecordingClient returns a copy of httpc, or of http.DefaultClient if httpc is nil,
+// that records through record.
+func RecordingClient(httpc *http.Client, record HTTPRecorder) *http.Client {
+	if httpc == nil {
+		httpc = http.DefaultClient
+	}
+	c := *httpc
+	c.Transport = &RecordingTransport{Transport: httpc.Transport, Record: record}
+	return &c
+}
+
+// RoundTrip sends req and records the exchange.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	ex := HTTPExchange{
+		URL:     redactURL(req),
+		Start:   time.Now(),
+		Attempt: Attempt(ctx),
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		ex.RequestBody = body
+		req = req.Clone(ctx)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		ex.Err = err
+		ex.Duration = time.Since(ex.Start)
+		t.Record(ctx, ex)
+		return nil, err
+	}
+	ex.StatusCode = resp.StatusCode
+	resp.Body = NewRecordedBody(resp.Body, func(body []byte, err error) {
+		ex.ResponseBody = body
+		ex.Err = err
+		ex.Duration = time.Since(ex.Start)
+		t.Record(ctx, ex)
+	})
+	return resp, nil
+}
+
+// NewRecordedBody returns a response body that passes body through, keeping a copy, and calls
+// done with the copy once reading it is done: at EOF, on an error, which done gets, or when the
+// body is closed.
+func NewRecordedBody(body io.ReadCloser, done func(body []byte, err error)) io.ReadCloser {
+	return &recordedBody{body: body, done: done}
+}
+
+// recordedBody passes a response body through, keeping a copy to record once reading it is done.
+type recordedBody struct {
+	body io.ReadCloser
+	buf  bytes.Buffer
+	done func(body []byte, err error)
+	once sync.Once
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.buf.Write(p[:n])
+	switch {
+	case err == io.EOF:
+		b.once.Do(func() { b.done(b.buf.Bytes(), nil) })
+	case err != nil:
+		b.once.Do(func() { b.done(b.buf.Bytes(), err) })
+	}
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	// Streams may be closed once their final event has been read, before EOF
+	b.once.Do(func() { b.done(b.buf.Bytes(), nil) })
+	return b.body.Close()
+}
+
+// redactURL returns req's URL without the API key Gemini takes as a query parameter.
+// Other providers take keys in headers, which are not recorded.
+func redactURL(req *http.Request) string {
+	q := req.URL.Query()
+	if !q.Has("key") {
+		return req.URL.String()
+	}
+	q.Set("key", "REDACTED")
+	u := *req.URL
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+type attemptCtxKeyType string
+
+const attemptCtxKey attemptCtxKeyType = "attempt"
+
+// WithAttempt returns a context for the given attempt at a request, 1 for the first,
+// so that a RecordingTransport can tell retries apart.
+func WithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptCtxKey, attempt)
+}
+
+// Attempt returns the attempt set with WithAttempt, or 1 if there is none.
+func Attempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptCtxKey).(int); ok {
+		return attempt
+	}
+	return 1
+}
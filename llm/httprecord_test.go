@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		io.WriteString(w, "reply to "+string(body))
+	}))
+	defer server.Close()
+
+	var exchanges []HTTPExchange
+	client := RecordingClient(nil, func(ctx context.Context, ex HTTPExchange) {
+		if ConversationID(ctx) != "c1" {
+			t.Errorf("recorder got the context of another request")
+		}
+		exchanges = append(exchanges, ex)
+	})
+	ctx := WithConversationID(context.Background(), "c1")
+	post := func(ctx context.Context, url, body string) string {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	if got := post(ctx, server.URL+"?fail=1&key=secret", "first"); got != "reply to first" {
+		t.Errorf("response = %q", got)
+	}
+	if got := post(WithAttempt(ctx, 2), server.URL+"?key=secret", "second"); got != "reply to second" {
+		t.Errorf("retried response = %q", got)
+	}
+
+	if len(exchanges) != 2 {
+		t.Fatalf("recorded %d exchanges, want 2", len(exchanges))
+	}
+	first, second := exchanges[0], exchanges[1]
+	if string(first.RequestBody) != "first" || string(first.ResponseBody) != "reply to first" || first.StatusCode != http.StatusServiceUnavailable || first.Attempt != 1 {
+		t.Errorf("first exchange = %+v", first)
+	}
+	if string(second.RequestBody) != "second" || second.StatusCode != http.StatusOK || second.Attempt != 2 || second.Err != nil {
+		t.Errorf("second exchange = %+v", second)
+	}
+	for _, ex := range exchanges {
+		if strings.Contains(ex.URL, "secret") || !strings.Contains(ex.URL, "key=REDACTED") {
+			t.Errorf("recorded URL %s, want the key redacted", ex.URL)
+		}
+	}
+}
+
+func TestRecordingTransportFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 1<<16))
+	}))
+	defer server.Close()
+
+	var exchanges []HTTPExchange
+	client := RecordingClient(nil, func(ctx context.Context, ex HTTPExchange) {
+		exchanges = append(exchanges, ex)
+	})
+
+	// A response closed part way is recorded as far as it was read
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Read(make([]byte, 10))
+	resp.Body.Close()
+	resp.Body.Close()
+	if len(exchanges) != 1 || len(exchanges[0].ResponseBody) == 0 || len(exchanges[0].ResponseBody) >= 1<<16 {
+		t.Fatalf("recorded %d exchanges, want one with part of the response", len(exchanges))
+	}
+
+	// A request that gets no response is recorded with its error
+	server.Close()
+	if _, err := client.Get(server.URL); err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+	if len(exchanges) != 2 || exchanges[1].Err == nil || exchanges[1].StatusCode != 0 {
+		t.Errorf("failed request recorded as %+v", exchanges[len(exchanges)-1])
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,6 +38,36 @@ func UseSimplifiedPatch(svc Service) bool {
 	return false
 }
 
+// StreamingService is implemented by services that can deliver a response incrementally.
+type StreamingService interface {
+	Service
+	// DoStream is like Do, but calls onDelta with partial output as it is generated.
+	// The returned Response is complete, exactly as if it had come from Do.
+	DoStream(ctx context.Context, req *Request, onDelta func(StreamDelta)) (*Response, error)
+}
+
+// DoStream sends req to svc, streaming partial output to onDelta if svc supports it.
+// If onDelta is nil or svc does not stream, DoStream is equivalent to svc.Do.
+func DoStream(ctx context.Context, svc Service, req *Request, onDelta func(StreamDelta)) (*Response, error) {
+	if ss, ok := svc.(StreamingService); ok && onDelta != nil {
+		return ss.DoStream(ctx, req, onDelta)
+	}
+	return svc.Do(ctx, req)
+}
+
+// StreamDelta is a piece of a response that is still being generated.
+type StreamDelta struct {
+	// Index identifies the content block the delta belongs to within the response.
+	Index int
+	// Type is ContentTypeText, ContentTypeThinking, or ContentTypeToolUse.
+	Type ContentType
+	// Text is newly generated text, thinking, or a fragment of tool input JSON.
+	Text string
+	// ID and ToolName identify a tool call. They are set on the first delta of a tool_use block.
+	ID       string
+	ToolName string
+}
+
 // MustSchema validates that schema is a valid JSON schema and returns it as a json.RawMessage.
 // It panics if the schema is invalid.
 // The schema must have at least type="object" and a properties key.
@@ -65,6 +96,101 @@ type Request struct {
 	ToolChoice *ToolChoice
 	Tools      []*Tool
 	System     []SystemContent
+	Thinking   *Thinking // if set, the model reasons before answering
+	// ResponseFormat, if set, makes the model answer with JSON matching a schema; see DoJSON
+	ResponseFormat *ResponseFormat
+}
+
+// CachePrefix reports whether anything in r is marked with Cache and, if so, how many of its
+// messages belong to the prefix worth caching: those up to the last one with marked content.
+// Providers that cache a request prefix as a whole, rather than at each marked block, use it.
+func (r *Request) CachePrefix() (messages int, ok bool) {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		for _, c := range r.Messages[i].Content {
+			if c.Cache {
+				return i + 1, true
+			}
+		}
+	}
+	for _, s := range r.System {
+		if s.Cache {
+			return 0, true
+		}
+	}
+	for _, t := range r.Tools {
+		if t.Cache {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// Thinking asks the model to reason before it answers.
+// Set Effort or BudgetTokens; each provider maps it to its own parameter,
+// deriving one from the other where needed.
+// It is part of the API, so unlike most LLM structs it has JSON tags.
+type Thinking struct {
+	// Effort is ThinkingEffortLow, ThinkingEffortMedium, or ThinkingEffortHigh.
+	Effort string `json:"effort,omitempty"`
+	// BudgetTokens is the number of tokens the model may spend thinking.
+	BudgetTokens int `json:"budget_tokens,omitempty"`
+}
+
+const (
+	ThinkingEffortLow    = "low"
+	ThinkingEffortMedium = "medium"
+	ThinkingEffortHigh   = "high"
+)
+
+// thinkingBudgets are the budgets that correspond to each effort level.
+var thinkingBudgets = []struct {
+	effort string
+	budget int
+}{
+	{ThinkingEffortLow, 4096},
+	{ThinkingEffortMedium, 16384},
+	{ThinkingEffortHigh, 32768},
+}
+
+// Validate reports whether t is a usable setting.
+func (t *Thinking) Validate() error {
+	if t.BudgetTokens < 0 {
+		return fmt.Errorf("thinking budget_tokens must not be negative")
+	}
+	if t.Effort == "" && t.BudgetTokens == 0 {
+		return fmt.Errorf("thinking needs an effort or budget_tokens")
+	}
+	switch t.Effort {
+	case "", ThinkingEffortLow, ThinkingEffortMedium, ThinkingEffortHigh:
+		return nil
+	}
+	return fmt.Errorf("unknown thinking effort %q", t.Effort)
+}
+
+// EffortLevel returns Effort, or the lowest level whose budget covers BudgetTokens.
+func (t *Thinking) EffortLevel() string {
+	if t.Effort != "" {
+		return t.Effort
+	}
+	for _, b := range thinkingBudgets {
+		if t.BudgetTokens <= b.budget {
+			return b.effort
+		}
+	}
+	return ThinkingEffortHigh
+}
+
+// Budget returns BudgetTokens, or the budget that corresponds to Effort.
+func (t *Thinking) Budget() int {
+	if t.BudgetTokens > 0 {
+		return t.BudgetTokens
+	}
+	for _, b := range thinkingBudgets {
+		if t.Effort == b.effort {
+			return b.budget
+		}
+	}
+	return thinkingBudgets[0].budget
 }
 
 // Message represents a message in the conversation.
@@ -73,8 +199,20 @@ type Message struct {
 	Content   []Content   `json:"Content"`
 	ToolUse   *ToolUse    `json:"ToolUse,omitempty"` // use to control whether/which tool to use
 	EndOfTurn bool        `json:"EndOfTurn"`         // true if this message completes the agent's turn (no tool calls to make)
+	// Thinking, on a user message, sets how much the model reasons during the turn it starts
+	Thinking *Thinking `json:"Thinking,omitempty"`
+	// TurnEnd, on an EndOfTurn message Shelley wrote rather than the model, says why the turn ended
+	TurnEnd TurnEnd `json:"TurnEnd,omitempty"`
 }
 
+// TurnEnd is why a turn was ended by Shelley rather than by the model.
+type TurnEnd string
+
+const (
+	TurnCancelled      TurnEnd = "cancelled"       // the user cancelled the turn
+	TurnBudgetExceeded TurnEnd = "budget_exceeded" // the conversation's budget ran out
+)
+
 // ToolUse represents a tool use in the message content.
 type ToolUse struct {
 	ID   string
@@ -104,6 +242,13 @@ type Tool struct {
 	EndsTurn bool
 	// Cache indicates whether to use prompt caching for this tool
 	Cache bool
+	// Serial indicates that calls to this tool must not overlap with other tool calls
+	// from the same response. Earlier calls finish first, and later calls wait for it.
+	// Tools without Serial set run concurrently with each other.
+	Serial bool
+	// SerialFor, if set, makes the calls for which it returns true Serial,
+	// for tools that are only sometimes unsafe to run concurrently.
+	SerialFor func(input json.RawMessage) bool `json:"-"`
 
 	// The Run function is automatically called when the tool is used.
 	// Run functions may be called concurrently with each other and themselves.
@@ -134,8 +279,10 @@ type Content struct {
 	Type ContentType
 	Text string
 
-	// Media type for image content
+	// Media type for image and document (PDF) content, whose base64 contents are in Data
 	MediaType string
+	// Filename is the name of the file the content was attached from, if any
+	Filename string
 
 	// for thinking
 	Thinking  string
@@ -313,6 +460,23 @@ func (u *Usage) Attr() slog.Attr {
 	)
 }
 
+// HTTPStatusError is an unsuccessful HTTP response from an LLM provider.
+// Services return it, possibly joined with the errors of earlier attempts,
+// so that callers can tell server errors and rate limits from other failures.
+type HTTPStatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return e.Message
+}
+
+// StatusErrorf returns an *HTTPStatusError for statusCode with a formatted message.
+func StatusErrorf(statusCode int, format string, args ...any) error {
+	return &HTTPStatusError{StatusCode: statusCode, Message: fmt.Sprintf(format, args...)}
+}
+
 // UserStringMessage creates a user message with a single text content item.
 func UserStringMessage(text string) Message {
 	return Message{
@@ -369,3 +533,62 @@ func DumpToFile(typ, url string, content []byte) error {
 
 	return os.WriteFile(filePath, data, 0o600)
 }
+
+type conversationIDCtxKeyType string
+
+const conversationIDCtxKey conversationIDCtxKeyType = "conversationID"
+
+// WithConversationID returns a context for LLM requests made on behalf of a conversation,
+// so that HTTP transports can attribute them to it.
+func WithConversationID(ctx context.Context, conversationID string) context.Context {
+	return context.WithValue(ctx, conversationIDCtxKey, conversationID)
+}
+
+// ConversationID returns the conversation set with WithConversationID, or "" if there is none.
+func ConversationID(ctx context.Context) string {
+	conversationID, _ := ctx.Value(conversationIDCtxKey).(string)
+	return conversationID
+}
+
+type requestLogCtxKeyType string
+
+const requestLogCtxKey requestLogCtxKeyType = "requestLog"
+
+// requestLog collects the IDs of the HTTP requests recorded while serving an LLM request.
+type requestLog struct {
+	mu  sync.Mutex
+	ids []int64
+}
+
+// WithRequestLog returns a context that collects the IDs under which HTTP transports record
+// the requests made with it, so that the caller can link the response to them.
+func WithRequestLog(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestLogCtxKey, &requestLog{})
+}
+
+// AddRequestID notes that a request made with ctx was recorded under id.
+// It does nothing if ctx has no request log.
+func AddRequestID(ctx context.Context, id int64) {
+	log, _ := ctx.Value(requestLogCtxKey).(*requestLog)
+	if log == nil {
+		return
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.ids = append(log.ids, id)
+}
+
+// LastRequestID returns the ID of the last request recorded in ctx's request log,
+// or 0 if there is none. After retries, it is the request that produced the response.
+func LastRequestID(ctx context.Context) int64 {
+	log, _ := ctx.Value(requestLogCtxKey).(*requestLog)
+	if log == nil {
+		return 0
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if len(log.ids) == 0 {
+		return 0
+	}
+	return log.ids[len(log.ids)-1]
+}
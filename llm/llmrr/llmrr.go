@@ -0,0 +1,332 @@
+// Package llmrr records the HTTP traffic of LLM services per conversation and replays it.
+//
+// Recordings use the httprr trace format: a "httprr trace v1" header followed by
+// records of the form "<request length> <response length>\n<request><response>",
+// with each request and response in HTTP/1.1 wire format.
+// Credentials are removed from requests before they are written.
+// A Replayer reads traces with httprr and only matches requests fuzzily when httprr has no exact match.
+package llmrr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"shelley.exe.dev/llm"
+	"sketch.dev/httprr"
+)
+
+const traceHeader = "httprr trace v1\n"
+
+// TraceExt is the extension of trace files written by a Recorder.
+const TraceExt = ".httprr"
+
+// credentialHeaders are the request headers providers take API keys in.
+var credentialHeaders = []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key"}
+
+// scrub removes credentials from req, which must be a clone the caller owns.
+func scrub(req *http.Request) {
+	for _, h := range credentialHeaders {
+		req.Header.Del(h)
+	}
+	if q := req.URL.Query(); q.Has("key") {
+		// Gemini takes its API key as a query parameter
+		q.Del("key")
+		req.URL.RawQuery = q.Encode()
+	}
+}
+
+// scrubRequest is scrub in the form httprr takes request scrubbers.
+func scrubRequest(req *http.Request) error {
+	scrub(req)
+	return nil
+}
+
+// requestWire returns req, without credentials, in wire format.
+// req.Body is replaced so that req can still be sent.
+func requestWire(req *http.Request) ([]byte, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	clone := req.Clone(req.Context())
+	scrub(clone)
+	clone.Body = io.NopCloser(bytes.NewReader(body))
+	clone.ContentLength = int64(len(body))
+	clone.Header.Del("Content-Length")
+	var buf bytes.Buffer
+	if err := clone.WriteProxy(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Recorder is an http.RoundTripper that writes every exchange made on behalf of a conversation,
+// as set with llm.WithConversationID, to <dir>/<conversation ID>.httprr.
+// Requests without a conversation pass through unrecorded.
+//
+// Responses are recorded once their body has been read to the end or closed, so streaming is
+// unaffected; a response whose body failed part way, e.g. because the user cancelled the turn,
+// is not recorded.
+type Recorder struct {
+	dir string
+	rt  http.RoundTripper
+	mu  sync.Mutex // serializes writes to trace files
+}
+
+// NewRecorder returns a Recorder that writes to dir, creating it if needed, and sends requests with rt.
+// If rt is nil, http.DefaultTransport is used.
+func NewRecorder(dir string, rt http.RoundTripper) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &Recorder{dir: dir, rt: rt}, nil
+}
+
+// Client returns an HTTP client that records through r.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// TracePath returns the file the exchanges of conversationID are recorded to.
+func (r *Recorder) TracePath(conversationID string) string {
+	return filepath.Join(r.dir, conversationID+TraceExt)
+}
+
+// RoundTrip sends req, recording the exchange if it belongs to a conversation.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	conversationID := llm.ConversationID(req.Context())
+	if conversationID == "" || filepath.Base(conversationID) != conversationID {
+		return r.rt.RoundTrip(req)
+	}
+	reqWire, err := requestWire(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = llm.NewRecordedBody(resp.Body, func(body []byte, err error) {
+		if err == nil {
+			r.record(conversationID, reqWire, resp, body)
+		}
+	})
+	return resp, nil
+}
+
+// record appends an exchange to the conversation's trace.
+// Recording is best effort: a failure to write must not fail the request.
+func (r *Recorder) record(conversationID string, reqWire []byte, resp *http.Response, body []byte) {
+	saved := *resp
+	saved.Body = io.NopCloser(bytes.NewReader(body))
+	saved.ContentLength = int64(len(body))
+	saved.TransferEncoding = nil
+	saved.Header = resp.Header.Clone()
+	saved.Header.Del("Content-Length")
+	var respWire bytes.Buffer
+	if err := saved.Write(&respWire); err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.OpenFile(r.TracePath(conversationID), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		io.WriteString(f, traceHeader)
+	}
+	fmt.Fprintf(f, "%d %d\n", len(reqWire), respWire.Len())
+	f.Write(reqWire)
+	f.Write(respWire.Bytes())
+}
+
+// exchange is a recorded request and its response.
+type exchange struct {
+	method, url string
+	body        []byte
+	response    []byte
+	used        bool
+}
+
+// Replayer is an http.RoundTripper that answers requests from a trace instead of the network.
+//
+// Requests are answered by httprr when the trace has an identical one. Otherwise a request
+// gets the unused exchange with the same method and URL whose body shares the longest prefix
+// with it, the earliest on a tie, and each exchange is served this way at most once.
+// This keeps a replay on track when tool output differs a little from the recording,
+// e.g. because it contains timestamps.
+type Replayer struct {
+	rr        *httprr.RecordReplay
+	mu        sync.Mutex
+	exchanges []*exchange
+}
+
+// OpenReplay reads the trace in file.
+func OpenReplay(file string) (*Replayer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	r, err := parseTrace(data)
+	if err != nil {
+		return nil, err
+	}
+	r.rr, err = httprr.Open(file, http.DefaultTransport)
+	if err != nil {
+		return nil, err
+	}
+	r.rr.ScrubReq(scrubRequest)
+	return r, nil
+}
+
+func parseTrace(data []byte) (*Replayer, error) {
+	rest, ok := bytes.CutPrefix(data, []byte(traceHeader))
+	if !ok {
+		return nil, fmt.Errorf("not an httprr trace")
+	}
+	rp := &Replayer{}
+	for len(rest) > 0 {
+		line, after, ok := bytes.Cut(rest, []byte("\n"))
+		if !ok {
+			return nil, fmt.Errorf("truncated trace")
+		}
+		reqLen, respLen, err := parseLengths(string(line))
+		if err != nil || reqLen+respLen > len(after) {
+			return nil, fmt.Errorf("corrupt trace record %d", len(rp.exchanges)+1)
+		}
+		reqWire, respWire := after[:reqLen], after[reqLen:reqLen+respLen]
+		rest = after[reqLen+respLen:]
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(reqWire)))
+		if err != nil {
+			return nil, fmt.Errorf("trace record %d: %w", len(rp.exchanges)+1, err)
+		}
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("trace record %d: %w", len(rp.exchanges)+1, err)
+		}
+		rp.exchanges = append(rp.exchanges, &exchange{
+			method:   req.Method,
+			url:      req.RequestURI,
+			body:     body,
+			response: respWire,
+		})
+	}
+	return rp, nil
+}
+
+func parseLengths(line string) (int, int, error) {
+	a, b, ok := strings.Cut(line, " ")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad record header %q", line)
+	}
+	reqLen, err := strconv.Atoi(a)
+	if err != nil || reqLen < 0 {
+		return 0, 0, fmt.Errorf("bad record header %q", line)
+	}
+	respLen, err := strconv.Atoi(b)
+	if err != nil || respLen < 0 {
+		return 0, 0, fmt.Errorf("bad record header %q", line)
+	}
+	return reqLen, respLen, nil
+}
+
+// Client returns an HTTP client that replays from r.
+func (r *Replayer) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Remaining returns how many recorded exchanges have not been served.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, ex := range r.exchanges {
+		if !ex.used {
+			n++
+		}
+	}
+	return n
+}
+
+// RoundTrip answers req from the trace.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	clone := req.Clone(req.Context())
+	scrub(clone)
+	url := clone.URL.String()
+
+	exact := req.Clone(req.Context())
+	exact.Body = io.NopCloser(bytes.NewReader(body))
+	resp, rrErr := r.rr.RoundTrip(exact)
+
+	r.mu.Lock()
+	var best *exchange
+	bestPrefix := -1
+	for _, ex := range r.exchanges {
+		if ex.used || ex.method != req.Method || ex.url != url {
+			continue
+		}
+		if bytes.Equal(ex.body, body) {
+			best = ex
+			break
+		}
+		if rrErr == nil {
+			continue
+		}
+		if n := commonPrefix(ex.body, body); n > bestPrefix {
+			best, bestPrefix = ex, n
+		}
+	}
+	if best != nil {
+		best.used = true
+	}
+	r.mu.Unlock()
+
+	if rrErr == nil {
+		return resp, nil
+	}
+	if best == nil {
+		// httprr's "cached HTTP response not found" error, which providers recognize as not worth retrying
+		return nil, rrErr
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(best.response)), req)
+}
+
+func commonPrefix(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
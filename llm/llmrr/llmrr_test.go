@@ -0,0 +1,217 @@
+package llmrr
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+	"sketch.dev/httprr"
+)
+
+func post(t *testing.T, ctx context.Context, client *http.Client, url, body string) (string, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("X-Api-Key", "secret-key")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "reply to "+string(body))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := recorder.Client()
+	ctx := llm.WithConversationID(context.Background(), "c123")
+
+	url := server.URL + "/v1/messages?key=secret-query&alt=sse"
+	for _, body := range []string{"first request", "second request"} {
+		got, err := post(t, ctx, client, url, body)
+		if err != nil {
+			t.Fatalf("recording %q: %v", body, err)
+		}
+		if got != "reply to "+body {
+			t.Fatalf("recorded response = %q", got)
+		}
+	}
+	if _, err := post(t, context.Background(), client, url, "unattributed"); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := os.ReadFile(recorder.TracePath("c123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(trace), traceHeader) {
+		t.Errorf("trace does not start with the httprr header")
+	}
+	for _, secret := range []string{"secret-token", "secret-key", "secret-query"} {
+		if strings.Contains(string(trace), secret) {
+			t.Errorf("trace contains credential %q", secret)
+		}
+	}
+	if strings.Contains(string(trace), "unattributed") {
+		t.Error("request without a conversation was recorded")
+	}
+
+	replayer, err := OpenReplay(recorder.TracePath("c123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	client = replayer.Client()
+
+	// Out of order, and the second with a body that differs from the recording at the end
+	got, err := post(t, ctx, client, url, "second request")
+	if err != nil || got != "reply to second request" {
+		t.Errorf("replay = %q, %v; want the second response", got, err)
+	}
+	got, err = post(t, ctx, client, url, "first request, changed")
+	if err != nil || got != "reply to first request" {
+		t.Errorf("replay = %q, %v; want the closest match, the first response", got, err)
+	}
+	if n := replayer.Remaining(); n != 0 {
+		t.Errorf("Remaining() = %d, want 0", n)
+	}
+
+	// httprr answers identical requests however often they are repeated,
+	// but an exchange is only matched fuzzily once
+	got, err = post(t, ctx, client, url, "first request")
+	if err != nil || got != "reply to first request" {
+		t.Errorf("repeating a recorded request = %q, %v; want the first response", got, err)
+	}
+	_, err = post(t, ctx, client, url, "first request, changed again")
+	if err == nil || !strings.Contains(err.Error(), "cached HTTP response not found") {
+		t.Errorf("fuzzily matching a used exchange: err = %v, want a cache miss", err)
+	}
+	_, err = post(t, ctx, client, server.URL+"/v1/other", "first request")
+	if err == nil || !strings.Contains(err.Error(), "cached HTTP response not found") {
+		t.Errorf("replaying another URL: err = %v, want a cache miss", err)
+	}
+}
+
+func TestRecorderRecordsStreamsClosedBeforeEOF(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {\"text\":\"hi\"}\n\ndata: [DONE]\n\n")
+		w.(http.Flusher).Flush()
+		// Keep the stream open: clients like go-openai stop reading at [DONE] and close it
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	recorder, err := NewRecorder(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := llm.WithConversationID(context.Background(), "c1")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/v1/chat/completions", strings.NewReader(`{"stream":true}`))
+	resp, err := recorder.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && scanner.Text() != "data: [DONE]" {
+	}
+	resp.Body.Close()
+
+	replayer, err := OpenReplay(recorder.TracePath("c1"))
+	if err != nil {
+		t.Fatalf("stream closed after its final event was not recorded: %v", err)
+	}
+	server.Close()
+	got, err := post(t, ctx, replayer.Client(), server.URL+"/v1/chat/completions", `{"stream":true}`)
+	if err != nil || !strings.Contains(got, "data: [DONE]") {
+		t.Errorf("replay = %q, %v; want the stream up to [DONE]", got, err)
+	}
+}
+
+func TestRecorderSkipsFailedResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Promise more than is sent, so that reading fails part way
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, "partial")
+	}))
+	defer server.Close()
+
+	recorder, err := NewRecorder(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := llm.WithConversationID(context.Background(), "c1")
+	if _, err := post(t, ctx, recorder.Client(), server.URL, "request"); err == nil {
+		t.Fatal("reading the truncated response succeeded")
+	}
+
+	if _, err := os.Stat(recorder.TracePath("c1")); !os.IsNotExist(err) {
+		t.Errorf("failed response was recorded: %v", err)
+	}
+}
+
+// TestTracesReplayExactlyWithHTTPRR checks that httprr itself, without the Replayer's fuzzy
+// matching, finds the requests of a trace the Recorder wrote.
+func TestTracesReplayExactlyWithHTTPRR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, "reply to "+string(body))
+	}))
+	defer server.Close()
+
+	recorder, err := NewRecorder(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := llm.WithConversationID(context.Background(), "c1")
+	url := server.URL + "/v1beta/models/gemini-2.5-flash:generateContent?key=secret-query"
+	if _, err := post(t, ctx, recorder.Client(), url, `{"contents":[]}`); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	rr, err := httprr.Open(recorder.TracePath("c1"), http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	rr.ScrubReq(scrubRequest)
+	got, err := post(t, ctx, rr.Client(), url, `{"contents":[]}`)
+	if err != nil || got != `reply to {"contents":[]}` {
+		t.Errorf("httprr replay = %q, %v; want the recorded response", got, err)
+	}
+}
+
+func TestOpenReplayRejectsCorruptTraces(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"not a trace\n",
+		traceHeader + "10 10\nshort",
+		traceHeader + "x y\n",
+	} {
+		if _, err := parseTrace([]byte(data)); err == nil {
+			t.Errorf("parseTrace(%q) succeeded", data)
+		}
+	}
+}
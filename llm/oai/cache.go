@@ -0,0 +1,69 @@
+package oai
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"shelley.exe.dev/llm"
+)
+
+// OpenAI caches prompt prefixes automatically. Requests with the same prompt_cache_key are routed
+// to the same cache, which makes hits far more likely once several conversations are going at once.
+// See https://platform.openai.com/docs/guides/prompt-caching
+
+// promptCacheKey returns the prompt_cache_key for ir, or "" if it should not have one:
+// when nothing in it is marked with Cache, or when the model is not served by OpenAI,
+// since other OpenAI-compatible APIs may reject unknown parameters.
+// The requests of a conversation share a key; others are keyed by their system prompt.
+func promptCacheKey(ctx context.Context, model Model, ir *llm.Request) string {
+	if model.URL != OpenAIURL {
+		return ""
+	}
+	if _, ok := ir.CachePrefix(); !ok {
+		return ""
+	}
+	if id := llm.ConversationID(ctx); id != "" {
+		return "shelley-" + id
+	}
+	h := sha256.New()
+	for _, sys := range ir.System {
+		io.WriteString(h, sys.Text)
+	}
+	return fmt.Sprintf("shelley-%x", h.Sum(nil)[:8])
+}
+
+// promptCacheDoer sends requests through httpc with prompt_cache_key added to their JSON body,
+// as go-openai's ChatCompletionRequest has no field for it.
+type promptCacheDoer struct {
+	httpc *http.Client
+	key   string
+}
+
+func (d promptCacheDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return d.httpc.Do(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err == nil {
+		fields["prompt_cache_key"], _ = json.Marshal(d.key)
+		if withKey, err := json.Marshal(fields); err == nil {
+			body = withKey
+		}
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return d.httpc.Do(req)
+}
@@ -0,0 +1,95 @@
+package oai
+
+import (
+	"context"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+// cachedRequest is a request whose last user message is marked for caching, as the loop sends them
+func cachedRequest() *llm.Request {
+	msg := llm.UserStringMessage("hi")
+	msg.Content[0].Cache = true
+	return &llm.Request{
+		System:   []llm.SystemContent{{Type: "text", Text: "You are helpful."}},
+		Messages: []llm.Message{msg},
+	}
+}
+
+func TestServicePromptCacheKey(t *testing.T) {
+	body := `data: {"id":"c1","model":"test","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}
+
+data: {"id":"c1","model":"test","choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":5,"total_tokens":1205,"prompt_tokens_details":{"cached_tokens":1024}}}
+
+data: [DONE]
+
+`
+	conversationCtx := llm.WithConversationID(context.Background(), "c1")
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		model Model
+		req   *llm.Request
+		want  any
+	}{
+		{"conversation", conversationCtx, GPT5, cachedRequest(), "shelley-c1"},
+		{"no caching asked for", conversationCtx, GPT5, &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}}, nil},
+		{"not OpenAI", conversationCtx, Qwen3CoderFireworks, cachedRequest(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]any
+			svc := &Service{HTTPC: capturingClient(body, &sent), APIKey: "test", Model: tt.model, ModelURL: "https://example.com/v1"}
+			resp, err := svc.DoStream(tt.ctx, tt.req, func(llm.StreamDelta) {})
+			if err != nil {
+				t.Fatalf("DoStream: %v", err)
+			}
+			if got := sent["prompt_cache_key"]; got != tt.want {
+				t.Errorf("prompt_cache_key = %v, want %v", got, tt.want)
+			}
+			if sent["model"] != tt.model.ModelName {
+				t.Errorf("model = %v, want the rest of the request intact", sent["model"])
+			}
+			want := llm.Usage{InputTokens: 176, CacheReadInputTokens: 1024, OutputTokens: 5}
+			if got := resp.Usage; got.InputTokens != want.InputTokens || got.CacheReadInputTokens != want.CacheReadInputTokens ||
+				got.CacheCreationInputTokens != 0 || got.OutputTokens != want.OutputTokens {
+				t.Errorf("usage = %+v, want %+v", got, want)
+			}
+			if total := resp.Usage.TotalInputTokens(); total != 1200 {
+				t.Errorf("total input tokens = %d, want the 1200 prompt tokens", total)
+			}
+		})
+	}
+
+	// Requests outside a conversation are keyed by their system prompt
+	var first, second map[string]any
+	for _, sent := range []*map[string]any{&first, &second} {
+		svc := &Service{HTTPC: capturingClient(body, sent), APIKey: "test", Model: GPT5, ModelURL: "https://example.com/v1"}
+		if _, err := svc.DoStream(context.Background(), cachedRequest(), func(llm.StreamDelta) {}); err != nil {
+			t.Fatalf("DoStream: %v", err)
+		}
+	}
+	if key, _ := first["prompt_cache_key"].(string); key == "" || key == "shelley-c1" || key != second["prompt_cache_key"] {
+		t.Errorf("prompt_cache_key without a conversation = %v and %v, want the same key", first["prompt_cache_key"], second["prompt_cache_key"])
+	}
+}
+
+func TestResponsesServicePromptCacheKey(t *testing.T) {
+	body := `event: response.completed
+data: {"type":"response.completed","response":{"id":"r1","model":"test","status":"completed","output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Hello"}]}],"usage":{"input_tokens":2000,"input_tokens_details":{"cached_tokens":1536},"output_tokens":2}}}
+
+`
+	var sent map[string]any
+	svc := &ResponsesService{HTTPC: capturingClient(body, &sent), APIKey: "test", Model: GPT5Codex, ModelURL: "https://example.com/v1"}
+	resp, err := svc.DoStream(llm.WithConversationID(context.Background(), "c2"), cachedRequest(), func(llm.StreamDelta) {})
+	if err != nil {
+		t.Fatalf("DoStream: %v", err)
+	}
+	if sent["prompt_cache_key"] != "shelley-c2" {
+		t.Errorf("prompt_cache_key = %v, want shelley-c2", sent["prompt_cache_key"])
+	}
+	if u := resp.Usage; u.InputTokens != 464 || u.CacheReadInputTokens != 1536 || u.CacheCreationInputTokens != 0 {
+		t.Errorf("usage = %+v, want 464 uncached and 1536 cached input tokens", u)
+	}
+}
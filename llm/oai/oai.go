@@ -45,6 +45,7 @@ type Model struct {
 	APIKeyEnv          string // environment variable name for the API key
 	IsReasoningModel   bool   // whether this model is a reasoning model (e.g. O3, O4-mini)
 	UseSimplifiedPatch bool   // whether to use the simplified patch input schema; defaults to false
+	ContextWindow      int    // context window size in tokens; if zero, a built-in size for ModelName is used
 }
 
 var (
@@ -315,9 +316,14 @@ type Service struct {
 	MaxTokens int          // defaults to DefaultMaxTokens if zero
 	Org       string       // optional - organization ID
 	DumpLLM   bool         // whether to dump request/response text to files for debugging; defaults to false
+	// APIKeyFunc, if set, returns the key for each request in place of APIKey, so that it can be rotated
+	APIKeyFunc func() string
 }
 
-var _ llm.Service = (*Service)(nil)
+var (
+	_ llm.Service          = (*Service)(nil)
+	_ llm.StreamingService = (*Service)(nil)
+)
 
 // ModelsRegistry is a registry of all known models with their user-friendly names.
 var ModelsRegistry = []Model{
@@ -448,6 +454,26 @@ func fromLLMContent(c llm.Content) (string, []openai.ToolCall) {
 	}
 }
 
+// fromLLMMedia converts image content to an image part. Chat completions don't take documents,
+// so a PDF becomes a note that it was left out.
+func fromLLMMedia(c llm.Content) openai.ChatMessagePart {
+	if !strings.HasPrefix(c.MediaType, "image/") {
+		return openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeText,
+			Text: fmt.Sprintf("[attached file %s (%s) is not supported by this model]", c.Filename, c.MediaType),
+		}
+	}
+	return openai.ChatMessagePart{
+		Type:     openai.ChatMessagePartTypeImageURL,
+		ImageURL: &openai.ChatMessageImageURL{URL: dataURL(c)},
+	}
+}
+
+// dataURL returns the data URL of image or document content.
+func dataURL(c llm.Content) string {
+	return "data:" + c.MediaType + ";base64," + c.Data
+}
+
 // fromLLMMessage converts llm.Message to OpenAI ChatCompletionMessage format
 func fromLLMMessage(msg llm.Message) []openai.ChatCompletionMessage {
 	// For OpenAI, we need to handle tool results differently than regular messages
@@ -504,8 +530,14 @@ func fromLLMMessage(msg llm.Message) []openai.ChatCompletionMessage {
 		// For assistant messages that contain tool calls
 		var toolCalls []openai.ToolCall
 		var textContent string
+		// Messages with images must send their text and images as parts
+		var parts []openai.ChatMessagePart
 
 		for _, c := range regularContent {
+			if c.MediaType != "" {
+				parts = append(parts, fromLLMMedia(c))
+				continue
+			}
 			content, tools := fromLLMContent(c)
 			if len(tools) > 0 {
 				toolCalls = append(toolCalls, tools...)
@@ -517,7 +549,14 @@ func fromLLMMessage(msg llm.Message) []openai.ChatCompletionMessage {
 			}
 		}
 
-		m.Content = textContent
+		if len(parts) > 0 {
+			if textContent != "" {
+				parts = append([]openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: textContent}}, parts...)
+			}
+			m.MultiContent = parts
+		} else {
+			m.Content = textContent
+		}
 		m.ToolCalls = toolCalls
 
 		messages = append(messages, m)
@@ -542,6 +581,12 @@ func (m Model) requiresMaxCompletionTokens() bool {
 	}
 }
 
+// supportsReasoningEffort returns true if the model accepts the reasoning_effort parameter.
+// These are the same models that require max_completion_tokens: reasoning models and the GPT-5 series.
+func (m Model) supportsReasoningEffort() bool {
+	return m.requiresMaxCompletionTokens()
+}
+
 // fromLLMToolChoice converts llm.ToolChoice to the format expected by OpenAI.
 func fromLLMToolChoice(tc *llm.ToolChoice) any {
 	if tc == nil {
@@ -671,17 +716,18 @@ func toLLMContents(msg openai.ChatCompletionMessage) []llm.Content {
 // toLLMUsage converts usage information from OpenAI to llm.Usage.
 func (s *Service) toLLMUsage(au openai.Usage, headers http.Header) llm.Usage {
 	// fmt.Printf("raw usage: %+v / %v / %v\n", au, au.PromptTokensDetails, au.CompletionTokensDetails)
+	// Prompt tokens include the cached ones. OpenAI does not charge for writing to its cache,
+	// so nothing counts as cache creation.
 	in := uint64(au.PromptTokens)
 	var inc uint64
 	if au.PromptTokensDetails != nil {
-		inc = uint64(au.PromptTokensDetails.CachedTokens)
+		inc = min(uint64(au.PromptTokensDetails.CachedTokens), in)
 	}
 	out := uint64(au.CompletionTokens)
 	u := llm.Usage{
-		InputTokens:              in,
-		CacheReadInputTokens:     inc,
-		CacheCreationInputTokens: in,
-		OutputTokens:             out,
+		InputTokens:          in - inc,
+		CacheReadInputTokens: inc,
+		OutputTokens:         out,
 	}
 	u.CostUSD = llm.CostUSDFromResponse(headers)
 	return u
@@ -736,11 +782,28 @@ func toStopReason(reason string) llm.StopReason {
 	return llm.StopReasonStopSequence // Default
 }
 
+// CountTokens estimates the input tokens of ir.
+// OpenAI-compatible APIs offer no way to count tokens before sending, so this is an approximation.
+func (s *Service) CountTokens(ctx context.Context, ir *llm.Request) (uint64, error) {
+	return llm.EstimateTokens(ir), nil
+}
+
+// apiKey returns the API key to send with a request.
+func (s *Service) apiKey() string {
+	if s.APIKeyFunc != nil {
+		return s.APIKeyFunc()
+	}
+	return s.APIKey
+}
+
 // TokenContextWindow returns the maximum token context window size for this service
 func (s *Service) TokenContextWindow() int {
 	// TODO: move TokenContextWindow information to Model struct
 
 	model := cmp.Or(s.Model, DefaultModel)
+	if model.ContextWindow > 0 {
+		return model.ContextWindow
+	}
 
 	// OpenAI models generally have 128k context windows
 	// Some newer models have larger windows, but 128k is a safe default
@@ -775,12 +838,21 @@ func (s *Service) MaxImageDimension() int {
 
 // Do sends a request to OpenAI using the go-openai package.
 func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error) {
+	return s.do(ctx, ir, nil)
+}
+
+// DoStream sends a streaming request to OpenAI, calling onDelta as content arrives.
+func (s *Service) DoStream(ctx context.Context, ir *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	return s.do(ctx, ir, onDelta)
+}
+
+func (s *Service) do(ctx context.Context, ir *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
 	// Configure the OpenAI client
 	httpc := cmp.Or(s.HTTPC, http.DefaultClient)
 	model := cmp.Or(s.Model, DefaultModel)
 
 	// TODO: do this one during Service setup? maybe with a constructor instead?
-	config := openai.DefaultConfig(s.APIKey)
+	config := openai.DefaultConfig(s.apiKey())
 	baseURL := cmp.Or(s.ModelURL, model.URL)
 	if baseURL != "" {
 		config.BaseURL = baseURL
@@ -789,6 +861,9 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 		config.OrgID = s.Org
 	}
 	config.HTTPClient = httpc
+	if key := promptCacheKey(ctx, model, ir); key != "" {
+		config.HTTPClient = promptCacheDoer{httpc: httpc, key: key}
+	}
 
 	client := openai.NewClientWithConfig(config)
 
@@ -823,6 +898,19 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 	} else {
 		req.MaxTokens = cmp.Or(s.MaxTokens, DefaultMaxTokens)
 	}
+	if ir.Thinking != nil && model.supportsReasoningEffort() {
+		req.ReasoningEffort = ir.Thinking.EffortLevel()
+	}
+	if rf := ir.ResponseFormat; rf != nil {
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:        rf.Name,
+				Description: rf.Description,
+				Schema:      rf.Schema,
+			},
+		}
+	}
 	// Construct the full URL for logging and debugging
 	fullURL := baseURL + "/chat/completions"
 
@@ -850,7 +938,14 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 			time.Sleep(sleep)
 		}
 
-		resp, err := client.CreateChatCompletion(ctx, req)
+		var resp openai.ChatCompletionResponse
+		var err error
+		attemptCtx := llm.WithAttempt(ctx, attempts+1)
+		if onDelta != nil {
+			resp, err = createChatCompletionStream(attemptCtx, client, req, onDelta)
+		} else {
+			resp, err = client.CreateChatCompletion(attemptCtx, req)
+		}
 
 		// Handle successful response
 		if err == nil {
@@ -883,24 +978,24 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 		case apiErr.HTTPStatusCode >= 500:
 			// Server error, try again with backoff
 			slog.WarnContext(ctx, "openai_request_failed", "error", apiErr.Error(), "status_code", apiErr.HTTPStatusCode, "url", fullURL, "model", model.ModelName)
-			errs = errors.Join(errs, fmt.Errorf("status %d (url=%s, model=%s): %s", apiErr.HTTPStatusCode, fullURL, model.ModelName, apiErr.Error()))
+			errs = errors.Join(errs, llm.StatusErrorf(apiErr.HTTPStatusCode, "status %d (url=%s, model=%s): %s", apiErr.HTTPStatusCode, fullURL, model.ModelName, apiErr.Error()))
 			continue
 
 		case apiErr.HTTPStatusCode == 429:
 			// Rate limited, accumulate error and retry
 			slog.WarnContext(ctx, "openai_request_rate_limited", "error", apiErr.Error(), "url", fullURL, "model", model.ModelName)
-			errs = errors.Join(errs, fmt.Errorf("status %d (rate limited, url=%s, model=%s): %s", apiErr.HTTPStatusCode, fullURL, model.ModelName, apiErr.Error()))
+			errs = errors.Join(errs, llm.StatusErrorf(apiErr.HTTPStatusCode, "status %d (rate limited, url=%s, model=%s): %s", apiErr.HTTPStatusCode, fullURL, model.ModelName, apiErr.Error()))
 			continue
 
 		case apiErr.HTTPStatusCode >= 400 && apiErr.HTTPStatusCode < 500:
 			// Client error, probably unrecoverable
 			slog.WarnContext(ctx, "openai_request_failed", "error", apiErr.Error(), "status_code", apiErr.HTTPStatusCode, "url", fullURL, "model", model.ModelName)
-			return nil, errors.Join(errs, fmt.Errorf("status %d (url=%s, model=%s): %s", apiErr.HTTPStatusCode, fullURL, model.ModelName, apiErr.Error()))
+			return nil, errors.Join(errs, llm.StatusErrorf(apiErr.HTTPStatusCode, "status %d (url=%s, model=%s): %s", apiErr.HTTPStatusCode, fullURL, model.ModelName, apiErr.Error()))
 
 		default:
 			// Other error, accumulate and retry
 			slog.WarnContext(ctx, "openai_request_failed", "error", apiErr.Error(), "status_code", apiErr.HTTPStatusCode, "url", fullURL, "model", model.ModelName)
-			errs = errors.Join(errs, fmt.Errorf("status %d (url=%s, model=%s): %s", apiErr.HTTPStatusCode, fullURL, model.ModelName, apiErr.Error()))
+			errs = errors.Join(errs, llm.StatusErrorf(apiErr.HTTPStatusCode, "status %d (url=%s, model=%s): %s", apiErr.HTTPStatusCode, fullURL, model.ModelName, apiErr.Error()))
 			continue
 		}
 	}
@@ -919,6 +1014,6 @@ func (s *Service) ConfigDetails() map[string]string {
 		"model_name":      model.ModelName,
 		"full_url":        baseURL + "/chat/completions",
 		"api_key_env":     model.APIKeyEnv,
-		"has_api_key_set": fmt.Sprintf("%v", s.APIKey != ""),
+		"has_api_key_set": fmt.Sprintf("%v", s.apiKey() != ""),
 	}
 }
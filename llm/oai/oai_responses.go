@@ -28,9 +28,14 @@ type ResponsesService struct {
 	MaxTokens int          // defaults to DefaultMaxTokens if zero
 	Org       string       // optional - organization ID
 	DumpLLM   bool         // whether to dump request/response text to files for debugging; defaults to false
+	// APIKeyFunc, if set, returns the key for each request in place of APIKey, so that it can be rotated
+	APIKeyFunc func() string
 }
 
-var _ llm.Service = (*ResponsesService)(nil)
+var (
+	_ llm.Service          = (*ResponsesService)(nil)
+	_ llm.StreamingService = (*ResponsesService)(nil)
+)
 
 // Responses API request/response types
 
@@ -41,10 +46,26 @@ type responsesRequest struct {
 	ToolChoice      any                  `json:"tool_choice,omitempty"`
 	MaxOutputTokens int                  `json:"max_output_tokens,omitempty"`
 	Reasoning       *responsesReasoning  `json:"reasoning,omitempty"`
+	Text            *responsesText       `json:"text,omitempty"`
+	Stream          bool                 `json:"stream,omitempty"`
+	PromptCacheKey  string               `json:"prompt_cache_key,omitempty"`
+}
+
+// responsesText configures the output text, e.g. to require JSON matching a schema
+type responsesText struct {
+	Format responsesTextFormat `json:"format"`
+}
+
+type responsesTextFormat struct {
+	Type        string          `json:"type"` // "text", "json_schema"
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
 }
 
 type responsesReasoning struct {
-	Effort string `json:"effort,omitempty"` // "low", "medium", "high"
+	Effort  string `json:"effort,omitempty"`  // "low", "medium", "high"
+	Summary string `json:"summary,omitempty"` // "auto", "concise", "detailed"
 }
 
 type responsesInputItem struct {
@@ -58,8 +79,11 @@ type responsesInputItem struct {
 }
 
 type responsesContent struct {
-	Type string `json:"type"` // "input_text", "output_text"
-	Text string `json:"text"`
+	Type     string `json:"type"` // "input_text", "output_text", "input_image", "input_file"
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"` // for input_image, a data URL
+	Filename string `json:"filename,omitempty"`  // for input_file
+	FileData string `json:"file_data,omitempty"` // for input_file, a data URL
 }
 
 type responsesTool struct {
@@ -89,7 +113,7 @@ type responsesOutputItem struct {
 	CallID    string             `json:"call_id,omitempty"`   // for function_call
 	Name      string             `json:"name,omitempty"`      // for function_call
 	Arguments string             `json:"arguments,omitempty"` // for function_call
-	Summary   []string           `json:"summary,omitempty"`   // for reasoning
+	Summary   []responsesContent `json:"summary,omitempty"`   // for reasoning, type "summary_text"
 }
 
 type responsesUsage struct {
@@ -166,7 +190,15 @@ func fromLLMMessageResponses(msg llm.Message) []responsesInputItem {
 		for _, c := range regularContent {
 			switch c.Type {
 			case llm.ContentTypeText:
-				if c.Text != "" {
+				if c.MediaType == "application/pdf" {
+					messageContent = append(messageContent, responsesContent{
+						Type:     "input_file",
+						Filename: cmp.Or(c.Filename, "attachment.pdf"),
+						FileData: dataURL(c),
+					})
+				} else if c.MediaType != "" {
+					messageContent = append(messageContent, responsesContent{Type: "input_image", ImageURL: dataURL(c)})
+				} else if c.Text != "" {
 					contentType := "input_text"
 					if msg.Role == llm.MessageRoleAssistant {
 						contentType = "output_text"
@@ -280,7 +312,11 @@ func (s *ResponsesService) toLLMResponseFromResponses(resp *responsesResponse, h
 		case "reasoning":
 			// Convert reasoning to thinking content
 			if len(item.Summary) > 0 {
-				summaryText := strings.Join(item.Summary, "\n")
+				var summary []string
+				for _, s := range item.Summary {
+					summary = append(summary, s.Text)
+				}
+				summaryText := strings.Join(summary, "\n")
 				contents = append(contents, llm.Content{
 					Type: llm.ContentTypeThinking,
 					Text: summaryText,
@@ -318,25 +354,42 @@ func (s *ResponsesService) toLLMResponseFromResponses(resp *responsesResponse, h
 
 // toLLMUsageFromResponses converts Responses API usage to llm.Usage
 func (s *ResponsesService) toLLMUsageFromResponses(usage responsesUsage, headers http.Header) llm.Usage {
+	// As with chat completions, input tokens include the cached ones
 	in := uint64(usage.InputTokens)
 	var inc uint64
 	if usage.InputTokensDetails != nil {
-		inc = uint64(usage.InputTokensDetails.CachedTokens)
+		inc = min(uint64(usage.InputTokensDetails.CachedTokens), in)
 	}
 	out := uint64(usage.OutputTokens)
 	u := llm.Usage{
-		InputTokens:              in,
-		CacheReadInputTokens:     inc,
-		CacheCreationInputTokens: in,
-		OutputTokens:             out,
+		InputTokens:          in - inc,
+		CacheReadInputTokens: inc,
+		OutputTokens:         out,
 	}
 	u.CostUSD = llm.CostUSDFromResponse(headers)
 	return u
 }
 
+// CountTokens estimates the input tokens of ir.
+// OpenAI-compatible APIs offer no way to count tokens before sending, so this is an approximation.
+func (s *ResponsesService) CountTokens(ctx context.Context, ir *llm.Request) (uint64, error) {
+	return llm.EstimateTokens(ir), nil
+}
+
+// apiKey returns the API key to send with a request.
+func (s *ResponsesService) apiKey() string {
+	if s.APIKeyFunc != nil {
+		return s.APIKeyFunc()
+	}
+	return s.APIKey
+}
+
 // TokenContextWindow returns the maximum token context window size for this service
 func (s *ResponsesService) TokenContextWindow() int {
 	model := cmp.Or(s.Model, DefaultModel)
+	if model.ContextWindow > 0 {
+		return model.ContextWindow
+	}
 
 	// Use the same context window logic as the regular service
 	switch model.ModelName {
@@ -359,6 +412,15 @@ func (s *ResponsesService) MaxImageDimension() int {
 
 // Do sends a request to OpenAI using the Responses API.
 func (s *ResponsesService) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error) {
+	return s.do(ctx, ir, nil)
+}
+
+// DoStream sends a streaming request to the Responses API, calling onDelta as output arrives.
+func (s *ResponsesService) DoStream(ctx context.Context, ir *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	return s.do(ctx, ir, onDelta)
+}
+
+func (s *ResponsesService) do(ctx context.Context, ir *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
 	httpc := cmp.Or(s.HTTPC, http.DefaultClient)
 	model := cmp.Or(s.Model, DefaultModel)
 
@@ -387,6 +449,8 @@ func (s *ResponsesService) Do(ctx context.Context, ir *llm.Request) (*llm.Respon
 		Input:           allInput,
 		Tools:           tools,
 		MaxOutputTokens: cmp.Or(s.MaxTokens, DefaultMaxTokens),
+		Stream:          onDelta != nil,
+		PromptCacheKey:  promptCacheKey(ctx, model, ir),
 	}
 
 	// Add tool choice if specified
@@ -394,6 +458,20 @@ func (s *ResponsesService) Do(ctx context.Context, ir *llm.Request) (*llm.Respon
 		req.ToolChoice = fromLLMToolChoice(ir.ToolChoice)
 	}
 
+	// Ask for reasoning summaries too, so that the reasoning can be shown
+	if ir.Thinking != nil {
+		req.Reasoning = &responsesReasoning{Effort: ir.Thinking.EffortLevel(), Summary: "auto"}
+	}
+
+	if rf := ir.ResponseFormat; rf != nil {
+		req.Text = &responsesText{Format: responsesTextFormat{
+			Type:        "json_schema",
+			Name:        rf.Name,
+			Description: rf.Description,
+			Schema:      rf.Schema,
+		}}
+	}
+
 	// Construct the full URL
 	baseURL := cmp.Or(s.ModelURL, model.URL, OpenAIURL)
 	fullURL := baseURL + "/responses"
@@ -429,13 +507,13 @@ func (s *ResponsesService) Do(ctx context.Context, ir *llm.Request) (*llm.Respon
 		}
 
 		// Create HTTP request
-		httpReq, err := http.NewRequestWithContext(ctx, "POST", fullURL, bytes.NewReader(reqJSON))
+		httpReq, err := http.NewRequestWithContext(llm.WithAttempt(ctx, attempts+1), "POST", fullURL, bytes.NewReader(reqJSON))
 		if err != nil {
 			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
 
 		httpReq.Header.Set("Content-Type", "application/json")
-		httpReq.Header.Set("Authorization", "Bearer "+s.APIKey)
+		httpReq.Header.Set("Authorization", "Bearer "+s.apiKey())
 		if s.Org != "" {
 			httpReq.Header.Set("OpenAI-Organization", s.Org)
 		}
@@ -448,6 +526,18 @@ func (s *ResponsesService) Do(ctx context.Context, ir *llm.Request) (*llm.Respon
 		}
 		defer httpResp.Body.Close()
 
+		if onDelta != nil && httpResp.StatusCode == http.StatusOK {
+			// Deltas have been delivered once the stream starts, so stream failures are not retried.
+			resp, err := readResponsesStream(httpResp.Body, onDelta)
+			if err != nil {
+				return nil, fmt.Errorf("responses stream (url=%s, model=%s): %w", fullURL, model.ModelName, err)
+			}
+			if resp.Error != nil {
+				return nil, fmt.Errorf("response contains error: %s", resp.Error.Message)
+			}
+			return s.toLLMResponseFromResponses(resp, httpResp.Header), nil
+		}
+
 		// Read response body
 		body, err := io.ReadAll(httpResp.Body)
 		if err != nil {
@@ -465,25 +555,25 @@ func (s *ResponsesService) Do(ctx context.Context, ir *llm.Request) (*llm.Respon
 				case httpResp.StatusCode >= 500:
 					// Server error, retry
 					slog.WarnContext(ctx, "responses_request_failed", "error", apiErr.Message, "status_code", httpResp.StatusCode, "url", fullURL, "model", model.ModelName)
-					errs = errors.Join(errs, fmt.Errorf("status %d (url=%s, model=%s): %s", httpResp.StatusCode, fullURL, model.ModelName, apiErr.Message))
+					errs = errors.Join(errs, llm.StatusErrorf(httpResp.StatusCode, "status %d (url=%s, model=%s): %s", httpResp.StatusCode, fullURL, model.ModelName, apiErr.Message))
 					continue
 
 				case httpResp.StatusCode == 429:
 					// Rate limited, retry
 					slog.WarnContext(ctx, "responses_request_rate_limited", "error", apiErr.Message, "url", fullURL, "model", model.ModelName)
-					errs = errors.Join(errs, fmt.Errorf("status %d (rate limited, url=%s, model=%s): %s", httpResp.StatusCode, fullURL, model.ModelName, apiErr.Message))
+					errs = errors.Join(errs, llm.StatusErrorf(httpResp.StatusCode, "status %d (rate limited, url=%s, model=%s): %s", httpResp.StatusCode, fullURL, model.ModelName, apiErr.Message))
 					continue
 
 				case httpResp.StatusCode >= 400 && httpResp.StatusCode < 500:
 					// Client error, probably unrecoverable
 					slog.WarnContext(ctx, "responses_request_failed", "error", apiErr.Message, "status_code", httpResp.StatusCode, "url", fullURL, "model", model.ModelName)
-					return nil, errors.Join(errs, fmt.Errorf("status %d (url=%s, model=%s): %s", httpResp.StatusCode, fullURL, model.ModelName, apiErr.Message))
+					return nil, errors.Join(errs, llm.StatusErrorf(httpResp.StatusCode, "status %d (url=%s, model=%s): %s", httpResp.StatusCode, fullURL, model.ModelName, apiErr.Message))
 				}
 			}
 
 			// No structured error, use the raw body
 			slog.WarnContext(ctx, "responses_request_failed", "status_code", httpResp.StatusCode, "url", fullURL, "model", model.ModelName, "body", string(body))
-			return nil, fmt.Errorf("status %d (url=%s, model=%s): %s", httpResp.StatusCode, fullURL, model.ModelName, string(body))
+			return nil, llm.StatusErrorf(httpResp.StatusCode, "status %d (url=%s, model=%s): %s", httpResp.StatusCode, fullURL, model.ModelName, string(body))
 		}
 
 		// Parse successful response
@@ -523,6 +613,6 @@ func (s *ResponsesService) ConfigDetails() map[string]string {
 		"model_name":      model.ModelName,
 		"full_url":        baseURL + "/responses",
 		"api_key_env":     model.APIKeyEnv,
-		"has_api_key_set": fmt.Sprintf("%v", s.APIKey != ""),
+		"has_api_key_set": fmt.Sprintf("%v", s.apiKey() != ""),
 	}
 }
@@ -123,6 +123,30 @@ func TestFromLLMMessageResponses(t *testing.T) {
 	}
 }
 
+func TestFromLLMMessageResponsesAttachments(t *testing.T) {
+	items := fromLLMMessageResponses(llm.Message{
+		Role: llm.MessageRoleUser,
+		Content: []llm.Content{
+			{Type: llm.ContentTypeText, Text: "what changed?"},
+			{Type: llm.ContentTypeText, MediaType: "image/png", Data: "iVBORw0KGgo=", Filename: "before.png"},
+			{Type: llm.ContentTypeText, MediaType: "application/pdf", Data: "JVBERi0xLjQ=", Filename: "spec.pdf"},
+		},
+	})
+	if len(items) != 1 || len(items[0].Content) != 3 {
+		t.Fatalf("items = %+v, want one message with three contents", items)
+	}
+	content := items[0].Content
+	if content[0].Type != "input_text" || content[0].Text != "what changed?" {
+		t.Errorf("first content = %+v, want the text", content[0])
+	}
+	if content[1].Type != "input_image" || content[1].ImageURL != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("second content = %+v, want the image as a data URL", content[1])
+	}
+	if content[2].Type != "input_file" || content[2].Filename != "spec.pdf" || content[2].FileData != "data:application/pdf;base64,JVBERi0xLjQ=" {
+		t.Errorf("third content = %+v, want the PDF as a file", content[2])
+	}
+}
+
 func TestFromLLMToolResponses(t *testing.T) {
 	tool := &llm.Tool{
 		Name:        "test_tool",
@@ -240,8 +264,11 @@ func TestToLLMResponseFromResponses(t *testing.T) {
 				Model: "gpt-5.1-codex",
 				Output: []responsesOutputItem{
 					{
-						Type:    "reasoning",
-						Summary: []string{"Let me think", "about this"},
+						Type: "reasoning",
+						Summary: []responsesContent{
+							{Type: "summary_text", Text: "Let me think"},
+							{Type: "summary_text", Text: "about this"},
+						},
 					},
 					{
 						Type: "message",
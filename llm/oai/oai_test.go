@@ -1,6 +1,13 @@
 package oai
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"shelley.exe.dev/llm"
+)
 
 func TestRequiresMaxCompletionTokens(t *testing.T) {
 	tests := []struct {
@@ -101,3 +108,36 @@ func TestRequestParameterGeneration(t *testing.T) {
 		})
 	}
 }
+
+func TestFromLLMMessageAttachments(t *testing.T) {
+	messages := fromLLMMessage(llm.Message{
+		Role: llm.MessageRoleUser,
+		Content: []llm.Content{
+			{Type: llm.ContentTypeText, Text: "what changed?"},
+			{Type: llm.ContentTypeText, MediaType: "image/png", Data: "iVBORw0KGgo=", Filename: "before.png"},
+			{Type: llm.ContentTypeText, MediaType: "application/pdf", Data: "JVBERi0xLjQ=", Filename: "spec.pdf"},
+		},
+	})
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.Content != "" || len(msg.MultiContent) != 3 {
+		t.Fatalf("message = %+v, want three parts and no plain content", msg)
+	}
+	if msg.MultiContent[0].Type != openai.ChatMessagePartTypeText || msg.MultiContent[0].Text != "what changed?" {
+		t.Errorf("first part = %+v, want the text", msg.MultiContent[0])
+	}
+	if image := msg.MultiContent[1]; image.Type != openai.ChatMessagePartTypeImageURL || image.ImageURL.URL != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Errorf("second part = %+v, want the image as a data URL", image)
+	}
+	if note := msg.MultiContent[2]; note.Type != openai.ChatMessagePartTypeText || !strings.Contains(note.Text, "spec.pdf") {
+		t.Errorf("third part = %+v, want a note that the PDF was left out", note)
+	}
+
+	// Messages without attachments keep using plain content
+	messages = fromLLMMessage(llm.UserStringMessage("hello"))
+	if messages[0].Content != "hello" || messages[0].MultiContent != nil {
+		t.Errorf("text message = %+v, want plain content", messages[0])
+	}
+}
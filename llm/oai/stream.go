@@ -0,0 +1,153 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"shelley.exe.dev/llm"
+)
+
+// blockIndexer assigns stream delta indexes to content blocks in order of first appearance.
+type blockIndexer map[string]int
+
+func (b blockIndexer) index(key string) int {
+	if i, ok := b[key]; ok {
+		return i
+	}
+	b[key] = len(b)
+	return b[key]
+}
+
+// createChatCompletionStream performs a streaming chat completion, calling onDelta as content arrives,
+// and assembles the chunks into the equivalent non-streaming response.
+func createChatCompletionStream(ctx context.Context, client *openai.Client, req openai.ChatCompletionRequest, onDelta func(llm.StreamDelta)) (openai.ChatCompletionResponse, error) {
+	req.Stream = true
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer stream.Close()
+
+	var resp openai.ChatCompletionResponse
+	resp.SetHeader(stream.Header())
+	msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+	var text strings.Builder
+	var finishReason openai.FinishReason
+	blocks := make(blockIndexer)
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return openai.ChatCompletionResponse{}, fmt.Errorf("reading stream: %w", err)
+		}
+		if resp.ID == "" {
+			resp.ID = chunk.ID
+			resp.Model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			resp.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+			d := choice.Delta
+			if d.ReasoningContent != "" {
+				onDelta(llm.StreamDelta{Index: blocks.index("thinking"), Type: llm.ContentTypeThinking, Text: d.ReasoningContent})
+			}
+			if d.Content != "" {
+				text.WriteString(d.Content)
+				onDelta(llm.StreamDelta{Index: blocks.index("text"), Type: llm.ContentTypeText, Text: d.Content})
+			}
+			for _, tc := range d.ToolCalls {
+				// Tool call fragments are identified by index. Some providers omit it;
+				// then a fragment with an ID starts a new call and one without continues the last.
+				i := len(msg.ToolCalls)
+				if tc.Index != nil {
+					i = *tc.Index
+				} else if tc.ID == "" && i > 0 {
+					i--
+				}
+				for len(msg.ToolCalls) <= i {
+					msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
+				}
+				call := &msg.ToolCalls[i]
+				delta := llm.StreamDelta{Index: blocks.index(fmt.Sprintf("tool:%d", i)), Type: llm.ContentTypeToolUse, Text: tc.Function.Arguments}
+				if tc.ID != "" {
+					call.ID = tc.ID
+					delta.ID = tc.ID
+				}
+				if tc.Function.Name != "" {
+					call.Function.Name += tc.Function.Name
+					delta.ToolName = call.Function.Name
+				}
+				call.Function.Arguments += tc.Function.Arguments
+				onDelta(delta)
+			}
+		}
+	}
+	msg.Content = text.String()
+	resp.Choices = []openai.ChatCompletionChoice{{Message: msg, FinishReason: finishReason}}
+	return resp, nil
+}
+
+// responsesStreamEvent is a server-sent event from the streaming Responses API.
+// See https://platform.openai.com/docs/api-reference/responses-streaming
+type responsesStreamEvent struct {
+	Type        string              `json:"type"`
+	OutputIndex int                 `json:"output_index"`
+	Delta       string              `json:"delta"`
+	Item        responsesOutputItem `json:"item"`     // response.output_item.added
+	Response    *responsesResponse  `json:"response"` // response.completed, response.incomplete, response.failed
+	Message     string              `json:"message"`  // error
+}
+
+// readResponsesStream reads a streaming Responses API body, calling onDelta as output arrives,
+// and returns the final response carried by the terminal event.
+func readResponsesStream(r io.Reader, onDelta func(llm.StreamDelta)) (*responsesResponse, error) {
+	var final *responsesResponse
+	err := llm.ReadSSE(r, func(_ string, data []byte) error {
+		var ev responsesStreamEvent
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return fmt.Errorf("decoding stream event: %w", err)
+		}
+		switch ev.Type {
+		case "response.output_text.delta":
+			onDelta(llm.StreamDelta{Index: ev.OutputIndex, Type: llm.ContentTypeText, Text: ev.Delta})
+		case "response.reasoning_summary_text.delta":
+			onDelta(llm.StreamDelta{Index: ev.OutputIndex, Type: llm.ContentTypeThinking, Text: ev.Delta})
+		case "response.output_item.added":
+			if ev.Item.Type == "function_call" {
+				onDelta(llm.StreamDelta{Index: ev.OutputIndex, Type: llm.ContentTypeToolUse, ID: ev.Item.CallID, ToolName: ev.Item.Name})
+			}
+		case "response.function_call_arguments.delta":
+			onDelta(llm.StreamDelta{Index: ev.OutputIndex, Type: llm.ContentTypeToolUse, Text: ev.Delta})
+		case "response.completed", "response.incomplete", "response.failed":
+			if ev.Response == nil {
+				return fmt.Errorf("%s event without response", ev.Type)
+			}
+			final = ev.Response
+		case "error":
+			return fmt.Errorf("stream error: %s", ev.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if final == nil {
+		return nil, fmt.Errorf("stream ended before response completed")
+	}
+	return final, nil
+}
@@ -0,0 +1,112 @@
+package oai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func sseClient(body string) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})}
+}
+
+func TestServiceDoStream(t *testing.T) {
+	body := `data: {"id":"c1","model":"test","choices":[{"index":0,"delta":{"role":"assistant","content":"Checking"}}]}
+
+data: {"id":"c1","model":"test","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"bash","arguments":""}}]}}]}
+
+data: {"id":"c1","model":"test","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"command\":\"ls\"}"}}]}}]}
+
+data: {"id":"c1","model":"test","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: {"id":"c1","model":"test","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}
+
+data: [DONE]
+
+`
+	svc := &Service{
+		HTTPC:    sseClient(body),
+		APIKey:   "test",
+		Model:    GPT41,
+		ModelURL: "https://example.com/v1",
+	}
+	var deltas []llm.StreamDelta
+	resp, err := svc.DoStream(context.Background(), &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("list files")},
+	}, func(d llm.StreamDelta) {
+		deltas = append(deltas, d)
+	})
+	if err != nil {
+		t.Fatalf("DoStream: %v", err)
+	}
+	if len(deltas) != 3 {
+		t.Fatalf("got %d deltas, want 3: %+v", len(deltas), deltas)
+	}
+	if deltas[1].ToolName != "bash" || deltas[1].ID != "call_1" || deltas[1].Index != 1 {
+		t.Errorf("unexpected tool delta: %+v", deltas[1])
+	}
+	if resp.StopReason != llm.StopReasonToolUse {
+		t.Errorf("StopReason = %v, want tool use", resp.StopReason)
+	}
+	if resp.Usage.InputTokens != 10 || resp.Usage.OutputTokens != 5 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+	if len(resp.Content) != 2 || resp.Content[0].Text != "Checking" || string(resp.Content[1].ToolInput) != `{"command":"ls"}` {
+		t.Errorf("unexpected content: %+v", resp.Content)
+	}
+}
+
+func TestResponsesServiceDoStream(t *testing.T) {
+	body := `event: response.output_item.added
+data: {"type":"response.output_item.added","output_index":0,"item":{"type":"message","role":"assistant"}}
+
+event: response.output_text.delta
+data: {"type":"response.output_text.delta","output_index":0,"delta":"Hel"}
+
+event: response.output_text.delta
+data: {"type":"response.output_text.delta","output_index":0,"delta":"lo"}
+
+event: response.completed
+data: {"type":"response.completed","response":{"id":"r1","model":"test","status":"completed","output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Hello"}]}],"usage":{"input_tokens":3,"output_tokens":2}}}
+
+`
+	svc := &ResponsesService{
+		HTTPC:    sseClient(body),
+		APIKey:   "test",
+		Model:    GPT5Codex,
+		ModelURL: "https://example.com/v1",
+	}
+	var text strings.Builder
+	resp, err := svc.DoStream(context.Background(), &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("hi")},
+	}, func(d llm.StreamDelta) {
+		text.WriteString(d.Text)
+	})
+	if err != nil {
+		t.Fatalf("DoStream: %v", err)
+	}
+	if text.String() != "Hello" {
+		t.Errorf("streamed text = %q", text.String())
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Text != "Hello" {
+		t.Errorf("unexpected content: %+v", resp.Content)
+	}
+	if resp.Usage.OutputTokens != 2 {
+		t.Errorf("unexpected usage: %+v", resp.Usage)
+	}
+}
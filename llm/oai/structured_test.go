@@ -0,0 +1,59 @@
+package oai
+
+import (
+	"context"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+var testResponseFormat = &llm.ResponseFormat{
+	Name:        "conversation_slug",
+	Description: "A slug for the conversation",
+	Schema:      llm.MustSchema(`{"type": "object", "properties": {"slug": {"type": "string"}}}`),
+}
+
+func TestServiceResponseFormat(t *testing.T) {
+	body := `data: {"id":"c1","model":"test","choices":[{"index":0,"delta":{"role":"assistant","content":"{\"slug\":\"x\"}"},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+	var sent map[string]any
+	svc := &Service{HTTPC: capturingClient(body, &sent), APIKey: "test", Model: GPT41, ModelURL: "https://example.com/v1"}
+	resp, err := svc.DoStream(context.Background(), &llm.Request{
+		Messages:       []llm.Message{llm.UserStringMessage("hi")},
+		ResponseFormat: testResponseFormat,
+	}, func(llm.StreamDelta) {})
+	if err != nil {
+		t.Fatalf("DoStream: %v", err)
+	}
+	format, _ := sent["response_format"].(map[string]any)
+	schema, _ := format["json_schema"].(map[string]any)
+	if format["type"] != "json_schema" || schema["name"] != "conversation_slug" || schema["schema"] == nil {
+		t.Errorf("response_format = %v, want the json_schema", sent["response_format"])
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Text != `{"slug":"x"}` {
+		t.Errorf("unexpected content: %+v", resp.Content)
+	}
+}
+
+func TestResponsesServiceResponseFormat(t *testing.T) {
+	body := `event: response.completed
+data: {"type":"response.completed","response":{"id":"r1","model":"test","status":"completed","output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"{\"slug\":\"x\"}"}]}],"usage":{"input_tokens":3,"output_tokens":2}}}
+
+`
+	var sent map[string]any
+	svc := &ResponsesService{HTTPC: capturingClient(body, &sent), APIKey: "test", Model: GPT5Codex, ModelURL: "https://example.com/v1"}
+	if _, err := svc.DoStream(context.Background(), &llm.Request{
+		Messages:       []llm.Message{llm.UserStringMessage("hi")},
+		ResponseFormat: testResponseFormat,
+	}, func(llm.StreamDelta) {}); err != nil {
+		t.Fatalf("DoStream: %v", err)
+	}
+	text, _ := sent["text"].(map[string]any)
+	format, _ := text["format"].(map[string]any)
+	if format["type"] != "json_schema" || format["name"] != "conversation_slug" || format["schema"] == nil {
+		t.Errorf("text = %v, want a json_schema format", sent["text"])
+	}
+}
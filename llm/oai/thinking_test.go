@@ -0,0 +1,85 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+// capturingClient responds with body as a server-sent event stream and stores each request body in sent.
+func capturingClient(body string, sent *map[string]any) *http.Client {
+	return &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if err := json.NewDecoder(req.Body).Decode(sent); err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})}
+}
+
+func TestServiceReasoningEffort(t *testing.T) {
+	body := `data: {"id":"c1","model":"test","choices":[{"index":0,"delta":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+	tests := []struct {
+		name     string
+		model    Model
+		thinking *llm.Thinking
+		want     any
+	}{
+		{"reasoning model", GPT5, &llm.Thinking{Effort: llm.ThinkingEffortHigh}, "high"},
+		{"budget mapped to effort", GPT5, &llm.Thinking{BudgetTokens: 10000}, "medium"},
+		{"no thinking", GPT5, nil, nil},
+		{"model without reasoning", GPT41, &llm.Thinking{Effort: llm.ThinkingEffortHigh}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]any
+			svc := &Service{HTTPC: capturingClient(body, &sent), APIKey: "test", Model: tt.model, ModelURL: "https://example.com/v1"}
+			_, err := svc.DoStream(context.Background(), &llm.Request{
+				Messages: []llm.Message{llm.UserStringMessage("hi")},
+				Thinking: tt.thinking,
+			}, func(llm.StreamDelta) {})
+			if err != nil {
+				t.Fatalf("DoStream: %v", err)
+			}
+			if got := sent["reasoning_effort"]; got != tt.want {
+				t.Errorf("reasoning_effort = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponsesServiceReasoning(t *testing.T) {
+	body := `event: response.completed
+data: {"type":"response.completed","response":{"id":"r1","model":"test","status":"completed","output":[{"type":"reasoning","summary":[{"type":"summary_text","text":"Planning"}]},{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Hello"}]}],"usage":{"input_tokens":3,"output_tokens":2}}}
+
+`
+	var sent map[string]any
+	svc := &ResponsesService{HTTPC: capturingClient(body, &sent), APIKey: "test", Model: GPT5Codex, ModelURL: "https://example.com/v1"}
+	resp, err := svc.DoStream(context.Background(), &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("hi")},
+		Thinking: &llm.Thinking{Effort: llm.ThinkingEffortLow},
+	}, func(llm.StreamDelta) {})
+	if err != nil {
+		t.Fatalf("DoStream: %v", err)
+	}
+	reasoning, _ := sent["reasoning"].(map[string]any)
+	if reasoning["effort"] != "low" || reasoning["summary"] != "auto" {
+		t.Errorf("reasoning = %v, want low effort with summaries", sent["reasoning"])
+	}
+	if len(resp.Content) != 2 || resp.Content[0].Type != llm.ContentTypeThinking || resp.Content[0].Text != "Planning" {
+		t.Errorf("unexpected content: %+v", resp.Content)
+	}
+}
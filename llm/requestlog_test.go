@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestLog(t *testing.T) {
+	// Without a log, IDs are dropped
+	AddRequestID(context.Background(), 1)
+	if id := LastRequestID(context.Background()); id != 0 {
+		t.Errorf("LastRequestID without a log = %d, want 0", id)
+	}
+
+	ctx := WithRequestLog(context.Background())
+	if id := LastRequestID(ctx); id != 0 {
+		t.Errorf("LastRequestID of an empty log = %d, want 0", id)
+	}
+	AddRequestID(ctx, 7)
+	AddRequestID(ctx, 9)
+	if id := LastRequestID(ctx); id != 9 {
+		t.Errorf("LastRequestID = %d, want 9", id)
+	}
+
+	// Derived contexts share the log
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	AddRequestID(child, 11)
+	if id := LastRequestID(ctx); id != 11 {
+		t.Errorf("LastRequestID after adding through a derived context = %d, want 11", id)
+	}
+}
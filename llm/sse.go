@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// ReadSSE reads server-sent events from r, calling fn with the event name and data of each event.
+// Multi-line data fields are joined with newlines. The data slice is only valid until fn returns.
+// ReadSSE returns when r is exhausted or fn returns an error.
+func ReadSSE(r io.Reader, fn func(event string, data []byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	var event string
+	var data bytes.Buffer
+	dispatch := func() error {
+		if event == "" && data.Len() == 0 {
+			return nil
+		}
+		err := fn(event, data.Bytes())
+		event = ""
+		data.Reset()
+		return err
+	}
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			if err := dispatch(); err != nil {
+				return err
+			}
+			continue
+		}
+		if line[0] == ':' {
+			continue // comment
+		}
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "event":
+			event = string(value)
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(value)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return dispatch()
+}
@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReadSSE(t *testing.T) {
+	input := ": keepalive\n" +
+		"event: message_start\n" +
+		"data: {\"a\":1}\n" +
+		"\n" +
+		"data: line one\n" +
+		"data: line two\n" +
+		"\n" +
+		"event: ping\n" +
+		"data:{}\n" // no trailing blank line
+
+	type ev struct{ event, data string }
+	var got []ev
+	err := ReadSSE(strings.NewReader(input), func(event string, data []byte) error {
+		got = append(got, ev{event, string(data)})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadSSE: %v", err)
+	}
+	want := []ev{
+		{"message_start", `{"a":1}`},
+		{"", "line one\nline two"},
+		{"ping", "{}"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+type fakeService struct {
+	streamed bool
+}
+
+func (f *fakeService) Do(context.Context, *Request) (*Response, error) {
+	return &Response{}, nil
+}
+
+func (f *fakeService) DoStream(_ context.Context, _ *Request, onDelta func(StreamDelta)) (*Response, error) {
+	f.streamed = true
+	onDelta(StreamDelta{Type: ContentTypeText, Text: "hi"})
+	return &Response{}, nil
+}
+
+func (f *fakeService) TokenContextWindow() int { return 0 }
+func (f *fakeService) MaxImageDimension() int  { return 0 }
+
+func TestDoStream(t *testing.T) {
+	svc := &fakeService{}
+	if _, err := DoStream(context.Background(), svc, &Request{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if svc.streamed {
+		t.Fatal("DoStream with nil onDelta should not stream")
+	}
+	var deltas []StreamDelta
+	if _, err := DoStream(context.Background(), svc, &Request{}, func(d StreamDelta) { deltas = append(deltas, d) }); err != nil {
+		t.Fatal(err)
+	}
+	if !svc.streamed || len(deltas) != 1 || deltas[0].Text != "hi" {
+		t.Fatalf("expected one streamed delta, got streamed=%v deltas=%+v", svc.streamed, deltas)
+	}
+}
@@ -0,0 +1,175 @@
+package llm
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ResponseFormat asks the model to answer with a JSON value matching Schema instead of free text.
+// Providers map it to their own structured output support: forced tool use for Anthropic,
+// response_format for OpenAI and responseSchema for Gemini.
+// The JSON is returned as the text of the response's only content.
+type ResponseFormat struct {
+	// Name identifies the schema to the provider. It must be a valid tool name, e.g. "conversation_slug".
+	Name string
+	// Description tells the model what the value is for (optional).
+	Description string
+	// Schema is a JSON schema whose root is an object.
+	// Keep to the widely supported subset: type, properties, required, items, enum and description.
+	Schema json.RawMessage
+}
+
+// Validator is implemented by results of DoJSON that have constraints beyond their schema.
+type Validator interface {
+	Validate() error
+}
+
+// jsonAttempts is how many times DoJSON asks for a valid answer before giving up.
+const jsonAttempts = 3
+
+// DoJSON sends req, which must have a ResponseFormat, and unmarshals the model's answer into v.
+// An answer that does not match the schema, or whose value fails Validate, is sent back to the model
+// with the problem, and it is asked again, up to a few times.
+// Models without structured output support often wrap JSON in prose or code fences; those are tolerated.
+func DoJSON(ctx context.Context, svc Service, req *Request, v any) error {
+	if req.ResponseFormat == nil {
+		return fmt.Errorf("DoJSON: request has no ResponseFormat")
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(req.ResponseFormat.Schema, &schema); err != nil {
+		return fmt.Errorf("DoJSON: invalid schema: %w", err)
+	}
+
+	attempt := *req
+	attempt.Messages = slices.Clone(req.Messages)
+	var errs error
+	for range jsonAttempts {
+		resp, err := svc.Do(ctx, &attempt)
+		if err != nil {
+			return errors.Join(errs, err)
+		}
+		text := responseText(resp)
+		err = decodeJSON(text, schema, v)
+		if err == nil {
+			return nil
+		}
+		errs = errors.Join(errs, err)
+		attempt.Messages = append(attempt.Messages,
+			Message{Role: MessageRoleAssistant, Content: TextContent(cmp.Or(text, "(no response)"))},
+			UserStringMessage(fmt.Sprintf("That response was invalid: %v\nRespond again with only a JSON value matching this schema:\n%s", err, req.ResponseFormat.Schema)),
+		)
+	}
+	return fmt.Errorf("no valid %s response after %d attempts: %w", req.ResponseFormat.Name, jsonAttempts, errs)
+}
+
+// responseText returns the text of resp, or the input of its tool call if it has no text.
+func responseText(resp *Response) string {
+	var text strings.Builder
+	for _, c := range resp.Content {
+		if c.Type == ContentTypeText {
+			text.WriteString(c.Text)
+		}
+	}
+	if text.Len() == 0 {
+		for _, c := range resp.Content {
+			if c.Type == ContentTypeToolUse {
+				return string(c.ToolInput)
+			}
+		}
+	}
+	return text.String()
+}
+
+// decodeJSON extracts the JSON object in text, checks it against schema and unmarshals it into v.
+func decodeJSON(text string, schema map[string]any, v any) error {
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return fmt.Errorf("response is not a JSON object")
+	}
+	raw := []byte(text[start : end+1])
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return fmt.Errorf("response is not valid JSON: %w", err)
+	}
+	if err := validateJSON(schema, value, "$"); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("response does not fit the expected structure: %w", err)
+	}
+	if val, ok := v.(Validator); ok {
+		if err := val.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateJSON checks value against the subset of JSON schema described on ResponseFormat.Schema.
+func validateJSON(schema map[string]any, value any, path string) error {
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return e == value }) {
+		return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+	}
+
+	switch typ, _ := schema["type"].(string); typ {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object", path)
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := obj[name]; !present {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, propSchema := range properties {
+			propValue, present := obj[name]
+			propSchema, ok := propSchema.(map[string]any)
+			if !present || !ok {
+				continue
+			}
+			if err := validateJSON(propSchema, propValue, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array", path)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range arr {
+				if err := validateJSON(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected a string", path)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s: expected a number", path)
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: expected an integer", path)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scriptedService answers with its texts in turn and records the requests it receives
+type scriptedService struct {
+	Service
+	texts    []string
+	requests []*Request
+}
+
+func (s *scriptedService) Do(ctx context.Context, req *Request) (*Response, error) {
+	s.requests = append(s.requests, req)
+	if len(s.texts) == 0 {
+		return nil, errors.New("no more responses")
+	}
+	text := s.texts[0]
+	s.texts = s.texts[1:]
+	return &Response{Content: TextContent(text)}, nil
+}
+
+var testFormat = &ResponseFormat{Name: "answer", Schema: MustSchema(`{
+  "type": "object",
+  "required": ["name", "tags"],
+  "properties": {
+    "name": {"type": "string"},
+    "count": {"type": "integer"},
+    "tags": {"type": "array", "items": {"type": "string", "enum": ["a", "b"]}}
+  }
+}`)}
+
+type testAnswer struct {
+	Name  string   `json:"name"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags"`
+}
+
+func (a *testAnswer) Validate() error {
+	if a.Name == "" {
+		return errors.New("name is empty")
+	}
+	return nil
+}
+
+func TestDoJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		texts    []string
+		want     testAnswer
+		attempts int
+		wantErr  bool
+	}{
+		{name: "valid", texts: []string{`{"name": "x", "count": 2, "tags": ["a"]}`}, want: testAnswer{Name: "x", Count: 2, Tags: []string{"a"}}, attempts: 1},
+		{name: "chatty", texts: []string{"Sure!\n```json\n{\"name\": \"x\", \"tags\": []}\n```\nAnything else?"}, want: testAnswer{Name: "x", Tags: []string{}}, attempts: 1},
+		{name: "missing property then valid", texts: []string{`{"name": "x"}`, `{"name": "y", "tags": ["b"]}`}, want: testAnswer{Name: "y", Tags: []string{"b"}}, attempts: 2},
+		{name: "enum then valid", texts: []string{`{"name": "x", "tags": ["c"]}`, `{"name": "x", "tags": ["a"]}`}, want: testAnswer{Name: "x", Tags: []string{"a"}}, attempts: 2},
+		{name: "fails Validate then valid", texts: []string{`{"name": "", "tags": []}`, `{"name": "z", "tags": []}`}, want: testAnswer{Name: "z", Tags: []string{}}, attempts: 2},
+		{name: "never valid", texts: []string{"no", `{"name": 1}`, `{"count": 1.5}`}, attempts: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &scriptedService{texts: tt.texts}
+			req := &Request{Messages: []Message{UserStringMessage("answer")}, ResponseFormat: testFormat}
+			var got testAnswer
+			err := DoJSON(context.Background(), svc, req, &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DoJSON error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(svc.requests) != tt.attempts {
+				t.Errorf("made %d requests, want %d", len(svc.requests), tt.attempts)
+			}
+			if len(req.Messages) != 1 {
+				t.Error("the original request was modified")
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Name != tt.want.Name || got.Count != tt.want.Count || strings.Join(got.Tags, ",") != strings.Join(tt.want.Tags, ",") {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if tt.attempts > 1 {
+				// The retry shows the model its invalid answer and what was wrong with it
+				retry := svc.requests[1].Messages
+				if len(retry) != 3 || retry[1].Role != MessageRoleAssistant || !strings.Contains(retry[2].Content[0].Text, "invalid") {
+					t.Errorf("unexpected retry messages %+v", retry)
+				}
+			}
+		})
+	}
+}
+
+func TestDoJSONRequiresResponseFormat(t *testing.T) {
+	var got testAnswer
+	if err := DoJSON(context.Background(), &scriptedService{}, &Request{}, &got); err == nil {
+		t.Error("DoJSON without a ResponseFormat should fail")
+	}
+}
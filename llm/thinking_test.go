@@ -0,0 +1,32 @@
+package llm
+
+import "testing"
+
+func TestThinking(t *testing.T) {
+	tests := []struct {
+		thinking Thinking
+		effort   string
+		budget   int
+		valid    bool
+	}{
+		{Thinking{Effort: ThinkingEffortLow}, "low", 4096, true},
+		{Thinking{Effort: ThinkingEffortHigh}, "high", 32768, true},
+		{Thinking{BudgetTokens: 2000}, "low", 2000, true},
+		{Thinking{BudgetTokens: 10000}, "medium", 10000, true},
+		{Thinking{BudgetTokens: 100000}, "high", 100000, true},
+		{Thinking{Effort: "extreme"}, "extreme", 4096, false},
+		{Thinking{BudgetTokens: -1}, "low", 4096, false},
+		{Thinking{}, "low", 4096, false},
+	}
+	for _, tt := range tests {
+		if got := tt.thinking.EffortLevel(); got != tt.effort {
+			t.Errorf("%+v EffortLevel() = %q, want %q", tt.thinking, got, tt.effort)
+		}
+		if got := tt.thinking.Budget(); got != tt.budget {
+			t.Errorf("%+v Budget() = %d, want %d", tt.thinking, got, tt.budget)
+		}
+		if err := tt.thinking.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v Validate() = %v, want valid %v", tt.thinking, err, tt.valid)
+		}
+	}
+}
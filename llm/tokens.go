@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenCounter is implemented by services that can count the input tokens of a request before sending it.
+type TokenCounter interface {
+	// CountTokens returns the number of input tokens req would use,
+	// including its system prompt and tool definitions.
+	CountTokens(ctx context.Context, req *Request) (uint64, error)
+}
+
+// ErrTokenCountUnsupported is returned by CountTokens for services that cannot count tokens.
+var ErrTokenCountUnsupported = errors.New("llm: service does not count tokens")
+
+// CountTokens counts the input tokens of req with svc.
+// It returns ErrTokenCountUnsupported if svc is not a TokenCounter.
+func CountTokens(ctx context.Context, svc Service, req *Request) (uint64, error) {
+	if tc, ok := svc.(TokenCounter); ok {
+		return tc.CountTokens(ctx, req)
+	}
+	return 0, ErrTokenCountUnsupported
+}
+
+// Per-item overheads used by EstimateTokens, following OpenAI's guidance for chat models:
+// every message is wrapped in a few formatting tokens, and the reply is primed with a few more.
+const (
+	messageOverheadTokens = 3
+	replyOverheadTokens   = 3
+	toolOverheadTokens    = 8
+	// imageTokens is the cost of a high-detail 1024x1024 image: 85 base tokens plus 170 per 512px tile.
+	imageTokens = 765
+)
+
+// pretokenRegexp splits text roughly the way BPE tokenizers such as o200k_base do before merging:
+// words with their leading space, numbers in groups of up to three digits, punctuation runs, and whitespace.
+var pretokenRegexp = regexp.MustCompile(`\s?\p{L}+|\p{N}{1,3}|\s?[^\s\p{L}\p{N}]+|\s+`)
+
+// EstimateTokens approximates the input tokens of req without a tokenizer.
+// It suits OpenAI-compatible models, whose APIs have no counting endpoint.
+// The estimate is rough, but close enough to size a conversation against its context window.
+func EstimateTokens(req *Request) uint64 {
+	tokens := replyOverheadTokens
+	for _, sys := range req.System {
+		tokens += estimateTextTokens(sys.Text)
+	}
+	if len(req.System) > 0 {
+		tokens += messageOverheadTokens
+	}
+	for _, msg := range req.Messages {
+		tokens += messageOverheadTokens
+		for _, c := range msg.Content {
+			tokens += estimateContentTokens(c)
+		}
+	}
+	for _, tool := range req.Tools {
+		tokens += toolOverheadTokens
+		tokens += estimateTextTokens(tool.Name)
+		tokens += estimateTextTokens(tool.Description)
+		tokens += estimateTextTokens(string(tool.InputSchema))
+	}
+	return uint64(tokens)
+}
+
+func estimateContentTokens(c Content) int {
+	if strings.HasPrefix(c.MediaType, "image/") {
+		return imageTokens
+	}
+	switch c.Type {
+	case ContentTypeText:
+		return estimateTextTokens(c.Text)
+	case ContentTypeThinking:
+		return estimateTextTokens(c.Thinking)
+	case ContentTypeToolUse:
+		return estimateTextTokens(c.ToolName) + estimateTextTokens(string(c.ToolInput))
+	case ContentTypeToolResult:
+		tokens := 0
+		for _, result := range c.ToolResult {
+			tokens += estimateContentTokens(result)
+		}
+		return tokens
+	}
+	return 0
+}
+
+// estimateTextTokens approximates how many tokens text is split into.
+// Common words are a single token; longer words and non-Latin scripts take more.
+func estimateTextTokens(text string) int {
+	tokens := 0
+	for _, piece := range pretokenRegexp.FindAllString(text, -1) {
+		runes := utf8.RuneCountInString(piece)
+		switch {
+		case strings.TrimSpace(piece) == "":
+			tokens++
+		case runes != len(piece):
+			// Non-ASCII scripts average close to one token per character
+			tokens += runes
+		case strings.ContainsFunc(piece, unicode.IsLetter):
+			// Words of up to six letters are usually one token
+			tokens += (len(piece) + 5) / 6
+		case strings.ContainsFunc(piece, unicode.IsDigit):
+			// Numbers are split into groups of up to three digits, one token each
+			tokens++
+		default:
+			// Punctuation merges less, about two characters per token
+			tokens += (len(piece) + 1) / 2
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type countingService struct {
+	Service
+	tokens uint64
+}
+
+func (s *countingService) CountTokens(context.Context, *Request) (uint64, error) {
+	return s.tokens, nil
+}
+
+func TestCountTokens(t *testing.T) {
+	req := &Request{Messages: []Message{UserStringMessage("hi")}}
+	n, err := CountTokens(context.Background(), &countingService{tokens: 42}, req)
+	if err != nil || n != 42 {
+		t.Errorf("CountTokens = %d, %v; want 42", n, err)
+	}
+
+	var plain struct{ Service }
+	if _, err := CountTokens(context.Background(), plain, req); !errors.Is(err, ErrTokenCountUnsupported) {
+		t.Errorf("CountTokens error = %v, want ErrTokenCountUnsupported", err)
+	}
+}
+
+func TestEstimateTextTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 1},
+		{"Hello world", 2},
+		{"12345", 2},
+		{"internationalization", 4},
+		{"a, b", 3},
+		{"你好", 2},
+		{"func main() {}", 5},
+	}
+	for _, tt := range tests {
+		if got := estimateTextTokens(tt.text); got != tt.want {
+			t.Errorf("estimateTextTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)
+	req := &Request{
+		System:   []SystemContent{{Type: "text", Text: "You are helpful."}},
+		Messages: []Message{UserStringMessage(text)},
+	}
+	got := EstimateTokens(req)
+	// A BPE tokenizer splits each sentence into 10 tokens
+	if got < 900 || got > 1100 {
+		t.Errorf("EstimateTokens = %d, want about 1000", got)
+	}
+
+	withImage := &Request{Messages: []Message{{Role: MessageRoleUser, Content: []Content{
+		{Type: ContentTypeText, MediaType: "image/png", Data: strings.Repeat("A", 100000)},
+	}}}}
+	if got, want := EstimateTokens(withImage), uint64(replyOverheadTokens+messageOverheadTokens+imageTokens); got != want {
+		t.Errorf("EstimateTokens with an image = %d, want %d", got, want)
+	}
+
+	withTools := &Request{
+		Messages: []Message{UserStringMessage("hi")},
+		Tools:    []*Tool{{Name: "bash", Description: "Run a shell command", InputSchema: EmptySchema()}},
+	}
+	if EstimateTokens(withTools) <= EstimateTokens(&Request{Messages: withTools.Messages}) {
+		t.Error("tool definitions should count towards the estimate")
+	}
+}
@@ -0,0 +1,76 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"shelley.exe.dev/llm"
+)
+
+// ErrBudgetExceeded is returned when a turn stops because a budget was exceeded.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Budget limits the resources a conversation may use.
+// The zero value of each field means unlimited.
+type Budget struct {
+	// MaxDollars is the most the whole conversation may cost.
+	MaxDollars float64 `json:"max_dollars,omitempty"`
+	// MaxTokens is the most input and output tokens the whole conversation may use.
+	MaxTokens uint64 `json:"max_tokens,omitempty"`
+	// MaxToolCallsPerTurn is the most tool calls run between two user messages.
+	MaxToolCallsPerTurn int `json:"max_tool_calls_per_turn,omitempty"`
+}
+
+// SetBudget replaces the loop's budget. It takes effect at the next LLM request or tool call.
+func (l *Loop) SetBudget(budget Budget) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.budget = budget
+}
+
+// Exceeded reports why usage exceeds the limits on dollars and tokens, or "" if it does not.
+func (b Budget) Exceeded(u llm.Usage) string {
+	if b.MaxDollars > 0 && u.CostUSD >= b.MaxDollars {
+		return fmt.Sprintf("$%.2f spent, budget is $%.2f", u.CostUSD, b.MaxDollars)
+	}
+	if tokens := u.TotalInputTokens() + u.OutputTokens; b.MaxTokens > 0 && tokens >= b.MaxTokens {
+		return fmt.Sprintf("%d tokens used, budget is %d", tokens, b.MaxTokens)
+	}
+	return ""
+}
+
+// overBudget reports why usage exceeds the conversation-wide limits or those it shares
+// with other conversations, or "" if it does not.
+func (l *Loop) overBudget() string {
+	l.mu.Lock()
+	reason := l.budget.Exceeded(l.totalUsage)
+	l.mu.Unlock()
+	if reason == "" && l.sharedBudgetExceeded != nil {
+		reason = l.sharedBudgetExceeded()
+	}
+	return reason
+}
+
+// stopForBudget ends the turn with a recorded message explaining which budget was exceeded,
+// marked with llm.TurnBudgetExceeded.
+func (l *Loop) stopForBudget(ctx context.Context, reason string) error {
+	l.logger.Warn("stopping turn: budget exceeded", "reason", reason)
+	message := llm.Message{
+		Role:      llm.MessageRoleAssistant,
+		Content:   []llm.Content{{Type: llm.ContentTypeText, Text: fmt.Sprintf("[Budget exceeded: %s. Stopping.]", reason)}},
+		EndOfTurn: true,
+		TurnEnd:   llm.TurnBudgetExceeded,
+	}
+
+	l.mu.Lock()
+	l.history = append(l.history, message)
+	l.mu.Unlock()
+
+	if err := l.recordMessage(ctx, message, llm.Usage{}); err != nil {
+		l.logger.Error("failed to record budget message", "error", err)
+	}
+
+	l.checkGitStateChange(ctx)
+	return fmt.Errorf("%w: %s", ErrBudgetExceeded, reason)
+}
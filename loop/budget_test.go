@@ -0,0 +1,132 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+func TestLoopStopsWhenOverBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		budget     Budget
+		usage      llm.Usage
+		wantReason string
+	}{
+		{name: "dollars", budget: Budget{MaxDollars: 1}, usage: llm.Usage{CostUSD: 1.5}, wantReason: "$1.50 spent, budget is $1.00"},
+		{name: "tokens", budget: Budget{MaxTokens: 1000}, usage: llm.Usage{InputTokens: 600, CacheReadInputTokens: 300, OutputTokens: 200}, wantReason: "1100 tokens used, budget is 1000"},
+		{name: "within budget", budget: Budget{MaxDollars: 2, MaxTokens: 2000}, usage: llm.Usage{CostUSD: 1.5, InputTokens: 600}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewPredictableService()
+			var recorded []llm.Message
+			loop := NewLoop(Config{
+				LLM: service,
+				RecordMessage: func(_ context.Context, msg llm.Message, _ llm.Usage) error {
+					recorded = append(recorded, msg)
+					return nil
+				},
+				Budget: tt.budget,
+				Usage:  tt.usage,
+			})
+			loop.QueueUserMessage(llm.UserStringMessage("echo: hi"))
+			err := loop.ProcessOneTurn(context.Background())
+
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("ProcessOneTurn: %v", err)
+				}
+				if service.GetLastRequest() == nil {
+					t.Fatal("expected an LLM request")
+				}
+				return
+			}
+			if !errors.Is(err, ErrBudgetExceeded) {
+				t.Fatalf("ProcessOneTurn error = %v, want ErrBudgetExceeded", err)
+			}
+			if service.GetLastRequest() != nil {
+				t.Error("LLM was called despite the exceeded budget")
+			}
+			if len(recorded) != 1 || !recorded[0].EndOfTurn {
+				t.Fatalf("expected one end-of-turn message, got %+v", recorded)
+			}
+			if text := recorded[0].Content[0].Text; !strings.Contains(text, tt.wantReason) {
+				t.Errorf("recorded %q, want it to mention %q", text, tt.wantReason)
+			}
+			if recorded[0].TurnEnd != llm.TurnBudgetExceeded {
+				t.Errorf("recorded TurnEnd = %q, want %q", recorded[0].TurnEnd, llm.TurnBudgetExceeded)
+			}
+		})
+	}
+}
+
+func TestLoopToolCallsPerTurnBudget(t *testing.T) {
+	var runs atomic.Int32
+	tool := &llm.Tool{
+		Name:        "count",
+		InputSchema: llm.EmptySchema(),
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			runs.Add(1)
+			return llm.ToolOut{LLMContent: llm.TextContent("ok")}
+		},
+	}
+	service := NewPredictableService()
+	var recorded []llm.Message
+	loop := NewLoop(Config{
+		LLM:   service,
+		Tools: []*llm.Tool{tool},
+		RecordMessage: func(_ context.Context, msg llm.Message, _ llm.Usage) error {
+			recorded = append(recorded, msg)
+			return nil
+		},
+		Budget: Budget{MaxToolCallsPerTurn: 2},
+	})
+	toolUses := func(n int) []llm.Content {
+		var content []llm.Content
+		for i := range n {
+			content = append(content, llm.Content{Type: llm.ContentTypeToolUse, ID: fmt.Sprintf("call_%d", i), ToolName: "count", ToolInput: json.RawMessage("{}")})
+		}
+		return content
+	}
+
+	err := loop.handleToolCalls(context.Background(), toolUses(3))
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("handleToolCalls error = %v, want ErrBudgetExceeded", err)
+	}
+	if n := runs.Load(); n != 2 {
+		t.Errorf("ran %d tool calls, want 2", n)
+	}
+	if service.GetLastRequest() != nil {
+		t.Error("LLM was called after the tool call budget was exceeded")
+	}
+	if len(recorded) != 2 {
+		t.Fatalf("recorded %d messages, want tool results and a budget message", len(recorded))
+	}
+	results := recorded[0].Content
+	if len(results) != 3 || results[1].ToolError || !results[2].ToolError {
+		t.Errorf("want the first two calls to succeed and the third to fail, got %+v", results)
+	}
+	if text := recorded[1].Content[0].Text; !strings.Contains(text, "3 tool calls this turn, budget is 2 per turn") || !recorded[1].EndOfTurn {
+		t.Errorf("unexpected budget message %+v", recorded[1])
+	}
+
+	// A new user message starts a new turn with a fresh allowance.
+	loop.QueueUserMessage(llm.UserStringMessage("echo: again"))
+	if err := loop.ProcessOneTurn(context.Background()); err != nil {
+		t.Fatalf("ProcessOneTurn: %v", err)
+	}
+	runs.Store(0)
+	if err := loop.handleToolCalls(context.Background(), toolUses(2)); err != nil {
+		t.Fatalf("handleToolCalls: %v", err)
+	}
+	if n := runs.Load(); n != 2 {
+		t.Errorf("ran %d tool calls in the new turn, want 2", n)
+	}
+}
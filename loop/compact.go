@@ -0,0 +1,164 @@
+package loop
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"shelley.exe.dev/llm"
+)
+
+// CompactionRecordFunc is called after older history has been replaced by a summary.
+// summarized is the number of messages, counted from the start of the history, that the summary replaces.
+type CompactionRecordFunc func(ctx context.Context, summary llm.Message, summarized int, usage llm.Usage) error
+
+// CompactionConfig enables summarizing older history when a conversation nears its context window.
+type CompactionConfig struct {
+	// LLM writes the summary. It is typically cheaper than the conversation's model.
+	LLM llm.Service
+	// Threshold is the fraction of the conversation model's TokenContextWindow
+	// above which history is compacted before the next request.
+	Threshold float64
+	// Record persists the compaction. The summarized messages themselves are left untouched.
+	Record CompactionRecordFunc
+}
+
+// compactionKeepMessages is the minimum number of recent messages kept verbatim after compaction.
+const compactionKeepMessages = 4
+
+// compactionToolTextLimit bounds how much of each tool input and result goes into the transcript.
+const compactionToolTextLimit = 2000
+
+// compactionSummaryPrefix starts the text of every summary message that replaces compacted history.
+const compactionSummaryPrefix = "[Summary of earlier conversation]\n\n"
+
+const compactionSystemPrompt = `You are summarizing the earlier part of a conversation between a user and a coding agent, so that the agent can continue the work without the full transcript.
+
+Write a concise summary that preserves:
+- the user's goals, requests, and stated preferences
+- decisions made and their reasons
+- files, commands, and other concrete details the agent relied on or changed
+- the current state of the work and anything left to do
+
+Do not address the user. Respond with the summary only.`
+
+const compactionRequestPrefix = "Summarize this conversation transcript:\n\n"
+
+// maybeCompact replaces older history with a summary once the conversation
+// uses more than the configured fraction of the context window.
+// It reports whether the history was compacted.
+func (l *Loop) maybeCompact(ctx context.Context) (bool, error) {
+	c := l.compaction
+	if c == nil {
+		return false, nil
+	}
+
+	l.mu.Lock()
+	used := l.contextWindowUsed
+	history := append([]llm.Message(nil), l.history...)
+	l.mu.Unlock()
+
+	limit := c.Threshold * float64(l.llm.TokenContextWindow())
+	if limit <= 0 || float64(used) < limit {
+		return false, nil
+	}
+	cut := compactionCut(history)
+	if cut == 0 {
+		return false, nil
+	}
+
+	l.logger.Info("compacting conversation history", "context_window_used", used, "summarized_messages", cut)
+	llmCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	resp, err := c.LLM.Do(llmCtx, &llm.Request{
+		System:   []llm.SystemContent{{Type: "text", Text: compactionSystemPrompt}},
+		Messages: []llm.Message{llm.UserStringMessage(compactionRequestPrefix + compactionTranscript(history[:cut]))},
+	})
+	if err != nil {
+		return false, fmt.Errorf("summarizing history: %w", err)
+	}
+
+	var text strings.Builder
+	for _, content := range resp.Content {
+		if content.Type == llm.ContentTypeText {
+			text.WriteString(content.Text)
+		}
+	}
+	if strings.TrimSpace(text.String()) == "" {
+		return false, fmt.Errorf("summarizing history: empty summary")
+	}
+	summary := llm.UserStringMessage(compactionSummaryPrefix + strings.TrimSpace(text.String()))
+
+	usage := resp.Usage
+	usage.Model = cmp.Or(usage.Model, resp.Model)
+	usage.StartTime = resp.StartTime
+	usage.EndTime = resp.EndTime
+
+	l.mu.Lock()
+	l.history = append([]llm.Message{summary}, l.history[cut:]...)
+	l.contextWindowUsed = 0
+	l.totalUsage.Add(resp.Usage)
+	l.mu.Unlock()
+
+	if err := c.Record(ctx, summary, cut, usage); err != nil {
+		l.logger.Error("failed to record compaction", "error", err)
+	}
+	return true, nil
+}
+
+// compactionCut returns how many messages at the start of history to summarize.
+// The kept messages begin with an assistant message, so they follow the user-role
+// summary naturally and no tool result is separated from its tool use.
+// It returns 0 if there is nothing worth compacting.
+func compactionCut(history []llm.Message) int {
+	for i := len(history) - compactionKeepMessages; i > 0; i-- {
+		if history[i].Role == llm.MessageRoleAssistant {
+			return i
+		}
+	}
+	return 0
+}
+
+// compactionTranscript renders messages as plain text for the summarizer.
+// Tool calls become text so the summary request needs no tool definitions.
+func compactionTranscript(messages []llm.Message) string {
+	var b strings.Builder
+	for _, msg := range messages {
+		role := "User"
+		if msg.Role == llm.MessageRoleAssistant {
+			role = "Assistant"
+		}
+		for _, c := range msg.Content {
+			switch c.Type {
+			case llm.ContentTypeText:
+				if c.Text != "" {
+					fmt.Fprintf(&b, "%s: %s\n\n", role, c.Text)
+				}
+			case llm.ContentTypeToolUse:
+				fmt.Fprintf(&b, "Assistant called tool %s: %s\n\n", c.ToolName, truncateForTranscript(string(c.ToolInput)))
+			case llm.ContentTypeToolResult:
+				var result strings.Builder
+				for _, r := range c.ToolResult {
+					if r.Type == llm.ContentTypeText {
+						result.WriteString(r.Text)
+					}
+				}
+				status := "result"
+				if c.ToolError {
+					status = "error"
+				}
+				fmt.Fprintf(&b, "Tool %s: %s\n\n", status, truncateForTranscript(result.String()))
+			}
+		}
+	}
+	return b.String()
+}
+
+func truncateForTranscript(s string) string {
+	if len(s) <= compactionToolTextLimit {
+		return s
+	}
+	return s[:compactionToolTextLimit] + "... [truncated]"
+}
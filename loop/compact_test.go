@@ -0,0 +1,204 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+// uncountedService hides PredictableService's token counting,
+// so the loop relies on the usage of the last response.
+type uncountedService struct{ llm.Service }
+
+// countedService reports a fixed input token count for every request
+type countedService struct {
+	*PredictableService
+	tokens uint64
+}
+
+func (s *countedService) CountTokens(context.Context, *llm.Request) (uint64, error) {
+	return s.tokens, nil
+}
+
+func TestLoopCompaction(t *testing.T) {
+	history := []llm.Message{
+		llm.UserStringMessage("echo: one"),
+		{Role: llm.MessageRoleAssistant, Content: []llm.Content{{Type: llm.ContentTypeText, Text: "one"}}},
+		llm.UserStringMessage("echo: two"),
+		{Role: llm.MessageRoleAssistant, Content: []llm.Content{{Type: llm.ContentTypeText, Text: "two"}}},
+		llm.UserStringMessage("echo: three"),
+		{Role: llm.MessageRoleAssistant, Content: []llm.Content{{Type: llm.ContentTypeText, Text: "three"}}},
+	}
+
+	tests := []struct {
+		name           string
+		used           uint64
+		counted        uint64 // 0 if the service can't count tokens
+		wantSummarized int
+	}{
+		{name: "below threshold", used: 100, wantSummarized: 0},
+		{name: "above threshold", used: 150000, wantSummarized: 3},
+		// Near the threshold, a count of the pending request takes precedence over the last response's usage
+		{name: "counted above threshold", used: 60000, counted: 150000, wantSummarized: 3},
+		{name: "not counted far below threshold", used: 100, counted: 150000, wantSummarized: 0},
+		{name: "counted below threshold", used: 150000, counted: 100, wantSummarized: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewPredictableService()
+			var conversationLLM llm.Service = uncountedService{service}
+			if tt.counted > 0 {
+				conversationLLM = &countedService{PredictableService: service, tokens: tt.counted}
+			}
+			var summaries []llm.Message
+			var summarized int
+			loop := NewLoop(Config{
+				LLM:               conversationLLM,
+				History:           append([]llm.Message(nil), history...),
+				RecordMessage:     func(context.Context, llm.Message, llm.Usage) error { return nil },
+				ContextWindowUsed: tt.used,
+				Compaction: &CompactionConfig{
+					LLM:       service,
+					Threshold: 0.5,
+					Record: func(_ context.Context, summary llm.Message, n int, _ llm.Usage) error {
+						summaries = append(summaries, summary)
+						summarized = n
+						return nil
+					},
+				},
+			})
+			loop.QueueUserMessage(llm.UserStringMessage("echo: four"))
+			if err := loop.ProcessOneTurn(context.Background()); err != nil {
+				t.Fatalf("ProcessOneTurn: %v", err)
+			}
+
+			if summarized != tt.wantSummarized {
+				t.Fatalf("summarized %d messages, want %d", summarized, tt.wantSummarized)
+			}
+			last := service.GetLastRequest()
+			wantLen := len(history) + 1 - tt.wantSummarized
+			if tt.wantSummarized > 0 {
+				wantLen++
+			}
+			if len(last.Messages) != wantLen {
+				t.Fatalf("request had %d messages, want %d", len(last.Messages), wantLen)
+			}
+			if tt.wantSummarized == 0 {
+				if len(summaries) != 0 {
+					t.Fatalf("unexpected compaction: %+v", summaries)
+				}
+				return
+			}
+
+			first := last.Messages[0].Content[0].Text
+			if !strings.HasPrefix(first, compactionSummaryPrefix) || first != summaries[0].Content[0].Text {
+				t.Errorf("first message = %q, want the recorded summary", first)
+			}
+			if last.Messages[1].Role != llm.MessageRoleAssistant || last.Messages[1].Content[0].Text != "two" {
+				t.Errorf("kept history should start at the assistant's %q, got %+v", "two", last.Messages[1])
+			}
+			if got := len(loop.GetHistory()); got != wantLen+1 {
+				t.Errorf("history has %d messages after the turn, want %d", got, wantLen+1)
+			}
+		})
+	}
+}
+
+// failingService fails every request
+type failingService struct{ llm.Service }
+
+func (failingService) Do(context.Context, *llm.Request) (*llm.Response, error) {
+	return nil, errors.New("summarizer unavailable")
+}
+
+func TestLoopCompactionFailure(t *testing.T) {
+	service := NewPredictableService()
+	history := []llm.Message{
+		llm.UserStringMessage("echo: one"),
+		{Role: llm.MessageRoleAssistant, Content: []llm.Content{{Type: llm.ContentTypeText, Text: "one"}}},
+	}
+	var recorded []llm.Message
+	loop := NewLoop(Config{
+		LLM:     uncountedService{service},
+		History: append([]llm.Message(nil), history...),
+		RecordMessage: func(_ context.Context, message llm.Message, _ llm.Usage) error {
+			recorded = append(recorded, message)
+			return nil
+		},
+		ContextWindowUsed: 150000,
+		Compaction: &CompactionConfig{
+			LLM:       failingService{service},
+			Threshold: 0.5,
+			Record: func(context.Context, llm.Message, int, llm.Usage) error {
+				t.Error("recorded a compaction that failed")
+				return nil
+			},
+		},
+	})
+	loop.QueueUserMessage(llm.UserStringMessage("echo: two"))
+	if err := loop.ProcessOneTurn(context.Background()); err != nil {
+		t.Fatalf("ProcessOneTurn: %v", err)
+	}
+
+	// The turn goes on with the whole history
+	if got := len(service.GetLastRequest().Messages); got != len(history)+1 {
+		t.Errorf("request had %d messages, want %d", got, len(history)+1)
+	}
+	if len(recorded) != 1 || recorded[0].Content[0].Text != "two" {
+		t.Errorf("recorded %+v, want the response to the request", recorded)
+	}
+}
+
+func TestCompactionTranscript(t *testing.T) {
+	messages := []llm.Message{
+		llm.UserStringMessage("list files"),
+		{Role: llm.MessageRoleAssistant, Content: []llm.Content{
+			{Type: llm.ContentTypeText, Text: "Sure."},
+			{Type: llm.ContentTypeToolUse, ToolName: "bash", ToolInput: []byte(`{"command":"ls"}`)},
+		}},
+		{Role: llm.MessageRoleUser, Content: []llm.Content{{
+			Type:       llm.ContentTypeToolResult,
+			ToolResult: []llm.Content{{Type: llm.ContentTypeText, Text: strings.Repeat("x", compactionToolTextLimit+1)}},
+		}}},
+	}
+	got := compactionTranscript(messages)
+	for _, want := range []string{
+		"User: list files\n",
+		"Assistant: Sure.\n",
+		`Assistant called tool bash: {"command":"ls"}`,
+		"Tool result: " + strings.Repeat("x", compactionToolTextLimit) + "... [truncated]",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("transcript missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestLoopCountsContextWindow(t *testing.T) {
+	service := NewPredictableService()
+	loop := NewLoop(Config{
+		LLM:               service,
+		History:           []llm.Message{llm.UserStringMessage(strings.Repeat("x", 4000))},
+		RecordMessage:     func(context.Context, llm.Message, llm.Usage) error { return nil },
+		ContextWindowUsed: 1,
+	})
+
+	req := loop.buildRequest()
+	loop.countContextWindow(context.Background(), req)
+	if got, want := loop.ContextWindowUsed(), service.countRequestTokens(req); got != want {
+		t.Errorf("ContextWindowUsed() = %d, want the counted %d", got, want)
+	}
+
+	loop = NewLoop(Config{
+		LLM:               uncountedService{service},
+		RecordMessage:     func(context.Context, llm.Message, llm.Usage) error { return nil },
+		ContextWindowUsed: 1,
+	})
+	loop.countContextWindow(context.Background(), loop.buildRequest())
+	if got := loop.ContextWindowUsed(); got != 1 {
+		t.Errorf("ContextWindowUsed() = %d, want the last response's usage to stand", got)
+	}
+}
@@ -1,7 +1,9 @@
 package loop
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
@@ -19,6 +21,10 @@ type MessageRecordFunc func(ctx context.Context, message llm.Message, usage llm.
 // This is used to record user-visible notifications about git changes.
 type GitStateChangeFunc func(ctx context.Context, state *gitstate.GitState)
 
+// StreamDeltaFunc is called with partial LLM output while a response is being generated.
+// The complete message is still passed to MessageRecordFunc once the response finishes.
+type StreamDeltaFunc func(ctx context.Context, delta llm.StreamDelta)
+
 // Config contains all configuration needed to create a Loop
 type Config struct {
 	LLM              llm.Service
@@ -33,6 +39,19 @@ type Config struct {
 	// If set, this is called at end of turn to check for git state changes.
 	// If nil, Config.WorkingDir is used as a static value.
 	GetWorkingDir func() string
+	// OnStreamDelta, if set, receives partial output from services that support streaming.
+	OnStreamDelta StreamDeltaFunc
+	// Compaction, if set, summarizes older history when the context window fills up.
+	Compaction *CompactionConfig
+	// ContextWindowUsed is the context window usage reported for the last response in History, if known.
+	ContextWindowUsed uint64
+	// Budget limits the conversation's spending; see SetBudget.
+	Budget Budget
+	// Usage is what the conversation used before this loop was created. It counts against Budget.
+	Usage llm.Usage
+	// SharedBudgetExceeded, if set, is checked with Budget before each LLM request. It reports why
+	// a limit the conversation shares with others is exceeded, or "" if none is.
+	SharedBudgetExceeded func() string
 }
 
 // Loop manages a conversation turn with an LLM including tool execution and message recording.
@@ -51,6 +70,17 @@ type Loop struct {
 	onGitStateChange GitStateChangeFunc
 	getWorkingDir    func() string
 	lastGitState     *gitstate.GitState
+	onStreamDelta    StreamDeltaFunc
+	compaction       *CompactionConfig
+	// contextWindowUsed is the size of the conversation in tokens, used to decide when to compact:
+	// the count of the next request for services that can count tokens, else the usage of the last response.
+	contextWindowUsed    uint64
+	budget               Budget
+	sharedBudgetExceeded func() string
+	turnToolCalls        int  // tool calls run since the last user message
+	resume               bool // continue the turn at the end of history without a new user message
+	// thinking is the reasoning setting for the current turn, from the user message that started it
+	thinking *llm.Thinking
 }
 
 // NewLoop creates a new Loop instance with the provided configuration
@@ -68,17 +98,23 @@ func NewLoop(config Config) *Loop {
 	initialGitState := gitstate.GetGitState(workingDir)
 
 	return &Loop{
-		llm:              config.LLM,
-		history:          config.History,
-		tools:            config.Tools,
-		recordMessage:    config.RecordMessage,
-		messageQueue:     make([]llm.Message, 0),
-		logger:           logger,
-		system:           config.System,
-		workingDir:       config.WorkingDir,
-		onGitStateChange: config.OnGitStateChange,
-		getWorkingDir:    config.GetWorkingDir,
-		lastGitState:     initialGitState,
+		llm:                  config.LLM,
+		history:              config.History,
+		tools:                config.Tools,
+		recordMessage:        config.RecordMessage,
+		messageQueue:         make([]llm.Message, 0),
+		logger:               logger,
+		system:               config.System,
+		workingDir:           config.WorkingDir,
+		onGitStateChange:     config.OnGitStateChange,
+		getWorkingDir:        config.GetWorkingDir,
+		lastGitState:         initialGitState,
+		onStreamDelta:        config.OnStreamDelta,
+		compaction:           config.Compaction,
+		contextWindowUsed:    config.ContextWindowUsed,
+		budget:               config.Budget,
+		sharedBudgetExceeded: config.SharedBudgetExceeded,
+		totalUsage:           config.Usage,
 	}
 }
 
@@ -90,13 +126,28 @@ func (l *Loop) QueueUserMessage(message llm.Message) {
 	l.logger.Debug("queued user message", "content_count", len(message.Content))
 }
 
-// GetUsage returns the total usage accumulated by this loop
+// Resume makes the loop send its history to the LLM without waiting for a user message,
+// continuing a turn that was interrupted before it ended.
+func (l *Loop) Resume() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.resume = true
+}
+
+// GetUsage returns the total usage of the conversation, including Config.Usage
 func (l *Loop) GetUsage() llm.Usage {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	return l.totalUsage
 }
 
+// ContextWindowUsed returns the size of the conversation in tokens, as of the last count or response
+func (l *Loop) ContextWindowUsed() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.contextWindowUsed
+}
+
 // GetHistory returns a copy of the current conversation history
 func (l *Loop) GetHistory() []llm.Message {
 	l.mu.Lock()
@@ -134,22 +185,32 @@ func (l *Loop) Go(ctx context.Context) error {
 
 		// Process any queued messages
 		l.mu.Lock()
-		hasQueuedMessages := len(l.messageQueue) > 0
+		hasQueuedMessages := len(l.messageQueue) > 0 || l.resume
+		l.resume = false
 		if hasQueuedMessages {
 			// Add queued messages to history (they are already recorded to DB by ConversationManager)
 			for _, msg := range l.messageQueue {
 				l.history = append(l.history, msg)
 			}
 			l.messageQueue = l.messageQueue[:0] // Clear queue
+			l.turnToolCalls = 0
+			l.thinking = turnThinking(l.history)
 		}
 		l.mu.Unlock()
 
 		if hasQueuedMessages {
 			// Send request to LLM
 			l.logger.Debug("processing queued messages", "count", 1)
-			if err := l.processLLMRequest(ctx); err != nil {
+			if err := l.processLLMRequest(ctx); errors.Is(err, ErrBudgetExceeded) {
+				continue // already recorded; wait for the next message
+			} else if err != nil {
 				l.logger.Error("failed to process LLM request", "error", err)
-				time.Sleep(time.Second) // Wait before retrying
+				// Wait before retrying, unless the loop is being stopped
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(time.Second):
+				}
 				continue
 			}
 			l.logger.Debug("finished processing queued messages")
@@ -181,19 +242,38 @@ func (l *Loop) ProcessOneTurn(ctx context.Context) error {
 		}
 		l.messageQueue = nil
 	}
+	l.turnToolCalls = 0
+	l.thinking = turnThinking(l.history)
 	l.mu.Unlock()
 
 	// Process one LLM request and response
 	return l.processLLMRequest(ctx)
 }
 
-// processLLMRequest sends a request to the LLM and handles the response
-func (l *Loop) processLLMRequest(ctx context.Context) error {
+// turnThinking returns the thinking setting of the latest user message in history
+// that was written by the user rather than carrying tool results.
+func turnThinking(history []llm.Message) *llm.Thinking {
+	for i := len(history) - 1; i >= 0; i-- {
+		msg := history[i]
+		if msg.Role != llm.MessageRoleUser {
+			continue
+		}
+		for _, c := range msg.Content {
+			if c.Type != llm.ContentTypeToolResult {
+				return msg.Thinking
+			}
+		}
+	}
+	return nil
+}
+
+// buildRequest assembles the next LLM request from the current history, tools and system prompt
+func (l *Loop) buildRequest() *llm.Request {
 	l.mu.Lock()
 	messages := append([]llm.Message(nil), l.history...)
 	tools := l.tools
 	system := l.system
-	llmService := l.llm
+	thinking := l.thinking
 	l.mu.Unlock()
 
 	// Enable prompt caching: set cache flag on last tool and last user message content
@@ -225,39 +305,102 @@ func (l *Loop) processLLMRequest(ctx context.Context) error {
 		Messages: messages,
 		Tools:    tools,
 		System:   system,
+		Thinking: thinking,
 	}
 
 	// Insert missing tool results if the previous message had tool_use blocks
 	// without corresponding tool_result blocks. This can happen when a request
 	// is cancelled or fails after the LLM responds but before tools execute.
 	l.insertMissingToolResults(req)
+	return req
+}
+
+// countFraction is the fraction of the compaction limit at which requests start to be counted.
+// Counting costs a round trip to the provider, so it is only done once compaction may be near.
+const countFraction = 0.5
+
+// shouldCount reports whether req's tokens are worth counting before sending it:
+// compaction is enabled, and the last response's usage or an estimate of req's size
+// is close enough to the compaction limit that the exact size matters.
+func (l *Loop) shouldCount(req *llm.Request) bool {
+	if l.compaction == nil {
+		return false
+	}
+	l.mu.Lock()
+	llmService := l.llm
+	used := l.contextWindowUsed
+	l.mu.Unlock()
+
+	near := countFraction * l.compaction.Threshold * float64(llmService.TokenContextWindow())
+	return near > 0 && (float64(used) >= near || float64(llm.EstimateTokens(req)) >= near)
+}
+
+// countContextWindow records the input tokens of req as the context window used,
+// for services that can count tokens before sending. Otherwise, or if counting fails,
+// the usage of the last response stands.
+func (l *Loop) countContextWindow(ctx context.Context, req *llm.Request) {
+	l.mu.Lock()
+	llmService := l.llm
+	l.mu.Unlock()
+
+	countCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	n, err := llm.CountTokens(countCtx, llmService, req)
+	if err != nil {
+		if !errors.Is(err, llm.ErrTokenCountUnsupported) {
+			l.logger.Warn("failed to count request tokens", "error", err)
+		}
+		return
+	}
+	l.mu.Lock()
+	l.contextWindowUsed = n
+	l.mu.Unlock()
+}
+
+// processLLMRequest sends a request to the LLM and handles the response
+func (l *Loop) processLLMRequest(ctx context.Context) error {
+	if reason := l.overBudget(); reason != "" {
+		return l.stopForBudget(ctx, reason)
+	}
+
+	req := l.buildRequest()
+	if l.shouldCount(req) {
+		l.countContextWindow(ctx, req)
+	}
+	compacted, err := l.maybeCompact(ctx)
+	if err != nil {
+		// Compaction only saves tokens, so send the request as it is
+		l.logger.Warn("failed to compact conversation history", "error", err)
+	}
+	if compacted {
+		req = l.buildRequest()
+	}
+
+	l.mu.Lock()
+	llmService := l.llm
+	l.mu.Unlock()
 
 	systemLen := 0
-	for _, sys := range system {
+	for _, sys := range req.System {
 		systemLen += len(sys.Text)
 	}
-	l.logger.Debug("sending LLM request", "message_count", len(messages), "tool_count", len(tools), "system_items", len(system), "system_length", systemLen)
+	l.logger.Debug("sending LLM request", "message_count", len(req.Messages), "tool_count", len(req.Tools), "system_items", len(req.System), "system_length", systemLen)
+
+	// Collect the IDs of the recorded HTTP requests, so the message recorded
+	// for the response or error can link to the request that produced it
+	reqCtx := llm.WithRequestLog(ctx)
 
 	// Add a timeout for the LLM request to prevent indefinite hangs
-	llmCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	llmCtx, cancel := context.WithTimeout(reqCtx, 5*time.Minute)
 	defer cancel()
 
-	resp, err := llmService.Do(llmCtx, req)
+	var onDelta func(llm.StreamDelta)
+	if l.onStreamDelta != nil {
+		onDelta = func(d llm.StreamDelta) { l.onStreamDelta(ctx, d) }
+	}
+	resp, err := llm.DoStream(llmCtx, llmService, req, onDelta)
 	if err != nil {
-		// Record the error as a message so it can be displayed in the UI
-		errorMessage := llm.Message{
-			Role: llm.MessageRoleAssistant,
-			Content: []llm.Content{
-				{
-					Type: llm.ContentTypeText,
-					Text: fmt.Sprintf("LLM request failed: %v", err),
-				},
-			},
-		}
-		if recordErr := l.recordMessage(ctx, errorMessage, llm.Usage{}); recordErr != nil {
-			l.logger.Error("failed to record error message", "error", recordErr)
-		}
-		return fmt.Errorf("LLM request failed: %w", err)
+		return l.recordRequestError(reqCtx, err)
 	}
 
 	l.logger.Debug("received LLM response", "content_count", len(resp.Content), "stop_reason", resp.StopReason.String(), "usage", resp.Usage.String())
@@ -265,6 +408,7 @@ func (l *Loop) processLLMRequest(ctx context.Context) error {
 	// Update total usage
 	l.mu.Lock()
 	l.totalUsage.Add(resp.Usage)
+	l.contextWindowUsed = resp.Usage.ContextWindowUsed()
 	l.mu.Unlock()
 
 	// Convert response to message and add to history
@@ -275,10 +419,11 @@ func (l *Loop) processLLMRequest(ctx context.Context) error {
 
 	// Record assistant message with model and timing metadata
 	usageWithMeta := resp.Usage
-	usageWithMeta.Model = resp.Model
+	// A fallback service reports the model that answered in Usage.Model
+	usageWithMeta.Model = cmp.Or(resp.Usage.Model, resp.Model)
 	usageWithMeta.StartTime = resp.StartTime
 	usageWithMeta.EndTime = resp.EndTime
-	if err := l.recordMessage(ctx, assistantMessage, usageWithMeta); err != nil {
+	if err := l.recordMessage(reqCtx, assistantMessage, usageWithMeta); err != nil {
 		l.logger.Error("failed to record assistant message", "error", err)
 	}
 
@@ -300,6 +445,23 @@ func (l *Loop) processLLMRequest(ctx context.Context) error {
 	return nil
 }
 
+// recordRequestError records a failed LLM request as a message so it can be displayed in the UI.
+func (l *Loop) recordRequestError(ctx context.Context, err error) error {
+	errorMessage := llm.Message{
+		Role: llm.MessageRoleAssistant,
+		Content: []llm.Content{
+			{
+				Type: llm.ContentTypeText,
+				Text: fmt.Sprintf("LLM request failed: %v", err),
+			},
+		},
+	}
+	if recordErr := l.recordMessage(ctx, errorMessage, llm.Usage{}); recordErr != nil {
+		l.logger.Error("failed to record error message", "error", recordErr)
+	}
+	return fmt.Errorf("LLM request failed: %w", err)
+}
+
 // checkGitStateChange checks if the git state has changed and calls the callback if so.
 // This is called at the end of each turn.
 func (l *Loop) checkGitStateChange(ctx context.Context) {
@@ -368,69 +530,55 @@ func (l *Loop) handleMaxTokensTruncation(ctx context.Context) error {
 	return nil
 }
 
-// handleToolCalls processes tool calls from the LLM response
+// handleToolCalls processes tool calls from the LLM response.
+// Calls run concurrently, except that a call to a Serial tool, or one its SerialFor
+// picks out, waits for the calls before it and runs alone. Results keep the order of the tool uses.
 func (l *Loop) handleToolCalls(ctx context.Context, content []llm.Content) error {
-	var toolResults []llm.Content
-
+	var toolUses []llm.Content
 	for _, c := range content {
-		if c.Type != llm.ContentTypeToolUse {
-			continue
+		if c.Type == llm.ContentTypeToolUse {
+			toolUses = append(toolUses, c)
 		}
+	}
 
-		l.logger.Debug("executing tool", "name", c.ToolName, "id", c.ID)
+	// Calls beyond the per-turn tool call budget are answered without running.
+	l.mu.Lock()
+	allowed := len(toolUses)
+	if limit := l.budget.MaxToolCallsPerTurn; limit > 0 {
+		allowed = max(0, min(allowed, limit-l.turnToolCalls))
+	}
+	l.turnToolCalls += allowed
+	budgetReason := ""
+	if allowed < len(toolUses) {
+		budgetReason = fmt.Sprintf("%d tool calls this turn, budget is %d per turn", l.turnToolCalls+len(toolUses)-allowed, l.budget.MaxToolCallsPerTurn)
+	}
+	l.mu.Unlock()
 
-		// Find the tool
-		var tool *llm.Tool
-		for _, t := range l.tools {
-			if t.Name == c.ToolName {
-				tool = t
-				break
+	toolResults := make([]llm.Content, len(toolUses))
+	var wg sync.WaitGroup
+	for i, c := range toolUses {
+		if i >= allowed {
+			toolResults[i] = llm.Content{
+				Type:       llm.ContentTypeToolResult,
+				ToolUseID:  c.ID,
+				ToolError:  true,
+				ToolResult: []llm.Content{{Type: llm.ContentTypeText, Text: "not executed: tool call budget for this turn exceeded"}},
 			}
-		}
-
-		if tool == nil {
-			l.logger.Error("tool not found", "name", c.ToolName)
-			toolResults = append(toolResults, llm.Content{
-				Type:      llm.ContentTypeToolResult,
-				ToolUseID: c.ID,
-				ToolError: true,
-				ToolResult: []llm.Content{
-					{Type: llm.ContentTypeText, Text: fmt.Sprintf("Tool '%s' not found", c.ToolName)},
-				},
-			})
 			continue
 		}
-
-		// Execute the tool with working directory set in context
-		toolCtx := ctx
-		if l.workingDir != "" {
-			toolCtx = claudetool.WithWorkingDir(ctx, l.workingDir)
-		}
-		startTime := time.Now()
-		result := tool.Run(toolCtx, c.ToolInput)
-		endTime := time.Now()
-
-		var toolResultContent []llm.Content
-		if result.Error != nil {
-			l.logger.Error("tool execution failed", "name", c.ToolName, "error", result.Error)
-			toolResultContent = []llm.Content{
-				{Type: llm.ContentTypeText, Text: result.Error.Error()},
-			}
-		} else {
-			toolResultContent = result.LLMContent
-			l.logger.Debug("tool executed successfully", "name", c.ToolName, "duration", endTime.Sub(startTime))
+		tool := l.findTool(c.ToolName)
+		if tool != nil && (tool.Serial || tool.SerialFor != nil && tool.SerialFor(c.ToolInput)) {
+			wg.Wait()
+			toolResults[i] = l.runTool(ctx, tool, c)
+			continue
 		}
-
-		toolResults = append(toolResults, llm.Content{
-			Type:             llm.ContentTypeToolResult,
-			ToolUseID:        c.ID,
-			ToolError:        result.Error != nil,
-			ToolResult:       toolResultContent,
-			ToolUseStartTime: &startTime,
-			ToolUseEndTime:   &endTime,
-			Display:          result.Display,
-		})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			toolResults[i] = l.runTool(ctx, tool, c)
+		}()
 	}
+	wg.Wait()
 
 	if len(toolResults) > 0 {
 		// Add tool results to history as a user message
@@ -448,6 +596,10 @@ func (l *Loop) handleToolCalls(ctx context.Context, content []llm.Content) error
 			l.logger.Error("failed to record tool result message", "error", err)
 		}
 
+		if budgetReason != "" {
+			return l.stopForBudget(ctx, budgetReason)
+		}
+
 		// Process another LLM request with the tool results
 		return l.processLLMRequest(ctx)
 	}
@@ -455,6 +607,64 @@ func (l *Loop) handleToolCalls(ctx context.Context, content []llm.Content) error
 	return nil
 }
 
+// findTool returns the tool with the given name, or nil if there is none.
+func (l *Loop) findTool(name string) *llm.Tool {
+	for _, t := range l.tools {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// runTool executes a single tool use and returns its tool result.
+// tool is nil if the model asked for a tool that does not exist.
+func (l *Loop) runTool(ctx context.Context, tool *llm.Tool, c llm.Content) llm.Content {
+	l.logger.Debug("executing tool", "name", c.ToolName, "id", c.ID)
+
+	if tool == nil {
+		l.logger.Error("tool not found", "name", c.ToolName)
+		return llm.Content{
+			Type:      llm.ContentTypeToolResult,
+			ToolUseID: c.ID,
+			ToolError: true,
+			ToolResult: []llm.Content{
+				{Type: llm.ContentTypeText, Text: fmt.Sprintf("Tool '%s' not found", c.ToolName)},
+			},
+		}
+	}
+
+	// Execute the tool with working directory set in context
+	toolCtx := ctx
+	if l.workingDir != "" {
+		toolCtx = claudetool.WithWorkingDir(ctx, l.workingDir)
+	}
+	startTime := time.Now()
+	result := tool.Run(toolCtx, c.ToolInput)
+	endTime := time.Now()
+
+	var toolResultContent []llm.Content
+	if result.Error != nil {
+		l.logger.Error("tool execution failed", "name", c.ToolName, "error", result.Error)
+		toolResultContent = []llm.Content{
+			{Type: llm.ContentTypeText, Text: result.Error.Error()},
+		}
+	} else {
+		toolResultContent = result.LLMContent
+		l.logger.Debug("tool executed successfully", "name", c.ToolName, "duration", endTime.Sub(startTime))
+	}
+
+	return llm.Content{
+		Type:             llm.ContentTypeToolResult,
+		ToolUseID:        c.ID,
+		ToolError:        result.Error != nil,
+		ToolResult:       toolResultContent,
+		ToolUseStartTime: &startTime,
+		ToolUseEndTime:   &endTime,
+		Display:          result.Display,
+	}
+}
+
 // insertMissingToolResults fixes tool_result issues in the conversation history:
 //  1. Adds error results for tool_uses that were requested but not included in the next message.
 //     This can happen when a request is cancelled or fails after the LLM responds with tool_use
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -276,6 +277,108 @@ func TestLoopWithPredictableService(t *testing.T) {
 	}
 }
 
+func TestLoopResume(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var recordedMessages []llm.Message
+	loop := NewLoop(Config{
+		LLM:     NewPredictableService(),
+		History: []llm.Message{llm.UserStringMessage("echo: resumed")},
+		RecordMessage: func(ctx context.Context, message llm.Message, usage llm.Usage) error {
+			recordedMessages = append(recordedMessages, message)
+			if message.EndOfTurn {
+				cancel()
+			}
+			return nil
+		},
+	})
+
+	// The history already ends with the user message, so nothing is queued.
+	loop.Resume()
+	if err := loop.Go(ctx); err != context.Canceled {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	if len(recordedMessages) != 1 || recordedMessages[0].Content[0].Text != "resumed" {
+		t.Fatalf("expected the turn to finish with one response, got %+v", recordedMessages)
+	}
+}
+
+func TestLoopThinking(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	service := NewPredictableService()
+	loop := NewLoop(Config{
+		LLM: service,
+		RecordMessage: func(ctx context.Context, message llm.Message, usage llm.Usage) error {
+			if message.EndOfTurn {
+				cancel()
+			}
+			return nil
+		},
+	})
+
+	// The think tool call makes the turn take two requests; both use the message's setting.
+	message := llm.UserStringMessage("think: carefully")
+	message.Thinking = &llm.Thinking{Effort: llm.ThinkingEffortHigh}
+	loop.QueueUserMessage(message)
+	if err := loop.Go(ctx); err != context.Canceled {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	requests := service.GetRecentRequests()
+	if len(requests) < 2 {
+		t.Fatalf("got %d requests, want the tool call to be followed up", len(requests))
+	}
+	for i, req := range requests {
+		if req.Thinking == nil || req.Thinking.Effort != llm.ThinkingEffortHigh {
+			t.Errorf("request %d thinking = %+v, want high", i, req.Thinking)
+		}
+	}
+
+	// The next turn's message has no setting, so thinking is off again.
+	service.ClearRequests()
+	loop.QueueUserMessage(llm.UserStringMessage("hello"))
+	if err := loop.ProcessOneTurn(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if req := service.GetLastRequest(); req.Thinking != nil {
+		t.Errorf("thinking = %+v for a message without a setting", req.Thinking)
+	}
+}
+
+func TestLoopStreamDeltas(t *testing.T) {
+	var recordedMessages []llm.Message
+	var streamed strings.Builder
+	loop := NewLoop(Config{
+		LLM:     NewPredictableService(),
+		History: []llm.Message{},
+		Tools:   []*llm.Tool{},
+		RecordMessage: func(ctx context.Context, message llm.Message, usage llm.Usage) error {
+			recordedMessages = append(recordedMessages, message)
+			return nil
+		},
+		OnStreamDelta: func(ctx context.Context, delta llm.StreamDelta) {
+			if delta.Type == llm.ContentTypeText {
+				streamed.WriteString(delta.Text)
+			}
+		},
+	})
+
+	loop.QueueUserMessage(llm.UserStringMessage("echo: streaming works fine"))
+	if err := loop.ProcessOneTurn(context.Background()); err != nil {
+		t.Fatalf("ProcessOneTurn: %v", err)
+	}
+
+	if streamed.String() != "streaming works fine" {
+		t.Errorf("streamed text = %q", streamed.String())
+	}
+	// Deltas are transient; only the final message is recorded.
+	if len(recordedMessages) != 1 || recordedMessages[0].Content[0].Text != "streaming works fine" {
+		t.Errorf("unexpected recorded messages: %+v", recordedMessages)
+	}
+}
+
 func TestLoopWithTools(t *testing.T) {
 	var toolCalls []string
 
@@ -1239,3 +1342,116 @@ func TestMaxTokensTruncation(t *testing.T) {
 		t.Errorf("expected error message to suggest smaller changes, got %q", secondMsg.Content[0].Text)
 	}
 }
+
+func TestHandleToolCallsConcurrent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Each "gather" call blocks until all three are running at once,
+	// which only succeeds if they execute concurrently.
+	const gatherCalls = 3
+	var inFlight, arrived atomic.Int32
+	allArrived := make(chan struct{})
+	gather := &llm.Tool{
+		Name:        "gather",
+		InputSchema: llm.EmptySchema(),
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			inFlight.Add(1)
+			defer inFlight.Add(-1)
+			if arrived.Add(1) == gatherCalls {
+				close(allArrived)
+			}
+			select {
+			case <-allArrived:
+				return llm.ToolOut{LLMContent: llm.TextContent("gathered " + string(input))}
+			case <-ctx.Done():
+				return llm.ErrorToolOut(ctx.Err())
+			}
+		},
+	}
+	serial := &llm.Tool{
+		Name:        "serial",
+		InputSchema: llm.EmptySchema(),
+		Serial:      true,
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			if n := inFlight.Load(); n != 0 {
+				return llm.ErrorfToolOut("%d other tool calls running", n)
+			}
+			return llm.ToolOut{LLMContent: llm.TextContent("serial " + string(input))}
+		},
+	}
+
+	// Serial for some inputs only, like bash commands that aren't read-only
+	sometimes := &llm.Tool{
+		Name:        "sometimes",
+		InputSchema: llm.EmptySchema(),
+		SerialFor:   func(input json.RawMessage) bool { return string(input) == "3" },
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			if n := inFlight.Load(); n != 0 {
+				return llm.ErrorfToolOut("%d other tool calls running", n)
+			}
+			return llm.ToolOut{LLMContent: llm.TextContent("sometimes " + string(input))}
+		},
+	}
+
+	loop := NewLoop(Config{
+		LLM:           NewPredictableService(),
+		Tools:         []*llm.Tool{gather, serial, sometimes},
+		RecordMessage: func(context.Context, llm.Message, llm.Usage) error { return nil },
+	})
+
+	var content []llm.Content
+	for i, name := range []string{"gather", "gather", "gather", "sometimes", "serial", "missing"} {
+		content = append(content, llm.Content{
+			Type:      llm.ContentTypeToolUse,
+			ID:        fmt.Sprintf("call_%d", i),
+			ToolName:  name,
+			ToolInput: json.RawMessage(fmt.Sprint(i)),
+		})
+	}
+	if err := loop.handleToolCalls(ctx, content); err != nil {
+		t.Fatalf("handleToolCalls: %v", err)
+	}
+
+	history := loop.GetHistory()
+	results := history[0].Content
+	if len(results) != len(content) {
+		t.Fatalf("got %d tool results, want %d", len(results), len(content))
+	}
+	want := []string{"gathered 0", "gathered 1", "gathered 2", "sometimes 3", "serial 4", "Tool 'missing' not found"}
+	for i, r := range results {
+		if r.ToolUseID != content[i].ID {
+			t.Errorf("result %d is for %s, want %s", i, r.ToolUseID, content[i].ID)
+		}
+		if got := r.ToolResult[0].Text; got != want[i] {
+			t.Errorf("result %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestPredictableStructuredResponse(t *testing.T) {
+	service := NewPredictableService()
+	var result struct {
+		Slug  string   `json:"slug"`
+		Files []string `json:"files"`
+		Kind  string   `json:"kind"`
+	}
+	err := llm.DoJSON(context.Background(), service, &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("hello")},
+		ResponseFormat: &llm.ResponseFormat{Name: "sample", Schema: llm.MustSchema(`{
+			"type": "object",
+			"required": ["slug", "files", "kind"],
+			"properties": {
+				"slug": {"type": "string"},
+				"files": {"type": "array", "items": {"type": "string"}},
+				"kind": {"type": "string", "enum": ["fix", "feature"]}
+			}
+		}`)},
+	}, &result)
+	if err != nil {
+		t.Fatalf("DoJSON: %v", err)
+	}
+	if result.Slug != "predictable" || result.Kind != "fix" || result.Files == nil {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
@@ -23,6 +23,8 @@ import (
 //   - "think: <thoughts>" - triggers think tool
 //   - "delay: <seconds>" - delays response by specified seconds
 //   - See Do() method for complete list of supported patterns
+//
+// A PredictableScript set with SetScript takes precedence over these patterns.
 type PredictableService struct {
 	// TokenContextWindow size
 	tokenContextWindow int
@@ -30,6 +32,7 @@ type PredictableService struct {
 	// Recent requests for testing inspection
 	recentRequests []*llm.Request
 	responseDelay  time.Duration
+	script         *PredictableScript
 }
 
 // NewPredictableService creates a new predictable LLM service
@@ -47,6 +50,14 @@ func NewPredictableService() *PredictableService {
 	return svc
 }
 
+// SetScript makes the service answer requests matching script's rules from it.
+// A nil script restores the built-in responses only.
+func (s *PredictableService) SetScript(script *PredictableScript) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = script
+}
+
 // TokenContextWindow returns the maximum token context window size
 func (s *PredictableService) TokenContextWindow() int {
 	return s.tokenContextWindow
@@ -57,11 +68,31 @@ func (s *PredictableService) MaxImageDimension() int {
 	return 2000
 }
 
+// DoStream behaves like Do, then replays the response's text and tool input as word-sized deltas.
+func (s *PredictableService) DoStream(ctx context.Context, req *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	resp, err := s.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range resp.Content {
+		switch c.Type {
+		case llm.ContentTypeText:
+			for _, word := range strings.SplitAfter(c.Text, " ") {
+				onDelta(llm.StreamDelta{Index: i, Type: llm.ContentTypeText, Text: word})
+			}
+		case llm.ContentTypeToolUse:
+			onDelta(llm.StreamDelta{Index: i, Type: llm.ContentTypeToolUse, ID: c.ID, ToolName: c.ToolName, Text: string(c.ToolInput)})
+		}
+	}
+	return resp, nil
+}
+
 // Do processes a request and returns a predictable response based on the input text
 func (s *PredictableService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
 	// Store request for testing inspection
 	s.mu.Lock()
 	delay := s.responseDelay
+	script := s.script
 	s.recentRequests = append(s.recentRequests, req)
 	// Keep only last 10 requests
 	if len(s.recentRequests) > 10 {
@@ -80,6 +111,21 @@ func (s *PredictableService) Do(ctx context.Context, req *llm.Request) (*llm.Res
 	// Calculate input token count based on the request content
 	inputTokens := s.countRequestTokens(req)
 
+	if req.ResponseFormat != nil {
+		return s.makeStructuredResponse(req.ResponseFormat, inputTokens)
+	}
+
+	if rule := script.match(req); rule != nil {
+		if d := rule.Response.delay; d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return s.makeScriptedResponse(&rule.Response, inputTokens)
+	}
+
 	// Extract the text content from the last user message
 	var inputText string
 	if len(req.Messages) > 0 {
@@ -160,6 +206,10 @@ func (s *PredictableService) Do(ctx context.Context, req *llm.Request) (*llm.Res
 			return s.makeScreenshotToolResponse(selector, inputTokens), nil
 		}
 
+		if strings.HasPrefix(inputText, compactionRequestPrefix) {
+			return s.makeResponse("The user and the assistant exchanged some messages.", inputTokens), nil
+		}
+
 		if strings.HasPrefix(inputText, "delay: ") {
 			delayStr := strings.TrimPrefix(inputText, "delay: ")
 			delaySeconds, err := strconv.ParseFloat(delayStr, 64)
@@ -179,6 +229,46 @@ func (s *PredictableService) Do(ctx context.Context, req *llm.Request) (*llm.Res
 	}
 }
 
+// makeStructuredResponse answers a structured output request with a minimal value matching its schema
+func (s *PredictableService) makeStructuredResponse(format *llm.ResponseFormat, inputTokens uint64) (*llm.Response, error) {
+	var schema map[string]any
+	if err := json.Unmarshal(format.Schema, &schema); err != nil {
+		return nil, fmt.Errorf("predictable: invalid response schema: %w", err)
+	}
+	data, err := json.Marshal(sampleJSON(schema))
+	if err != nil {
+		return nil, err
+	}
+	return s.makeResponse(string(data), inputTokens), nil
+}
+
+// sampleJSON returns a value matching schema: the first enum value, "predictable" for strings,
+// and zero values for other types. Objects have all their properties.
+func sampleJSON(schema map[string]any) any {
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	switch schema["type"] {
+	case "object":
+		obj := map[string]any{}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, prop := range properties {
+			prop, _ := prop.(map[string]any)
+			obj[name] = sampleJSON(prop)
+		}
+		return obj
+	case "array":
+		return []any{}
+	case "string":
+		return "predictable"
+	case "number", "integer":
+		return 0
+	case "boolean":
+		return false
+	}
+	return nil
+}
+
 // makeMaxTokensResponse creates a response that simulates hitting max_tokens limit
 func (s *PredictableService) makeMaxTokensResponse(text string, inputTokens uint64) *llm.Response {
 	outputTokens := uint64(len(text) / 4)
@@ -399,6 +489,11 @@ func (s *PredictableService) ClearRequests() {
 	s.recentRequests = nil
 }
 
+// CountTokens returns the input tokens Do would report for req
+func (s *PredictableService) CountTokens(ctx context.Context, req *llm.Request) (uint64, error) {
+	return s.countRequestTokens(req), nil
+}
+
 // countRequestTokens estimates token count based on character count.
 // Uses a simple ~4 chars per token approximation.
 func (s *PredictableService) countRequestTokens(req *llm.Request) uint64 {
@@ -0,0 +1,299 @@
+package loop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"shelley.exe.dev/llm"
+)
+
+// PredictableScript is a scenario for PredictableService, loaded from a YAML or JSON file,
+// so that UI and end-to-end scenarios can be written without changing Go code.
+//
+// Each request is checked against the rules in order, and the first rule that matches supplies
+// the response. Requests that no rule matches get the built-in responses described on PredictableService.
+// For example:
+//
+//	rules:
+//	  - text: "list files"
+//	    response:
+//	      text: "I'll list them."
+//	      tool_uses:
+//	        - name: bash
+//	          input: {command: "ls"}
+//	  - tool: bash
+//	    response:
+//	      thinking: "The listing looks right."
+//	      text: "Done."
+//	  - text_prefix: "flaky"
+//	    response:
+//	      delay: 2s
+//	      error: "overloaded"
+//	      status: 529
+type PredictableScript struct {
+	Rules []PredictableRule `json:"rules" yaml:"rules"`
+}
+
+// PredictableRule pairs conditions on a request with the response to give it.
+// All the conditions that are set must hold, and at least one must be set.
+type PredictableRule struct {
+	// Text matches requests whose last message is the user's and has exactly this text,
+	// ignoring surrounding whitespace.
+	Text string `json:"text,omitempty" yaml:"text,omitempty"`
+	// TextPrefix matches requests whose last message is the user's and starts with this text.
+	TextPrefix string `json:"text_prefix,omitempty" yaml:"text_prefix,omitempty"`
+	// TextRegexp matches requests whose last message is the user's and matches this regular expression.
+	TextRegexp string `json:"text_regexp,omitempty" yaml:"text_regexp,omitempty"`
+	// Tool matches requests whose last message has a result of this tool.
+	Tool string `json:"tool,omitempty" yaml:"tool,omitempty"`
+	// ToolResult matches requests whose last message has a tool result containing this text.
+	ToolResult string `json:"tool_result,omitempty" yaml:"tool_result,omitempty"`
+
+	Response PredictableResponse `json:"response" yaml:"response"`
+
+	textRegexp *regexp.Regexp
+}
+
+// PredictableResponse is a scripted response: either content or an error, optionally after a delay.
+type PredictableResponse struct {
+	// Thinking is reasoning shown before the rest of the response.
+	Thinking string `json:"thinking,omitempty" yaml:"thinking,omitempty"`
+	// Text is the response's text.
+	Text string `json:"text,omitempty" yaml:"text,omitempty"`
+	// ToolUses are tool calls made after the text.
+	ToolUses []PredictableToolUse `json:"tool_uses,omitempty" yaml:"tool_uses,omitempty"`
+	// MaxTokens ends the response as if it had been cut off at the output token limit.
+	MaxTokens bool `json:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	// Error fails the request with this message instead of responding.
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+	// Status makes Error an HTTP status error, e.g. 429 or 529, as a provider would return.
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+	// Delay waits this long before responding, e.g. "1.5s".
+	Delay string `json:"delay,omitempty" yaml:"delay,omitempty"`
+
+	delay time.Duration
+}
+
+// PredictableToolUse is a scripted tool call.
+type PredictableToolUse struct {
+	Name  string         `json:"name" yaml:"name"`
+	Input map[string]any `json:"input,omitempty" yaml:"input,omitempty"`
+}
+
+// LoadPredictableScript reads a scenario from a .json file, or from YAML for any other extension.
+// Unknown fields are rejected, so that typos don't silently change a scenario.
+func LoadPredictableScript(path string) (*PredictableScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var script PredictableScript
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&script)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&script)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid predictable script %s: %w", path, err)
+	}
+	if err := script.compile(); err != nil {
+		return nil, fmt.Errorf("invalid predictable script %s: %w", path, err)
+	}
+	return &script, nil
+}
+
+// compile validates the rules and parses their regular expressions and delays.
+func (s *PredictableScript) compile() error {
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		if rule.Text == "" && rule.TextPrefix == "" && rule.TextRegexp == "" && rule.Tool == "" && rule.ToolResult == "" {
+			return fmt.Errorf("rule %d: no conditions", i+1)
+		}
+		if rule.TextRegexp != "" {
+			re, err := regexp.Compile(rule.TextRegexp)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			rule.textRegexp = re
+		}
+
+		resp := &rule.Response
+		if resp.Delay != "" {
+			delay, err := time.ParseDuration(resp.Delay)
+			if err != nil {
+				return fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			resp.delay = delay
+		}
+		hasContent := resp.Thinking != "" || resp.Text != "" || len(resp.ToolUses) > 0
+		switch {
+		case resp.Error != "" && hasContent:
+			return fmt.Errorf("rule %d: response has both an error and content", i+1)
+		case resp.Error == "" && !hasContent:
+			return fmt.Errorf("rule %d: response has no content", i+1)
+		case resp.Status != 0 && resp.Error == "":
+			return fmt.Errorf("rule %d: status requires an error", i+1)
+		case resp.MaxTokens && len(resp.ToolUses) > 0:
+			return fmt.Errorf("rule %d: max_tokens responses cannot use tools", i+1)
+		}
+		for _, tu := range resp.ToolUses {
+			if tu.Name == "" {
+				return fmt.Errorf("rule %d: tool use without a name", i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// match returns the first rule that matches req, or nil.
+func (s *PredictableScript) match(req *llm.Request) *PredictableRule {
+	if s == nil || len(req.Messages) == 0 {
+		return nil
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != llm.MessageRoleUser {
+		return nil
+	}
+
+	var userText string
+	var results []llm.Content
+	for _, c := range last.Content {
+		switch c.Type {
+		case llm.ContentTypeText:
+			if userText == "" {
+				userText = strings.TrimSpace(c.Text)
+			}
+		case llm.ContentTypeToolResult:
+			results = append(results, c)
+		}
+	}
+	isUserText := len(results) == 0
+	toolNames := toolUseNames(req.Messages)
+
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		if (rule.Text != "" || rule.TextPrefix != "" || rule.textRegexp != nil) && !isUserText {
+			continue
+		}
+		if rule.Text != "" && userText != strings.TrimSpace(rule.Text) {
+			continue
+		}
+		if rule.TextPrefix != "" && !strings.HasPrefix(userText, rule.TextPrefix) {
+			continue
+		}
+		if rule.textRegexp != nil && !rule.textRegexp.MatchString(userText) {
+			continue
+		}
+		if rule.Tool != "" || rule.ToolResult != "" {
+			matched := false
+			for _, result := range results {
+				if (rule.Tool == "" || toolNames[result.ToolUseID] == rule.Tool) &&
+					(rule.ToolResult == "" || strings.Contains(toolResultText(result), rule.ToolResult)) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		return rule
+	}
+	return nil
+}
+
+// toolUseNames maps the IDs of the tool calls in messages to the names of their tools.
+func toolUseNames(messages []llm.Message) map[string]string {
+	names := make(map[string]string)
+	for _, msg := range messages {
+		for _, c := range msg.Content {
+			if c.Type == llm.ContentTypeToolUse {
+				names[c.ID] = c.ToolName
+			}
+		}
+	}
+	return names
+}
+
+func toolResultText(result llm.Content) string {
+	var text strings.Builder
+	for _, c := range result.ToolResult {
+		if c.Type == llm.ContentTypeText {
+			text.WriteString(c.Text)
+		}
+	}
+	return text.String()
+}
+
+// makeScriptedResponse builds the response for a rule that matched.
+func (s *PredictableService) makeScriptedResponse(resp *PredictableResponse, inputTokens uint64) (*llm.Response, error) {
+	if resp.Error != "" {
+		if resp.Status != 0 {
+			return nil, llm.StatusErrorf(resp.Status, "predictable error: %s", resp.Error)
+		}
+		return nil, fmt.Errorf("predictable error: %s", resp.Error)
+	}
+
+	baseNano := time.Now().UnixNano()
+	var content []llm.Content
+	outputChars := 0
+	if resp.Thinking != "" {
+		content = append(content, llm.Content{Type: llm.ContentTypeThinking, Thinking: resp.Thinking})
+		outputChars += len(resp.Thinking)
+	}
+	if resp.Text != "" {
+		content = append(content, llm.Content{Type: llm.ContentTypeText, Text: resp.Text})
+		outputChars += len(resp.Text)
+	}
+	for i, tu := range resp.ToolUses {
+		input := tu.Input
+		if input == nil {
+			// Tools expect an object
+			input = map[string]any{}
+		}
+		data, err := json.Marshal(input)
+		if err != nil {
+			return nil, fmt.Errorf("predictable: invalid input for %s: %w", tu.Name, err)
+		}
+		content = append(content, llm.Content{
+			ID:        fmt.Sprintf("tool_%s_%d", tu.Name, (baseNano+int64(i))%1000),
+			Type:      llm.ContentTypeToolUse,
+			ToolName:  tu.Name,
+			ToolInput: json.RawMessage(data),
+		})
+		outputChars += len(data)
+	}
+
+	stopReason := llm.StopReasonStopSequence
+	switch {
+	case resp.MaxTokens:
+		stopReason = llm.StopReasonMaxTokens
+	case len(resp.ToolUses) > 0:
+		stopReason = llm.StopReasonToolUse
+	}
+	return &llm.Response{
+		ID:         fmt.Sprintf("pred-script-%d", baseNano),
+		Type:       "message",
+		Role:       llm.MessageRoleAssistant,
+		Model:      "predictable-v1",
+		Content:    content,
+		StopReason: stopReason,
+		Usage: llm.Usage{
+			InputTokens:  inputTokens,
+			OutputTokens: max(uint64(outputChars/4), 1),
+			CostUSD:      0.001,
+		},
+	}, nil
+}
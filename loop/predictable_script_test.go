@@ -0,0 +1,159 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"shelley.exe.dev/llm"
+)
+
+const testScriptYAML = `
+rules:
+  - text: "list files"
+    response:
+      thinking: "The user wants a listing."
+      text: "I'll list them."
+      tool_uses:
+        - name: bash
+          input: {command: "ls -la"}
+  - tool: bash
+    tool_result: "main.go"
+    response:
+      text: "Found main.go."
+  - tool: bash
+    response:
+      text: "No Go files."
+  - text_regexp: "^overloaded( now)?$"
+    response:
+      error: "overloaded"
+      status: 529
+  - text_prefix: "long"
+    response:
+      delay: 10ms
+      text: "This answer was cut"
+      max_tokens: true
+`
+
+func writeScript(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func toolResultMessage(id, text string) llm.Message {
+	return llm.Message{Role: llm.MessageRoleUser, Content: []llm.Content{{
+		Type:       llm.ContentTypeToolResult,
+		ToolUseID:  id,
+		ToolResult: []llm.Content{{Type: llm.ContentTypeText, Text: text}},
+	}}}
+}
+
+func TestPredictableScript(t *testing.T) {
+	script, err := LoadPredictableScript(writeScript(t, "scenario.yaml", testScriptYAML))
+	if err != nil {
+		t.Fatalf("LoadPredictableScript: %v", err)
+	}
+	service := NewPredictableService()
+	service.SetScript(script)
+	ctx := context.Background()
+
+	resp, err := service.Do(ctx, &llm.Request{Messages: []llm.Message{llm.UserStringMessage("list files")}})
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if len(resp.Content) != 3 || resp.StopReason != llm.StopReasonToolUse {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if resp.Content[0].Type != llm.ContentTypeThinking || resp.Content[1].Text != "I'll list them." {
+		t.Errorf("unexpected content %+v", resp.Content[:2])
+	}
+	toolUse := resp.Content[2]
+	var input map[string]string
+	if err := json.Unmarshal(toolUse.ToolInput, &input); err != nil || toolUse.ToolName != "bash" || input["command"] != "ls -la" {
+		t.Errorf("unexpected tool use %+v", toolUse)
+	}
+
+	history := []llm.Message{
+		llm.UserStringMessage("list files"),
+		{Role: llm.MessageRoleAssistant, Content: resp.Content},
+	}
+	for _, tt := range []struct{ result, want string }{
+		{"main.go\ngo.mod", "Found main.go."},
+		{"README.md", "No Go files."},
+	} {
+		messages := append(history, toolResultMessage(toolUse.ID, tt.result))
+		resp, err := service.Do(ctx, &llm.Request{Messages: messages})
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		if resp.Content[0].Text != tt.want {
+			t.Errorf("response to tool result %q = %q, want %q", tt.result, resp.Content[0].Text, tt.want)
+		}
+	}
+
+	_, err = service.Do(ctx, &llm.Request{Messages: []llm.Message{llm.UserStringMessage("overloaded now")}})
+	var statusErr *llm.HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != 529 {
+		t.Errorf("Do(overloaded) error = %v, want a 529 status error", err)
+	}
+
+	start := time.Now()
+	resp, err = service.Do(ctx, &llm.Request{Messages: []llm.Message{llm.UserStringMessage("long answer")}})
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if resp.StopReason != llm.StopReasonMaxTokens || time.Since(start) < 10*time.Millisecond {
+		t.Errorf("expected a delayed max_tokens response, got %v after %v", resp.StopReason, time.Since(start))
+	}
+
+	// Unscripted input falls back to the built-in responses
+	resp, err = service.Do(ctx, &llm.Request{Messages: []llm.Message{llm.UserStringMessage("echo: still here")}})
+	if err != nil || resp.Content[0].Text != "still here" {
+		t.Errorf("unscripted input: %+v, %v", resp, err)
+	}
+}
+
+func TestLoadPredictableScriptJSON(t *testing.T) {
+	path := writeScript(t, "scenario.json", `{"rules": [{"text": "hi", "response": {"text": "Hello from JSON"}}]}`)
+	script, err := LoadPredictableScript(path)
+	if err != nil {
+		t.Fatalf("LoadPredictableScript: %v", err)
+	}
+	service := NewPredictableService()
+	service.SetScript(script)
+	resp, err := service.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}})
+	if err != nil || resp.Content[0].Text != "Hello from JSON" {
+		t.Errorf("Do: %+v, %v", resp, err)
+	}
+}
+
+func TestLoadPredictableScriptInvalid(t *testing.T) {
+	tests := []struct {
+		name, content, wantErr string
+	}{
+		{"unknown field", "rules:\n  - text: hi\n    respnse: {text: x}\n", "respnse"},
+		{"no conditions", "rules:\n  - response: {text: x}\n", "no conditions"},
+		{"no content", "rules:\n  - text: hi\n    response: {delay: 1s}\n", "no content"},
+		{"error and content", "rules:\n  - text: hi\n    response: {text: x, error: y}\n", "both"},
+		{"bad regexp", "rules:\n  - text_regexp: '('\n    response: {text: x}\n", "rule 1"},
+		{"bad delay", "rules:\n  - text: hi\n    response: {text: x, delay: soon}\n", "rule 1"},
+		{"status without error", "rules:\n  - text: hi\n    response: {text: x, status: 500}\n", "status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadPredictableScript(writeScript(t, "scenario.yaml", tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadPredictableScript error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Credentials looks up API keys by name, e.g. "ANTHROPIC_API_KEY".
+// The Manager asks again for every key when it reloads its credentials.
+type Credentials interface {
+	// APIKey returns the named key, or "" if the source doesn't have it
+	APIKey(name string) (string, error)
+}
+
+// EnvCredentials reads keys from the environment variables of the same name.
+type EnvCredentials struct{}
+
+func (EnvCredentials) APIKey(name string) (string, error) {
+	return os.Getenv(name), nil
+}
+
+// DirCredentials reads each key from the file of the same name in a directory,
+// such as the $CREDENTIALS_DIRECTORY of a systemd service started with LoadCredential=.
+type DirCredentials string
+
+func (d DirCredentials) APIKey(name string) (string, error) {
+	return readKeyFile(filepath.Join(string(d), name))
+}
+
+// CommandCredentials runs a helper command with the key name as its last argument,
+// and uses what it prints as the key. A helper that prints nothing doesn't have the key.
+type CommandCredentials []string
+
+func (c CommandCredentials) APIKey(name string) (string, error) {
+	return runKeyCommand(c[0], append(c[1:len(c):len(c)], name)...)
+}
+
+// credentialsCommandTimeout bounds how long a helper command may take to print a key
+const credentialsCommandTimeout = 30 * time.Second
+
+func runKeyCommand(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialsCommandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return "", fmt.Errorf("credentials command %s: %w", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func readKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// CredentialsConfig says where to find API keys, typically from "credentials" in shelley.json:
+//
+//	"credentials": {
+//	  "keys": {"ANTHROPIC_API_KEY": "file:/etc/shelley/anthropic", "OPENAI_API_KEY": "command:pass show openai"},
+//	  "directory": "/run/keys/shelley",
+//	  "command": ["/usr/local/bin/shelley-keys"]
+//	}
+//
+// Each key is looked up in Keys, then Directory, then with Command, then in the environment.
+type CredentialsConfig struct {
+	// Keys maps key names to references: "env:NAME", "file:/path" or "command:shell command"
+	Keys map[string]string `json:"keys,omitempty"`
+
+	// Directory holds one file per key, named after it. Defaults to $CREDENTIALS_DIRECTORY.
+	Directory string `json:"directory,omitempty"`
+
+	// Command is a helper run with the key name as its last argument, printing the key
+	Command []string `json:"command,omitempty"`
+}
+
+// Validate checks the references in Keys.
+func (c *CredentialsConfig) Validate() error {
+	for name, ref := range c.Keys {
+		kind, value, _ := strings.Cut(ref, ":")
+		if value == "" || (kind != "env" && kind != "file" && kind != "command") {
+			return fmt.Errorf("credentials: key %s: want env:NAME, file:/path or command:..., got %q", name, ref)
+		}
+	}
+	return nil
+}
+
+// Credentials returns the lookup c describes. A nil c reads $CREDENTIALS_DIRECTORY and the environment.
+func (c *CredentialsConfig) Credentials() Credentials {
+	var chain chainCredentials
+	if c == nil {
+		c = &CredentialsConfig{}
+	}
+	if len(c.Keys) > 0 {
+		chain = append(chain, refCredentials(c.Keys))
+	}
+	if dir := c.directory(); dir != "" {
+		chain = append(chain, DirCredentials(dir))
+	}
+	if len(c.Command) > 0 {
+		chain = append(chain, CommandCredentials(c.Command))
+	}
+	return append(chain, EnvCredentials{})
+}
+
+// Files returns the files and directories keys are read from, which are worth watching for changes.
+func (c *CredentialsConfig) Files() []string {
+	if c == nil {
+		c = &CredentialsConfig{}
+	}
+	var files []string
+	for _, ref := range c.Keys {
+		if path, ok := strings.CutPrefix(ref, "file:"); ok {
+			files = append(files, path)
+		}
+	}
+	slices.Sort(files)
+	if dir := c.directory(); dir != "" {
+		files = append(files, dir)
+	}
+	return files
+}
+
+func (c *CredentialsConfig) directory() string {
+	if c.Directory != "" {
+		return c.Directory
+	}
+	return os.Getenv("CREDENTIALS_DIRECTORY")
+}
+
+// refCredentials looks up the keys it has a reference for
+type refCredentials map[string]string
+
+func (r refCredentials) APIKey(name string) (string, error) {
+	kind, value, _ := strings.Cut(r[name], ":")
+	switch kind {
+	case "env":
+		return os.Getenv(value), nil
+	case "file":
+		key, err := readKeyFile(value)
+		if err == nil && key == "" {
+			err = fmt.Errorf("credentials: %s: %s is missing or empty", name, value)
+		}
+		return key, err
+	case "command":
+		return runKeyCommand("sh", "-c", value)
+	}
+	return "", nil
+}
+
+// chainCredentials returns the first key one of its sources has
+type chainCredentials []Credentials
+
+func (c chainCredentials) APIKey(name string) (string, error) {
+	for _, creds := range c {
+		key, err := creds.APIKey(name)
+		if key != "" || err != nil {
+			return key, err
+		}
+	}
+	return "", nil
+}
+
+// WatchCredentials reloads the manager's credentials whenever one of files changes, checking every
+// interval until ctx is done. Directories are watched for changes to the files in them.
+func (m *Manager) WatchCredentials(ctx context.Context, files []string, interval time.Duration) {
+	if len(files) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := filesFingerprint(files)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current := filesFingerprint(files)
+		if current == last {
+			continue
+		}
+		last = current
+		err := m.ReloadCredentials()
+		if m.logger != nil {
+			if err != nil {
+				m.logger.Warn("Failed to reload credentials", "error", err)
+			} else {
+				m.logger.Info("Reloaded credentials after a change", "models", m.GetAvailableModels())
+			}
+		}
+	}
+}
+
+// filesFingerprint describes the size and modification time of files and of the files in
+// directories among them, so that a change to any of them changes it
+func filesFingerprint(files []string) string {
+	var b strings.Builder
+	for _, path := range files {
+		paths := []string{path}
+		if entries, err := os.ReadDir(path); err == nil {
+			for _, entry := range entries {
+				paths = append(paths, filepath.Join(path, entry.Name()))
+			}
+		}
+		for _, p := range paths {
+			// Stat follows symlinks, which is how mounted secrets are usually swapped
+			if info, err := os.Stat(p); err == nil {
+				fmt.Fprintf(&b, "%s %d %d\n", p, info.Size(), info.ModTime().UnixNano())
+			} else {
+				fmt.Fprintf(&b, "%s missing\n", p)
+			}
+		}
+	}
+	return b.String()
+}
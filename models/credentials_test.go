@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+func TestCredentialsConfig(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "DIR_KEY"), []byte("from-dir\n"), 0o600)
+	os.WriteFile(filepath.Join(dir, "SHADOWED_KEY"), []byte("from-dir"), 0o600)
+	keyFile := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, []byte("from-file\n"), 0o600)
+	t.Setenv("SHELLEY_TEST_REF_ENV", "from-ref-env")
+	t.Setenv("SHELLEY_TEST_ENV_KEY", "from-env")
+
+	config := &CredentialsConfig{
+		Keys: map[string]string{
+			"FILE_KEY":     "file:" + keyFile,
+			"ENV_KEY":      "env:SHELLEY_TEST_REF_ENV",
+			"CMD_KEY":      "command:echo from-ref-command",
+			"SHADOWED_KEY": "env:SHELLEY_TEST_UNSET",
+		},
+		Directory: dir,
+		Command:   []string{"sh", "-c", `if [ "$0" = HELPER_KEY ]; then echo from-helper; fi`},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate(): %v", err)
+	}
+	creds := config.Credentials()
+	for name, want := range map[string]string{
+		"FILE_KEY":             "from-file",
+		"ENV_KEY":              "from-ref-env",
+		"CMD_KEY":              "from-ref-command",
+		"SHADOWED_KEY":         "from-dir",
+		"DIR_KEY":              "from-dir",
+		"HELPER_KEY":           "from-helper",
+		"SHELLEY_TEST_ENV_KEY": "from-env",
+		"MISSING_KEY":          "",
+	} {
+		if got, err := creds.APIKey(name); err != nil || got != want {
+			t.Errorf("APIKey(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if files := config.Files(); !slices.Equal(files, []string{keyFile, dir}) {
+		t.Errorf("Files() = %v, want the key file and the directory", files)
+	}
+
+	if _, err := (&CredentialsConfig{Keys: map[string]string{"KEY": "command:exit 3"}}).Credentials().APIKey("KEY"); err == nil {
+		t.Error("APIKey() with a failing command succeeded, want error")
+	}
+	if err := (&CredentialsConfig{Keys: map[string]string{"KEY": "vault:secret/key"}}).Validate(); err == nil {
+		t.Error("Validate() accepted an unknown reference")
+	}
+}
+
+func TestReloadCredentials(t *testing.T) {
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1","object":"chat.completion","model":"qwen","choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	manager, err := NewManager(&Config{
+		Credentials: DirCredentials(dir),
+		Models:      []ModelConfig{{ID: "local", Provider: ProviderTypeOpenAIChat, BaseURL: server.URL + "/v1", ModelName: "qwen", APIKeyEnv: "LOCAL_KEY"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	if manager.HasModel("local") {
+		t.Fatal("local is available without its key")
+	}
+
+	// A key that appears makes its model available
+	os.WriteFile(filepath.Join(dir, "LOCAL_KEY"), []byte("first"), 0o600)
+	if err := manager.ReloadCredentials(); err != nil {
+		t.Fatalf("ReloadCredentials(): %v", err)
+	}
+	if !slices.Contains(manager.GetAvailableModels(), "local") {
+		t.Fatalf("available models = %v, want local once its key exists", manager.GetAvailableModels())
+	}
+	svc, err := manager.GetService("local")
+	if err != nil {
+		t.Fatalf("GetService(local): %v", err)
+	}
+	request := &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}}
+	if _, err := svc.Do(context.Background(), request); err != nil {
+		t.Fatalf("Do(): %v", err)
+	}
+	if gotAuth != "Bearer first" {
+		t.Errorf("Authorization = %q, want the first key", gotAuth)
+	}
+
+	// A rotated key reaches the service already in use
+	os.WriteFile(filepath.Join(dir, "LOCAL_KEY"), []byte("second"), 0o600)
+	if err := manager.ReloadCredentials(); err != nil {
+		t.Fatalf("ReloadCredentials(): %v", err)
+	}
+	if _, err := svc.Do(context.Background(), request); err != nil {
+		t.Fatalf("Do(): %v", err)
+	}
+	if gotAuth != "Bearer second" {
+		t.Errorf("Authorization = %q, want the rotated key", gotAuth)
+	}
+}
+
+func TestGatewayImplicitKeys(t *testing.T) {
+	manager, err := NewManager(&Config{Gateway: "https://gateway.example", Credentials: DirCredentials(t.TempDir())}, nil)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	for _, id := range []string{"claude-opus-4.5", "gpt-5", "gemini-2.5-pro", "qwen3-coder-fireworks"} {
+		if !manager.HasModel(id) {
+			t.Errorf("%s is not available through the gateway", id)
+		}
+	}
+}
+
+func TestFilesFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(keyFile, []byte("one"), 0o600)
+	files := []string{keyFile, dir}
+
+	before := filesFingerprint(files)
+	if filesFingerprint(files) != before {
+		t.Fatal("fingerprint changed without any change")
+	}
+	os.WriteFile(keyFile, []byte("rotated"), 0o600)
+	afterRotation := filesFingerprint(files)
+	if afterRotation == before {
+		t.Error("fingerprint did not change when a key file was rewritten")
+	}
+	os.WriteFile(filepath.Join(dir, "ANTHROPIC_API_KEY"), []byte("key"), 0o600)
+	if filesFingerprint(files) == afterRotation {
+		t.Error("fingerprint did not change when a key was added to the directory")
+	}
+}
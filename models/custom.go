@@ -0,0 +1,193 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/llm/ant"
+	"shelley.exe.dev/llm/gem"
+	"shelley.exe.dev/llm/oai"
+)
+
+// Provider types accepted in ModelConfig.Provider
+const (
+	ProviderTypeAnthropic       = "anthropic"
+	ProviderTypeOpenAIChat      = "openai-chat"
+	ProviderTypeOpenAIResponses = "openai-responses"
+	ProviderTypeGemini          = "gemini"
+)
+
+// ModelConfig describes a user-defined model, typically from the "models" array in shelley.json.
+// It can point at a provider's own API or at any compatible server, such as a local llama.cpp or vLLM.
+type ModelConfig struct {
+	// ID is the user-facing identifier. If it matches a built-in model, this entry replaces it.
+	ID string `json:"id"`
+
+	// Provider is the API the endpoint speaks: "anthropic", "openai-chat", "openai-responses" or "gemini".
+	Provider string `json:"provider"`
+
+	// BaseURL is the API base URL, e.g. "http://localhost:8080/v1" for an OpenAI-compatible server.
+	// For anthropic, "/v1/messages" is appended, as with ANTHROPIC_BASE_URL.
+	// If empty, the provider's default API (or the gateway) is used with the provider's usual API key.
+	BaseURL string `json:"base_url,omitempty"`
+
+	// ModelName is the model name sent to the API.
+	ModelName string `json:"model_name"`
+
+	// APIKeyEnv names the API key, which is read from the environment variable of that name
+	// unless Config.Credentials has it elsewhere.
+	// If empty, the provider's usual API key is used, or none at all when BaseURL is set.
+	APIKeyEnv string `json:"api_key_env,omitempty"`
+
+	// ContextWindow is the model's context window in tokens (optional).
+	ContextWindow int `json:"context_window,omitempty"`
+
+	// SimplifiedPatch makes the patch tool use its simplified input schema,
+	// for models that struggle with the full one. Only supported for openai-* providers.
+	SimplifiedPatch bool `json:"simplified_patch,omitempty"`
+}
+
+// Validate checks that the entry has everything needed to build a Model.
+func (c *ModelConfig) Validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("model id is required")
+	}
+	if c.ModelName == "" {
+		return fmt.Errorf("model %s: model_name is required", c.ID)
+	}
+	if c.ContextWindow < 0 {
+		return fmt.Errorf("model %s: context_window must not be negative", c.ID)
+	}
+	switch c.Provider {
+	case ProviderTypeOpenAIChat, ProviderTypeOpenAIResponses:
+	case ProviderTypeAnthropic, ProviderTypeGemini:
+		if c.SimplifiedPatch {
+			return fmt.Errorf("model %s: simplified_patch is not supported for provider %q", c.ID, c.Provider)
+		}
+	default:
+		return fmt.Errorf("model %s: unknown provider %q", c.ID, c.Provider)
+	}
+	return nil
+}
+
+// Model converts the entry into a Model whose Factory builds a service for the configured endpoint.
+func (c *ModelConfig) Model() (Model, error) {
+	if err := c.Validate(); err != nil {
+		return Model{}, err
+	}
+
+	model := Model{
+		ID:          c.ID,
+		Description: c.ModelName,
+		Factory:     c.newService,
+	}
+	if c.BaseURL != "" {
+		model.Description = fmt.Sprintf("%s at %s", c.ModelName, c.BaseURL)
+	}
+	providerEnv := "OPENAI_API_KEY"
+	switch c.Provider {
+	case ProviderTypeAnthropic:
+		model.Provider = ProviderAnthropic
+		providerEnv = "ANTHROPIC_API_KEY"
+	case ProviderTypeGemini:
+		model.Provider = ProviderGemini
+		providerEnv = "GEMINI_API_KEY"
+	default:
+		model.Provider = ProviderOpenAI
+	}
+	if c.APIKeyEnv != "" {
+		model.RequiredEnvVars = []string{c.APIKeyEnv}
+	} else if c.BaseURL == "" {
+		model.RequiredEnvVars = []string{providerEnv}
+	}
+	return model, nil
+}
+
+// apiKey returns the key to send with requests, which may be empty for a custom endpoint.
+func (c *ModelConfig) apiKey(config *Config, providerKey, providerEnv string) (string, error) {
+	if c.APIKeyEnv != "" {
+		key := config.apiKey(c.APIKeyEnv)
+		if key == "" {
+			return "", fmt.Errorf("%s requires %s", c.ID, c.APIKeyEnv)
+		}
+		return key, nil
+	}
+	if c.BaseURL != "" {
+		return "", nil
+	}
+	if providerKey == "" {
+		return "", fmt.Errorf("%s requires %s", c.ID, providerEnv)
+	}
+	return providerKey, nil
+}
+
+func (c *ModelConfig) newService(config *Config) (llm.Service, error) {
+	baseURL := strings.TrimSuffix(c.BaseURL, "/")
+	switch c.Provider {
+	case ProviderTypeAnthropic:
+		key, err := c.apiKey(config, config.AnthropicAPIKey, "ANTHROPIC_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		svc := &ant.Service{APIKey: key, Model: c.ModelName, ContextWindow: c.ContextWindow}
+		if baseURL != "" {
+			svc.URL = baseURL + "/v1/messages"
+		} else if url := config.getAnthropicURL(); url != "" {
+			svc.URL = url
+		}
+		return svc, nil
+
+	case ProviderTypeGemini:
+		key, err := c.apiKey(config, config.GeminiAPIKey, "GEMINI_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		svc := &gem.Service{APIKey: key, Model: c.ModelName, ContextWindow: c.ContextWindow}
+		svc.URL = baseURL
+		if svc.URL == "" {
+			svc.URL = config.getGeminiURL()
+		}
+		return svc, nil
+
+	default:
+		key, err := c.apiKey(config, config.OpenAIAPIKey, "OPENAI_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		model := oai.Model{
+			UserName:           c.ID,
+			ModelName:          c.ModelName,
+			URL:                oai.OpenAIURL,
+			UseSimplifiedPatch: c.SimplifiedPatch,
+			ContextWindow:      c.ContextWindow,
+		}
+		modelURL := baseURL
+		if modelURL == "" {
+			modelURL = config.getOpenAIURL()
+		}
+		if c.Provider == ProviderTypeOpenAIResponses {
+			return &oai.ResponsesService{Model: model, APIKey: key, ModelURL: modelURL}, nil
+		}
+		return &oai.Service{Model: model, APIKey: key, ModelURL: modelURL}, nil
+	}
+}
+
+// mergeModels returns the built-in models with the custom ones applied.
+// A custom model with the ID of a built-in one replaces it in place; others are appended in order.
+func mergeModels(builtIn []Model, custom []Model) []Model {
+	merged := append([]Model(nil), builtIn...)
+	index := make(map[string]int, len(merged))
+	for i, m := range merged {
+		index[m.ID] = i
+	}
+	for _, m := range custom {
+		if i, ok := index[m.ID]; ok {
+			merged[i] = m
+			continue
+		}
+		index[m.ID] = len(merged)
+		merged = append(merged, m)
+	}
+	return merged
+}
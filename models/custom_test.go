@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/llm/ant"
+	"shelley.exe.dev/llm/oai"
+)
+
+func TestModelConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ModelConfig
+		wantErr bool
+	}{
+		{"valid", ModelConfig{ID: "local", Provider: ProviderTypeOpenAIChat, ModelName: "qwen"}, false},
+		{"simplified patch", ModelConfig{ID: "local", Provider: ProviderTypeOpenAIResponses, ModelName: "qwen", SimplifiedPatch: true}, false},
+		{"missing id", ModelConfig{Provider: ProviderTypeOpenAIChat, ModelName: "qwen"}, true},
+		{"missing model name", ModelConfig{ID: "local", Provider: ProviderTypeOpenAIChat}, true},
+		{"unknown provider", ModelConfig{ID: "local", Provider: "openai", ModelName: "qwen"}, true},
+		{"negative context window", ModelConfig{ID: "local", Provider: ProviderTypeGemini, ModelName: "g", ContextWindow: -1}, true},
+		{"simplified patch on anthropic", ModelConfig{ID: "c", Provider: ProviderTypeAnthropic, ModelName: "c", SimplifiedPatch: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManagerCustomModels(t *testing.T) {
+	t.Setenv("SHELLEY_TEST_LOCAL_KEY", "")
+	cfg := &Config{
+		AnthropicAPIKey: "test-key",
+		Models: []ModelConfig{
+			{ID: "local-qwen", Provider: ProviderTypeOpenAIChat, BaseURL: "http://localhost:8080/v1", ModelName: "qwen", ContextWindow: 32768, SimplifiedPatch: true},
+			{ID: "claude-opus-4.5", Provider: ProviderTypeAnthropic, ModelName: "claude-opus-4-5-custom", ContextWindow: 100000},
+			{ID: "missing-key", Provider: ProviderTypeOpenAIChat, BaseURL: "http://localhost:8081/v1", ModelName: "m", APIKeyEnv: "SHELLEY_TEST_LOCAL_KEY"},
+			{ID: "invalid", Provider: "bogus", ModelName: "m"},
+			{ID: "vllm", Provider: ProviderTypeOpenAIResponses, BaseURL: "http://localhost:8000/v1", ModelName: "llama"},
+		},
+	}
+	manager, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	available := manager.GetAvailableModels()
+	// The built-in model keeps its place; new models follow the built-ins in config order.
+	if available[0] != "claude-opus-4.5" {
+		t.Errorf("first model = %q, want the overridden claude-opus-4.5 in place", available[0])
+	}
+	if got := available[len(available)-2:]; !slices.Equal(got, []string{"local-qwen", "vllm"}) {
+		t.Errorf("last models = %v, want [local-qwen vllm]", got)
+	}
+	for _, id := range []string{"missing-key", "invalid"} {
+		if manager.HasModel(id) {
+			t.Errorf("model %q should not be available", id)
+		}
+	}
+
+	svc, err := manager.GetService("local-qwen")
+	if err != nil {
+		t.Fatalf("GetService(local-qwen): %v", err)
+	}
+	if got := svc.TokenContextWindow(); got != 32768 {
+		t.Errorf("local-qwen TokenContextWindow() = %d, want 32768", got)
+	}
+	if sp, ok := svc.(llm.SimplifiedPatcher); !ok || !sp.UseSimplifiedPatch() {
+		t.Error("local-qwen should use the simplified patch schema")
+	}
+
+	svc, err = manager.GetService("claude-opus-4.5")
+	if err != nil {
+		t.Fatalf("GetService(claude-opus-4.5): %v", err)
+	}
+	antSvc, ok := svc.(*ant.Service)
+	if !ok {
+		t.Fatalf("claude-opus-4.5 service is %T, want *ant.Service", svc)
+	}
+	if antSvc.Model != "claude-opus-4-5-custom" || antSvc.APIKey != "test-key" {
+		t.Errorf("override not applied: model %q, key %q", antSvc.Model, antSvc.APIKey)
+	}
+	if got := antSvc.TokenContextWindow(); got != 100000 {
+		t.Errorf("claude-opus-4.5 TokenContextWindow() = %d, want 100000", got)
+	}
+}
+
+func TestCustomOpenAIChatModelUsesBaseURL(t *testing.T) {
+	var gotPath, gotAuth, gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		var body struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotModel = body.Model
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1","object":"chat.completion","model":"qwen","choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("SHELLEY_TEST_LOCAL_KEY", "local-secret")
+	config := ModelConfig{ID: "local", Provider: ProviderTypeOpenAIChat, BaseURL: server.URL + "/v1/", ModelName: "qwen", APIKeyEnv: "SHELLEY_TEST_LOCAL_KEY"}
+	model, err := config.Model()
+	if err != nil {
+		t.Fatalf("Model(): %v", err)
+	}
+	if !slices.Equal(model.RequiredEnvVars, []string{"SHELLEY_TEST_LOCAL_KEY"}) {
+		t.Errorf("RequiredEnvVars = %v", model.RequiredEnvVars)
+	}
+	svc, err := model.Factory(&Config{})
+	if err != nil {
+		t.Fatalf("Factory(): %v", err)
+	}
+
+	resp, err := svc.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}})
+	if err != nil {
+		t.Fatalf("Do(): %v", err)
+	}
+	if len(resp.Content) == 0 || resp.Content[0].Text != "hello" {
+		t.Errorf("unexpected response %+v", resp.Content)
+	}
+	if gotPath != "/v1/chat/completions" {
+		t.Errorf("request path = %q, want /v1/chat/completions", gotPath)
+	}
+	if gotAuth != "Bearer local-secret" {
+		t.Errorf("Authorization = %q, want the key from SHELLEY_TEST_LOCAL_KEY", gotAuth)
+	}
+	if gotModel != "qwen" {
+		t.Errorf("model = %q, want qwen", gotModel)
+	}
+}
+
+func TestManagerHTTPClient(t *testing.T) {
+	httpc := &http.Client{}
+	cfg := &Config{
+		AnthropicAPIKey: "test-key",
+		Models:          []ModelConfig{{ID: "local", Provider: ProviderTypeOpenAIChat, BaseURL: "http://localhost:8080/v1", ModelName: "qwen"}},
+		HTTPClient:      httpc,
+	}
+	manager, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	svc, err := manager.GetService("claude-opus-4.5")
+	if err != nil {
+		t.Fatalf("GetService(claude-opus-4.5): %v", err)
+	}
+	if antSvc, ok := svc.(*ant.Service); !ok || antSvc.HTTPC != httpc {
+		t.Errorf("claude-opus-4.5 does not use the configured HTTP client")
+	}
+	if oaiSvc, ok := manager.services["local"].(*oai.Service); !ok || oaiSvc.HTTPC != httpc {
+		t.Errorf("local does not use the configured HTTP client")
+	}
+}
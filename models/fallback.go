@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"shelley.exe.dev/llm"
+)
+
+// errAttemptTimeout cancels a model's attempt that used up its share of the request's deadline.
+var errAttemptTimeout = errors.New("model did not answer within its share of the deadline")
+
+// fallbackService sends each request to the first model in a chain,
+// moving on to the next model when a provider keeps failing with a server error,
+// a rate limit or a timeout. Other errors, such as invalid requests, are returned as is.
+type fallbackService struct {
+	modelIDs []string
+	services []llm.Service
+	logger   *slog.Logger
+}
+
+// Do sends the request to the first model in the chain that answers it
+func (f *fallbackService) Do(ctx context.Context, request *llm.Request) (*llm.Response, error) {
+	return f.do(ctx, request, nil)
+}
+
+// DoStream is like Do, streaming from whichever model answers.
+// Once a model has streamed output, its errors are returned rather than falling back.
+func (f *fallbackService) DoStream(ctx context.Context, request *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	return f.do(ctx, request, onDelta)
+}
+
+// If ctx has a deadline, each model but the last gets an equal share of the time left,
+// so that a hung provider leaves time for the next one. Once a model streams output,
+// it has the rest of the time to finish.
+func (f *fallbackService) do(ctx context.Context, request *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	var errs error
+	for i, svc := range f.services {
+		attemptCtx, cancel := context.WithCancelCause(ctx)
+		stopTimer := func() bool { return false }
+		if deadline, ok := ctx.Deadline(); ok && i < len(f.services)-1 {
+			share := time.Until(deadline) / time.Duration(len(f.services)-i)
+			stopTimer = time.AfterFunc(share, func() { cancel(errAttemptTimeout) }).Stop
+		}
+
+		streamed := false
+		var deltas func(llm.StreamDelta)
+		if onDelta != nil {
+			deltas = func(d llm.StreamDelta) {
+				stopTimer()
+				streamed = true
+				onDelta(d)
+			}
+		}
+
+		response, err := llm.DoStream(attemptCtx, svc, portableRequest(request, i > 0), deltas)
+		timedOut := context.Cause(attemptCtx) == errAttemptTimeout
+		stopTimer()
+		cancel(nil)
+		if err == nil {
+			response.Usage.Model = f.modelIDs[i]
+			return response, nil
+		}
+		if timedOut {
+			err = fmt.Errorf("%w: %w", errAttemptTimeout, err)
+		}
+		errs = errors.Join(errs, fmt.Errorf("%s: %w", f.modelIDs[i], err))
+		if streamed || !(timedOut || shouldFallBack(ctx, err)) || i == len(f.services)-1 {
+			return nil, errs
+		}
+		if f.logger != nil {
+			f.logger.Warn("LLM request failed, falling back to next model", "model", f.modelIDs[i], "fallback", f.modelIDs[i+1], "error", err)
+		}
+	}
+	return nil, errs
+}
+
+// TokenContextWindow returns the primary model's context window, which conversations are sized for
+func (f *fallbackService) TokenContextWindow() int {
+	return f.services[0].TokenContextWindow()
+}
+
+// MaxImageDimension returns the smallest limit in the chain, so images fit any model that may receive them
+func (f *fallbackService) MaxImageDimension() int {
+	dimension := 0
+	for _, svc := range f.services {
+		if d := svc.MaxImageDimension(); d > 0 && (dimension == 0 || d < dimension) {
+			dimension = d
+		}
+	}
+	return dimension
+}
+
+// CountTokens counts with the primary model, which requests are sized for
+func (f *fallbackService) CountTokens(ctx context.Context, request *llm.Request) (uint64, error) {
+	return llm.CountTokens(ctx, f.services[0], portableRequest(request, false))
+}
+
+// UseSimplifiedPatch follows the primary model, since tools are set up once per conversation
+func (f *fallbackService) UseSimplifiedPatch() bool {
+	return llm.UseSimplifiedPatch(f.services[0])
+}
+
+// shouldFallBack reports whether err is worth retrying with another model:
+// a server error, a rate limit or a timeout, rather than a problem with the request itself.
+// Providers join the errors of all their attempts, so the last one decides.
+func shouldFallBack(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		// The caller gave up, e.g. the user cancelled the turn
+		return false
+	}
+	for err != nil {
+		if err == context.DeadlineExceeded {
+			return true
+		}
+		switch e := err.(type) {
+		case *llm.HTTPStatusError:
+			return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+		case net.Error:
+			if e.Timeout() {
+				return true
+			}
+		case interface{ Unwrap() []error }:
+			joined := e.Unwrap()
+			if len(joined) == 0 {
+				return false
+			}
+			err = joined[len(joined)-1]
+			continue
+		}
+		err = errors.Unwrap(err)
+	}
+	return false
+}
+
+// portableRequest returns request with thinking content a model may not accept removed.
+// Signed thinking is only meaningful to the provider that produced it, so a fallback model gets none;
+// unsigned thinking (e.g. an OpenAI reasoning summary) is dropped for every model.
+func portableRequest(request *llm.Request, fallback bool) *llm.Request {
+	keep := func(c llm.Content) bool {
+		switch c.Type {
+		case llm.ContentTypeThinking:
+			return !fallback && c.Signature != ""
+		case llm.ContentTypeRedactedThinking:
+			return !fallback
+		}
+		return true
+	}
+
+	changed := false
+	messages := make([]llm.Message, len(request.Messages))
+	for i, msg := range request.Messages {
+		messages[i] = msg
+		if msg.Role != llm.MessageRoleAssistant {
+			continue
+		}
+		var content []llm.Content
+		for _, c := range msg.Content {
+			if keep(c) {
+				content = append(content, c)
+			}
+		}
+		if len(content) != len(msg.Content) {
+			messages[i].Content = content
+			changed = true
+		}
+	}
+	if !changed {
+		return request
+	}
+	portable := *request
+	portable.Messages = messages
+	return &portable
+}
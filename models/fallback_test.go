@@ -0,0 +1,231 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"shelley.exe.dev/llm"
+)
+
+// scriptedService returns err, or a text response, and records the requests it receives.
+type scriptedService struct {
+	err      error
+	delta    bool // stream a delta before returning
+	hang     bool // wait for the context to be done, like a provider that never answers
+	requests []*llm.Request
+}
+
+func (s *scriptedService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	return s.DoStream(ctx, req, nil)
+}
+
+func (s *scriptedService) DoStream(ctx context.Context, req *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	s.requests = append(s.requests, req)
+	if s.delta && onDelta != nil {
+		onDelta(llm.StreamDelta{Type: llm.ContentTypeText, Text: "partial"})
+	}
+	if s.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &llm.Response{Model: "provider-model", Content: []llm.Content{llm.StringContent("ok")}}, nil
+}
+
+func (s *scriptedService) TokenContextWindow() int { return 1000 }
+func (s *scriptedService) MaxImageDimension() int  { return 2000 }
+
+func TestFallbackService(t *testing.T) {
+	overloaded := fmt.Errorf("request failed after 3 attempts: %w", errors.Join(
+		llm.StatusErrorf(http.StatusInternalServerError, "status 500"),
+		llm.StatusErrorf(529, "status 529"),
+	))
+	tests := []struct {
+		name      string
+		primary   *scriptedService
+		wantModel string // empty if the request should fail
+		fallback  bool   // whether the fallback should be called
+	}{
+		{"primary answers", &scriptedService{}, "primary", false},
+		{"server error", &scriptedService{err: overloaded}, "fallback", true},
+		{"rate limited", &scriptedService{err: llm.StatusErrorf(http.StatusTooManyRequests, "status 429")}, "fallback", true},
+		{"timeout", &scriptedService{err: &url.Error{Op: "Post", URL: "http://x", Err: context.DeadlineExceeded}}, "fallback", true},
+		{"bad request", &scriptedService{err: llm.StatusErrorf(http.StatusBadRequest, "status 400")}, "", false},
+		{"bad request after server errors", &scriptedService{err: errors.Join(overloaded, llm.StatusErrorf(http.StatusBadRequest, "status 400"))}, "", false},
+		{"streamed before failing", &scriptedService{err: llm.StatusErrorf(http.StatusInternalServerError, "status 500"), delta: true}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fallback := &scriptedService{}
+			svc := &fallbackService{modelIDs: []string{"primary", "fallback"}, services: []llm.Service{tt.primary, fallback}}
+
+			resp, err := svc.DoStream(context.Background(), &llm.Request{}, func(llm.StreamDelta) {})
+			if tt.wantModel == "" {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("DoStream: %v", err)
+				}
+				if resp.Usage.Model != tt.wantModel {
+					t.Errorf("Usage.Model = %q, want %q", resp.Usage.Model, tt.wantModel)
+				}
+			}
+			if called := len(fallback.requests) > 0; called != tt.fallback {
+				t.Errorf("fallback called = %v, want %v", called, tt.fallback)
+			}
+		})
+	}
+}
+
+func TestFallbackServiceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fallback := &scriptedService{}
+	svc := &fallbackService{
+		modelIDs: []string{"primary", "fallback"},
+		services: []llm.Service{&scriptedService{err: context.Canceled}, fallback},
+	}
+	if _, err := svc.Do(ctx, &llm.Request{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(fallback.requests) > 0 {
+		t.Error("fell back after the caller cancelled")
+	}
+}
+
+func TestFallbackServiceHungProvider(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		fallback := &scriptedService{}
+		svc := &fallbackService{
+			modelIDs: []string{"primary", "fallback"},
+			services: []llm.Service{&scriptedService{hang: true}, fallback},
+		}
+		start := time.Now()
+		resp, err := svc.Do(ctx, &llm.Request{})
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		if resp.Usage.Model != "fallback" {
+			t.Errorf("Usage.Model = %q, want the fallback", resp.Usage.Model)
+		}
+		if elapsed := time.Since(start); elapsed != 150*time.Second {
+			t.Errorf("fell back after %v, want half the deadline", elapsed)
+		}
+
+		// A model that has started streaming is not cut off
+		streaming := &scriptedService{hang: true, delta: true}
+		svc.services[0] = streaming
+		if _, err := svc.DoStream(ctx, &llm.Request{}, func(llm.StreamDelta) {}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("DoStream: %v, want the request's deadline exceeded", err)
+		}
+		if len(fallback.requests) != 1 {
+			t.Error("fell back after the primary streamed output")
+		}
+	})
+}
+
+func TestFallbackServiceDropsThinking(t *testing.T) {
+	fallback := &scriptedService{}
+	primary := &scriptedService{err: llm.StatusErrorf(http.StatusServiceUnavailable, "status 503")}
+	svc := &fallbackService{modelIDs: []string{"primary", "fallback"}, services: []llm.Service{primary, fallback}}
+
+	req := &llm.Request{Messages: []llm.Message{
+		llm.UserStringMessage("hi"),
+		{Role: llm.MessageRoleAssistant, Content: []llm.Content{
+			{Type: llm.ContentTypeThinking, Thinking: "signed", Signature: "sig"},
+			{Type: llm.ContentTypeThinking, Text: "unsigned summary"},
+			{Type: llm.ContentTypeRedactedThinking, Data: "redacted"},
+			llm.StringContent("hello"),
+		}},
+		llm.UserStringMessage("again"),
+	}}
+	if _, err := svc.Do(context.Background(), req); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	// The primary keeps its own signed thinking; the fallback gets none.
+	if got := len(primary.requests[0].Messages[1].Content); got != 3 {
+		t.Errorf("primary got %d content blocks, want 3", got)
+	}
+	content := fallback.requests[0].Messages[1].Content
+	if len(content) != 1 || content[0].Text != "hello" {
+		t.Errorf("fallback got content %+v, want only the text", content)
+	}
+	if len(req.Messages[1].Content) != 4 {
+		t.Error("the original request was modified")
+	}
+}
+
+func TestManagerFallbacks(t *testing.T) {
+	cfg := &Config{
+		Models: []ModelConfig{
+			{ID: "local", Provider: ProviderTypeOpenAIChat, BaseURL: "http://localhost:8080/v1", ModelName: "qwen"},
+		},
+		Fallbacks: map[string][]string{"predictable": {"unavailable", "local"}},
+	}
+	manager, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	svc, err := manager.GetService("predictable")
+	if err != nil {
+		t.Fatalf("GetService: %v", err)
+	}
+	chain, ok := svc.(*fallbackService)
+	if !ok {
+		t.Fatalf("service is %T, want a fallback chain", svc)
+	}
+	if len(chain.modelIDs) != 2 || chain.modelIDs[0] != "predictable" || chain.modelIDs[1] != "local" {
+		t.Errorf("chain = %v, want [predictable local]", chain.modelIDs)
+	}
+
+	// Models without fallbacks are returned unwrapped.
+	if svc, err := manager.GetService("local"); err != nil {
+		t.Fatalf("GetService(local): %v", err)
+	} else if _, ok := svc.(*fallbackService); ok {
+		t.Error("local has no fallbacks but got a chain")
+	}
+}
+
+// countingService is a scriptedService that can count tokens
+type countingService struct {
+	scriptedService
+	tokens uint64
+}
+
+func (s *countingService) CountTokens(context.Context, *llm.Request) (uint64, error) {
+	return s.tokens, nil
+}
+
+func TestWrappersCountTokens(t *testing.T) {
+	req := &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}}
+	counting := &countingService{tokens: 42}
+
+	// The fallback chain counts with its primary model, which requests are sized for
+	chain := &fallbackService{modelIDs: []string{"primary", "fallback"}, services: []llm.Service{counting, &scriptedService{}}}
+	if n, err := llm.CountTokens(context.Background(), chain, req); err != nil || n != 42 {
+		t.Errorf("fallback CountTokens = %d, %v; want 42", n, err)
+	}
+	chain = &fallbackService{modelIDs: []string{"primary", "fallback"}, services: []llm.Service{&scriptedService{}, counting}}
+	if _, err := llm.CountTokens(context.Background(), chain, req); !errors.Is(err, llm.ErrTokenCountUnsupported) {
+		t.Errorf("fallback CountTokens error = %v, want ErrTokenCountUnsupported", err)
+	}
+
+	logged := &loggingService{service: counting, modelID: "primary"}
+	if n, err := llm.CountTokens(context.Background(), logged, req); err != nil || n != 42 {
+		t.Errorf("logging CountTokens = %d, %v; want 42", n, err)
+	}
+}
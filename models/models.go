@@ -2,13 +2,18 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
+	"slices"
 	"sync"
 	"time"
 
 	"shelley.exe.dev/llm"
 	"shelley.exe.dev/llm/ant"
+	"shelley.exe.dev/llm/gem"
 	"shelley.exe.dev/llm/oai"
 	"shelley.exe.dev/loop"
 )
@@ -44,17 +49,57 @@ type Model struct {
 
 // Config holds the configuration needed to create LLM services
 type Config struct {
-	// API keys for each provider
+	// API keys for each provider, taking precedence over Credentials
 	AnthropicAPIKey string
 	OpenAIAPIKey    string
 	GeminiAPIKey    string
 	FireworksAPIKey string
 
+	// Credentials looks up the API keys not set above, including those named by Models,
+	// when the Manager is created and whenever it reloads them (optional, defaults to EnvCredentials)
+	Credentials Credentials
+
 	// Gateway is the base URL of the LLM gateway (optional)
-	// If set, model-specific suffixes will be appended
+	// If set, model-specific suffixes will be appended, and the gateway
+	// supplies the provider API keys that are not set ("implicit")
 	Gateway string
 
+	// Models are user-defined models, added to the built-in ones or replacing those with the same ID
+	Models []ModelConfig
+
+	// Fallbacks maps a model ID to the models to try, in order, when its provider
+	// keeps failing with server errors, rate limits or timeouts
+	Fallbacks map[string][]string
+
+	// HTTPClient, if set, sends the requests of every service, e.g. to record them
+	HTTPClient *http.Client
+
+	// PredictableScript, if set, scripts the predictable model's responses
+	PredictableScript *loop.PredictableScript
+
 	Logger *slog.Logger
+
+	// keys are the API keys the Manager looked up, see apiKey
+	keys map[string]string
+}
+
+// providerKeys returns the API keys set for the built-in providers, by environment variable name
+func (c *Config) providerKeys() map[string]string {
+	return map[string]string{
+		"ANTHROPIC_API_KEY": c.AnthropicAPIKey,
+		"OPENAI_API_KEY":    c.OpenAIAPIKey,
+		"GEMINI_API_KEY":    c.GeminiAPIKey,
+		"FIREWORKS_API_KEY": c.FireworksAPIKey,
+	}
+}
+
+// apiKey returns the named API key, as looked up by the Manager,
+// or from the environment when the Config is used without one.
+func (c *Config) apiKey(name string) string {
+	if c.keys != nil {
+		return c.keys[name]
+	}
+	return os.Getenv(name)
 }
 
 // getAnthropicURL returns the Anthropic API URL, with gateway suffix if gateway is set
@@ -73,10 +118,13 @@ func (c *Config) getOpenAIURL() string {
 	return "" // use default from oai package
 }
 
-// getGeminiURL returns the Gemini API URL, with gateway suffix if gateway is set
+// getGeminiURL returns the Gemini API base URL, with gateway suffix if gateway is set.
+// The gem package appends the model and method, e.g. "/models/gemini-2.5-pro:generateContent".
+// Like the other gateway paths, the suffix mirrors the provider's own API path, here that of
+// the gemini package's default endpoint, https://generativelanguage.googleapis.com/v1beta.
 func (c *Config) getGeminiURL() string {
 	if c.Gateway != "" {
-		return c.Gateway + "/_/gateway/gemini/v1/models/generate"
+		return c.Gateway + "/_/gateway/gemini/v1beta"
 	}
 	return "" // use default from gem package
 }
@@ -220,13 +268,47 @@ func All() []Model {
 				return svc, nil
 			},
 		},
+		{
+			ID:              "gemini-2.5-pro",
+			Provider:        ProviderGemini,
+			Description:     "Gemini 2.5 Pro",
+			RequiredEnvVars: []string{"GEMINI_API_KEY"},
+			Factory: func(config *Config) (llm.Service, error) {
+				if config.GeminiAPIKey == "" {
+					return nil, fmt.Errorf("gemini-2.5-pro requires GEMINI_API_KEY")
+				}
+				svc := &gem.Service{APIKey: config.GeminiAPIKey, Model: gem.Gemini25Pro}
+				if url := config.getGeminiURL(); url != "" {
+					svc.URL = url
+				}
+				return svc, nil
+			},
+		},
+		{
+			ID:              "gemini-2.5-flash",
+			Provider:        ProviderGemini,
+			Description:     "Gemini 2.5 Flash",
+			RequiredEnvVars: []string{"GEMINI_API_KEY"},
+			Factory: func(config *Config) (llm.Service, error) {
+				if config.GeminiAPIKey == "" {
+					return nil, fmt.Errorf("gemini-2.5-flash requires GEMINI_API_KEY")
+				}
+				svc := &gem.Service{APIKey: config.GeminiAPIKey, Model: gem.Gemini25Flash}
+				if url := config.getGeminiURL(); url != "" {
+					svc.URL = url
+				}
+				return svc, nil
+			},
+		},
 		{
 			ID:              "predictable",
 			Provider:        ProviderBuiltIn,
 			Description:     "Deterministic test model (no API key)",
 			RequiredEnvVars: []string{},
 			Factory: func(config *Config) (llm.Service, error) {
-				return loop.NewPredictableService(), nil
+				svc := loop.NewPredictableService()
+				svc.SetScript(config.PredictableScript)
+				return svc, nil
 			},
 		},
 	}
@@ -259,13 +341,24 @@ func Default() Model {
 
 // Manager manages LLM services for all configured models
 type Manager struct {
+	cfg       *Config
+	models    []Model // every configured model, available or not, in display order
+	fallbacks map[string][]string
+	logger    *slog.Logger
+	history   *LLMRequestHistory
+
+	reloadMu sync.Mutex // serializes ReloadCredentials
+	mu       sync.RWMutex
 	services map[string]llm.Service
-	logger   *slog.Logger
-	history  *LLMRequestHistory
+	order    []string          // IDs of the available models in display order
+	keys     map[string]string // API keys by name, which the services read for each request
 }
 
 // LLMRequestRecord stores a request/response pair for debugging
 type LLMRequestRecord struct {
+	ID             int64     `json:"id,omitempty"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	MessageID      string    `json:"message_id,omitempty"` // the message the response became, once recorded
 	Timestamp      time.Time `json:"timestamp"`
 	ModelID        string    `json:"model_id"`
 	URL            string    `json:"url"`
@@ -274,43 +367,71 @@ type LLMRequestRecord struct {
 	HTTPStatusCode int       `json:"http_status_code,omitempty"`
 	Error          string    `json:"error,omitempty"`
 	Duration       float64   `json:"duration_seconds"`
+	Attempt        int       `json:"attempt,omitempty"` // 1 for the first attempt, more for retries
+}
+
+// LLMRequestStore persists LLM request records
+type LLMRequestStore interface {
+	// AddLLMRequest stores record and returns the ID it was stored under
+	AddLLMRequest(ctx context.Context, record LLMRequestRecord) (int64, error)
 }
 
-// LLMRequestHistory maintains a circular buffer of recent LLM requests
+// LLMRequestHistory records the raw HTTP exchanges of LLM services, attributed to the
+// conversation they were made for. It keeps the most recent in memory, and all of them
+// in its store, if it has one.
 type LLMRequestHistory struct {
-	mu      sync.RWMutex
-	records []LLMRequestRecord
+	store   LLMRequestStore
 	maxSize int
+
+	mu      sync.Mutex
+	records []LLMRequestRecord // the most recent, oldest first
+	lastID  int64              // of the records kept only in memory
 }
 
-// NewLLMRequestHistory creates a new request history with the given max size
-func NewLLMRequestHistory(maxSize int) *LLMRequestHistory {
-	return &LLMRequestHistory{
-		records: make([]LLMRequestRecord, 0, maxSize),
-		maxSize: maxSize,
-	}
+// NewLLMRequestHistory creates a request history that keeps the last maxSize records in
+// memory and, if store is not nil, every record in store
+func NewLLMRequestHistory(maxSize int, store LLMRequestStore) *LLMRequestHistory {
+	return &LLMRequestHistory{store: store, maxSize: maxSize}
 }
 
-// Add adds a new record to the history
-func (h *LLMRequestHistory) Add(record LLMRequestRecord) {
+// Add records a request made with ctx. The record is attributed to ctx's conversation,
+// if any. If the history has a store, the ID the record is stored under is added to
+// ctx's request log, if any.
+func (h *LLMRequestHistory) Add(ctx context.Context, record LLMRequestRecord) error {
+	record.ConversationID = llm.ConversationID(ctx)
+	if h.store != nil {
+		// Record requests even if they were cancelled
+		id, err := h.store.AddLLMRequest(context.WithoutCancel(ctx), record)
+		if err != nil {
+			return err
+		}
+		record.ID = id
+		llm.AddRequestID(ctx, id)
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
-
-	if len(h.records) >= h.maxSize {
-		// Remove oldest record
-		h.records = h.records[1:]
+	if h.store == nil {
+		h.lastID++
+		record.ID = h.lastID
 	}
 	h.records = append(h.records, record)
+	if len(h.records) > h.maxSize {
+		h.records = slices.Delete(h.records, 0, len(h.records)-h.maxSize)
+	}
+	return nil
 }
 
-// GetRecords returns a copy of all records
+// GetRecords returns the records kept in memory, oldest first
 func (h *LLMRequestHistory) GetRecords() []LLMRequestRecord {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.records)
+}
 
-	result := make([]LLMRequestRecord, len(h.records))
-	copy(result, h.records)
-	return result
+// Stored reports whether the history keeps every record in a store
+func (h *LLMRequestHistory) Stored() bool {
+	return h.store != nil
 }
 
 // ConfigInfo is an optional interface that services can implement to provide configuration details for logging
@@ -324,20 +445,28 @@ type loggingService struct {
 	service llm.Service
 	logger  *slog.Logger
 	modelID string
-	history *LLMRequestHistory
 }
 
 // Do wraps the underlying service's Do method with logging
 func (l *loggingService) Do(ctx context.Context, request *llm.Request) (*llm.Response, error) {
+	return l.do(ctx, request, nil)
+}
+
+// DoStream streams from the underlying service if it supports streaming, with logging
+func (l *loggingService) DoStream(ctx context.Context, request *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
+	return l.do(ctx, request, onDelta)
+}
+
+func (l *loggingService) do(ctx context.Context, request *llm.Request, onDelta func(llm.StreamDelta)) (*llm.Response, error) {
 	start := time.Now()
 
 	// Call the underlying service
-	response, err := l.service.Do(ctx, request)
+	response, err := llm.DoStream(ctx, l.service, request, onDelta)
 
 	duration := time.Since(start)
 	durationSeconds := duration.Seconds()
 
-	// History recording now happens in the provider (e.g., ant.Service)
+	// History recording happens in the services' HTTP clients
 	// to capture raw HTTP requests/responses
 
 	// Log the completion with usage information
@@ -394,6 +523,11 @@ func (l *loggingService) MaxImageDimension() int {
 	return l.service.MaxImageDimension()
 }
 
+// CountTokens delegates to the underlying service if it can count tokens
+func (l *loggingService) CountTokens(ctx context.Context, request *llm.Request) (uint64, error) {
+	return llm.CountTokens(ctx, l.service, request)
+}
+
 // UseSimplifiedPatch delegates to the underlying service if it supports it
 func (l *loggingService) UseSimplifiedPatch() bool {
 	if sp, ok := l.service.(llm.SimplifiedPatcher); ok {
@@ -402,54 +536,211 @@ func (l *loggingService) UseSimplifiedPatch() bool {
 	return false
 }
 
-// NewManager creates a new Manager with all models configured
+// NewManager creates a new Manager with all models configured,
+// including the user-defined models in cfg.Models
 func NewManager(cfg *Config, history *LLMRequestHistory) (*Manager, error) {
 	manager := &Manager{
-		services: make(map[string]llm.Service),
-		logger:   cfg.Logger,
-		history:  history,
+		cfg:       cfg,
+		services:  make(map[string]llm.Service),
+		fallbacks: cfg.Fallbacks,
+		logger:    cfg.Logger,
+		history:   history,
 	}
 
-	for _, model := range All() {
-		svc, err := model.Factory(cfg)
+	var custom []Model
+	for i := range cfg.Models {
+		model, err := cfg.Models[i].Model()
 		if err != nil {
-			// Model not available (e.g., missing API key) - skip it
+			if cfg.Logger != nil {
+				cfg.Logger.Warn("Skipping invalid model in config", "error", err)
+			}
 			continue
 		}
-		manager.services[model.ID] = svc
+		custom = append(custom, model)
 	}
+	manager.models = mergeModels(All(), custom)
 
+	if err := manager.ReloadCredentials(); err != nil && cfg.Logger != nil {
+		// Models whose keys could not be looked up are unavailable until a reload finds them
+		cfg.Logger.Warn("Failed to load credentials", "error", err)
+	}
 	return manager, nil
 }
 
-// GetService returns the LLM service for the given model ID, wrapped with logging
-func (m *Manager) GetService(modelID string) (llm.Service, error) {
-	if svc, ok := m.services[modelID]; ok {
-		// Set HTTP recorder on ant.Service if we have history
-		if antSvc, ok := svc.(*ant.Service); ok && m.history != nil {
-			antSvc.HTTPRecorder = func(url string, requestBody, responseBody []byte, statusCode int, err error, duration time.Duration) {
-				record := LLMRequestRecord{
-					Timestamp:      time.Now().Add(-duration),
-					ModelID:        modelID,
-					URL:            url,
-					HTTPRequest:    requestBody,
-					HTTPResponse:   responseBody,
-					HTTPStatusCode: statusCode,
-					Duration:       duration.Seconds(),
-				}
-				if err != nil {
-					record.Error = err.Error()
+// ReloadCredentials looks up the API keys again. Services already handed out, including those of
+// running conversations, send the new keys from their next request on, and models whose keys have
+// become available are added. A key that can't be looked up keeps its previous value.
+func (m *Manager) ReloadCredentials() error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	m.mu.RLock()
+	previous := m.keys
+	m.mu.RUnlock()
+	keys, err := m.lookUpKeys(previous)
+
+	cfg := *m.cfg
+	cfg.keys = keys
+	cfg.AnthropicAPIKey = keys["ANTHROPIC_API_KEY"]
+	cfg.OpenAIAPIKey = keys["OPENAI_API_KEY"]
+	cfg.GeminiAPIKey = keys["GEMINI_API_KEY"]
+	cfg.FireworksAPIKey = keys["FIREWORKS_API_KEY"]
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = keys
+	m.order = m.order[:0]
+	for _, model := range m.models {
+		if _, ok := m.services[model.ID]; !ok {
+			svc, err := model.Factory(&cfg)
+			if err != nil {
+				// Model not available (e.g., missing API key) - skip it
+				continue
+			}
+			if m.history != nil {
+				setHTTPClient(svc, llm.RecordingClient(cfg.HTTPClient, m.recordHTTP(model.ID)))
+			} else if cfg.HTTPClient != nil {
+				setHTTPClient(svc, cfg.HTTPClient)
+			}
+			if len(model.RequiredEnvVars) == 1 {
+				setAPIKeyFunc(svc, m.apiKeyFunc(model.RequiredEnvVars[0]))
+			}
+			m.services[model.ID] = svc
+		}
+		m.order = append(m.order, model.ID)
+	}
+	return err
+}
+
+// lookUpKeys looks up the API keys the models require, using previous for those that fail.
+func (m *Manager) lookUpKeys(previous map[string]string) (map[string]string, error) {
+	creds := m.cfg.Credentials
+	if creds == nil {
+		creds = EnvCredentials{}
+	}
+	explicit := m.cfg.providerKeys()
+	keys := make(map[string]string)
+	var errs []error
+	for _, model := range m.models {
+		for _, name := range model.RequiredEnvVars {
+			if _, ok := keys[name]; ok {
+				continue
+			}
+			key := explicit[name]
+			if key == "" {
+				var err error
+				if key, err = creds.APIKey(name); err != nil {
+					errs = append(errs, err)
+					key = previous[name]
 				}
-				m.history.Add(record)
 			}
+			if _, ok := explicit[name]; ok && key == "" && m.cfg.Gateway != "" {
+				key = "implicit"
+			}
+			keys[name] = key
+		}
+	}
+	return keys, errors.Join(errs...)
+}
+
+// apiKeyFunc returns a function that returns the current value of the named key
+func (m *Manager) apiKeyFunc(name string) func() string {
+	return func() string {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.keys[name]
+	}
+}
+
+// setAPIKeyFunc makes svc get its API key from f for every request.
+func setAPIKeyFunc(svc llm.Service, f func() string) {
+	switch s := svc.(type) {
+	case *ant.Service:
+		s.APIKeyFunc = f
+	case *oai.Service:
+		s.APIKeyFunc = f
+	case *oai.ResponsesService:
+		s.APIKeyFunc = f
+	case *gem.Service:
+		s.APIKeyFunc = f
+	}
+}
+
+// setHTTPClient makes svc send its requests with httpc.
+// Every service that makes HTTP requests must be handled here, so that they are recorded.
+func setHTTPClient(svc llm.Service, httpc *http.Client) {
+	switch s := svc.(type) {
+	case *ant.Service:
+		s.HTTPC = httpc
+	case *oai.Service:
+		s.HTTPC = httpc
+	case *oai.ResponsesService:
+		s.HTTPC = httpc
+	case *gem.Service:
+		s.HTTPC = httpc
+	}
+}
+
+// recordHTTP returns a recorder that adds the HTTP exchanges of a model's service to the history
+func (m *Manager) recordHTTP(modelID string) llm.HTTPRecorder {
+	return func(ctx context.Context, ex llm.HTTPExchange) {
+		record := LLMRequestRecord{
+			Timestamp:      ex.Start,
+			ModelID:        modelID,
+			URL:            ex.URL,
+			HTTPRequest:    ex.RequestBody,
+			HTTPResponse:   ex.ResponseBody,
+			HTTPStatusCode: ex.StatusCode,
+			Duration:       ex.Duration.Seconds(),
+			Attempt:        ex.Attempt,
+		}
+		if ex.Err != nil {
+			record.Error = ex.Err.Error()
+		}
+		if err := m.history.Add(ctx, record); err != nil && m.logger != nil {
+			m.logger.Warn("Failed to record LLM request", "model", modelID, "error", err)
+		}
+	}
+}
+
+// GetService returns the LLM service for the given model ID, wrapped with logging.
+// If the model has fallbacks configured, the service falls back to those that are available.
+func (m *Manager) GetService(modelID string) (llm.Service, error) {
+	svc, err := m.getService(modelID)
+	if err != nil {
+		return nil, err
+	}
+	chain := &fallbackService{modelIDs: []string{modelID}, services: []llm.Service{svc}, logger: m.logger}
+	for _, id := range m.fallbacks[modelID] {
+		if id == modelID {
+			continue
+		}
+		fallback, err := m.getService(id)
+		if err != nil {
+			// Fallback not available (e.g., missing API key) - skip it
+			continue
 		}
+		chain.modelIDs = append(chain.modelIDs, id)
+		chain.services = append(chain.services, fallback)
+	}
+	if len(chain.services) == 1 {
+		return svc, nil
+	}
+	return chain, nil
+}
+
+// getService returns the LLM service for a single model, wrapped with logging
+func (m *Manager) getService(modelID string) (llm.Service, error) {
+	m.mu.RLock()
+	svc, ok := m.services[modelID]
+	m.mu.RUnlock()
+	if ok {
 		// Wrap with logging if we have a logger
 		if m.logger != nil {
 			return &loggingService{
 				service: svc,
 				logger:  m.logger,
 				modelID: modelID,
-				history: m.history,
 			}, nil
 		}
 		return svc, nil
@@ -462,21 +753,18 @@ func (m *Manager) GetHistory() *LLMRequestHistory {
 	return m.history
 }
 
-// GetAvailableModels returns a list of available model IDs in the same order as All()
+// GetAvailableModels returns a list of available model IDs in the same order as All(),
+// followed by user-defined models in config order
 func (m *Manager) GetAvailableModels() []string {
-	// Return IDs in the same order as All() for consistency
-	all := All()
-	var ids []string
-	for _, model := range all {
-		if _, ok := m.services[model.ID]; ok {
-			ids = append(ids, model.ID)
-		}
-	}
-	return ids
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string(nil), m.order...)
 }
 
 // HasModel reports whether the manager has a service for the given model ID
 func (m *Manager) HasModel(modelID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	_, ok := m.services[modelID]
 	return ok
 }
@@ -1,7 +1,16 @@
 package models
 
 import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/llm/gem"
 )
 
 func TestAll(t *testing.T) {
@@ -35,6 +44,8 @@ func TestByID(t *testing.T) {
 		{id: "claude-sonnet-4.5", wantID: "claude-sonnet-4.5", wantNil: false},
 		{id: "claude-haiku-4.5", wantID: "claude-haiku-4.5", wantNil: false},
 		{id: "claude-opus-4.5", wantID: "claude-opus-4.5", wantNil: false},
+		{id: "gemini-2.5-pro", wantID: "gemini-2.5-pro", wantNil: false},
+		{id: "gemini-2.5-flash", wantID: "gemini-2.5-flash", wantNil: false},
 		{id: "nonexistent", wantNil: true},
 	}
 
@@ -99,6 +110,44 @@ func TestFactory(t *testing.T) {
 	}
 }
 
+func TestGeminiModels(t *testing.T) {
+	for _, id := range []string{"gemini-2.5-pro", "gemini-2.5-flash"} {
+		t.Run(id, func(t *testing.T) {
+			m := ByID(id)
+			if m == nil {
+				t.Fatalf("ByID(%q) = nil", id)
+			}
+			if m.Provider != ProviderGemini {
+				t.Errorf("Provider = %q, want %q", m.Provider, ProviderGemini)
+			}
+			if _, err := m.Factory(&Config{}); err == nil {
+				t.Error("Factory() without GEMINI_API_KEY succeeded, want error")
+			}
+
+			svc, err := m.Factory(&Config{GeminiAPIKey: "key", Gateway: "https://gateway.example"})
+			if err != nil {
+				t.Fatalf("Factory() failed: %v", err)
+			}
+			gemSvc, ok := svc.(*gem.Service)
+			if !ok {
+				t.Fatalf("Factory() returned %T, want *gem.Service", svc)
+			}
+			if gemSvc.Model != id {
+				t.Errorf("Model = %q, want %q", gemSvc.Model, id)
+			}
+			if want := "https://gateway.example/_/gateway/gemini/v1beta"; gemSvc.URL != want {
+				t.Errorf("URL = %q, want %q", gemSvc.URL, want)
+			}
+			if got := svc.TokenContextWindow(); got != 1048576 {
+				t.Errorf("TokenContextWindow() = %d, want 1048576", got)
+			}
+			if got := svc.MaxImageDimension(); got == 0 {
+				t.Error("MaxImageDimension() = 0, want a limit")
+			}
+		})
+	}
+}
+
 func TestManagerGetAvailableModelsOrder(t *testing.T) {
 	// Test that GetAvailableModels returns models in consistent order
 	cfg := &Config{}
@@ -170,3 +219,128 @@ func TestManagerGetAvailableModelsMatchesAllOrder(t *testing.T) {
 		}
 	}
 }
+
+func TestLoggingServiceStreams(t *testing.T) {
+	manager, err := NewManager(&Config{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}, nil)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	svc, err := manager.GetService("predictable")
+	if err != nil {
+		t.Fatalf("GetService failed: %v", err)
+	}
+	if _, ok := svc.(*loggingService); !ok {
+		t.Fatalf("expected logging wrapper, got %T", svc)
+	}
+
+	var text strings.Builder
+	resp, err := llm.DoStream(context.Background(), svc, &llm.Request{
+		Messages: []llm.Message{llm.UserStringMessage("echo: a b c")},
+	}, func(d llm.StreamDelta) {
+		text.WriteString(d.Text)
+	})
+	if err != nil {
+		t.Fatalf("DoStream failed: %v", err)
+	}
+	if text.String() != "a b c" || resp.Content[0].Text != "a b c" {
+		t.Errorf("streamed %q, response %q", text.String(), resp.Content[0].Text)
+	}
+}
+
+// memoryRequestStore is an LLMRequestStore that keeps records in memory
+type memoryRequestStore struct {
+	records []LLMRequestRecord
+}
+
+func (s *memoryRequestStore) AddLLMRequest(ctx context.Context, record LLMRequestRecord) (int64, error) {
+	s.records = append(s.records, record)
+	return int64(len(s.records)), nil
+}
+
+func TestManagerRecordsRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/v1/messages"):
+			io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-haiku-4-5","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`)
+		case strings.HasSuffix(r.URL.Path, "/chat/completions"):
+			io.WriteString(w, `{"id":"chat_1","object":"chat.completion","model":"qwen","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`)
+		case strings.HasSuffix(r.URL.Path, "/responses"):
+			io.WriteString(w, `{"id":"resp_1","object":"response","status":"completed","model":"qwen","output":[{"type":"message","id":"m1","role":"assistant","content":[{"type":"output_text","text":"hi"}]}],"usage":{"input_tokens":3,"output_tokens":1,"total_tokens":4}}`)
+		case strings.Contains(r.URL.Path, ":generateContent"):
+			io.WriteString(w, `{"candidates":[{"content":{"role":"model","parts":[{"text":"hi"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":1,"totalTokenCount":4}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("SHELLEY_TEST_GEMINI_KEY", "gemini-secret")
+
+	store := &memoryRequestStore{}
+	manager, err := NewManager(&Config{
+		AnthropicAPIKey: "test-key",
+		Gateway:         server.URL,
+		Models: []ModelConfig{
+			{ID: "local-chat", Provider: ProviderTypeOpenAIChat, BaseURL: server.URL + "/v1", ModelName: "qwen"},
+			{ID: "local-responses", Provider: ProviderTypeOpenAIResponses, BaseURL: server.URL + "/v1", ModelName: "qwen"},
+			{ID: "local-gemini", Provider: ProviderTypeGemini, BaseURL: server.URL + "/v1beta", ModelName: "gemini-test", APIKeyEnv: "SHELLEY_TEST_GEMINI_KEY"},
+		},
+	}, NewLLMRequestHistory(10, store))
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	for _, modelID := range []string{"claude-haiku-4.5", "local-chat", "local-responses", "local-gemini"} {
+		t.Run(modelID, func(t *testing.T) {
+			svc, err := manager.GetService(modelID)
+			if err != nil {
+				t.Fatalf("GetService failed: %v", err)
+			}
+			store.records = nil
+			ctx := llm.WithRequestLog(llm.WithConversationID(context.Background(), "c1"))
+			if _, err := svc.Do(ctx, &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}}); err != nil {
+				t.Fatalf("Do failed: %v", err)
+			}
+			if len(store.records) != 1 {
+				t.Fatalf("stored %d records, want 1", len(store.records))
+			}
+			record := store.records[0]
+			if record.ConversationID != "c1" || record.ModelID != modelID || record.HTTPStatusCode != http.StatusOK || record.Attempt != 1 {
+				t.Errorf("unexpected record %+v", record)
+			}
+			if !strings.Contains(string(record.HTTPRequest), "hello") || !strings.Contains(string(record.HTTPResponse), `"hi"`) {
+				t.Errorf("record is missing the request or response body: %s\n%s", record.HTTPRequest, record.HTTPResponse)
+			}
+			if strings.Contains(record.URL, "gemini-secret") {
+				t.Errorf("recorded URL %s contains the API key", record.URL)
+			}
+			if id := llm.LastRequestID(ctx); id != 1 {
+				t.Errorf("LastRequestID = %d, want the stored record's ID 1", id)
+			}
+		})
+	}
+}
+
+func TestLLMRequestHistoryInMemory(t *testing.T) {
+	history := NewLLMRequestHistory(2, nil)
+	ctx := llm.WithRequestLog(llm.WithConversationID(context.Background(), "c1"))
+	for _, url := range []string{"/1", "/2", "/3"} {
+		if err := history.Add(ctx, LLMRequestRecord{URL: url}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	records := history.GetRecords()
+	if len(records) != 2 || records[0].URL != "/2" || records[1].URL != "/3" {
+		t.Fatalf("GetRecords() = %+v, want the last two records", records)
+	}
+	if records[1].ID != 3 || records[1].ConversationID != "c1" {
+		t.Errorf("unexpected record %+v", records[1])
+	}
+	// Only stored records can be linked to messages
+	if id := llm.LastRequestID(ctx); id != 0 {
+		t.Errorf("LastRequestID = %d, want 0 for records kept in memory", id)
+	}
+	if history.Stored() {
+		t.Error("Stored() = true without a store")
+	}
+}
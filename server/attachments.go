@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	_ "image/gif" // decoded by imageutil.ResizeImage
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	_ "golang.org/x/image/webp"
+
+	"shelley.exe.dev/claudetool/browse"
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/llm/imageutil"
+)
+
+// Attachment is a file sent along with a chat message, uploaded first through /api/upload.
+type Attachment struct {
+	// Path is the path /api/upload returned
+	Path string `json:"path"`
+	// Name is the file's original name, shown in the UI and to the model (optional)
+	Name string `json:"name,omitempty"`
+}
+
+// maxAttachmentText is how much of a text attachment the model sees, in bytes.
+const maxAttachmentText = 128 * 1024
+
+// content reads the attachment into what the model sees: an image scaled down to fit
+// maxImageDimension, a PDF document, or the text of a text file, cut to maxAttachmentText.
+func (a Attachment) content(maxImageDimension int) (llm.Content, error) {
+	// Resolve symlinks first, so that a link in the upload directory can't point out of it
+	path, err := filepath.EvalSymlinks(a.Path)
+	if err != nil {
+		return llm.Content{}, fmt.Errorf("attachment %s: %w", a.Path, err)
+	}
+	uploadDir, err := filepath.EvalSymlinks(browse.ScreenshotDir)
+	if err != nil {
+		return llm.Content{}, fmt.Errorf("attachment %s was not uploaded", a.Path)
+	}
+	if !strings.HasPrefix(path, uploadDir+"/") {
+		return llm.Content{}, fmt.Errorf("attachment %s was not uploaded", a.Path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return llm.Content{}, fmt.Errorf("attachment %s: %w", a.Path, err)
+	}
+	name := a.Name
+	if name == "" {
+		name = filepath.Base(a.Path)
+	}
+
+	mediaType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		// A maximum of 0 means the model has no known limit
+		if maxImageDimension > 0 {
+			var format string
+			data, format, _, err = imageutil.ResizeImage(data, maxImageDimension)
+			if err != nil {
+				return llm.Content{}, fmt.Errorf("attachment %s: %w", name, err)
+			}
+			mediaType = "image/" + format
+		}
+		return llm.Content{Type: llm.ContentTypeText, MediaType: mediaType, Data: base64.StdEncoding.EncodeToString(data), Filename: name}, nil
+	case mediaType == "application/pdf":
+		return llm.Content{Type: llm.ContentTypeText, MediaType: mediaType, Data: base64.StdEncoding.EncodeToString(data), Filename: name}, nil
+	case utf8.Valid(data):
+		if len(data) > maxAttachmentText {
+			cut := maxAttachmentText
+			for !utf8.RuneStart(data[cut]) {
+				cut--
+			}
+			data = fmt.Appendf(data[:cut:cut], "\n[attachment truncated: got %d bytes, showing the first %d]", len(data), cut)
+		}
+		text := fmt.Sprintf("<attachment name=%q>\n%s\n</attachment>", name, data)
+		return llm.Content{Type: llm.ContentTypeText, Text: text, Filename: name}, nil
+	}
+	return llm.Content{}, fmt.Errorf("attachment %s: unsupported file type %s", name, mediaType)
+}
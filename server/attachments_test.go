@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"shelley.exe.dev/claudetool/browse"
+	"shelley.exe.dev/llm"
+)
+
+// writeUpload writes data where /api/upload would have put it
+func writeUpload(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	if err := os.MkdirAll(browse.ScreenshotDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(browse.ScreenshotDir, "upload_test_"+t.Name()+"_"+name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(path) })
+	return path
+}
+
+func TestAttachmentContent(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 3000, 1500))); err != nil {
+		t.Fatal(err)
+	}
+	imagePath := writeUpload(t, "wide.png", pngData.Bytes())
+	textPath := writeUpload(t, "notes.txt", []byte("deploy on friday"))
+	pdfPath := writeUpload(t, "spec.pdf", []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\n"))
+	binaryPath := writeUpload(t, "archive.zip", []byte("PK\x03\x04\x00\xff\xfe\x00"))
+
+	content, err := Attachment{Path: imagePath, Name: "wide.png"}.content(2000)
+	if err != nil {
+		t.Fatalf("image: %v", err)
+	}
+	if content.MediaType != "image/png" || content.Filename != "wide.png" {
+		t.Errorf("image content = %s %q, want image/png wide.png", content.MediaType, content.Filename)
+	}
+	data, _ := base64.StdEncoding.DecodeString(content.Data)
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || config.Width != 2000 || config.Height != 1000 {
+		t.Errorf("image is %dx%d (%v), want it scaled down to 2000x1000", config.Width, config.Height, err)
+	}
+
+	content, err = Attachment{Path: textPath, Name: "notes.txt"}.content(2000)
+	if err != nil {
+		t.Fatalf("text: %v", err)
+	}
+	if content.MediaType != "" || !strings.Contains(content.Text, `<attachment name="notes.txt">`) || !strings.Contains(content.Text, "deploy on friday") {
+		t.Errorf("text content = %+v, want the file's text wrapped with its name", content)
+	}
+
+	content, err = Attachment{Path: pdfPath}.content(2000)
+	if err != nil {
+		t.Fatalf("pdf: %v", err)
+	}
+	if content.MediaType != "application/pdf" || content.Data == "" || content.Filename != filepath.Base(pdfPath) {
+		t.Errorf("pdf content = %s %q, want an application/pdf document named after the file", content.MediaType, content.Filename)
+	}
+
+	if _, err := (Attachment{Path: binaryPath}).content(2000); err == nil {
+		t.Error("binary attachment accepted, want an unsupported file type error")
+	}
+	long := strings.Repeat("é", maxAttachmentText)
+	content, err = Attachment{Path: writeUpload(t, "long.txt", []byte(long))}.content(2000)
+	if err != nil {
+		t.Fatalf("long text: %v", err)
+	}
+	if !utf8.ValidString(content.Text) || len(content.Text) > maxAttachmentText+200 || !strings.Contains(content.Text, "[attachment truncated: got 262144 bytes") {
+		t.Errorf("long text content is %d bytes, want it cut to %d with a note", len(content.Text), maxAttachmentText)
+	}
+
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	os.WriteFile(outside, []byte("secret"), 0o644)
+	link := filepath.Join(browse.ScreenshotDir, "upload_test_"+t.Name()+"_link.txt")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(link) })
+	for _, path := range []string{outside, browse.ScreenshotDir + "/../../" + outside, link} {
+		if _, err := (Attachment{Path: path}).content(2000); err == nil {
+			t.Errorf("attachment %s outside the upload directory accepted", path)
+		}
+	}
+}
+
+func TestChatWithAttachments(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	chatReq := ChatRequest{
+		Message: "echo: see attached",
+		Model:   "predictable",
+		Attachments: []Attachment{
+			{Path: writeUpload(t, "screen.png", pngData.Bytes()), Name: "screen.png"},
+			{Path: writeUpload(t, "log.txt", []byte("panic: nil map")), Name: "log.txt"},
+		},
+	}
+	body, _ := json.Marshal(chatReq)
+	w := httptest.NewRecorder()
+	h.server.handleNewConversation(w, httptest.NewRequest("POST", "/api/conversations/new", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		ConversationID string `json:"conversation_id"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	h.convID = resp.ConversationID
+	h.WaitResponse()
+
+	request := h.llm.GetLastRequest()
+	var user llm.Message
+	for _, msg := range request.Messages {
+		if msg.Role == llm.MessageRoleUser {
+			user = msg
+		}
+	}
+	if len(user.Content) != 3 {
+		t.Fatalf("user message has %d contents, want the text and both attachments: %+v", len(user.Content), user.Content)
+	}
+	if user.Content[1].MediaType != "image/png" || user.Content[1].Filename != "screen.png" {
+		t.Errorf("second content = %s %q, want the image", user.Content[1].MediaType, user.Content[1].Filename)
+	}
+	if !strings.Contains(user.Content[2].Text, "panic: nil map") {
+		t.Errorf("third content = %q, want the log's text", user.Content[2].Text)
+	}
+
+	// A message may be only attachments, but an unreadable attachment is rejected
+	for _, tc := range []struct {
+		req  ChatRequest
+		code int
+	}{
+		{ChatRequest{Model: "predictable", Attachments: chatReq.Attachments[1:]}, http.StatusAccepted},
+		{ChatRequest{Message: "hi", Model: "predictable", Attachments: []Attachment{{Path: "/etc/passwd"}}}, http.StatusBadRequest},
+		{ChatRequest{Model: "predictable"}, http.StatusBadRequest},
+	} {
+		body, _ := json.Marshal(tc.req)
+		w := httptest.NewRecorder()
+		h.server.handleChatConversation(w, httptest.NewRequest("POST", "/api/conversation/"+h.convID+"/chat", bytes.NewReader(body)), h.convID)
+		if w.Code != tc.code {
+			t.Errorf("chat %+v: status %d, want %d: %s", tc.req, w.Code, tc.code, w.Body.String())
+		}
+		if w.Code == http.StatusAccepted {
+			h.WaitResponse()
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/loop"
+)
+
+func TestSetConversationBudget(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	setBudget := func(body string) *generated.Conversation {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/conversation/"+h.ConversationID()+"/budget", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var conv generated.Conversation
+		if err := json.NewDecoder(rec.Body).Decode(&conv); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return &conv
+	}
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+
+	// Any response uses more than one token, so the next turn stops before calling the LLM.
+	conv := setBudget(`{"max_tokens": 1}`)
+	if conv.Budget == nil || *conv.Budget != `{"max_tokens":1}` {
+		t.Fatalf("stored budget = %v, want max_tokens 1", conv.Budget)
+	}
+	h.llm.ClearRequests()
+	h.Chat("echo: two")
+	if got := h.WaitResponse(); !strings.HasPrefix(got, "[Budget exceeded:") {
+		t.Fatalf("response = %q, want a budget exceeded message", got)
+	}
+	if h.llm.GetLastRequest() != nil {
+		t.Error("LLM was called after the budget was exceeded")
+	}
+
+	// Clearing the budget lets the conversation continue.
+	if conv := setBudget("null"); conv.Budget != nil {
+		t.Fatalf("budget = %q after clearing, want nil", *conv.Budget)
+	}
+	h.Chat("echo: three")
+	if got := h.WaitResponse(); got != "three" {
+		t.Fatalf("response = %q, want %q", got, "three")
+	}
+
+	req := httptest.NewRequest("POST", "/api/conversation/"+h.ConversationID()+"/budget", strings.NewReader(`{"max_dollars": -1}`))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a negative budget, got %d", rec.Code)
+	}
+}
+
+func TestBudgetCountsHiddenMessages(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	req := httptest.NewRequest("POST", "/api/conversation/"+h.ConversationID()+"/budget", strings.NewReader(`{"max_tokens": 1}`))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Editing the first message hides the response, but not what it cost.
+	editMessage(t, h, "echo: one", "echo: two")
+	h.responsesCount = 0
+	if got := h.WaitResponse(); !strings.HasPrefix(got, "[Budget exceeded:") {
+		t.Fatalf("response = %q, want a budget exceeded message", got)
+	}
+
+	req = httptest.NewRequest("POST", "/api/conversation/nope/budget", strings.NewReader(`{"max_tokens": 1}`))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown conversation, got %d", rec.Code)
+	}
+}
+
+func TestServerBudget(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+
+	// The first conversation used more than one token, so a new one stops before calling the LLM.
+	h.server.SetServerBudget(loop.Budget{MaxTokens: 1})
+	h.NewConversation("echo: two", "")
+	if got := h.WaitResponse(); !strings.HasPrefix(got, "[Budget exceeded: server-wide,") {
+		t.Fatalf("response = %q, want a server budget exceeded message", got)
+	}
+
+	h.server.SetServerBudget(loop.Budget{})
+	h.Chat("echo: three")
+	if got := h.WaitResponse(); got != "three" {
+		t.Fatalf("response = %q, want %q", got, "three")
+	}
+}
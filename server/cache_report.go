@@ -0,0 +1,134 @@
+package server
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+)
+
+// CacheUsage totals the prompt caching of LLM responses.
+type CacheUsage struct {
+	ResponseCount            int     `json:"response_count"`
+	InputTokens              uint64  `json:"input_tokens"` // neither written to nor read from a cache
+	CacheCreationInputTokens uint64  `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     uint64  `json:"cache_read_input_tokens"`
+	OutputTokens             uint64  `json:"output_tokens"`
+	CostUSD                  float64 `json:"cost_usd"`
+	// HitRate is the fraction of input tokens that were read from a cache
+	HitRate float64 `json:"hit_rate"`
+}
+
+func (u *CacheUsage) add(usage llm.Usage) {
+	u.ResponseCount++
+	u.InputTokens += usage.InputTokens
+	u.CacheCreationInputTokens += usage.CacheCreationInputTokens
+	u.CacheReadInputTokens += usage.CacheReadInputTokens
+	u.OutputTokens += usage.OutputTokens
+	u.CostUSD += usage.CostUSD
+	u.HitRate = hitRate(u.CacheReadInputTokens, u.InputTokens+u.CacheCreationInputTokens+u.CacheReadInputTokens)
+}
+
+func hitRate(read, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(read) / float64(total)
+}
+
+// ModelCacheUsage is the prompt caching of the responses of one model.
+type ModelCacheUsage struct {
+	Model string `json:"model"`
+	CacheUsage
+}
+
+// CacheResponse is the prompt caching of one response.
+type CacheResponse struct {
+	MessageID                string  `json:"message_id"`
+	SequenceID               int64   `json:"sequence_id"`
+	Type                     string  `json:"type"`
+	Model                    string  `json:"model,omitempty"`
+	InputTokens              uint64  `json:"input_tokens"`
+	CacheCreationInputTokens uint64  `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     uint64  `json:"cache_read_input_tokens"`
+	HitRate                  float64 `json:"hit_rate"`
+}
+
+// CacheReport shows how well prompt caching works in a conversation,
+// from the usage recorded with its messages.
+type CacheReport struct {
+	ConversationID string `json:"conversation_id"`
+	CacheUsage
+	Models    []ModelCacheUsage `json:"models"`    // by the model that responded
+	Responses []CacheResponse   `json:"responses"` // oldest first
+}
+
+// newCacheReport builds the cache report of a conversation's messages.
+func newCacheReport(conversationID string, messages []generated.Message) *CacheReport {
+	report := &CacheReport{
+		ConversationID: conversationID,
+		Models:         []ModelCacheUsage{},
+		Responses:      []CacheResponse{},
+	}
+	models := make(map[string]*CacheUsage)
+	for _, msg := range messages {
+		if msg.UsageData == nil {
+			continue
+		}
+		var usage llm.Usage
+		if err := json.Unmarshal([]byte(*msg.UsageData), &usage); err != nil || usage.IsZero() {
+			continue
+		}
+		report.add(usage)
+		if models[usage.Model] == nil {
+			models[usage.Model] = &CacheUsage{}
+		}
+		models[usage.Model].add(usage)
+		report.Responses = append(report.Responses, CacheResponse{
+			MessageID:                msg.MessageID,
+			SequenceID:               msg.SequenceID,
+			Type:                     msg.Type,
+			Model:                    usage.Model,
+			InputTokens:              usage.InputTokens,
+			CacheCreationInputTokens: usage.CacheCreationInputTokens,
+			CacheReadInputTokens:     usage.CacheReadInputTokens,
+			HitRate:                  hitRate(usage.CacheReadInputTokens, usage.TotalInputTokens()),
+		})
+	}
+	for model, usage := range models {
+		report.Models = append(report.Models, ModelCacheUsage{Model: model, CacheUsage: *usage})
+	}
+	slices.SortFunc(report.Models, func(a, b ModelCacheUsage) int { return cmp.Compare(a.Model, b.Model) })
+	return report
+}
+
+// handleCacheReport handles GET /conversation/<id>/cache
+func (s *Server) handleCacheReport(w http.ResponseWriter, r *http.Request, conversationID string) {
+	ctx := r.Context()
+	var messages []generated.Message
+	err := s.db.Queries(ctx, func(q *generated.Queries) error {
+		if _, err := q.GetConversation(ctx, conversationID); err != nil {
+			return err
+		}
+		var err error
+		messages, err = q.ListMessages(ctx, conversationID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		s.logger.Error("Failed to get conversation messages", "conversationID", conversationID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newCacheReport(conversationID, messages))
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+)
+
+func TestNewCacheReport(t *testing.T) {
+	message := func(seq int64, usage *llm.Usage) generated.Message {
+		msg := generated.Message{MessageID: fmt.Sprintf("m%d", seq), SequenceID: seq, Type: "agent"}
+		if usage != nil {
+			data, _ := json.Marshal(usage)
+			s := string(data)
+			msg.UsageData = &s
+		}
+		return msg
+	}
+	messages := []generated.Message{
+		{SequenceID: 1, Type: "user"},
+		message(2, &llm.Usage{Model: "claude", InputTokens: 10, CacheCreationInputTokens: 990, OutputTokens: 20, CostUSD: 0.01}),
+		message(3, &llm.Usage{}),
+		message(4, &llm.Usage{Model: "claude", InputTokens: 10, CacheReadInputTokens: 990, CacheCreationInputTokens: 100, OutputTokens: 30, CostUSD: 0.002}),
+		message(5, &llm.Usage{Model: "gpt-5", InputTokens: 500, CacheReadInputTokens: 500, OutputTokens: 5}),
+	}
+	report := newCacheReport("c1", messages)
+
+	if report.ConversationID != "c1" || len(report.Responses) != 3 {
+		t.Fatalf("report = %+v, want the 3 responses with usage", report)
+	}
+	total := report.CacheUsage
+	if total.InputTokens != 520 || total.CacheCreationInputTokens != 1090 || total.CacheReadInputTokens != 1490 || total.OutputTokens != 55 {
+		t.Errorf("totals = %+v", total)
+	}
+	if math.Abs(total.HitRate-1490.0/3100) > 1e-9 {
+		t.Errorf("hit rate = %v, want %v", total.HitRate, 1490.0/3100)
+	}
+	if len(report.Models) != 2 || report.Models[0].Model != "claude" || report.Models[0].ResponseCount != 2 || report.Models[1].Model != "gpt-5" {
+		t.Errorf("models = %+v, want claude and gpt-5", report.Models)
+	}
+	if first, second := report.Responses[0], report.Responses[1]; first.HitRate != 0 || first.SequenceID != 2 || math.Abs(second.HitRate-0.9) > 1e-9 {
+		t.Errorf("responses = %+v", report.Responses)
+	}
+}
+
+func TestCacheReportEndpoint(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+
+	h.NewConversation("echo: hello", "")
+	h.WaitResponse()
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/conversation/"+h.ConversationID()+"/cache", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var report CacheReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.ConversationID != h.ConversationID() || len(report.Responses) == 0 || report.ResponseCount != len(report.Responses) {
+		t.Errorf("report = %+v, want the conversation's responses", report)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/conversation/missing/cache", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("report of a missing conversation: status %d, want 404", rec.Code)
+	}
+}
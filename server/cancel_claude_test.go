@@ -54,9 +54,9 @@ func NewClaudeTestHarness(t *testing.T) *ClaudeTestHarness {
 	}
 
 	service := &ant.Service{
-		APIKey:       apiKey,
-		Model:        ant.Claude45Haiku, // Use cheaper model for testing
-		HTTPRecorder: h.recordHTTPRequest,
+		APIKey: apiKey,
+		Model:  ant.Claude45Haiku, // Use cheaper model for testing
+		HTTPC:  llm.RecordingClient(nil, h.recordHTTPRequest),
 	}
 	h.llmService = service
 
@@ -76,10 +76,10 @@ func NewClaudeTestHarness(t *testing.T) *ClaudeTestHarness {
 }
 
 // recordHTTPRequest is a callback to record HTTP requests for token tracking
-func (h *ClaudeTestHarness) recordHTTPRequest(url string, requestBody, responseBody []byte, statusCode int, err error, duration time.Duration) {
-	h.t.Logf("HTTP callback: status=%d, err=%v, responseLen=%d", statusCode, err, len(responseBody))
+func (h *ClaudeTestHarness) recordHTTPRequest(_ context.Context, ex llm.HTTPExchange) {
+	h.t.Logf("HTTP callback: status=%d, err=%v, responseLen=%d", ex.StatusCode, ex.Err, len(ex.ResponseBody))
 
-	if statusCode != http.StatusOK || responseBody == nil {
+	if ex.StatusCode != http.StatusOK || ex.ResponseBody == nil {
 		return
 	}
 
@@ -92,7 +92,7 @@ func (h *ClaudeTestHarness) recordHTTPRequest(url string, requestBody, responseB
 			CacheReadInputTokens     uint64 `json:"cache_read_input_tokens"`
 		} `json:"usage"`
 	}
-	if jsonErr := json.Unmarshal(responseBody, &resp); jsonErr == nil {
+	if jsonErr := json.Unmarshal(ex.ResponseBody, &resp); jsonErr == nil {
 		// Total tokens = input + cache_creation + cache_read (this represents total context)
 		totalTokens := resp.Usage.InputTokens + resp.Usage.CacheCreationInputTokens + resp.Usage.CacheReadInputTokens
 		h.mu.Lock()
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+)
+
+func TestCompactionReplacesHistoryAndKeepsMessages(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	// Any real response exceeds this threshold, so every turn with enough history compacts.
+	if err := h.server.SetCompaction(CompactionConfig{Threshold: 0.0001}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+	h.Chat("echo: two")
+	h.WaitResponse()
+	h.Chat("echo: three")
+	if got := h.WaitResponse(); got != "three" {
+		t.Fatalf("response = %q, want %q", got, "three")
+	}
+
+	last := h.llm.GetLastRequest()
+	if len(last.Messages) != 5 {
+		t.Fatalf("last request had %d messages, want summary plus 4 kept", len(last.Messages))
+	}
+	if !strings.HasPrefix(last.Messages[0].Content[0].Text, "[Summary of earlier conversation]") {
+		t.Errorf("first message in request is not a summary: %q", last.Messages[0].Content[0].Text)
+	}
+
+	var messages []generated.Message
+	if err := h.db.Queries(context.Background(), func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(context.Background(), h.ConversationID())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var compactions, users int
+	for _, msg := range messages {
+		switch msg.Type {
+		case string(db.MessageTypeCompaction):
+			compactions++
+			var userData CompactionUserData
+			if err := json.Unmarshal([]byte(*msg.UserData), &userData); err != nil {
+				t.Fatal(err)
+			}
+			if userData.SummarizedMessages != 1 {
+				t.Errorf("summarized_messages = %d, want 1", userData.SummarizedMessages)
+			}
+		case string(db.MessageTypeUser):
+			users++
+		}
+	}
+	if compactions != 1 {
+		t.Fatalf("got %d compaction messages, want 1", compactions)
+	}
+	if users != 3 {
+		t.Errorf("got %d user messages, want all 3 kept in the database", users)
+	}
+
+	// A freshly hydrated manager sees the same history the loop used.
+	cm := NewConversationManager(h.ConversationID(), h.db, nil, h.server.toolSetConfig, nil)
+	if err := cm.Hydrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.history) != 6 {
+		t.Fatalf("hydrated history has %d messages, want 6", len(cm.history))
+	}
+	if cm.history[0].Role != llm.MessageRoleUser || !strings.HasPrefix(cm.history[0].Content[0].Text, "[Summary of earlier conversation]") {
+		t.Errorf("hydrated history does not start with the summary: %+v", cm.history[0])
+	}
+	if cm.contextWindowUsed == 0 {
+		t.Error("hydrated context window usage should come from the last agent message")
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -17,7 +18,7 @@ import (
 	"shelley.exe.dev/subpub"
 )
 
-var errConversationModelMismatch = errors.New("conversation model mismatch")
+var errConversationBusy = errors.New("conversation busy")
 
 // ConversationManager manages a single active conversation
 type ConversationManager struct {
@@ -26,9 +27,10 @@ type ConversationManager struct {
 	loop           *loop.Loop
 	loopCancel     context.CancelFunc
 	loopCtx        context.Context
+	loopDone       chan struct{} // closed when the latest loop's goroutine returns
 	mu             sync.Mutex
 	lastActivity   time.Time
-	modelID        string
+	modelID        string // model of the running loop
 	history        []llm.Message
 	system         []llm.SystemContent
 	recordMessage  loop.MessageRecordFunc
@@ -36,11 +38,26 @@ type ConversationManager struct {
 	toolSetConfig  claudetool.ToolSetConfig
 	toolSet        *claudetool.ToolSet // created per-conversation when loop starts
 
-	subpub *subpub.SubPub[StreamResponse]
+	subpub    *subpub.SubPub[StreamResponse]
+	streamSeq int // deltas published for the response being generated
 
 	hydrated              bool
 	hasConversationEvents bool
 	cwd                   string // working directory for tools
+	savedModelID          string // model stored on the conversation, when hydrated
+	contextWindowUsed     uint64 // as of the last response in history, when hydrated
+
+	compactionThreshold float64     // zero disables compaction
+	compactionLLM       llm.Service // nil means the conversation's own model
+
+	defaultBudget loop.Budget // used when the conversation has no budget of its own
+	budget        loop.Budget // effective budget, when hydrated
+	usage         llm.Usage   // usage recorded before the loop started, when hydrated
+
+	// serverBudgetExceeded reports why all conversations together are over the server's budget, if they are
+	serverBudgetExceeded func() string
+
+	onGitStateChange func(ctx context.Context, state string) // called after a git state change is recorded, if set
 }
 
 // NewConversationManager constructs a manager with dependencies but defers hydration until needed.
@@ -77,10 +94,18 @@ func (cm *ConversationManager) Hydrate(ctx context.Context) error {
 		return fmt.Errorf("conversation not found: %w", err)
 	}
 
-	var messages []generated.Message
+	var (
+		messages  []generated.Message
+		usageData []*string
+	)
 	err = cm.db.Queries(ctx, func(q *generated.Queries) error {
 		var err error
 		messages, err = q.ListMessages(ctx, cm.conversationID)
+		if err != nil {
+			return err
+		}
+		// Messages hidden by an edit or rewind still count against the budget
+		usageData, err = q.ListMessageUsage(ctx, cm.conversationID)
 		return err
 	})
 	if err != nil {
@@ -105,13 +130,27 @@ func (cm *ConversationManager) Hydrate(ctx context.Context) error {
 		cwd = *conversation.Cwd
 	}
 
+	budget := cm.defaultBudget
+	if conversation.Budget != nil {
+		if err := json.Unmarshal([]byte(*conversation.Budget), &budget); err != nil {
+			return fmt.Errorf("invalid conversation budget: %w", err)
+		}
+	}
+
 	cm.mu.Lock()
 	cm.history = history
 	cm.system = system
+	cm.contextWindowUsed = calculateContextWindowSize(toAPIMessages(messages))
+	cm.budget = budget
+	cm.usage = sumUsage(usageData)
 	cm.hasConversationEvents = len(history) > 0
 	cm.lastActivity = time.Now()
 	cm.hydrated = true
 	cm.cwd = cwd
+	cm.savedModelID = ""
+	if conversation.Model != nil {
+		cm.savedModelID = *conversation.Model
+	}
 	cm.mu.Unlock()
 
 	cm.logSystemPromptState(system, len(messages))
@@ -131,7 +170,7 @@ func (cm *ConversationManager) AcceptUserMessage(ctx context.Context, service ll
 		return false, err
 	}
 
-	if err := cm.ensureLoop(service, modelID); err != nil {
+	if err := cm.ensureLoop(ctx, service, modelID); err != nil {
 		return false, err
 	}
 
@@ -161,6 +200,33 @@ func (cm *ConversationManager) AcceptUserMessage(ctx context.Context, service ll
 	return isFirst, nil
 }
 
+// ResumeTurn starts the loop and continues the unfinished turn at the end of the
+// history without waiting for a user message.
+func (cm *ConversationManager) ResumeTurn(ctx context.Context, service llm.Service, modelID string) error {
+	if service == nil {
+		return fmt.Errorf("llm service is required")
+	}
+
+	if err := cm.Hydrate(ctx); err != nil {
+		return err
+	}
+
+	if err := cm.ensureLoop(ctx, service, modelID); err != nil {
+		return err
+	}
+
+	cm.mu.Lock()
+	loopInstance := cm.loop
+	cm.lastActivity = time.Now()
+	cm.mu.Unlock()
+
+	if loopInstance == nil {
+		return fmt.Errorf("conversation loop not initialized")
+	}
+	loopInstance.Resume()
+	return nil
+}
+
 // Touch updates last activity timestamp.
 func (cm *ConversationManager) Touch() {
 	cm.mu.Lock()
@@ -218,8 +284,10 @@ func (cm *ConversationManager) partitionMessages(messages []generated.Message) (
 	var system []llm.SystemContent
 
 	for _, msg := range messages {
-		// Skip gitinfo messages - they are user-visible only, not sent to LLM
-		if msg.Type == string(db.MessageTypeGitInfo) {
+		// Skip gitinfo and model_change messages - they are user-visible only, not sent to LLM.
+		// Skip error messages too; the loop never adds failed requests to its history,
+		// and compaction counts summarized messages against that history.
+		if msg.Type == string(db.MessageTypeGitInfo) || msg.Type == string(db.MessageTypeModelChange) || msg.Type == string(db.MessageTypeError) {
 			continue
 		}
 
@@ -238,6 +306,17 @@ func (cm *ConversationManager) partitionMessages(messages []generated.Message) (
 			continue
 		}
 
+		if msg.Type == string(db.MessageTypeCompaction) {
+			var userData CompactionUserData
+			if msg.UserData == nil || json.Unmarshal([]byte(*msg.UserData), &userData) != nil {
+				cm.logger.Warn("Ignoring compaction message without user data", "messageID", msg.MessageID)
+				continue
+			}
+			n := min(userData.SummarizedMessages, len(history))
+			history = append([]llm.Message{llmMsg}, history[n:]...)
+			continue
+		}
+
 		history = append(history, llmMsg)
 	}
 
@@ -257,22 +336,49 @@ func (cm *ConversationManager) logSystemPromptState(system []llm.SystemContent,
 	cm.logger.Info("Loaded system prompt from database", "system_items", len(system), "total_length", length)
 }
 
-func (cm *ConversationManager) ensureLoop(service llm.Service, modelID string) error {
+// SavedModelID returns the model the conversation last used, or "" if none is recorded.
+func (cm *ConversationManager) SavedModelID() string {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.savedModelID
+}
+
+// ensureLoop starts a loop for modelID. If a loop for another model is running,
+// it is replaced, which requires the agent to be idle.
+func (cm *ConversationManager) ensureLoop(ctx context.Context, service llm.Service, modelID string) error {
 	cm.mu.Lock()
 	if cm.loop != nil {
 		existingModel := cm.modelID
 		cm.mu.Unlock()
-		if existingModel != "" && modelID != "" && existingModel != modelID {
-			return fmt.Errorf("%w: conversation already uses model %s; requested %s", errConversationModelMismatch, existingModel, modelID)
+		if modelID == "" || existingModel == modelID {
+			return nil
 		}
-		return nil
+		if err := cm.stopLoopForModelSwitch(ctx); err != nil {
+			return err
+		}
+		cm.mu.Lock()
 	}
 
+	previousModel := cm.savedModelID
 	history := append([]llm.Message(nil), cm.history...)
 	system := append([]llm.SystemContent(nil), cm.system...)
 	recordMessage := cm.recordMessage
 	logger := cm.logger
 	cwd := cm.cwd
+	contextWindowUsed := cm.contextWindowUsed
+	budget := cm.budget
+	usage := cm.usage
+	var compaction *loop.CompactionConfig
+	if cm.compactionThreshold > 0 {
+		compaction = &loop.CompactionConfig{
+			LLM:       cm.compactionLLM,
+			Threshold: cm.compactionThreshold,
+			Record:    cm.recordCompaction,
+		}
+		if compaction.LLM == nil {
+			compaction.LLM = service
+		}
+	}
 	toolSetConfig := cm.toolSetConfig
 	conversationID := cm.conversationID
 	db := cm.db
@@ -288,7 +394,17 @@ func (cm *ConversationManager) ensureLoop(service llm.Service, modelID string) e
 		}
 	}
 
-	processCtx, cancel := context.WithTimeout(context.Background(), 12*time.Hour)
+	if record := recordMessage; record != nil {
+		recordMessage = func(ctx context.Context, message llm.Message, usage llm.Usage) error {
+			// Once a message is recorded, the next delta starts a new response
+			cm.mu.Lock()
+			cm.streamSeq = 0
+			cm.mu.Unlock()
+			return record(ctx, message, usage)
+		}
+	}
+
+	processCtx, cancel := context.WithTimeout(llm.WithConversationID(context.Background(), conversationID), 12*time.Hour)
 	toolSet := claudetool.NewToolSet(processCtx, toolSetConfig)
 
 	loopInstance := loop.NewLoop(loop.Config{
@@ -303,29 +419,45 @@ func (cm *ConversationManager) ensureLoop(service llm.Service, modelID string) e
 		OnGitStateChange: func(ctx context.Context, state *gitstate.GitState) {
 			cm.recordGitStateChange(ctx, state)
 		},
+		OnStreamDelta:        cm.publishStreamDelta,
+		Compaction:           compaction,
+		ContextWindowUsed:    contextWindowUsed,
+		Budget:               budget,
+		Usage:                usage,
+		SharedBudgetExceeded: cm.serverBudgetExceeded,
 	})
 
 	cm.mu.Lock()
 	if cm.loop != nil {
+		// Another request started a loop concurrently; use it.
 		cm.mu.Unlock()
 		cancel()
 		toolSet.Cleanup()
-		existingModel := cm.modelID
-		if existingModel != "" && modelID != "" && existingModel != modelID {
-			return fmt.Errorf("%w: conversation already uses model %s; requested %s", errConversationModelMismatch, existingModel, modelID)
-		}
 		return nil
 	}
 	cm.loop = loopInstance
 	cm.loopCancel = cancel
 	cm.loopCtx = processCtx
+	loopDone := make(chan struct{})
+	cm.loopDone = loopDone
 	cm.modelID = modelID
+	cm.savedModelID = modelID
 	cm.toolSet = toolSet
 	cm.history = nil
 	cm.system = nil
 	cm.mu.Unlock()
 
+	if modelID != "" && modelID != previousModel {
+		if err := cm.db.UpdateConversationModel(ctx, conversationID, modelID); err != nil {
+			logger.Error("failed to persist conversation model", "error", err, "model", modelID)
+		}
+		if previousModel != "" {
+			cm.recordModelChange(ctx, previousModel, modelID)
+		}
+	}
+
 	go func() {
+		defer close(loopDone)
 		if err := loopInstance.Go(processCtx); err != nil && err != context.DeadlineExceeded && err != context.Canceled {
 			if logger != nil {
 				logger.Error("Conversation loop stopped", "error", err)
@@ -338,6 +470,34 @@ func (cm *ConversationManager) ensureLoop(service llm.Service, modelID string) e
 	return nil
 }
 
+// SetBudget replaces the conversation's budget, including in its running loop.
+func (cm *ConversationManager) SetBudget(budget loop.Budget) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.budget = budget
+	if cm.loop != nil {
+		cm.loop.SetBudget(budget)
+	}
+}
+
+// stopLoopForModelSwitch stops the running loop so that one for a different
+// model can be created from the history in the database.
+func (cm *ConversationManager) stopLoopForModelSwitch(ctx context.Context) error {
+	latest, err := cm.db.GetLatestMessage(ctx, cm.conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to get latest message: %w", err)
+	}
+	if !isEndOfTurn(latest) {
+		return fmt.Errorf("%w: wait for the agent to finish or cancel it before switching models", errConversationBusy)
+	}
+
+	cm.stopLoop()
+	cm.mu.Lock()
+	cm.hydrated = false
+	cm.mu.Unlock()
+	return cm.Hydrate(ctx)
+}
+
 func (cm *ConversationManager) stopLoop() {
 	cm.mu.Lock()
 	cancel := cm.loopCancel
@@ -357,6 +517,52 @@ func (cm *ConversationManager) stopLoop() {
 	}
 }
 
+// Rewind hides the message at sequenceID and every later message, so the next
+// user message continues from the history before it. If the agent is mid-turn,
+// the turn is cancelled first.
+func (cm *ConversationManager) Rewind(ctx context.Context, sequenceID int64) error {
+	cm.mu.Lock()
+	loopDone := cm.loopDone
+	cm.mu.Unlock()
+
+	latest, err := cm.db.GetLatestMessage(ctx, cm.conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to get latest message: %w", err)
+	}
+	if !isEndOfTurn(latest) {
+		if err := cm.CancelConversation(ctx); err != nil {
+			return err
+		}
+	}
+	cm.stopLoop()
+
+	// A loop that is still finishing could record messages after they are hidden
+	if loopDone != nil {
+		select {
+		case <-loopDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := cm.db.SoftDeleteMessagesFrom(ctx, cm.conversationID, sequenceID); err != nil {
+		return fmt.Errorf("failed to hide messages: %w", err)
+	}
+
+	// Reload history from the database on the next AcceptUserMessage
+	cm.mu.Lock()
+	cm.hydrated = false
+	cm.mu.Unlock()
+
+	// Tell open clients to drop the hidden messages
+	conversation, err := cm.db.GetConversationByID(ctx, cm.conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to get conversation: %w", err)
+	}
+	cm.subpub.Notify(StreamResponse{Conversation: *conversation, HiddenFrom: &sequenceID})
+	return nil
+}
+
 // CancelConversation cancels the current conversation loop and records a cancelled tool result if a tool was in progress
 func (cm *ConversationManager) CancelConversation(ctx context.Context) error {
 	cm.mu.Lock()
@@ -473,6 +679,7 @@ func (cm *ConversationManager) CancelConversation(ctx context.Context) error {
 		Role:      llm.MessageRoleAssistant,
 		Content:   []llm.Content{{Type: llm.ContentTypeText, Text: "[Operation cancelled]"}},
 		EndOfTurn: true,
+		TurnEnd:   llm.TurnCancelled,
 	}
 
 	if err := cm.recordMessage(ctx, endTurnMessage, llm.Usage{}); err != nil {
@@ -535,13 +742,112 @@ func (cm *ConversationManager) recordGitStateChange(ctx context.Context, state *
 	}
 
 	cm.logger.Debug("Recorded git state change", "state", state.String())
+	if cm.onGitStateChange != nil {
+		cm.onGitStateChange(ctx, state.String())
+	}
 
 	// Notify subscribers so the UI updates
-	go cm.notifyGitStateChange(context.WithoutCancel(ctx), createdMsg)
+	go cm.publishMessage(context.WithoutCancel(ctx), createdMsg)
+}
+
+// ModelChangeUserData is the structured data stored in user_data for model_change messages.
+type ModelChangeUserData struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// recordModelChange creates a model_change message when the conversation switches models.
+// This message is visible to users in the UI but is not sent to the LLM.
+func (cm *ConversationManager) recordModelChange(ctx context.Context, from, to string) {
+	text := fmt.Sprintf("Switched model from %s to %s", from, to)
+	createdMsg, err := cm.db.CreateMessage(ctx, db.CreateMessageParams{
+		ConversationID: cm.conversationID,
+		Type:           db.MessageTypeModelChange,
+		LLMData: llm.Message{
+			Role:    llm.MessageRoleAssistant,
+			Content: []llm.Content{{Type: llm.ContentTypeText, Text: text}},
+		},
+		UserData:  ModelChangeUserData{From: from, To: to},
+		UsageData: llm.Usage{},
+	})
+	if err != nil {
+		cm.logger.Error("Failed to record model change", "error", err)
+		return
+	}
+
+	cm.logger.Info("Switched conversation model", "from", from, "to", to)
+	go cm.publishMessage(context.WithoutCancel(ctx), createdMsg)
+}
+
+// CompactionUserData is the structured data stored in user_data for compaction messages.
+type CompactionUserData struct {
+	// SummarizedMessages is how many messages at the start of the LLM history the summary replaces.
+	SummarizedMessages int `json:"summarized_messages"`
+}
+
+// recordCompaction stores the summary that replaced older history in the loop.
+// The summarized messages are left in the database and stay visible in the UI.
+func (cm *ConversationManager) recordCompaction(ctx context.Context, summary llm.Message, summarized int, usage llm.Usage) error {
+	createdMsg, err := cm.db.CreateMessage(ctx, db.CreateMessageParams{
+		ConversationID: cm.conversationID,
+		Type:           db.MessageTypeCompaction,
+		LLMData:        summary,
+		UserData:       CompactionUserData{SummarizedMessages: summarized},
+		UsageData:      usage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record compaction: %w", err)
+	}
+
+	cm.logger.Info("Recorded compaction", "summarized_messages", summarized)
+
+	go cm.publishMessage(context.WithoutCancel(ctx), createdMsg)
+	return nil
+}
+
+// publishStreamDelta forwards partial agent output to subscribers.
+// Deltas are not persisted; the complete message is recorded once the response finishes.
+func (cm *ConversationManager) publishStreamDelta(ctx context.Context, delta llm.StreamDelta) {
+	var typ string
+	switch delta.Type {
+	case llm.ContentTypeText:
+		typ = "text"
+	case llm.ContentTypeThinking:
+		typ = "thinking"
+	case llm.ContentTypeToolUse:
+		typ = "tool_use"
+	default:
+		return
+	}
+	cm.mu.Lock()
+	cm.streamSeq++
+	seq := cm.streamSeq
+	cm.mu.Unlock()
+	cm.subpub.Broadcast(StreamResponse{
+		AgentWorking: true,
+		Delta: &StreamDelta{
+			Seq:      seq,
+			Index:    delta.Index,
+			Type:     typ,
+			Text:     delta.Text,
+			ToolName: delta.ToolName,
+		},
+	})
+}
+
+// contextWindowSize returns the running loop's count of the conversation's tokens, or 0 if no loop is running.
+func (cm *ConversationManager) contextWindowSize() uint64 {
+	cm.mu.Lock()
+	loopInstance := cm.loop
+	cm.mu.Unlock()
+	if loopInstance == nil {
+		return 0
+	}
+	return loopInstance.ContextWindowUsed()
 }
 
-// notifyGitStateChange publishes a gitinfo message to subscribers.
-func (cm *ConversationManager) notifyGitStateChange(ctx context.Context, msg *generated.Message) {
+// publishMessage publishes a message recorded by the manager itself to subscribers.
+func (cm *ConversationManager) publishMessage(ctx context.Context, msg *generated.Message) {
 	var conversation generated.Conversation
 	err := cm.db.Queries(ctx, func(q *generated.Queries) error {
 		var err error
@@ -549,7 +855,7 @@ func (cm *ConversationManager) notifyGitStateChange(ctx context.Context, msg *ge
 		return err
 	})
 	if err != nil {
-		cm.logger.Error("Failed to get conversation for git state notification", "error", err)
+		cm.logger.Error("Failed to get conversation for message notification", "error", err)
 		return
 	}
 
@@ -557,7 +863,7 @@ func (cm *ConversationManager) notifyGitStateChange(ctx context.Context, msg *ge
 	streamData := StreamResponse{
 		Messages:     apiMessages,
 		Conversation: conversation,
-		AgentWorking: false, // Gitinfo is recorded at end of turn, agent is done
+		AgentWorking: !isEndOfTurn(msg), // Gitinfo is recorded at end of turn; compaction happens mid-turn
 	}
 	cm.subpub.Publish(msg.SequenceID, streamData)
 }
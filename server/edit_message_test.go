@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+)
+
+// editMessage replaces the user message with the given text via the edit endpoint.
+func editMessage(t *testing.T, h *TestHarness, text, replacement string) {
+	t.Helper()
+	var messages []generated.Message
+	if err := h.db.Queries(context.Background(), func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(context.Background(), h.ConversationID())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	at := int64(-1)
+	for _, msg := range messages {
+		if msg.Type == "user" && msg.LlmData != nil && strings.Contains(*msg.LlmData, text) {
+			at = msg.SequenceID
+		}
+	}
+	if at < 0 {
+		t.Fatalf("no user message containing %q", text)
+	}
+
+	body, _ := json.Marshal(ChatRequest{Message: replacement, Model: "predictable"})
+	req := httptest.NewRequest("POST", "/api/conversation/"+h.ConversationID()+"/edit?at="+strconv.FormatInt(at, 10), strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	h.server.handleEditMessage(w, req, h.ConversationID())
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// visibleTexts returns the text of every visible user and agent message.
+func visibleTexts(t *testing.T, h *TestHarness) []string {
+	t.Helper()
+	var messages []generated.Message
+	if err := h.db.Queries(context.Background(), func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(context.Background(), h.ConversationID())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var texts []string
+	for _, msg := range messages {
+		if msg.LlmData == nil || (msg.Type != "user" && msg.Type != "agent") {
+			continue
+		}
+		var llmMsg llm.Message
+		if err := json.Unmarshal([]byte(*msg.LlmData), &llmMsg); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range llmMsg.Content {
+			if c.Type == llm.ContentTypeText {
+				texts = append(texts, c.Text)
+			}
+		}
+	}
+	return texts
+}
+
+func TestEditMessageReplacesLaterHistory(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+	h.Chat("echo: two")
+	h.WaitResponse()
+
+	editMessage(t, h, "echo: two", "echo: three")
+	h.responsesCount = 1
+	if got := h.WaitResponse(); got != "three" {
+		t.Fatalf("response = %q, want %q", got, "three")
+	}
+
+	want := []string{"echo: one", "one", "echo: three", "three"}
+	if got := visibleTexts(t, h); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("visible messages = %q, want %q", got, want)
+	}
+	for _, msg := range h.llm.GetLastRequest().Messages {
+		for _, c := range msg.Content {
+			if strings.Contains(c.Text, "two") {
+				t.Errorf("request after edit includes hidden message %q", c.Text)
+			}
+		}
+	}
+}
+
+func TestEditMessageCancelsRunningTurn(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+	h.Chat("delay: 30")
+
+	// The agent is still working on the slow request, so the edit cancels it first.
+	editMessage(t, h, "delay: 30", "echo: fast")
+
+	h.responsesCount = 1
+	if got := h.WaitResponse(); got != "fast" {
+		t.Fatalf("response = %q, want %q", got, "fast")
+	}
+	want := []string{"echo: one", "one", "echo: fast", "fast"}
+	if got := visibleTexts(t, h); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("visible messages = %q, want %q", got, want)
+	}
+}
+
+func TestEditMessageTellsSubscribersToDropHiddenMessages(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+	h.Chat("echo: two")
+	h.WaitResponse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	manager, err := h.server.getOrCreateConversationManager(ctx, h.ConversationID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []generated.Message
+	if err := h.db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(ctx, h.ConversationID())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var edited int64
+	for _, msg := range messages {
+		if msg.Type == "user" && msg.LlmData != nil && strings.Contains(*msg.LlmData, "echo: two") {
+			edited = msg.SequenceID
+		}
+	}
+	next := manager.subpub.Subscribe(ctx, messages[len(messages)-1].SequenceID)
+
+	editMessage(t, h, "echo: two", "echo: three")
+
+	// The update hiding "echo: two" comes before the message that replaces it
+	var hiddenFrom int64
+	for hiddenFrom == 0 {
+		update, ok := next()
+		if !ok {
+			t.Fatal("no update hiding the edited message")
+		}
+		for _, msg := range update.Messages {
+			if msg.LlmData != nil && strings.Contains(*msg.LlmData, "echo: three") {
+				t.Fatal("the replacement message came before the update hiding the edited one")
+			}
+		}
+		if update.HiddenFrom != nil {
+			hiddenFrom = *update.HiddenFrom
+		}
+	}
+	if hiddenFrom != edited {
+		t.Errorf("hidden from message %d, want the edited message %d", hiddenFrom, edited)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/db/generated"
+)
+
+func TestForkConversation(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	h.NewConversation("echo: first", "")
+	h.WaitResponse()
+	h.Chat("echo: second")
+	h.WaitResponse()
+
+	parentID := h.ConversationID()
+	if _, err := h.db.UpdateConversationSlug(context.Background(), parentID, "original"); err != nil {
+		t.Fatal(err)
+	}
+	var parentMessages []generated.Message
+	if err := h.db.Queries(context.Background(), func(q *generated.Queries) error {
+		var err error
+		parentMessages, err = q.ListMessages(context.Background(), parentID)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Fork after the first response, dropping the second exchange.
+	var at int64
+	for _, msg := range parentMessages {
+		if msg.Type == "agent" {
+			at = msg.SequenceID
+			break
+		}
+	}
+
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	fork := func(query, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/conversation/"+parentID+"/fork"+query, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := fork("?at="+strconv.FormatInt(at, 10), `{"cwd": "/tmp"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var forked generated.Conversation
+	if err := json.NewDecoder(rec.Body).Decode(&forked); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if forked.ParentConversationID == nil || *forked.ParentConversationID != parentID {
+		t.Errorf("Expected parent %s, got %v", parentID, forked.ParentConversationID)
+	}
+	if forked.Slug == nil || *forked.Slug != "original-fork" {
+		t.Errorf("Expected slug original-fork, got %v", forked.Slug)
+	}
+	if forked.Cwd == nil || *forked.Cwd != "/tmp" {
+		t.Errorf("Expected cwd /tmp, got %v", forked.Cwd)
+	}
+
+	// Continuing the fork sends only the history up to the fork point,
+	// which holds one response.
+	h.convID = forked.ConversationID
+	h.responsesCount = 1
+	h.Chat("echo: alternate")
+	if got := h.WaitResponse(); got != "alternate" {
+		t.Fatalf("response = %q, want %q", got, "alternate")
+	}
+	last := h.llm.GetLastRequest()
+	for _, msg := range last.Messages {
+		for _, c := range msg.Content {
+			if strings.Contains(c.Text, "second") {
+				t.Errorf("fork request includes a message after the fork point: %q", c.Text)
+			}
+		}
+	}
+
+	if rec := fork("?at=9999", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a missing message, got %d", rec.Code)
+	}
+	if rec := fork("", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without at, got %d", rec.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"cmp"
 	"compress/gzip"
 	"context"
 	"crypto/rand"
@@ -9,19 +10,23 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"shelley.exe.dev/claudetool/browse"
+	"shelley.exe.dev/db"
 	"shelley.exe.dev/db/generated"
 	"shelley.exe.dev/llm"
+	"shelley.exe.dev/loop"
 	"shelley.exe.dev/models"
 	"shelley.exe.dev/slug"
 	"shelley.exe.dev/ui"
@@ -188,6 +193,11 @@ func isConversationSlugPath(path string) bool {
 	return strings.HasPrefix(path, "/c/")
 }
 
+// isIndexPath reports whether staticHandler answers path with index.html and its initialization data.
+func isIndexPath(path string) bool {
+	return path == "/" || path == "/index.html" || isConversationSlugPath(path)
+}
+
 // acceptsGzip returns true if the client accepts gzip encoding
 func acceptsGzip(r *http.Request) bool {
 	return strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
@@ -228,7 +238,7 @@ func (s *Server) staticHandler(fsys http.FileSystem) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Inject initialization data into index.html
-		if r.URL.Path == "/" || r.URL.Path == "/index.html" || isConversationSlugPath(r.URL.Path) {
+		if isIndexPath(r.URL.Path) {
 			w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 			w.Header().Set("Pragma", "no-cache")
 			w.Header().Set("Expires", "0")
@@ -517,6 +527,44 @@ func (s *Server) handleConversations(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(conversations)
 }
 
+// maxSearchResults bounds the limit of GET /search, since each result carries a snippet
+const maxSearchResults = 200
+
+// handleSearch handles GET /search?q=<words>[&archived=true], searching the contents of messages.
+// Each result has a snippet of the message and its sequence ID, to jump to it in its conversation.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	limit := 50
+	offset := 0
+
+	// Parse query parameters
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = min(l, maxSearchResults)
+		}
+	}
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+	archived := r.URL.Query().Get("archived") == "true"
+
+	results, err := s.db.SearchMessages(ctx, r.URL.Query().Get("q"), archived, int64(limit), int64(offset))
+	if err != nil {
+		s.logger.Error("Failed to search messages", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
 // conversationMux returns a mux for /api/conversation/<id>/* routes
 func (s *Server) conversationMux() *http.ServeMux {
 	mux := http.NewServeMux()
@@ -530,10 +578,21 @@ func (s *Server) conversationMux() *http.ServeMux {
 	mux.HandleFunc("GET /{id}/stream", func(w http.ResponseWriter, r *http.Request) {
 		s.handleStreamConversation(w, r, r.PathValue("id"))
 	})
+	// GET /api/conversation/<id>/wait - blocks until the current turn ends
+	mux.Handle("GET /{id}/wait", gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handleWaitConversation(w, r, r.PathValue("id"))
+	})))
+	// GET /api/conversation/<id>/cache - how well prompt caching works in the conversation
+	mux.Handle("GET /{id}/cache", gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handleCacheReport(w, r, r.PathValue("id"))
+	})))
 	// POST endpoints - small responses, no compression needed
 	mux.HandleFunc("POST /{id}/chat", func(w http.ResponseWriter, r *http.Request) {
 		s.handleChatConversation(w, r, r.PathValue("id"))
 	})
+	mux.HandleFunc("POST /{id}/edit", func(w http.ResponseWriter, r *http.Request) {
+		s.handleEditMessage(w, r, r.PathValue("id"))
+	})
 	mux.HandleFunc("POST /{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
 		s.handleCancelConversation(w, r, r.PathValue("id"))
 	})
@@ -549,6 +608,12 @@ func (s *Server) conversationMux() *http.ServeMux {
 	mux.HandleFunc("POST /{id}/rename", func(w http.ResponseWriter, r *http.Request) {
 		s.handleRenameConversation(w, r, r.PathValue("id"))
 	})
+	mux.HandleFunc("POST /{id}/fork", func(w http.ResponseWriter, r *http.Request) {
+		s.handleForkConversation(w, r, r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /{id}/budget", func(w http.ResponseWriter, r *http.Request) {
+		s.handleSetConversationBudget(w, r, r.PathValue("id"))
+	})
 	return mux
 }
 
@@ -589,15 +654,97 @@ func (s *Server) handleGetConversation(w http.ResponseWriter, r *http.Request, c
 		Messages:          apiMessages,
 		Conversation:      conversation,
 		AgentWorking:      agentWorking(apiMessages),
-		ContextWindowSize: calculateContextWindowSize(apiMessages),
+		ContextWindowSize: s.contextWindowSize(conversationID, apiMessages),
 	})
 }
 
+// defaultWaitTimeout is how long GET /conversation/<id>/wait waits for a turn to end
+const defaultWaitTimeout = 10 * time.Minute
+
+// handleWaitConversation handles GET /conversation/<id>/wait?timeout=<duration>.
+// It waits until the conversation's turn ends and then responds like GET /conversation/<id>,
+// so a script can send a message and wait for the answer. It gives up with 504 after timeout.
+func (s *Server) handleWaitConversation(w http.ResponseWriter, r *http.Request, conversationID string) {
+	timeout := defaultWaitTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Query parameter timeout must be a positive duration such as 30s", http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+	if _, err := s.db.GetConversationByID(r.Context(), conversationID); err != nil {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if err := s.waitForEndOfTurn(ctx, conversationID); err != nil {
+		switch {
+		case r.Context().Err() != nil:
+			// The client went away
+		case errors.Is(err, context.DeadlineExceeded):
+			http.Error(w, fmt.Sprintf("Turn did not end within %s", timeout), http.StatusGatewayTimeout)
+		default:
+			s.logger.Error("Failed to wait for end of turn", "conversationID", conversationID, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	s.handleGetConversation(w, r, conversationID)
+}
+
 // ChatRequest represents a chat message from the user
 type ChatRequest struct {
 	Message string `json:"message"`
 	Model   string `json:"model,omitempty"`
 	Cwd     string `json:"cwd,omitempty"`
+	// Thinking sets how much the model reasons during the turn this message starts (optional)
+	Thinking *llm.Thinking `json:"thinking,omitempty"`
+	// Attachments are files uploaded through /api/upload to send along with the message (optional)
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// validate checks the request has something to send and a valid thinking level.
+func (req *ChatRequest) validate() error {
+	if req.Message == "" && len(req.Attachments) == 0 {
+		return errors.New("Message is required")
+	}
+	if req.Thinking != nil {
+		return req.Thinking.Validate()
+	}
+	return nil
+}
+
+// userMessage returns the LLM message for the request, with its attachments read
+// and prepared for svc.
+func (req *ChatRequest) userMessage(svc llm.Service) (llm.Message, error) {
+	msg := llm.Message{Role: llm.MessageRoleUser, Thinking: req.Thinking}
+	if req.Message != "" {
+		msg.Content = append(msg.Content, llm.Content{Type: llm.ContentTypeText, Text: req.Message})
+	}
+	for _, attachment := range req.Attachments {
+		content, err := attachment.content(svc.MaxImageDimension())
+		if err != nil {
+			return llm.Message{}, err
+		}
+		msg.Content = append(msg.Content, content)
+	}
+	return msg, nil
+}
+
+// slugText returns what to name a conversation started by the request after.
+func (req *ChatRequest) slugText() string {
+	if req.Message != "" {
+		return req.Message
+	}
+	var names []string
+	for _, attachment := range req.Attachments {
+		names = append(names, cmp.Or(attachment.Name, filepath.Base(attachment.Path)))
+	}
+	return strings.Join(names, ", ")
 }
 
 // handleChatConversation handles POST /conversation/<id>/chat
@@ -616,13 +763,24 @@ func (s *Server) handleChatConversation(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	if req.Message == "" {
-		http.Error(w, "Message is required", http.StatusBadRequest)
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Get LLM service for the requested model
+	// Get or create conversation manager
+	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
+	if err != nil {
+		s.logger.Error("Failed to get conversation manager", "conversationID", conversationID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Get LLM service for the requested model, or the one the conversation last used
 	modelID := req.Model
+	if modelID == "" {
+		modelID = manager.SavedModelID()
+	}
 	if modelID == "" {
 		modelID = s.defaultModel
 	}
@@ -634,30 +792,133 @@ func (s *Server) handleChatConversation(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	// Get or create conversation manager
-	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
+	userMessage, err := req.userMessage(llmService)
 	if err != nil {
-		if errors.Is(err, errConversationModelMismatch) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	firstMessage, err := manager.AcceptUserMessage(ctx, llmService, modelID, userMessage)
+	if err != nil {
+		if errors.Is(err, errConversationBusy) {
+			http.Error(w, err.Error(), http.StatusConflict)
 			return
 		}
+		s.logger.Error("Failed to accept user message", "conversationID", conversationID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.recordChatRequest(conversationID, req, modelID, 0)
+	if firstMessage {
+		s.generateSlug(ctx, conversationID, req.slugText(), modelID)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
+}
+
+// generateSlug names the conversation after its first message in the background.
+// The returned channel is closed once it is done.
+func (s *Server) generateSlug(ctx context.Context, conversationID, message, modelID string) <-chan struct{} {
+	ctxNoCancel := llm.WithConversationID(context.WithoutCancel(ctx), conversationID)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		slugCtx, cancel := context.WithTimeout(ctxNoCancel, 15*time.Second)
+		defer cancel()
+		_, err := slug.GenerateSlug(slugCtx, s.llmManager, s.db, s.logger, conversationID, message, modelID)
+		if err != nil {
+			s.logger.Warn("Failed to generate slug for conversation", "conversationID", conversationID, "error", err)
+		} else {
+			go s.notifySubscribers(ctxNoCancel, conversationID)
+		}
+	}()
+	return done
+}
+
+// handleEditMessage handles POST /conversation/<id>/edit?at=<sequence_id>.
+// It replaces the user message at sequence_id with the request's message: that message
+// and everything after it are hidden, and the new message starts a turn in their place.
+func (s *Server) handleEditMessage(w http.ResponseWriter, r *http.Request, conversationID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+
+	at, err := strconv.ParseInt(r.URL.Query().Get("at"), 10, 64)
+	if err != nil {
+		http.Error(w, "Query parameter at must be a message sequence ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var messages []generated.Message
+	err = s.db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(ctx, conversationID)
+		return err
+	})
+	if err != nil {
+		s.logger.Error("Failed to list messages", "conversationID", conversationID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	idx := slices.IndexFunc(messages, func(m generated.Message) bool { return m.SequenceID == at })
+	if idx < 0 || !isUserText(messages[idx]) {
+		http.Error(w, fmt.Sprintf("No user message at sequence %d", at), http.StatusBadRequest)
+		return
+	}
+
+	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
+	if err != nil {
 		s.logger.Error("Failed to get conversation manager", "conversationID", conversationID, "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Create user message
-	userMessage := llm.Message{
-		Role: llm.MessageRoleUser,
-		Content: []llm.Content{
-			{Type: llm.ContentTypeText, Text: req.Message},
-		},
+	modelID := req.Model
+	if modelID == "" {
+		modelID = manager.SavedModelID()
+	}
+	if modelID == "" {
+		modelID = s.defaultModel
 	}
 
-	firstMessage, err := manager.AcceptUserMessage(ctx, llmService, modelID, userMessage)
+	llmService, err := s.llmManager.GetService(modelID)
 	if err != nil {
-		if errors.Is(err, errConversationModelMismatch) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		s.logger.Error("Unsupported model requested", "model", modelID, "error", err)
+		http.Error(w, fmt.Sprintf("Unsupported model: %s", modelID), http.StatusBadRequest)
+		return
+	}
+
+	if err := manager.Rewind(ctx, at); err != nil {
+		s.logger.Error("Failed to rewind conversation", "conversationID", conversationID, "at", at, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	userMessage, err := req.userMessage(llmService)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := manager.AcceptUserMessage(ctx, llmService, modelID, userMessage); err != nil {
+		if errors.Is(err, errConversationBusy) {
+			http.Error(w, err.Error(), http.StatusConflict)
 			return
 		}
 		s.logger.Error("Failed to accept user message", "conversationID", conversationID, "error", err)
@@ -665,24 +926,31 @@ func (s *Server) handleChatConversation(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	if firstMessage {
-		ctxNoCancel := context.WithoutCancel(ctx)
-		go func() {
-			slugCtx, cancel := context.WithTimeout(ctxNoCancel, 15*time.Second)
-			defer cancel()
-			_, err := slug.GenerateSlug(slugCtx, s.llmManager, s.db, s.logger, conversationID, req.Message, modelID)
-			if err != nil {
-				s.logger.Warn("Failed to generate slug for conversation", "conversationID", conversationID, "error", err)
-			} else {
-				go s.notifySubscribers(ctxNoCancel, conversationID)
-			}
-		}()
+	// Record the edit by the message's position, as sequence IDs differ in a replay
+	edit := 0
+	for _, msg := range messages[:idx+1] {
+		if isUserText(msg) {
+			edit++
+		}
 	}
+	s.recordChatRequest(conversationID, req, modelID, edit)
 
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]string{"status": "accepted"})
 }
 
+// isUserText reports whether msg is a message the user typed, as opposed to a tool result.
+func isUserText(msg generated.Message) bool {
+	if msg.Type != string(db.MessageTypeUser) || msg.LlmData == nil {
+		return false
+	}
+	var llmMsg llm.Message
+	if err := json.Unmarshal([]byte(*msg.LlmData), &llmMsg); err != nil {
+		return false
+	}
+	return !slices.ContainsFunc(llmMsg.Content, func(c llm.Content) bool { return c.Type == llm.ContentTypeToolResult })
+}
+
 // handleNewConversation handles POST /api/conversations/new - creates conversation implicitly on first message
 func (s *Server) handleNewConversation(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -699,8 +967,8 @@ func (s *Server) handleNewConversation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Message == "" {
-		http.Error(w, "Message is required", http.StatusBadRequest)
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -734,27 +1002,21 @@ func (s *Server) handleNewConversation(w http.ResponseWriter, r *http.Request) {
 	// Get or create conversation manager
 	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
 	if err != nil {
-		if errors.Is(err, errConversationModelMismatch) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
 		s.logger.Error("Failed to get conversation manager", "conversationID", conversationID, "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Create user message
-	userMessage := llm.Message{
-		Role: llm.MessageRoleUser,
-		Content: []llm.Content{
-			{Type: llm.ContentTypeText, Text: req.Message},
-		},
+	userMessage, err := req.userMessage(llmService)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	firstMessage, err := manager.AcceptUserMessage(ctx, llmService, modelID, userMessage)
 	if err != nil {
-		if errors.Is(err, errConversationModelMismatch) {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if errors.Is(err, errConversationBusy) {
+			http.Error(w, err.Error(), http.StatusConflict)
 			return
 		}
 		s.logger.Error("Failed to accept user message", "conversationID", conversationID, "error", err)
@@ -762,18 +1024,9 @@ func (s *Server) handleNewConversation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.recordChatRequest(conversationID, req, modelID, 0)
 	if firstMessage {
-		ctxNoCancel := context.WithoutCancel(ctx)
-		go func() {
-			slugCtx, cancel := context.WithTimeout(ctxNoCancel, 15*time.Second)
-			defer cancel()
-			_, err := slug.GenerateSlug(slugCtx, s.llmManager, s.db, s.logger, conversationID, req.Message, modelID)
-			if err != nil {
-				s.logger.Warn("Failed to generate slug for conversation", "conversationID", conversationID, "error", err)
-			} else {
-				go s.notifySubscribers(ctxNoCancel, conversationID)
-			}
-		}()
+		s.generateSlug(ctx, conversationID, req.slugText(), modelID)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -856,7 +1109,7 @@ func (s *Server) handleStreamConversation(w http.ResponseWriter, r *http.Request
 		Messages:          apiMessages,
 		Conversation:      conversation,
 		AgentWorking:      agentWorking(apiMessages),
-		ContextWindowSize: calculateContextWindowSize(apiMessages),
+		ContextWindowSize: s.contextWindowSize(conversationID, apiMessages),
 	}
 	data, _ := json.Marshal(streamData)
 	fmt.Fprintf(w, "data: %s\n\n", data)
@@ -887,72 +1140,66 @@ func (s *Server) handleStreamConversation(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// handleDebugLLM serves recent LLM requests and responses for debugging
+// handleDebugLLM serves the recorded LLM requests and responses for debugging.
+// It lists the most recent requests, or a conversation's with ?conversation=ID, or the one
+// that produced a message with ?message=ID. ?id=N&type=request|response serves a request's
+// body, and ?id=N alone the whole record.
 func (s *Server) handleDebugLLM(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	ctx := r.Context()
+	query := r.URL.Query()
 
 	// Check if requesting a specific record JSON
-	if idx := r.URL.Query().Get("index"); idx != "" {
-		var i int
-		if _, err := fmt.Sscanf(idx, "%d", &i); err != nil {
-			http.Error(w, "Invalid index", http.StatusBadRequest)
+	if idStr := query.Get("id"); idStr != "" {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid id", http.StatusBadRequest)
 			return
 		}
-
-		type historyProvider interface {
-			GetHistory() *models.LLMRequestHistory
-		}
-
-		var records []models.LLMRequestRecord
-		if hp, ok := s.llmManager.(historyProvider); ok && hp.GetHistory() != nil {
-			records = hp.GetHistory().GetRecords()
-		}
-
-		if i < 0 || i >= len(records) {
-			http.Error(w, "Index out of range", http.StatusNotFound)
+		record, err := s.getLLMRequest(ctx, id)
+		if err != nil {
+			http.Error(w, "LLM request not found", http.StatusNotFound)
 			return
 		}
 
-		record := records[i]
-		recordType := r.URL.Query().Get("type")
-
-		switch recordType {
+		w.Header().Set("Content-Type", "application/json")
+		switch query.Get("type") {
 		case "request":
-			w.Header().Set("Content-Type", "application/json")
 			w.Write(record.HTTPRequest)
 		case "response":
-			w.Header().Set("Content-Type", "application/json")
 			w.Write(record.HTTPResponse)
 		default:
 			// Return the full record
-			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(record)
 		}
 		return
 	}
 
-	// Get history from the LLM manager if it's a models.Manager
-	type historyProvider interface {
-		GetHistory() *models.LLMRequestHistory
+	title := "Recent Requests"
+	messageID, conversationID := query.Get("message"), query.Get("conversation")
+	if messageID != "" {
+		title = "Request for Message " + messageID
+	} else if conversationID != "" {
+		title = "Requests for Conversation " + conversationID
 	}
-
-	var records []models.LLMRequestRecord
-	if hp, ok := s.llmManager.(historyProvider); ok && hp.GetHistory() != nil {
-		records = hp.GetHistory().GetRecords()
+	records, err := s.listLLMRequests(ctx, conversationID, messageID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list LLM requests: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 
 	// Write simple HTML with links to JSON
-	fmt.Fprint(w, `<!DOCTYPE html>
+	fmt.Fprintf(w, `<!DOCTYPE html>
 <html>
 <head>
 <meta charset="utf-8">
-<title>LLM Debug - Recent Requests</title>
+<title>LLM Debug - %s</title>
 <style>
 body {
 	font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
@@ -965,7 +1212,7 @@ h1 {
 }
 table {
 	border-collapse: collapse;
-	width: 100%;
+	width: 100%%;
 }
 th, td {
 	padding: 8px 12px;
@@ -995,17 +1242,15 @@ a:hover {
 </style>
 </head>
 <body>
-<h1>LLM Debug - Recent Requests</h1>
-`)
+<h1>LLM Debug - %s</h1>
+`, html.EscapeString(title), html.EscapeString(title))
 
 	if len(records) == 0 {
-		fmt.Fprint(w, "<p>No requests recorded yet.</p>")
+		fmt.Fprint(w, "<p>No requests recorded.</p>")
 	} else {
 		fmt.Fprint(w, "<table>")
-		fmt.Fprint(w, "<tr><th>#</th><th>Time</th><th>Model</th><th>URL</th><th>Status</th><th>Duration</th><th>Request</th><th>Response</th></tr>")
-		for i := len(records) - 1; i >= 0; i-- {
-			record := records[i]
-			num := len(records) - i
+		fmt.Fprint(w, "<tr><th>#</th><th>Time</th><th>Conversation</th><th>Model</th><th>URL</th><th>Attempt</th><th>Status</th><th>Duration</th><th>Request</th><th>Response</th></tr>")
+		for _, record := range records {
 			statusClass := "success"
 			statusText := fmt.Sprintf("%d", record.HTTPStatusCode)
 			if record.Error != "" {
@@ -1014,15 +1259,22 @@ a:hover {
 			} else if record.HTTPStatusCode >= 400 {
 				statusClass = "error"
 			}
+			conversation := ""
+			if record.ConversationID != "" {
+				id := html.EscapeString(record.ConversationID)
+				conversation = fmt.Sprintf("<a href=\"/debug/llm?conversation=%s\">%s</a>", url.QueryEscape(record.ConversationID), id)
+			}
 			fmt.Fprintf(w, "<tr>")
-			fmt.Fprintf(w, "<td>%d</td>", num)
-			fmt.Fprintf(w, "<td>%s</td>", record.Timestamp.Format("15:04:05"))
-			fmt.Fprintf(w, "<td>%s</td>", record.ModelID)
-			fmt.Fprintf(w, "<td>%s</td>", record.URL)
-			fmt.Fprintf(w, "<td class=\"%s\">%s</td>", statusClass, statusText)
+			fmt.Fprintf(w, "<td><a href=\"/debug/llm?id=%d\" target=\"_blank\">%d</a></td>", record.ID, record.ID)
+			fmt.Fprintf(w, "<td>%s</td>", record.Timestamp.Local().Format("2006-01-02 15:04:05"))
+			fmt.Fprintf(w, "<td>%s</td>", conversation)
+			fmt.Fprintf(w, "<td>%s</td>", html.EscapeString(record.ModelID))
+			fmt.Fprintf(w, "<td>%s</td>", html.EscapeString(record.URL))
+			fmt.Fprintf(w, "<td>%d</td>", record.Attempt)
+			fmt.Fprintf(w, "<td class=\"%s\">%s</td>", statusClass, html.EscapeString(statusText))
 			fmt.Fprintf(w, "<td>%.2fs</td>", record.Duration)
-			fmt.Fprintf(w, "<td><a href=\"/debug/llm?index=%d&type=request\" target=\"_blank\">json</a></td>", i)
-			fmt.Fprintf(w, "<td><a href=\"/debug/llm?index=%d&type=response\" target=\"_blank\">json</a></td>", i)
+			fmt.Fprintf(w, "<td><a href=\"/debug/llm?id=%d&type=request\" target=\"_blank\">json</a></td>", record.ID)
+			fmt.Fprintf(w, "<td><a href=\"/debug/llm?id=%d&type=response\" target=\"_blank\">json</a></td>", record.ID)
 			fmt.Fprintf(w, "</tr>")
 		}
 		fmt.Fprint(w, "</table>")
@@ -1211,3 +1463,122 @@ func (s *Server) handleRenameConversation(w http.ResponseWriter, r *http.Request
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(conversation)
 }
+
+// ForkRequest represents a request to fork a conversation
+type ForkRequest struct {
+	Cwd string `json:"cwd,omitempty"` // defaults to the parent's working directory
+}
+
+// handleForkConversation handles POST /conversation/<id>/fork?at=<sequence_id>.
+// It creates a conversation holding the messages up to and including sequence_id,
+// which the user can continue independently, with any model.
+func (s *Server) handleForkConversation(w http.ResponseWriter, r *http.Request, conversationID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+
+	at, err := strconv.ParseInt(r.URL.Query().Get("at"), 10, 64)
+	if err != nil {
+		http.Error(w, "Query parameter at must be a message sequence ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ForkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	var cwdPtr *string
+	if req.Cwd != "" {
+		cwdPtr = &req.Cwd
+	}
+
+	parent, err := s.db.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	conversation, err := s.db.ForkConversation(ctx, conversationID, at, cwdPtr)
+	if err != nil {
+		if errors.Is(err, db.ErrForkPointNotFound) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.logger.Error("Failed to fork conversation", "conversationID", conversationID, "at", at, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if parent.Slug != nil {
+		if newSlug, err := slug.SetUnique(ctx, s.db, s.logger, conversation.ConversationID, *parent.Slug+"-fork"); err != nil {
+			s.logger.Warn("Failed to set slug for forked conversation", "conversationID", conversation.ConversationID, "error", err)
+		} else {
+			conversation.Slug = &newSlug
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conversation)
+}
+
+// handleSetConversationBudget handles POST /conversation/<id>/budget.
+// The body is a loop.Budget, or null to fall back to the server's default budget.
+func (s *Server) handleSetConversationBudget(w http.ResponseWriter, r *http.Request, conversationID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+
+	var budget *loop.Budget
+	if err := json.NewDecoder(r.Body).Decode(&budget); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if budget != nil && (budget.MaxDollars < 0 || budget.MaxToolCallsPerTurn < 0) {
+		http.Error(w, "Budget limits must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	var stored *string
+	if budget != nil {
+		data, err := json.Marshal(budget)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		encoded := string(data)
+		stored = &encoded
+	}
+
+	conversation, err := s.db.UpdateConversationBudget(ctx, conversationID, stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to set conversation budget", "conversationID", conversationID, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	effective := s.defaultBudget
+	manager := s.activeConversations[conversationID]
+	s.mu.Unlock()
+	if budget != nil {
+		effective = *budget
+	}
+	if manager != nil {
+		manager.SetBudget(effective)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conversation)
+}
@@ -1,6 +1,13 @@
 package server
 
-import "log/slog"
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"shelley.exe.dev/loop"
+	"shelley.exe.dev/models"
+)
 
 // Link represents a custom link to be displayed in the UI
 type Link struct {
@@ -11,15 +18,26 @@ type Link struct {
 
 // LLMConfig holds all configuration for LLM services
 type LLMConfig struct {
-	// API keys for each provider
+	// API keys for each provider, taking precedence over Credentials
 	AnthropicAPIKey string
 	OpenAIAPIKey    string
 	GeminiAPIKey    string
 	FireworksAPIKey string
 
+	// Credentials says where to look up the other API keys (optional, defaults to the environment)
+	Credentials *models.CredentialsConfig
+
 	// Gateway is the base URL of the LLM gateway (optional)
 	Gateway string
 
+	// Models are user-defined models, e.g. local OpenAI-compatible servers (optional).
+	// An entry with the ID of a built-in model replaces it.
+	Models []models.ModelConfig
+
+	// Fallbacks maps a model ID to the models to try, in order, when its provider
+	// keeps failing with server errors, rate limits or timeouts (optional)
+	Fallbacks map[string][]string
+
 	// TerminalURL is the URL to the terminal interface (optional)
 	TerminalURL string
 
@@ -29,5 +47,51 @@ type LLMConfig struct {
 	// Links are custom links to be displayed in the UI (optional)
 	Links []Link
 
+	// Compaction controls summarizing long conversations (optional, disabled if Threshold is zero)
+	Compaction CompactionConfig
+
+	// Budget limits each conversation's cost, tokens, and tool calls per turn (optional).
+	// Conversations can override it through the API.
+	Budget loop.Budget
+
+	// ServerBudget limits the cost and tokens of all conversations together while the
+	// server runs (optional). Its MaxToolCallsPerTurn is not used.
+	ServerBudget loop.Budget
+
+	// InterruptedTurns says what to do at startup with turns a previous process
+	// left unfinished (optional, defaults to InterruptedTurnsMark).
+	InterruptedTurns InterruptedTurnPolicy
+
+	// RecordLLMRequests stores the raw body of every LLM request and response in the
+	// database for /debug/llm (optional); otherwise only the most recent are kept, in memory.
+	// Each body holds the whole conversation, so this takes a lot of space and keeps every
+	// prompt on disk.
+	RecordLLMRequests bool
+
+	// LLMRequestRetention is how long recorded LLM requests are kept. Zero keeps them forever.
+	LLMRequestRetention time.Duration
+
+	// Webhooks are sent conversation events (optional). More can be added through the API.
+	Webhooks []WebhookConfig
+
+	// AllowPrivateWebhooks lets webhooks added through the API target loopback, private
+	// and link-local addresses (optional).
+	AllowPrivateWebhooks bool
+
+	// HTTPClient sends all LLM requests, e.g. to record or replay them (optional)
+	HTTPClient *http.Client
+
+	// PredictableScript scripts the predictable model's responses (optional)
+	PredictableScript *loop.PredictableScript
+
 	Logger *slog.Logger
 }
+
+// CompactionConfig controls automatic summarization of older conversation history.
+type CompactionConfig struct {
+	// Threshold is the fraction of the model's context window above which older history is summarized.
+	// Zero disables compaction.
+	Threshold float64
+	// Model writes the summaries. If empty, each conversation's own model is used.
+	Model string
+}
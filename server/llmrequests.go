@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/models"
+)
+
+// llmRequestStore stores the LLM request history in the database
+type llmRequestStore struct {
+	db *db.DB
+}
+
+// NewLLMRequestStore returns a store for models.LLMRequestHistory that keeps
+// LLM requests in the database, where /debug/llm shows them.
+func NewLLMRequestStore(database *db.DB) models.LLMRequestStore {
+	return llmRequestStore{db: database}
+}
+
+func (s llmRequestStore) AddLLMRequest(ctx context.Context, record models.LLMRequestRecord) (int64, error) {
+	params := generated.CreateLLMRequestParams{
+		ModelID:         record.ModelID,
+		Url:             record.URL,
+		RequestBody:     record.HTTPRequest,
+		ResponseBody:    record.HTTPResponse,
+		StatusCode:      int64(record.HTTPStatusCode),
+		DurationSeconds: record.Duration,
+		Attempt:         int64(max(record.Attempt, 1)),
+	}
+	if record.ConversationID != "" {
+		params.ConversationID = &record.ConversationID
+	}
+	if record.Error != "" {
+		params.Error = &record.Error
+	}
+	return s.db.CreateLLMRequest(ctx, params)
+}
+
+// memoryLLMRequests returns the LLM request history when requests are only kept in
+// memory, or nil when they are stored in the database.
+func (s *Server) memoryLLMRequests() *models.LLMRequestHistory {
+	type historyProvider interface {
+		GetHistory() *models.LLMRequestHistory
+	}
+	if hp, ok := s.llmManager.(historyProvider); ok {
+		if history := hp.GetHistory(); history != nil && !history.Stored() {
+			return history
+		}
+	}
+	return nil
+}
+
+// getLLMRequest returns the recorded LLM request with the given ID.
+func (s *Server) getLLMRequest(ctx context.Context, id int64) (models.LLMRequestRecord, error) {
+	if history := s.memoryLLMRequests(); history != nil {
+		for _, record := range history.GetRecords() {
+			if record.ID == id {
+				return record, nil
+			}
+		}
+		return models.LLMRequestRecord{}, fmt.Errorf("LLM request %d not found", id)
+	}
+	request, err := s.db.GetLLMRequest(ctx, id)
+	if err != nil {
+		return models.LLMRequestRecord{}, err
+	}
+	return llmRequestRecord(request), nil
+}
+
+// listLLMRequests returns recorded LLM requests without their bodies, newest first:
+// the one that produced messageID if it is set, else conversationID's if it is set,
+// else the most recent.
+func (s *Server) listLLMRequests(ctx context.Context, conversationID, messageID string) ([]models.LLMRequestRecord, error) {
+	var records []models.LLMRequestRecord
+	if history := s.memoryLLMRequests(); history != nil {
+		// Requests kept in memory aren't linked to messages
+		if messageID != "" {
+			return nil, nil
+		}
+		for _, record := range slices.Backward(history.GetRecords()) {
+			if conversationID == "" || record.ConversationID == conversationID {
+				record.HTTPRequest, record.HTTPResponse = nil, nil
+				records = append(records, record)
+			}
+		}
+		return records, nil
+	}
+
+	if messageID != "" {
+		request, err := s.db.GetLLMRequestByMessage(ctx, messageID)
+		if err != nil {
+			// No request is linked to the message
+			return nil, nil
+		}
+		record := llmRequestRecord(request)
+		record.HTTPRequest, record.HTTPResponse = nil, nil
+		return append(records, record), nil
+	}
+	rows, err := s.db.ListLLMRequests(ctx, conversationID, 200)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		records = append(records, models.LLMRequestRecord{
+			ID:             row.RequestID,
+			ConversationID: derefString(row.ConversationID),
+			MessageID:      derefString(row.MessageID),
+			Timestamp:      row.CreatedAt,
+			ModelID:        row.ModelID,
+			URL:            row.Url,
+			HTTPStatusCode: int(row.StatusCode),
+			Error:          derefString(row.Error),
+			Duration:       row.DurationSeconds,
+			Attempt:        int(row.Attempt),
+		})
+	}
+	return records, nil
+}
+
+// llmRequestRecord converts a recorded LLM request from the database
+func llmRequestRecord(request *generated.LlmRequest) models.LLMRequestRecord {
+	return models.LLMRequestRecord{
+		ID:             request.RequestID,
+		ConversationID: derefString(request.ConversationID),
+		MessageID:      derefString(request.MessageID),
+		Timestamp:      request.CreatedAt,
+		ModelID:        request.ModelID,
+		URL:            request.Url,
+		HTTPRequest:    request.RequestBody,
+		HTTPResponse:   request.ResponseBody,
+		HTTPStatusCode: int(request.StatusCode),
+		Error:          derefString(request.Error),
+		Duration:       request.DurationSeconds,
+		Attempt:        int(request.Attempt),
+	}
+}
+
+// DefaultLLMRequestRetention is how long recorded LLM requests are kept unless shelley.json says otherwise.
+const DefaultLLMRequestRetention = 7 * 24 * time.Hour
+
+// SetLLMRequestRetention makes the server delete recorded LLM requests older than retention.
+// Without it, they are kept forever.
+func (s *Server) SetLLMRequestRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.llmRequestRetention = retention
+}
+
+// PruneLLMRequests deletes the recorded LLM requests that are past their retention.
+func (s *Server) PruneLLMRequests(ctx context.Context) {
+	s.mu.Lock()
+	retention := s.llmRequestRetention
+	s.mu.Unlock()
+	if retention <= 0 {
+		return
+	}
+	deleted, err := s.db.DeleteLLMRequestsBefore(ctx, time.Now().Add(-retention))
+	if err != nil {
+		s.logger.Warn("Failed to prune LLM requests", "error", err)
+		return
+	}
+	if deleted > 0 {
+		s.logger.Info("Pruned LLM requests", "count", deleted, "retention", retention)
+	}
+}
+
+// derefString returns *p, or "" if p is nil
+func derefString(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/models"
+)
+
+// historyRecordingService records each request in a history, as providers do with their HTTP exchanges
+type historyRecordingService struct {
+	llm.Service
+	history *models.LLMRequestHistory
+}
+
+func (s *historyRecordingService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	resp, err := s.Service.Do(ctx, req)
+	reqBody, _ := json.Marshal(req)
+	respBody, _ := json.Marshal(resp)
+	if err := s.history.Add(ctx, models.LLMRequestRecord{
+		ModelID:        "predictable",
+		URL:            "http://predictable.test/v1/messages",
+		HTTPRequest:    reqBody,
+		HTTPResponse:   respBody,
+		HTTPStatusCode: http.StatusOK,
+	}); err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+func TestLLMRequestsLinkedToMessages(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	history := models.NewLLMRequestHistory(10, NewLLMRequestStore(h.db))
+	h.server.llmManager = &testLLMManager{service: &historyRecordingService{Service: h.llm, history: history}}
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	get := func(url string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", url, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	h.NewConversation("echo: hello", "")
+	h.WaitResponse()
+
+	ctx := context.Background()
+	agentMessages, err := h.db.ListMessagesByType(ctx, h.ConversationID(), db.MessageTypeAgent)
+	if err != nil || len(agentMessages) == 0 {
+		t.Fatalf("ListMessagesByType: %d messages, %v", len(agentMessages), err)
+	}
+	messageID := agentMessages[len(agentMessages)-1].MessageID
+	request, err := h.db.GetLLMRequestByMessage(ctx, messageID)
+	if err != nil {
+		t.Fatalf("no LLM request linked to the agent message: %v", err)
+	}
+	if request.ConversationID == nil || *request.ConversationID != h.ConversationID() {
+		t.Errorf("request conversation = %v, want %s", request.ConversationID, h.ConversationID())
+	}
+	if !strings.Contains(string(request.RequestBody), "echo: hello") {
+		t.Errorf("linked request body = %s, want the user's message", request.RequestBody)
+	}
+
+	if body := get("/debug/llm?message=" + messageID); !strings.Contains(body, fmt.Sprintf("/debug/llm?id=%d&type=request", request.RequestID)) {
+		t.Errorf("message page does not link to request %d:\n%s", request.RequestID, body)
+	}
+	if body := get("/debug/llm?conversation=" + h.ConversationID()); !strings.Contains(body, fmt.Sprintf("/debug/llm?id=%d", request.RequestID)) {
+		t.Errorf("conversation page does not list request %d:\n%s", request.RequestID, body)
+	}
+	if body := get(fmt.Sprintf("/debug/llm?id=%d&type=request", request.RequestID)); body != string(request.RequestBody) {
+		t.Errorf("request body = %s, want %s", body, request.RequestBody)
+	}
+	var record models.LLMRequestRecord
+	if err := json.Unmarshal([]byte(get(fmt.Sprintf("/debug/llm?id=%d", request.RequestID))), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.MessageID != messageID || record.ConversationID != h.ConversationID() {
+		t.Errorf("record = %+v, want message %s in conversation %s", record, messageID, h.ConversationID())
+	}
+
+	// Requests past their retention are pruned
+	h.server.SetLLMRequestRetention(time.Nanosecond)
+	h.server.PruneLLMRequests(ctx)
+	if _, err := h.db.GetLLMRequest(ctx, request.RequestID); err == nil {
+		t.Error("LLM request was not pruned")
+	}
+}
+
+// historyLLMManager is a testLLMManager with a request history, as models.Manager has
+type historyLLMManager struct {
+	testLLMManager
+	history *models.LLMRequestHistory
+}
+
+func (m *historyLLMManager) GetHistory() *models.LLMRequestHistory {
+	return m.history
+}
+
+func TestDebugLLMShowsRequestsInMemory(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	history := models.NewLLMRequestHistory(10, nil)
+	h.server.llmManager = &historyLLMManager{
+		testLLMManager: testLLMManager{service: &historyRecordingService{Service: h.llm, history: history}},
+		history:        history,
+	}
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	get := func(url string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", url, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	h.NewConversation("echo: hello", "")
+	h.WaitResponse()
+
+	records := history.GetRecords()
+	if len(records) == 0 {
+		t.Fatal("no LLM request kept in memory")
+	}
+	record := records[len(records)-1]
+	if body := get("/debug/llm?conversation=" + h.ConversationID()); !strings.Contains(body, fmt.Sprintf("/debug/llm?id=%d&type=request", record.ID)) {
+		t.Errorf("conversation page does not list request %d:\n%s", record.ID, body)
+	}
+	if body := get(fmt.Sprintf("/debug/llm?id=%d&type=request", record.ID)); !strings.Contains(body, "echo: hello") {
+		t.Errorf("request body = %s, want the user's message", body)
+	}
+	// Nothing was stored in the database
+	if requests, err := h.db.ListLLMRequests(context.Background(), "", 10); err != nil || len(requests) != 0 {
+		t.Errorf("ListLLMRequests() = %+v, %v; want no stored requests", requests, err)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenCookieMaxAge is how long a browser keeps the API token it logged in with.
+const tokenCookieMaxAge = 30 * 24 * time.Hour
+
+// handleLogin lets a browser use the web UI when API tokens are required. GET serves a page that
+// asks for a token; it POSTs the token back as "Authorization: Bearer", which TokenAuthMiddleware
+// checks, and is answered with the token as an HttpOnly cookie.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(loginPage))
+	case http.MethodPost:
+		if apiTokenFrom(r.Context()) == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "API token required", http.StatusUnauthorized)
+			return
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		http.SetCookie(w, &http.Cookie{
+			Name:     tokenCookieName,
+			Value:    token,
+			Path:     "/",
+			MaxAge:   int(tokenCookieMaxAge.Seconds()),
+			Secure:   r.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+const loginPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Shelley - Log in</title>
+<style>
+body {
+	font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif;
+	margin: 20px;
+	background: #ffffff;
+	color: #000000;
+}
+input {
+	width: 24em;
+	max-width: 100%;
+	padding: 6px;
+}
+.error {
+	color: #d32f2f;
+}
+</style>
+</head>
+<body>
+<h1>Shelley</h1>
+<form id="login">
+<p>This server requires an API token. Create one with <code>shelley token create</code>.</p>
+<p><input type="password" name="token" placeholder="shelley_..." autocomplete="off" autofocus required></p>
+<p><button type="submit">Log in</button></p>
+<p id="error" class="error"></p>
+</form>
+<script>
+document.getElementById("login").addEventListener("submit", async (event) => {
+	event.preventDefault();
+	const token = event.target.token.value.trim();
+	const res = await fetch("/login", { method: "POST", headers: { Authorization: "Bearer " + token } });
+	if (!res.ok) {
+		document.getElementById("error").textContent = res.status === 401 ? "Invalid or revoked token." : "Login failed: " + res.status;
+		return;
+	}
+	// Only go back to pages of this server
+	const next = new URLSearchParams(location.search).get("next");
+	location.replace(next && next.startsWith("/") && !next.startsWith("//") && !next.startsWith("/\\") ? next : "/");
+});
+</script>
+</body>
+</html>
+`
@@ -2,12 +2,18 @@ package server
 
 import (
 	"compress/gzip"
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 
 	sloghttp "github.com/samber/slog-http"
+
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/db/generated"
 )
 
 // LoggerMiddleware adds request logging using slog-http
@@ -28,8 +34,9 @@ func LoggerMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
 func CSRFMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Only check state-changing methods
-			if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodDelete {
+			// Only check state-changing methods. Browsers don't send API tokens on their own,
+			// so requests authenticated with one can't be forged.
+			if apiTokenFrom(r.Context()) == nil && (r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodDelete) {
 				// Require X-Shelley-Request header (value doesn't matter, just presence)
 				if r.Header.Get("X-Shelley-Request") == "" {
 					http.Error(w, "CSRF protection: X-Shelley-Request header required", http.StatusForbidden)
@@ -46,8 +53,8 @@ func CSRFMiddleware() func(http.Handler) http.Handler {
 func RequireHeaderMiddleware(headerName string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Only check API routes
-			if strings.HasPrefix(r.URL.Path, "/api/") {
+			// Only check API routes, and let requests with an API token through
+			if strings.HasPrefix(r.URL.Path, "/api/") && apiTokenFrom(r.Context()) == nil {
 				if r.Header.Get(headerName) == "" {
 					http.Error(w, "missing required header: "+headerName, http.StatusForbidden)
 					return
@@ -58,6 +65,82 @@ func RequireHeaderMiddleware(headerName string) func(http.Handler) http.Handler
 	}
 }
 
+// servesPublicPage returns a function reporting whether mux routes a request to the login page or
+// to the web UI's static assets, which hold no conversation data. Everything else is not public:
+// index.html carries initialization data, and /debug/ holds recorded LLM requests.
+func servesPublicPage(mux *http.ServeMux) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		_, pattern := mux.Handler(r)
+		return pattern == "/login" || (pattern == "/" && !isIndexPath(r.URL.Path))
+	}
+}
+
+type apiTokenKey struct{}
+
+// apiTokenFrom returns the API token the request was authenticated with, if any.
+func apiTokenFrom(ctx context.Context) *generated.ApiToken {
+	token, _ := ctx.Value(apiTokenKey{}).(*generated.ApiToken)
+	return token
+}
+
+// tokenCookieName is the cookie /login keeps an API token in for the web UI, whose requests carry
+// no Authorization header.
+const tokenCookieName = "shelley_token"
+
+// TokenAuthMiddleware authenticates requests that carry an API token as "Authorization: Bearer shelley_...",
+// rejecting unknown and revoked tokens. Authenticated requests need neither the CSRF header nor the
+// header of RequireHeaderMiddleware. Browsers send a token set by /login as a cookie, so requests
+// authenticated that way still need both headers.
+//
+// If required, requests without a valid token are rejected unless public reports that they are for
+// something anyone may see, such as the web UI's static assets. Browsers loading a page are sent
+// to /login instead.
+func TokenAuthMiddleware(database *db.DB, required bool, public func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Bearer tokens of other kinds may be meant for a proxy in front of Shelley
+			bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			bearer = strings.TrimSpace(bearer)
+			if ok && strings.HasPrefix(bearer, db.APITokenPrefix) {
+				token, err := database.AuthenticateAPIToken(r.Context(), bearer)
+				if errors.Is(err, db.ErrAPITokenNotFound) {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(w, "invalid API token", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, token)))
+				return
+			}
+			if cookie, err := r.Cookie(tokenCookieName); err == nil && strings.HasPrefix(cookie.Value, db.APITokenPrefix) {
+				_, err := database.AuthenticateAPIToken(r.Context(), cookie.Value)
+				if err == nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				// The cookie of a revoked token counts as no token
+				if !errors.Is(err, db.ErrAPITokenNotFound) {
+					http.Error(w, "Internal server error", http.StatusInternalServerError)
+					return
+				}
+			}
+			if required && (public == nil || !public(r)) {
+				if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+					http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "API token required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // gzipResponseWriter wraps http.ResponseWriter to compress responses
 type gzipResponseWriter struct {
 	http.ResponseWriter
@@ -3,10 +3,15 @@ package server
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/db/generated"
 )
 
 func TestCSRFMiddleware_BlocksPostWithoutHeader(t *testing.T) {
@@ -199,3 +204,156 @@ func TestGzipHandler_SkipsWhenNoAcceptEncoding(t *testing.T) {
 		t.Errorf("body doesn't contain expected content: %s", w.Body.String())
 	}
 }
+
+func TestTokenAuthMiddleware(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	token, record, err := database.CreateAPIToken(context.Background(), "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedRecord, _ := database.CreateAPIToken(context.Background(), "old")
+	database.RevokeAPIToken(context.Background(), revokedRecord.TokenID)
+
+	for _, tc := range []struct {
+		name          string
+		required      bool
+		authorization string
+		cookie        string
+		headers       map[string]string
+		want          int
+	}{
+		{"token skips CSRF and proxy header", false, "Bearer " + token, "", nil, http.StatusOK},
+		{"no token still needs CSRF header", false, "", "", nil, http.StatusForbidden},
+		{"browser request", false, "", "", map[string]string{"X-Shelley-Request": "1", "X-Exedev-Userid": "u"}, http.StatusOK},
+		{"revoked token", false, "Bearer " + revoked, "", nil, http.StatusUnauthorized},
+		{"unknown token", false, "Bearer " + db.APITokenPrefix + "nope", "", nil, http.StatusUnauthorized},
+		{"proxy's own bearer token", false, "Bearer proxy-token", "", map[string]string{"X-Shelley-Request": "1", "X-Exedev-Userid": "u"}, http.StatusOK},
+		{"token required", true, "", "", map[string]string{"X-Shelley-Request": "1", "X-Exedev-Userid": "u"}, http.StatusUnauthorized},
+		{"token required and given", true, "Bearer " + token, "", nil, http.StatusOK},
+		{"login cookie still needs CSRF header", true, "", token, nil, http.StatusForbidden},
+		{"login cookie", true, "", token, map[string]string{"X-Shelley-Request": "1", "X-Exedev-Userid": "u"}, http.StatusOK},
+		{"revoked login cookie", true, "", revoked, map[string]string{"X-Shelley-Request": "1", "X-Exedev-Userid": "u"}, http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotToken *generated.ApiToken
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotToken = apiTokenFrom(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+			handler = CSRFMiddleware()(handler)
+			handler = RequireHeaderMiddleware("X-Exedev-Userid")(handler)
+			handler = TokenAuthMiddleware(database, tc.required, nil)(handler)
+
+			req := httptest.NewRequest("POST", "/api/conversations/new", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: tokenCookieName, Value: tc.cookie})
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", w.Code, tc.want, w.Body.String())
+			}
+			if tc.authorization == "Bearer "+token && (gotToken == nil || gotToken.TokenID != record.TokenID) {
+				t.Errorf("handler saw token %+v, want %d", gotToken, record.TokenID)
+			}
+		})
+	}
+}
+
+func TestTokenAuthMiddlewareRoutes(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+	token, _, err := h.db.CreateAPIToken(context.Background(), "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	handler := TokenAuthMiddleware(h.db, true, servesPublicPage(mux))(mux)
+
+	// Recorded LLM requests hold whole conversations, so /debug/ needs a token as much as /api/ does
+	for _, path := range []string{"/debug/llm", "/api/conversations", "/version"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without a token: status %d, want 401", path, w.Code)
+		}
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s with a token: status %d, want 200", path, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/assets/app.js", nil))
+	if w.Code == http.StatusUnauthorized {
+		t.Error("static asset needs a token")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+	token, _, err := h.db.CreateAPIToken(context.Background(), "browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	handler := CSRFMiddleware()(mux)
+	handler = TokenAuthMiddleware(h.db, true, servesPublicPage(mux))(handler)
+
+	// Browsers opening the UI without a token are sent to the login page, which anyone may load
+	req := httptest.NewRequest("GET", "/c/some-slug", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login?next=%2Fc%2Fsome-slug" {
+		t.Fatalf("GET /c/some-slug: status %d, Location %q; want a redirect to /login", w.Code, w.Header().Get("Location"))
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<form") {
+		t.Fatalf("GET /login: status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/login", nil)
+	req.Header.Set("Authorization", "Bearer "+db.APITokenPrefix+"nope")
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("login with an unknown token: status %d, cookies %v", w.Code, w.Result().Cookies())
+	}
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/login", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != tokenCookieName || cookies[0].Value != token || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("login set cookies %+v", cookies)
+	}
+
+	// The UI's own requests then carry the cookie, and the CSRF header as always
+	req = httptest.NewRequest("GET", "/api/conversations", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /api/conversations with the login cookie: status %d", w.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"shelley.exe.dev/claudetool"
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/loop"
+)
+
+// multiModelLLMManager is an LLMProvider that serves a separate service per model ID.
+type multiModelLLMManager struct {
+	services map[string]llm.Service
+}
+
+func (m *multiModelLLMManager) GetService(modelID string) (llm.Service, error) {
+	service, ok := m.services[modelID]
+	if !ok {
+		return nil, fmt.Errorf("unsupported model: %s", modelID)
+	}
+	return service, nil
+}
+
+func (m *multiModelLLMManager) GetAvailableModels() []string {
+	var ids []string
+	for id := range m.services {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (m *multiModelLLMManager) HasModel(modelID string) bool {
+	_, ok := m.services[modelID]
+	return ok
+}
+
+func TestSwitchModelMidConversation(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	first, second := loop.NewPredictableService(), loop.NewPredictableService()
+	llmManager := &multiModelLLMManager{services: map[string]llm.Service{"predictable": first, "second": second}}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	server := NewServer(database, llmManager, claudetool.ToolSetConfig{}, logger, true, "", "predictable", "", nil)
+	h := &TestHarness{t: t, db: database, server: server, cleanup: func() {}, llm: first, timeout: 5 * time.Second}
+
+	chat := func(model, message string) int {
+		t.Helper()
+		body, _ := json.Marshal(ChatRequest{Message: message, Model: model})
+		req := httptest.NewRequest("POST", "/api/conversation/"+h.ConversationID()+"/chat", strings.NewReader(string(body)))
+		w := httptest.NewRecorder()
+		server.handleChatConversation(w, req, h.ConversationID())
+		return w.Code
+	}
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+
+	if code := chat("second", "echo: two"); code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", code)
+	}
+	if got := h.WaitResponse(); got != "two" {
+		t.Fatalf("response = %q, want %q", got, "two")
+	}
+	if second.GetLastRequest() == nil {
+		t.Fatal("the second model was not called after switching")
+	}
+
+	conv, err := database.GetConversationByID(context.Background(), h.ConversationID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Model == nil || *conv.Model != "second" {
+		t.Errorf("conversation model = %v, want %q", conv.Model, "second")
+	}
+
+	var messages []generated.Message
+	if err := database.Queries(context.Background(), func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(context.Background(), h.ConversationID())
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var change *ModelChangeUserData
+	for _, msg := range messages {
+		if msg.Type == string(db.MessageTypeModelChange) && msg.UserData != nil {
+			change = &ModelChangeUserData{}
+			if err := json.Unmarshal([]byte(*msg.UserData), change); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if change == nil || change.From != "predictable" || change.To != "second" {
+		t.Fatalf("model change = %+v, want predictable to second", change)
+	}
+
+	// The new model saw the earlier history, and the model change marker was not sent to it.
+	req := second.GetLastRequest()
+	if len(req.Messages) < 3 {
+		t.Fatalf("second model got %d messages, want the earlier history too", len(req.Messages))
+	}
+	for _, msg := range req.Messages {
+		for _, c := range msg.Content {
+			if strings.Contains(c.Text, "Switched model") {
+				t.Errorf("model change marker was sent to the LLM: %q", c.Text)
+			}
+		}
+	}
+
+	// Without an explicit model, the conversation keeps using the one it switched to.
+	first.ClearRequests()
+	second.ClearRequests()
+	if code := chat("", "echo: three"); code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", code)
+	}
+	h.WaitResponse()
+	if first.GetLastRequest() != nil || second.GetLastRequest() == nil {
+		t.Error("a chat without a model did not use the conversation's saved model")
+	}
+}
+
+func TestSwitchModelDuringTurnIsRejected(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	first, second := loop.NewPredictableService(), loop.NewPredictableService()
+	llmManager := &multiModelLLMManager{services: map[string]llm.Service{"predictable": first, "second": second}}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	server := NewServer(database, llmManager, claudetool.ToolSetConfig{}, logger, true, "", "predictable", "", nil)
+	h := &TestHarness{t: t, db: database, server: server, cleanup: func() {}, llm: first, timeout: 5 * time.Second}
+
+	h.NewConversation("delay: 30", "")
+
+	body, _ := json.Marshal(ChatRequest{Message: "echo: two", Model: "second"})
+	req := httptest.NewRequest("POST", "/api/conversation/"+h.ConversationID()+"/chat", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	server.handleChatConversation(w, req, h.ConversationID())
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected status 409, got %d: %s", w.Code, w.Body.String())
+	}
+
+	manager, err := server.getOrCreateConversationManager(context.Background(), h.ConversationID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.CancelConversation(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if second.GetLastRequest() != nil {
+		t.Error("the second model was called during another model's turn")
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+)
+
+// InterruptedTurnPolicy says what happens at startup to turns that a previous
+// server process left unfinished, for example because it was restarted mid-turn.
+type InterruptedTurnPolicy string
+
+const (
+	// InterruptedTurnsMark ends each unfinished turn with a visible message.
+	// The user continues the conversation by sending another message.
+	InterruptedTurnsMark InterruptedTurnPolicy = "mark"
+	// InterruptedTurnsResume continues each unfinished turn with the conversation's model.
+	InterruptedTurnsResume InterruptedTurnPolicy = "resume"
+)
+
+const (
+	interruptedToolResultText = "Tool execution interrupted by a server restart; retry possible"
+	interruptedTurnText       = "[Interrupted: the server restarted before this turn finished. Send a message to continue.]"
+)
+
+// RecoverInterruptedTurns finds conversations whose last message does not end a turn
+// and, depending on policy, resumes them or marks them as interrupted.
+// It is meant to be called once at startup, before any conversation is active. Turns still
+// being run by another process, as recorded in turn_owner_pid, are left alone.
+func (s *Server) RecoverInterruptedTurns(ctx context.Context, policy InterruptedTurnPolicy) error {
+	var latest []generated.Message
+	err := s.db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		latest, err = q.ListLatestMessagesOfOpenConversations(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list conversations: %w", err)
+	}
+
+	for i := range latest {
+		if !turnInterrupted(&latest[i]) {
+			continue
+		}
+		running, err := s.turnRunningElsewhere(ctx, latest[i].ConversationID)
+		if err != nil {
+			s.logger.Error("Failed to check turn owner", "conversationID", latest[i].ConversationID, "error", err)
+			continue
+		}
+		if running {
+			s.logger.Info("Leaving turn to the process running it", "conversationID", latest[i].ConversationID)
+			continue
+		}
+		if err := s.recoverInterruptedTurn(ctx, &latest[i], policy); err != nil {
+			s.logger.Error("Failed to recover interrupted turn", "conversationID", latest[i].ConversationID, "error", err)
+		}
+	}
+	return nil
+}
+
+// turnInterrupted reports whether msg, the latest message of a conversation, leaves a turn unfinished.
+func turnInterrupted(msg *generated.Message) bool {
+	switch db.MessageType(msg.Type) {
+	case db.MessageTypeUser, db.MessageTypeTool, db.MessageTypeCompaction:
+		return true
+	case db.MessageTypeAgent:
+		return !isEndOfTurn(msg)
+	}
+	return false
+}
+
+// turnRunningElsewhere reports whether another live process, such as a 'shelley run' using the same
+// database, is running the conversation's turn.
+func (s *Server) turnRunningElsewhere(ctx context.Context, conversationID string) (bool, error) {
+	conversation, err := s.db.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return false, err
+	}
+	owner := conversation.TurnOwnerPid
+	if owner == nil || int(*owner) == os.Getpid() {
+		return false, nil
+	}
+	// Signal 0 only checks that the process exists; EPERM means it belongs to another user
+	err = syscall.Kill(int(*owner), 0)
+	return err == nil || errors.Is(err, syscall.EPERM), nil
+}
+
+func (s *Server) recoverInterruptedTurn(ctx context.Context, latest *generated.Message, policy InterruptedTurnPolicy) error {
+	conversationID := latest.ConversationID
+	if err := s.recordInterruptedToolResults(ctx, latest); err != nil {
+		return err
+	}
+
+	if policy == InterruptedTurnsResume {
+		err := s.resumeTurn(ctx, conversationID)
+		if err == nil {
+			s.logger.Info("Resumed interrupted turn", "conversationID", conversationID)
+			return nil
+		}
+		s.logger.Warn("Failed to resume interrupted turn, marking it interrupted", "conversationID", conversationID, "error", err)
+	}
+
+	s.logger.Info("Marking interrupted turn", "conversationID", conversationID)
+	return s.recordMessage(ctx, conversationID, llm.Message{
+		Role:      llm.MessageRoleAssistant,
+		Content:   []llm.Content{{Type: llm.ContentTypeText, Text: interruptedTurnText}},
+		EndOfTurn: true,
+	}, llm.Usage{})
+}
+
+// recordInterruptedToolResults records an error result for each tool call in latest,
+// whose results were lost with the previous process.
+func (s *Server) recordInterruptedToolResults(ctx context.Context, latest *generated.Message) error {
+	if latest.Type != string(db.MessageTypeAgent) || latest.LlmData == nil {
+		return nil
+	}
+	var message llm.Message
+	if err := json.Unmarshal([]byte(*latest.LlmData), &message); err != nil {
+		return fmt.Errorf("failed to parse latest message: %w", err)
+	}
+
+	now := time.Now()
+	var results []llm.Content
+	for _, content := range message.Content {
+		if content.Type != llm.ContentTypeToolUse {
+			continue
+		}
+		results = append(results, llm.Content{
+			Type:             llm.ContentTypeToolResult,
+			ToolUseID:        content.ID,
+			ToolError:        true,
+			ToolResult:       []llm.Content{{Type: llm.ContentTypeText, Text: interruptedToolResultText}},
+			ToolUseStartTime: &now,
+			ToolUseEndTime:   &now,
+		})
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return s.recordMessage(ctx, latest.ConversationID, llm.Message{Role: llm.MessageRoleUser, Content: results}, llm.Usage{})
+}
+
+// resumeTurn restarts the conversation's loop with the model it last used.
+func (s *Server) resumeTurn(ctx context.Context, conversationID string) error {
+	conversation, err := s.db.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	modelID := s.defaultModel
+	if conversation.Model != nil && *conversation.Model != "" {
+		modelID = *conversation.Model
+	}
+	service, err := s.llmManager.GetService(modelID)
+	if err != nil {
+		return fmt.Errorf("model %q: %w", modelID, err)
+	}
+
+	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	return manager.ResumeTurn(ctx, service, modelID)
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"testing"
+
+	"shelley.exe.dev/claudetool"
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+	"shelley.exe.dev/loop"
+)
+
+// createInterruptedConversation stores a conversation whose turn stopped after the given messages.
+func createInterruptedConversation(t *testing.T, h *TestHarness, messages ...llm.Message) string {
+	t.Helper()
+	ctx := context.Background()
+	conversation, err := h.db.CreateConversation(ctx, nil, true, nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	for _, msg := range messages {
+		if err := h.server.recordMessage(ctx, conversation.ConversationID, msg, llm.Usage{}); err != nil {
+			t.Fatalf("failed to record message: %v", err)
+		}
+	}
+	return conversation.ConversationID
+}
+
+func listLLMMessages(t *testing.T, h *TestHarness, conversationID string) []llm.Message {
+	t.Helper()
+	var messages []generated.Message
+	if err := h.db.Queries(context.Background(), func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(context.Background(), conversationID)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var llmMessages []llm.Message
+	for _, msg := range messages {
+		var llmMsg llm.Message
+		if err := json.Unmarshal([]byte(*msg.LlmData), &llmMsg); err != nil {
+			t.Fatal(err)
+		}
+		llmMessages = append(llmMessages, llmMsg)
+	}
+	return llmMessages
+}
+
+func TestRecoverInterruptedTurnsMark(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	interrupted := createInterruptedConversation(t, h,
+		llm.UserStringMessage("run something"),
+		llm.Message{Role: llm.MessageRoleAssistant, Content: []llm.Content{
+			{Type: llm.ContentTypeToolUse, ID: "call_1", ToolName: "bash", ToolInput: json.RawMessage(`{"command":"sleep 100"}`)},
+		}},
+	)
+	finished := createInterruptedConversation(t, h,
+		llm.UserStringMessage("hello"),
+		llm.Message{Role: llm.MessageRoleAssistant, Content: []llm.Content{{Type: llm.ContentTypeText, Text: "hi"}}, EndOfTurn: true},
+	)
+
+	if err := h.server.RecoverInterruptedTurns(context.Background(), InterruptedTurnsMark); err != nil {
+		t.Fatalf("RecoverInterruptedTurns: %v", err)
+	}
+
+	messages := listLLMMessages(t, h, interrupted)
+	if len(messages) != 4 {
+		t.Fatalf("got %d messages, want a tool result and an interrupted marker added", len(messages))
+	}
+	result := messages[2].Content[0]
+	if result.Type != llm.ContentTypeToolResult || result.ToolUseID != "call_1" || !result.ToolError {
+		t.Errorf("unexpected tool result %+v", result)
+	}
+	if last := messages[3]; !last.EndOfTurn || last.Content[0].Text != interruptedTurnText {
+		t.Errorf("unexpected last message %+v", last)
+	}
+	if h.llm.GetLastRequest() != nil {
+		t.Error("LLM was called for a turn that should only be marked")
+	}
+
+	if n := len(listLLMMessages(t, h, finished)); n != 2 {
+		t.Errorf("finished conversation has %d messages, want it untouched", n)
+	}
+	conversation, err := h.db.GetConversationByID(context.Background(), interrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conversation.TurnOwnerPid != nil {
+		t.Errorf("turn owner %d left set after the turn was ended", *conversation.TurnOwnerPid)
+	}
+}
+
+func TestRecoverInterruptedTurnsLeavesRunningTurns(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+	ctx := context.Background()
+
+	// Another live process, here the parent of the test binary, is still running the turn
+	conversationID := createInterruptedConversation(t, h, llm.UserStringMessage("run something"))
+	owner := int64(os.Getppid())
+	if err := h.db.QueriesTx(ctx, func(q *generated.Queries) error {
+		return q.SetConversationTurnOwner(ctx, generated.SetConversationTurnOwnerParams{TurnOwnerPid: &owner, ConversationID: conversationID})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.server.RecoverInterruptedTurns(ctx, InterruptedTurnsMark); err != nil {
+		t.Fatalf("RecoverInterruptedTurns: %v", err)
+	}
+	if n := len(listLLMMessages(t, h, conversationID)); n != 1 {
+		t.Errorf("conversation has %d messages, want the running turn untouched", n)
+	}
+}
+
+func TestRecoverInterruptedTurnsResume(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	h.convID = createInterruptedConversation(t, h, llm.UserStringMessage("echo: resumed"))
+	if err := h.db.UpdateConversationModel(context.Background(), h.convID, "predictable"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.server.RecoverInterruptedTurns(context.Background(), InterruptedTurnsResume); err != nil {
+		t.Fatalf("RecoverInterruptedTurns: %v", err)
+	}
+	if got := h.WaitResponse(); got != "resumed" {
+		t.Fatalf("response = %q, want %q", got, "resumed")
+	}
+}
+
+func TestRecoverInterruptedTurnsResumeWithoutModel(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	service := loop.NewPredictableService()
+	llmManager := &multiModelLLMManager{services: map[string]llm.Service{"predictable": service}}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	server := NewServer(database, llmManager, claudetool.ToolSetConfig{}, logger, true, "", "predictable", "", nil)
+	h := &TestHarness{t: t, db: database, server: server, cleanup: func() {}, llm: service}
+
+	conversationID := createInterruptedConversation(t, h, llm.UserStringMessage("echo: resumed"))
+	if err := database.UpdateConversationModel(context.Background(), conversationID, "removed-model"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.RecoverInterruptedTurns(context.Background(), InterruptedTurnsResume); err != nil {
+		t.Fatalf("RecoverInterruptedTurns: %v", err)
+	}
+
+	// The conversation's model is gone, so the turn is marked instead.
+	messages := listLLMMessages(t, h, conversationID)
+	if last := messages[len(messages)-1]; !last.EndOfTurn || last.Content[0].Text != interruptedTurnText {
+		t.Errorf("unexpected last message %+v", last)
+	}
+	if service.GetLastRequest() != nil {
+		t.Error("LLM was called although the conversation's model is unavailable")
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"shelley.exe.dev/db/generated"
+)
+
+// RecordingExt is the extension of the files SetRecordDir writes the user's side of a conversation to.
+// They sit next to the conversation's HTTP trace, which shares their name.
+const RecordingExt = ".json"
+
+// Recording is the user's side of a recorded conversation: the chat requests that started
+// each of its turns, including edits. Replaying it against the conversation's HTTP trace
+// reproduces the conversation.
+type Recording struct {
+	Cwd      string            `json:"cwd,omitempty"`
+	Messages []RecordedMessage `json:"messages"`
+}
+
+// RecordedMessage is a chat request in a Recording.
+type RecordedMessage struct {
+	ChatRequest
+	// Edit is set when the request edited an earlier message, to that message's position
+	// among the conversation's visible user messages at the time, counting from 1.
+	// The message and everything after it were hidden before the request was sent.
+	Edit int `json:"edit,omitempty"`
+}
+
+// LoadRecording reads a recording written by a server with a record directory.
+func LoadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rec Recording
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid recording %s: %w", path, err)
+	}
+	if len(rec.Messages) == 0 {
+		return nil, fmt.Errorf("recording %s has no messages", path)
+	}
+	return &rec, nil
+}
+
+// SetRecordDir makes the server write the chat requests of every conversation to
+// <dir>/<conversation ID>.json, to be replayed with Replay.
+// Only conversations started while recording can be replayed.
+func (s *Server) SetRecordDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	s.recordDir = dir
+	return nil
+}
+
+// recordChatRequest appends an accepted chat request to the conversation's recording.
+// The model is recorded as resolved, so that a replay uses the same one. edit is the
+// position of the user message the request replaced, or 0 if it was not an edit.
+func (s *Server) recordChatRequest(conversationID string, req ChatRequest, modelID string, edit int) {
+	if s.recordDir == "" {
+		return
+	}
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+
+	path := filepath.Join(s.recordDir, conversationID+RecordingExt)
+	var rec Recording
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &rec); err != nil {
+			s.logger.Warn("Failed to read recording", "path", path, "error", err)
+			return
+		}
+	}
+	if len(rec.Messages) == 0 {
+		rec.Cwd = req.Cwd
+	}
+	req.Model = modelID
+	req.Cwd = ""
+	rec.Messages = append(rec.Messages, RecordedMessage{ChatRequest: req, Edit: edit})
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		s.logger.Warn("Failed to encode recording", "conversationID", conversationID, "error", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		s.logger.Warn("Failed to write recording", "path", path, "error", err)
+	}
+}
+
+// Replay re-runs a recorded conversation as a new one, sending each recorded message
+// once the previous turn has ended, and rewinding the conversation first for edits. Tools run for real; the LLM's side comes from
+// whatever the server's LLM services are configured with, normally a replay of the
+// conversation's HTTP trace. It returns the new conversation's ID once the last turn ends.
+func (s *Server) Replay(ctx context.Context, rec *Recording) (string, error) {
+	var cwd *string
+	if rec.Cwd != "" {
+		cwd = &rec.Cwd
+	}
+	conversation, err := s.db.CreateConversation(ctx, nil, true, cwd)
+	if err != nil {
+		return "", fmt.Errorf("failed to create conversation: %w", err)
+	}
+	conversationID := conversation.ConversationID
+
+	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
+	if err != nil {
+		return conversationID, err
+	}
+	for i, req := range rec.Messages {
+		modelID := req.Model
+		if modelID == "" {
+			modelID = s.defaultModel
+		}
+		llmService, err := s.llmManager.GetService(modelID)
+		if err != nil {
+			return conversationID, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		userMessage, err := req.userMessage(llmService)
+		if err != nil {
+			return conversationID, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		if req.Edit > 0 {
+			if err := s.rewindToUserMessage(ctx, manager, req.Edit); err != nil {
+				return conversationID, fmt.Errorf("message %d: %w", i+1, err)
+			}
+		}
+		firstMessage, err := manager.AcceptUserMessage(ctx, llmService, modelID, userMessage)
+		if err != nil {
+			return conversationID, fmt.Errorf("message %d: %w", i+1, err)
+		}
+		if firstMessage && req.Edit == 0 {
+			s.generateSlug(ctx, conversationID, req.slugText(), modelID)
+		}
+		if err := s.waitForEndOfTurn(ctx, conversationID); err != nil {
+			return conversationID, fmt.Errorf("message %d: %w", i+1, err)
+		}
+	}
+	return conversationID, nil
+}
+
+// rewindToUserMessage hides the conversation's n-th visible user message, counting
+// from 1, and everything after it, as editing that message does.
+func (s *Server) rewindToUserMessage(ctx context.Context, manager *ConversationManager, n int) error {
+	var messages []generated.Message
+	err := s.db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(ctx, manager.conversationID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list messages: %w", err)
+	}
+	for _, msg := range messages {
+		if !isUserText(msg) {
+			continue
+		}
+		if n--; n == 0 {
+			return manager.Rewind(ctx, msg.SequenceID)
+		}
+	}
+	return fmt.Errorf("edited message is not in the conversation")
+}
+
+// waitForEndOfTurn waits until the conversation's latest message ends a turn.
+// A conversation without messages has not reached the end of a turn yet.
+func (s *Server) waitForEndOfTurn(ctx context.Context, conversationID string) error {
+	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	for {
+		// Subscribe before looking at the latest message, so that none recorded in between is missed
+		subCtx, cancel := context.WithCancel(ctx)
+		next := manager.subpub.Subscribe(subCtx, -1)
+		latest, err := s.db.GetLatestMessage(ctx, conversationID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			cancel()
+			return fmt.Errorf("failed to get latest message: %w", err)
+		}
+		if isEndOfTurn(latest) {
+			cancel()
+			return nil
+		}
+		for {
+			update, ok := next()
+			if !ok {
+				// Cancelled, or dropped for falling behind, in which case look again
+				break
+			}
+			if len(update.Messages) > 0 && !update.AgentWorking {
+				cancel()
+				return nil
+			}
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
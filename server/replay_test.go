@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+// turnTexts returns the text of each user and agent message, in order.
+func turnTexts(messages []llm.Message) []string {
+	var texts []string
+	for _, msg := range messages {
+		for _, c := range msg.Content {
+			if c.Type == llm.ContentTypeText && c.Text != "" {
+				texts = append(texts, msg.Role.String()+": "+c.Text)
+			}
+		}
+	}
+	return texts
+}
+
+func TestRecordAndReplayConversation(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	dir := t.TempDir()
+	if err := h.server.SetRecordDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	cwd := t.TempDir()
+	h.NewConversation("echo: first", cwd)
+	h.WaitResponse()
+	h.Chat("echo: second")
+	h.WaitResponse()
+	original := h.ConversationID()
+
+	rec, err := LoadRecording(filepath.Join(dir, original+RecordingExt))
+	if err != nil {
+		t.Fatalf("LoadRecording: %v", err)
+	}
+	if rec.Cwd != cwd {
+		t.Errorf("recorded cwd = %q, want %q", rec.Cwd, cwd)
+	}
+	if len(rec.Messages) != 2 || rec.Messages[0].Message != "echo: first" || rec.Messages[1].Message != "echo: second" {
+		t.Fatalf("unexpected recorded messages %+v", rec.Messages)
+	}
+	for _, msg := range rec.Messages {
+		if msg.Model != "predictable" {
+			t.Errorf("recorded model = %q, want predictable", msg.Model)
+		}
+	}
+
+	replayed, err := h.server.Replay(context.Background(), rec)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if replayed == original {
+		t.Fatal("Replay reused the recorded conversation")
+	}
+	want := turnTexts(listLLMMessages(t, h, original))
+	got := turnTexts(listLLMMessages(t, h, replayed))
+	if !slices.Equal(got, want) {
+		t.Errorf("replayed conversation = %q, want %q", got, want)
+	}
+}
+
+func TestRecordAndReplayEdit(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	dir := t.TempDir()
+	if err := h.server.SetRecordDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+	h.Chat("echo: two")
+	h.WaitResponse()
+	editMessage(t, h, "echo: two", "echo: three")
+	h.responsesCount = 1
+	h.WaitResponse()
+	original := h.ConversationID()
+
+	rec, err := LoadRecording(filepath.Join(dir, original+RecordingExt))
+	if err != nil {
+		t.Fatalf("LoadRecording: %v", err)
+	}
+	if len(rec.Messages) != 3 || rec.Messages[2].Message != "echo: three" || rec.Messages[2].Edit != 2 {
+		t.Fatalf("unexpected recorded messages %+v", rec.Messages)
+	}
+
+	replayed, err := h.server.Replay(context.Background(), rec)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	want := turnTexts(listLLMMessages(t, h, original))
+	if !slices.Contains(want, "MessageRoleUser: echo: three") || slices.Contains(want, "MessageRoleUser: echo: two") {
+		t.Fatalf("original conversation = %q, want the edit to have replaced the second message", want)
+	}
+	if got := turnTexts(listLLMMessages(t, h, replayed)); !slices.Equal(got, want) {
+		t.Errorf("replayed conversation = %q, want %q", got, want)
+	}
+}
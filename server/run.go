@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+)
+
+// RunOutcome says how a turn started by Run ended.
+type RunOutcome string
+
+const (
+	RunDone           RunOutcome = "done"            // the agent finished its turn
+	RunError          RunOutcome = "error"           // the LLM request failed
+	RunBudgetExceeded RunOutcome = "budget_exceeded" // the conversation's budget ran out
+)
+
+// RunResult is the outcome of a turn started by Run.
+type RunResult struct {
+	ConversationID string     `json:"conversation_id"`
+	Slug           string     `json:"slug,omitempty"`
+	Outcome        RunOutcome `json:"outcome"`
+	// Answer is the text of the message that ended the turn
+	Answer string `json:"answer"`
+	// Usage is what the turn's LLM requests used
+	Usage llm.Usage `json:"usage"`
+}
+
+// Run starts a conversation with req like POST /api/conversations/new, and waits for the end of its
+// first turn, calling progress with each message recorded meanwhile. The conversation is kept like any
+// other, so it can be browsed in the web UI afterwards. If ctx is cancelled, so is the turn.
+func (s *Server) Run(ctx context.Context, req ChatRequest, progress func(APIMessage)) (*RunResult, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	modelID := req.Model
+	if modelID == "" {
+		modelID = s.defaultModel
+	}
+	llmService, err := s.llmManager.GetService(modelID)
+	if err != nil {
+		return nil, err
+	}
+	userMessage, err := req.userMessage(llmService)
+	if err != nil {
+		return nil, err
+	}
+
+	var cwd *string
+	if req.Cwd != "" {
+		cwd = &req.Cwd
+	}
+	conversation, err := s.db.CreateConversation(ctx, nil, true, cwd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	conversationID := conversation.ConversationID
+	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := manager.AcceptUserMessage(ctx, llmService, modelID, userMessage); err != nil {
+		return nil, err
+	}
+	slugDone := s.generateSlug(ctx, conversationID, req.slugText(), modelID)
+
+	result := &RunResult{ConversationID: conversationID}
+	if err := s.followTurn(ctx, conversationID, result, progress); err != nil {
+		if ctx.Err() != nil {
+			manager.CancelConversation(context.WithoutCancel(ctx))
+		}
+		return result, err
+	}
+
+	// Wait for the name the web UI shows the conversation under
+	<-slugDone
+	if conversation, err := s.db.GetConversationByID(ctx, conversationID); err == nil && conversation.Slug != nil {
+		result.Slug = *conversation.Slug
+	}
+	return result, nil
+}
+
+// followTurn calls progress with each new message of the conversation until one ends the turn,
+// and fills in result from them.
+func (s *Server) followTurn(ctx context.Context, conversationID string, result *RunResult, progress func(APIMessage)) error {
+	manager, err := s.getOrCreateConversationManager(ctx, conversationID)
+	if err != nil {
+		return err
+	}
+	last := int64(0)
+	for {
+		// Subscribe before listing new messages, so that none recorded in between is missed
+		subCtx, cancel := context.WithCancel(ctx)
+		next := manager.subpub.Subscribe(subCtx, -1)
+		done, err := s.followMessages(ctx, conversationID, &last, result, progress)
+		if err != nil || done {
+			cancel()
+			return err
+		}
+		for {
+			update, ok := next()
+			// Cancelled, or dropped for falling behind, or a new message: look again
+			if !ok || len(update.Messages) > 0 {
+				break
+			}
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// followMessages handles the conversation's messages after *last for followTurn, advancing *last,
+// and reports whether one of them ended the turn.
+func (s *Server) followMessages(ctx context.Context, conversationID string, last *int64, result *RunResult, progress func(APIMessage)) (bool, error) {
+	var messages []generated.Message
+	err := s.db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessagesSince(ctx, generated.ListMessagesSinceParams{
+			ConversationID: conversationID,
+			SequenceID:     *last,
+		})
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list messages: %w", err)
+	}
+	for _, msg := range messages {
+		*last = msg.SequenceID
+		if msg.Type == string(db.MessageTypeUser) || msg.Type == string(db.MessageTypeSystem) {
+			continue
+		}
+		if progress != nil {
+			progress(toAPIMessages([]generated.Message{msg})[0])
+		}
+		var usage llm.Usage
+		if msg.UsageData != nil && json.Unmarshal([]byte(*msg.UsageData), &usage) == nil {
+			result.Usage.Add(usage)
+		}
+		if !isEndOfTurn(&msg) {
+			continue
+		}
+		llmMsg, err := convertToLLMMessage(msg)
+		if err != nil {
+			return false, err
+		}
+		result.Answer = messageText(llmMsg)
+		switch {
+		case msg.Type == string(db.MessageTypeError):
+			result.Outcome = RunError
+		case llmMsg.TurnEnd == llm.TurnBudgetExceeded:
+			result.Outcome = RunBudgetExceeded
+		default:
+			result.Outcome = RunDone
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// messageText returns the text of a message's text contents.
+func messageText(msg llm.Message) string {
+	var texts []string
+	for _, c := range msg.Content {
+		if c.Type == llm.ContentTypeText && c.Text != "" {
+			texts = append(texts, c.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
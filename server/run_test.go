@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/loop"
+)
+
+func TestRun(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+	ctx := context.Background()
+
+	var progress []APIMessage
+	result, err := h.server.Run(ctx, ChatRequest{Message: "echo: all done", Cwd: t.TempDir()}, func(msg APIMessage) {
+		progress = append(progress, msg)
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result.Outcome != RunDone || result.Answer != "all done" {
+		t.Errorf("result = %+v, want done with the echoed answer", result)
+	}
+	if len(progress) == 0 || progress[len(progress)-1].Type != "agent" {
+		t.Errorf("progress = %+v, want the agent's messages", progress)
+	}
+	if result.Usage.OutputTokens == 0 {
+		t.Error("result has no usage")
+	}
+	// The run is an ordinary conversation, listed in the web UI
+	conversation, err := h.db.GetConversationByID(ctx, result.ConversationID)
+	if err != nil {
+		t.Fatalf("conversation of the run: %v", err)
+	}
+	if conversation.Slug == nil || result.Slug != *conversation.Slug {
+		t.Errorf("result slug %q, conversation slug %v", result.Slug, conversation.Slug)
+	}
+
+	result, err = h.server.Run(ctx, ChatRequest{Message: "error: overloaded"}, nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result.Outcome != RunError || !strings.Contains(result.Answer, "overloaded") {
+		t.Errorf("result = %+v, want an error", result)
+	}
+
+	h.server.SetDefaultBudget(loop.Budget{MaxTokens: 1})
+	result, err = h.server.Run(ctx, ChatRequest{Message: "bash: echo hi"}, nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result.Outcome != RunBudgetExceeded {
+		t.Errorf("result = %+v, want the budget exceeded", result)
+	}
+
+	if _, err := h.server.Run(ctx, ChatRequest{}, nil); err == nil {
+		t.Error("Run without a message succeeded")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := h.server.Run(ctx, ChatRequest{Message: "delay: 5"}, nil); err == nil {
+		t.Error("cancelled Run succeeded")
+	}
+}
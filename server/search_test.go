@@ -0,0 +1,53 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/db/generated"
+)
+
+func TestSearchEndpoint(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+
+	h.NewConversation("echo: the exporter flushes twice", "")
+	h.WaitResponse()
+
+	search := func(query string) []generated.SearchMessagesRow {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var results []generated.SearchMessagesRow
+		if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+
+	results := search("/api/search?q=exporter+flush")
+	if len(results) == 0 {
+		t.Fatal("found nothing, want the conversation's messages")
+	}
+	for _, result := range results {
+		if result.ConversationID != h.ConversationID() || result.SequenceID == 0 || !strings.Contains(result.Snippet, "**exporter**") {
+			t.Errorf("result = %+v, want a marked snippet of the conversation", result)
+		}
+	}
+
+	if results := search("/api/search?q=exporter&archived=true"); len(results) != 0 {
+		t.Errorf("archived search found %+v, want nothing", results)
+	}
+	if results := search("/api/search?q="); len(results) != 0 {
+		t.Errorf("empty search found %+v, want nothing", results)
+	}
+}
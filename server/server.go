@@ -22,6 +22,7 @@ import (
 	"shelley.exe.dev/db"
 	"shelley.exe.dev/db/generated"
 	"shelley.exe.dev/llm"
+	"shelley.exe.dev/loop"
 	"shelley.exe.dev/models"
 	"shelley.exe.dev/ui"
 )
@@ -47,6 +48,23 @@ type StreamResponse struct {
 	Conversation      generated.Conversation `json:"conversation"`
 	AgentWorking      bool                   `json:"agent_working"`
 	ContextWindowSize uint64                 `json:"context_window_size,omitempty"`
+	// Delta is set on transient updates carrying partial agent output.
+	// Such updates carry no messages; the complete message follows when the response finishes.
+	Delta *StreamDelta `json:"delta,omitempty"`
+	// HiddenFrom is set on updates sent when a rewind or edit hides messages.
+	// Clients drop the message with this sequence ID and all later ones.
+	HiddenFrom *int64 `json:"hidden_from,omitempty"`
+}
+
+// StreamDelta is a piece of an agent response that is still being generated.
+// Deltas may be dropped for subscribers that fall behind; Seq numbers the deltas of
+// each response from 1, so that a client can tell when its partial output is incomplete.
+type StreamDelta struct {
+	Seq      int    `json:"seq"`
+	Index    int    `json:"index"`
+	Type     string `json:"type"` // "text", "thinking", or "tool_use"
+	Text     string `json:"text,omitempty"`
+	ToolName string `json:"tool_name,omitempty"`
 }
 
 // LLMProvider is an interface for getting LLM services
@@ -57,15 +75,22 @@ type LLMProvider interface {
 }
 
 // NewLLMServiceManager creates a new LLM service manager from config
-func NewLLMServiceManager(cfg *LLMConfig, history *models.LLMRequestHistory) LLMProvider {
+func NewLLMServiceManager(cfg *LLMConfig, history *models.LLMRequestHistory) *models.Manager {
 	// Convert LLMConfig to models.Config
 	modelConfig := &models.Config{
-		AnthropicAPIKey: cfg.AnthropicAPIKey,
-		OpenAIAPIKey:    cfg.OpenAIAPIKey,
-		GeminiAPIKey:    cfg.GeminiAPIKey,
-		FireworksAPIKey: cfg.FireworksAPIKey,
-		Gateway:         cfg.Gateway,
-		Logger:          cfg.Logger,
+		AnthropicAPIKey:   cfg.AnthropicAPIKey,
+		OpenAIAPIKey:      cfg.OpenAIAPIKey,
+		GeminiAPIKey:      cfg.GeminiAPIKey,
+		FireworksAPIKey:   cfg.FireworksAPIKey,
+		Gateway:           cfg.Gateway,
+		Models:            cfg.Models,
+		Fallbacks:         cfg.Fallbacks,
+		HTTPClient:        cfg.HTTPClient,
+		PredictableScript: cfg.PredictableScript,
+		Logger:            cfg.Logger,
+	}
+	if cfg.Credentials != nil {
+		modelConfig.Credentials = cfg.Credentials.Credentials()
 	}
 
 	manager, err := models.NewManager(modelConfig, history)
@@ -131,6 +156,10 @@ func calculateContextWindowSize(messages []APIMessage) uint64 {
 		if msg.UsageData == nil {
 			continue
 		}
+		// The history was just summarized; its size is unknown until the next response.
+		if msg.Type == string(db.MessageTypeCompaction) {
+			return 0
+		}
 		var usage llm.Usage
 		if err := json.Unmarshal([]byte(*msg.UsageData), &usage); err != nil {
 			continue
@@ -142,6 +171,21 @@ func calculateContextWindowSize(messages []APIMessage) uint64 {
 	return 0
 }
 
+// contextWindowSize returns how much of the context window a conversation uses.
+// A running loop knows best, since it counts each request before sending it when the model can count tokens;
+// otherwise the usage of the last response in messages is used.
+func (s *Server) contextWindowSize(conversationID string, messages []APIMessage) uint64 {
+	s.mu.Lock()
+	manager := s.activeConversations[conversationID]
+	s.mu.Unlock()
+	if manager != nil {
+		if used := manager.contextWindowSize(); used > 0 {
+			return used
+		}
+	}
+	return calculateContextWindowSize(messages)
+}
+
 func agentWorking(messages []APIMessage) bool {
 	if len(messages) == 0 {
 		return false
@@ -217,9 +261,10 @@ func isEndOfTurn(msg *generated.Message) bool {
 
 // calculateContextWindowSizeFromMsg calculates context window usage from a single message.
 // Returns 0 if the message has no usage data (e.g., user messages), in which case
-// the client should keep its previous context window value.
+// the client should keep its previous context window value. Compaction usage
+// describes the summarization request, not the conversation, so it is ignored too.
 func calculateContextWindowSizeFromMsg(msg *generated.Message) uint64 {
-	if msg == nil || msg.UsageData == nil {
+	if msg == nil || msg.UsageData == nil || msg.Type == string(db.MessageTypeCompaction) {
 		return 0
 	}
 	var usage llm.Usage
@@ -229,6 +274,23 @@ func calculateContextWindowSizeFromMsg(msg *generated.Message) uint64 {
 	return usage.ContextWindowUsed()
 }
 
+// sumUsage totals the usage recorded with messages, as listed by ListMessageUsage,
+// for counting against a budget.
+func sumUsage(usageData []*string) llm.Usage {
+	var total llm.Usage
+	for _, data := range usageData {
+		if data == nil {
+			continue
+		}
+		var usage llm.Usage
+		if err := json.Unmarshal([]byte(*data), &usage); err != nil {
+			continue
+		}
+		total.Add(usage)
+	}
+	return total
+}
+
 // Server manages the HTTP API and active conversations
 type Server struct {
 	db                  *db.DB
@@ -242,11 +304,30 @@ type Server struct {
 	defaultModel        string
 	links               []Link
 	requireHeader       string
+	requireToken        bool // whether API requests need an API token
 	conversationGroup   singleflight.Group[string, *ConversationManager]
+	compactionThreshold float64
+	compactionLLM       llm.Service   // nil means each conversation's own model
+	defaultBudget       loop.Budget   // applies to conversations without their own budget
+	serverBudget        loop.Budget   // limits all conversations together
+	serverUsage         llm.Usage     // what all conversations have used since the server started
+	recordDir           string        // where chat requests are recorded for replay, if set
+	recordMu            sync.Mutex    // serializes writes to recordings
+	llmRequestRetention time.Duration // how long recorded LLM requests are kept; zero keeps them forever
+
+	webhooks             []WebhookConfig // configured in shelley.json
+	allowPrivateWebhooks bool            // whether webhooks added through the API may target private addresses
+	webhookClient        *http.Client
+	webhookRetryDelay    time.Duration      // before the first retry of a failed delivery
+	webhookWG            sync.WaitGroup     // deliveries in progress
+	webhookCtx           context.Context    // cancelled to abandon deliveries still being retried
+	stopWebhooks         context.CancelFunc // cancels webhookCtx
+	webhooksClosed       bool               // whether events are no longer sent to webhooks
 }
 
 // NewServer creates a new server instance
 func NewServer(database *db.DB, llmManager LLMProvider, toolSetConfig claudetool.ToolSetConfig, logger *slog.Logger, predictableOnly bool, terminalURL, defaultModel, requireHeader string, links []Link) *Server {
+	webhookCtx, stopWebhooks := context.WithCancel(context.Background())
 	return &Server{
 		db:                  database,
 		llmManager:          llmManager,
@@ -258,7 +339,58 @@ func NewServer(database *db.DB, llmManager LLMProvider, toolSetConfig claudetool
 		defaultModel:        defaultModel,
 		requireHeader:       requireHeader,
 		links:               links,
+		webhookClient:       newWebhookClient(),
+		webhookRetryDelay:   defaultWebhookRetryDelay,
+		webhookCtx:          webhookCtx,
+		stopWebhooks:        stopWebhooks,
+	}
+}
+
+// SetCompaction enables summarizing older history once a conversation uses more
+// than cfg.Threshold of its model's context window.
+func (s *Server) SetCompaction(cfg CompactionConfig) error {
+	var summarizer llm.Service
+	if cfg.Model != "" {
+		svc, err := s.llmManager.GetService(cfg.Model)
+		if err != nil {
+			return fmt.Errorf("compaction model %q: %w", cfg.Model, err)
+		}
+		summarizer = svc
 	}
+	s.compactionThreshold = cfg.Threshold
+	s.compactionLLM = summarizer
+	return nil
+}
+
+// SetRequireToken sets whether API requests must be authenticated with an API token.
+func (s *Server) SetRequireToken(required bool) {
+	s.requireToken = required
+}
+
+// SetDefaultBudget sets the budget for conversations that have not set their own.
+func (s *Server) SetDefaultBudget(budget loop.Budget) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultBudget = budget
+}
+
+// SetServerBudget limits the dollars and tokens all conversations may use together
+// while the server runs. Its MaxToolCallsPerTurn is not used.
+func (s *Server) SetServerBudget(budget loop.Budget) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serverBudget = budget
+}
+
+// overServerBudget reports why the usage of all conversations exceeds the server's budget,
+// or "" if it does not.
+func (s *Server) overServerBudget() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reason := s.serverBudget.Exceeded(s.serverUsage); reason != "" {
+		return "server-wide, " + reason
+	}
+	return ""
 }
 
 // RegisterRoutes registers HTTP routes on the given mux
@@ -267,6 +399,7 @@ func (s *Server) RegisterRoutes(mux *http.ServeMux) {
 	mux.Handle("/api/conversations", gzipHandler(http.HandlerFunc(s.handleConversations)))
 	mux.Handle("/api/conversations/archived", gzipHandler(http.HandlerFunc(s.handleArchivedConversations)))
 	mux.Handle("/api/conversations/new", http.HandlerFunc(s.handleNewConversation)) // Small response
+	mux.Handle("/api/search", gzipHandler(http.HandlerFunc(s.handleSearch)))
 	mux.Handle("/api/conversation/", http.StripPrefix("/api/conversation", s.conversationMux()))
 	mux.Handle("/api/conversation-by-slug/", gzipHandler(http.HandlerFunc(s.handleConversationBySlug)))
 	mux.Handle("/api/validate-cwd", http.HandlerFunc(s.handleValidateCwd)) // Small response
@@ -278,6 +411,15 @@ func (s *Server) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/read", s.handleRead)                          // Serves images
 	mux.Handle("/api/write-file", http.HandlerFunc(s.handleWriteFile)) // Small response
 
+	// Webhooks - small responses
+	mux.HandleFunc("GET /api/webhooks", s.handleListWebhooks)
+	mux.HandleFunc("POST /api/webhooks", s.handleCreateWebhook)
+	mux.HandleFunc("DELETE /api/webhooks/{id}", s.handleDeleteWebhook)
+	mux.HandleFunc("GET /api/webhooks/deliveries", s.handleListWebhookDeliveries)
+
+	// Login page for the web UI when API tokens are required
+	mux.HandleFunc("/login", s.handleLogin)
+
 	// Version endpoint
 	mux.Handle("/version", http.HandlerFunc(s.handleVersion)) // Small response
 
@@ -463,6 +605,13 @@ func (s *Server) getOrCreateConversationManager(ctx context.Context, conversatio
 		}
 
 		manager := NewConversationManager(conversationID, s.db, s.logger, s.toolSetConfig, recordMessage)
+		manager.compactionThreshold = s.compactionThreshold
+		manager.compactionLLM = s.compactionLLM
+		manager.defaultBudget = s.defaultBudget
+		manager.serverBudgetExceeded = s.overServerBudget
+		manager.onGitStateChange = func(ctx context.Context, state string) {
+			s.sendWebhooks(ctx, conversationID, WebhookGitStateChange, state)
+		}
 		if err := manager.Hydrate(ctx); err != nil {
 			return nil, err
 		}
@@ -535,10 +684,32 @@ func (s *Server) recordMessage(ctx context.Context, conversationID string, messa
 	if err != nil {
 		return fmt.Errorf("failed to create message: %w", err)
 	}
+	s.mu.Lock()
+	s.serverUsage.Add(usage)
+	s.mu.Unlock()
+
+	// Link the message to the recorded LLM request that produced it, if any
+	if requestID := llm.LastRequestID(ctx); requestID != 0 {
+		if err := s.db.SetLLMRequestMessage(ctx, requestID, createdMsg.MessageID); err != nil {
+			s.logger.Warn("Failed to link LLM request to message", "requestID", requestID, "error", err)
+		}
+	}
 
-	// Update conversation's last updated timestamp for correct ordering
+	// Update conversation's last updated timestamp for correct ordering, and claim its turn
+	// for this process until the turn ends, so that RecoverInterruptedTurns in another leaves it alone
+	var turnOwner *int64
+	if !isEndOfTurn(createdMsg) {
+		pid := int64(os.Getpid())
+		turnOwner = &pid
+	}
 	if err := s.db.QueriesTx(ctx, func(q *generated.Queries) error {
-		return q.UpdateConversationTimestamp(ctx, conversationID)
+		if err := q.UpdateConversationTimestamp(ctx, conversationID); err != nil {
+			return err
+		}
+		return q.SetConversationTurnOwner(ctx, generated.SetConversationTurnOwnerParams{
+			TurnOwnerPid:   turnOwner,
+			ConversationID: conversationID,
+		})
 	}); err != nil {
 		s.logger.Warn("Failed to update conversation timestamp", "conversationID", conversationID, "error", err)
 	}
@@ -556,6 +727,15 @@ func (s *Server) recordMessage(ctx context.Context, conversationID string, messa
 	// we still want the notification to complete so SSE clients see the message immediately
 	go s.notifySubscribersNewMessage(context.WithoutCancel(ctx), conversationID, createdMsg)
 
+	switch {
+	case messageType == db.MessageTypeError:
+		s.sendWebhooks(ctx, conversationID, WebhookError, messageText(message))
+	case message.TurnEnd == llm.TurnCancelled:
+		s.sendWebhooks(ctx, conversationID, WebhookCancelled, "")
+	case messageType == db.MessageTypeAgent && message.EndOfTurn:
+		s.sendWebhooks(ctx, conversationID, WebhookEndOfTurn, "")
+	}
+
 	return nil
 }
 
@@ -621,30 +801,13 @@ func (s *Server) notifySubscribers(ctx context.Context, conversationID string) {
 		return
 	}
 
-	// For conversation-only updates, we need to get the latest sequence ID
-	// to properly notify subscribers, but we send an empty message list
-	var latestSequenceID int64
-	err = s.db.Queries(ctx, func(q *generated.Queries) error {
-		messages, err := q.ListMessages(ctx, conversationID)
-		if err != nil {
-			return err
-		}
-		if len(messages) > 0 {
-			latestSequenceID = messages[len(messages)-1].SequenceID
-		}
-		return nil
-	})
-	if err != nil {
-		s.logger.Error("Failed to get latest sequence ID", "conversationID", conversationID, "error", err)
-		return
-	}
-
-	// Publish conversation update with no new messages
+	// Publish conversation update with no new messages. It is not indexed, so that
+	// subscribers still get messages recorded before it but not yet published.
 	streamData := StreamResponse{
 		Messages:     nil, // No new messages, just conversation update
 		Conversation: conversation,
 	}
-	manager.subpub.Publish(latestSequenceID, streamData)
+	manager.subpub.Notify(streamData)
 }
 
 // notifySubscribersNewMessage sends a single new message to all subscribers.
@@ -680,9 +843,13 @@ func (s *Server) notifySubscribersNewMessage(ctx context.Context, conversationID
 		AgentWorking: !isEndOfTurn(newMsg),
 		// ContextWindowSize: 0 for messages without usage data (user/tool messages).
 		// With omitempty, 0 is omitted from JSON, so the UI keeps its cached value.
-		// Only agent messages have usage data, so context window updates when they arrive.
+		// Only agent messages have usage data, so context window updates when they arrive,
+		// with the running loop's count if it has one.
 		ContextWindowSize: calculateContextWindowSizeFromMsg(newMsg),
 	}
+	if used := manager.contextWindowSize(); used > 0 && streamData.ContextWindowSize > 0 {
+		streamData.ContextWindowSize = used
+	}
 	manager.subpub.Publish(newMsg.SequenceID, streamData)
 }
 
@@ -728,6 +895,7 @@ func (s *Server) StartWithListener(listener net.Listener) error {
 	if s.requireHeader != "" {
 		handler = RequireHeaderMiddleware(s.requireHeader)(handler)
 	}
+	handler = TokenAuthMiddleware(s.db, s.requireToken, servesPublicPage(mux))(handler)
 
 	httpServer := &http.Server{
 		Handler: handler,
@@ -739,6 +907,8 @@ func (s *Server) StartWithListener(listener net.Listener) error {
 		defer ticker.Stop()
 		for range ticker.C {
 			s.Cleanup()
+			s.PruneLLMRequests(context.Background())
+			s.PruneWebhookDeliveries(context.Background())
 		}
 	}()
 
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"shelley.exe.dev/claudetool"
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/loop"
+)
+
+// TestStreamDeltasPrecedeFinalMessage verifies that partial agent output is
+// published to subscribers before the complete agent message, and that only
+// the complete message is persisted.
+func TestStreamDeltasPrecedeFinalMessage(t *testing.T) {
+	database, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	llmManager := &testLLMManager{service: loop.NewPredictableService()}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	server := NewServer(database, llmManager, claudetool.ToolSetConfig{}, logger, true, "", "predictable", "", nil)
+
+	conversation, err := database.CreateConversation(context.Background(), nil, true, nil)
+	if err != nil {
+		t.Fatalf("failed to create conversation: %v", err)
+	}
+	conversationID := conversation.ConversationID
+
+	manager, err := server.getOrCreateConversationManager(context.Background(), conversationID)
+	if err != nil {
+		t.Fatalf("failed to get conversation manager: %v", err)
+	}
+	subCtx, subCancel := context.WithCancel(context.Background())
+	defer subCancel()
+	next := manager.subpub.Subscribe(subCtx, -1)
+	updates := make(chan StreamResponse, 100)
+	go func() {
+		for {
+			data, ok := next()
+			if !ok {
+				return
+			}
+			updates <- data
+		}
+	}()
+
+	chatBody, _ := json.Marshal(ChatRequest{Message: "echo: one two three", Model: "predictable"})
+	req := httptest.NewRequest("POST", "/api/conversation/"+conversationID+"/chat", strings.NewReader(string(chatBody)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	server.handleChatConversation(w, req, conversationID)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var streamed strings.Builder
+	seq := 0
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case update := <-updates:
+			if update.Delta != nil {
+				if len(update.Messages) != 0 {
+					t.Fatalf("delta update carried %d messages", len(update.Messages))
+				}
+				if seq++; update.Delta.Seq != seq {
+					t.Fatalf("delta seq = %d, want %d", update.Delta.Seq, seq)
+				}
+				if update.Delta.Type == "text" {
+					streamed.WriteString(update.Delta.Text)
+				}
+				continue
+			}
+			for _, msg := range update.Messages {
+				if msg.Type != string(db.MessageTypeAgent) {
+					continue
+				}
+				if streamed.String() != "one two three" {
+					t.Fatalf("streamed text before agent message = %q, want %q", streamed.String(), "one two three")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for agent message")
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"shelley.exe.dev/llm"
+)
+
+func TestChatWithThinking(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	h.NewConversation("echo: one", "")
+	h.WaitResponse()
+
+	chat := func(body string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/conversation/"+h.ConversationID()+"/chat", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.server.handleChatConversation(w, req, h.ConversationID())
+		return w.Code
+	}
+
+	if code := chat(`{"message": "echo: two", "thinking": {"effort": "extreme"}}`); code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an unknown effort, got %d", code)
+	}
+
+	h.llm.ClearRequests()
+	if code := chat(`{"message": "echo: two", "thinking": {"effort": "high"}}`); code != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", code)
+	}
+	h.WaitResponse()
+	if req := h.llm.GetLastRequest(); req.Thinking == nil || req.Thinking.Effort != llm.ThinkingEffortHigh {
+		t.Errorf("LLM request thinking = %+v, want high", req.Thinking)
+	}
+
+	// The setting is stored with the user message, so the UI can show it.
+	var found bool
+	for _, msg := range listLLMMessages(t, h, h.ConversationID()) {
+		if msg.Role == llm.MessageRoleUser && msg.Thinking != nil && msg.Thinking.Effort == llm.ThinkingEffortHigh {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("no stored user message has the thinking setting")
+	}
+}
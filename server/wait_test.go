@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWaitConversation(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	wait := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/conversation/"+h.ConversationID()+"/wait"+query, nil))
+		return rec
+	}
+
+	h.NewConversation("delay: 0.5", "")
+	if rec := wait("?timeout=10ms"); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("wait before the turn ended: status %d, want 504", rec.Code)
+	}
+
+	rec := wait("?timeout=5s")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wait: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp StreamResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	last := resp.Messages[len(resp.Messages)-1]
+	if resp.AgentWorking || last.EndOfTurn == nil || !*last.EndOfTurn {
+		t.Errorf("wait returned while the agent was working: %+v", last)
+	}
+
+	if rec := wait("?timeout=soon"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid timeout: status %d, want 400", rec.Code)
+	}
+	h.convID = "cmissing"
+	if rec := wait(""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown conversation: status %d, want 404", rec.Code)
+	}
+
+	// A conversation without messages hasn't reached the end of a turn yet
+	empty, err := h.db.CreateConversation(context.Background(), nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.convID = empty.ConversationID
+	if rec := wait("?timeout=10ms"); rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("conversation without messages: status %d, want 504: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,553 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"shelley.exe.dev/db"
+	"shelley.exe.dev/db/generated"
+	"shelley.exe.dev/llm"
+)
+
+// WebhookEvent is a conversation event that webhooks are sent.
+type WebhookEvent string
+
+const (
+	WebhookEndOfTurn      WebhookEvent = "end_of_turn"      // the agent finished its turn, done or waiting for an answer
+	WebhookError          WebhookEvent = "error"            // an LLM request failed
+	WebhookGitStateChange WebhookEvent = "git_state_change" // the conversation's worktree, branch or commit changed
+	WebhookCancelled      WebhookEvent = "cancelled"        // the user cancelled the turn
+)
+
+var webhookEvents = []WebhookEvent{WebhookEndOfTurn, WebhookError, WebhookGitStateChange, WebhookCancelled}
+
+const (
+	// webhookMaxAttempts is how many times a delivery is attempted before giving up
+	webhookMaxAttempts = 5
+	// defaultWebhookRetryDelay is the delay before the first retry; it doubles after each one
+	defaultWebhookRetryDelay = 2 * time.Second
+	// webhookTimeout bounds each delivery attempt
+	webhookTimeout = 10 * time.Second
+	// WebhookSignatureHeader carries the HMAC-SHA256 of the body, keyed with the webhook's secret
+	WebhookSignatureHeader = "X-Shelley-Signature"
+	// maxWebhookDeliveries caps how many deliveries, payloads included, one request lists
+	maxWebhookDeliveries = 200
+	// webhookDeliveryRetention is how long deliveries are logged before PruneWebhookDeliveries deletes them
+	webhookDeliveryRetention = 30 * 24 * time.Hour
+)
+
+// WebhookConfig is a webhook: a URL that is POSTed a WebhookPayload for each event.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// Secret signs the payloads. Webhooks in shelley.json must have one; for those added
+	// through the API, one is generated if not given.
+	Secret string `json:"secret,omitempty"`
+	// Events are the events sent (optional, defaults to all of them)
+	Events []WebhookEvent `json:"events,omitempty"`
+}
+
+// validate checks that the webhook has an HTTP URL and known events.
+func (c WebhookConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL %q is not an http or https URL", c.URL)
+	}
+	for _, event := range c.Events {
+		if !slices.Contains(webhookEvents, event) {
+			return fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	return nil
+}
+
+// wants reports whether the webhook is sent event.
+func (c WebhookConfig) wants(event WebhookEvent) bool {
+	return len(c.Events) == 0 || slices.Contains(c.Events, event)
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks.
+type WebhookPayload struct {
+	Event          WebhookEvent `json:"event"`
+	ConversationID string       `json:"conversation_id"`
+	Slug           string       `json:"slug,omitempty"`
+	// LastAgentText is the text of the agent's latest message
+	LastAgentText string `json:"last_agent_text"`
+	// Detail is the error for error events, and the new state for git_state_change events
+	Detail string `json:"detail,omitempty"`
+	// Usage is what the conversation has used so far, not counting what a fork copied from its parent
+	Usage llm.Usage `json:"usage"`
+	Time  time.Time `json:"time"`
+}
+
+// WebhookSignature returns the value of the WebhookSignatureHeader for body.
+// Receivers compute it with their copy of the secret and compare.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SetWebhooks sets the webhooks configured in shelley.json. Webhooks added through the API are sent events too.
+func (s *Server) SetWebhooks(webhooks []WebhookConfig) error {
+	for _, webhook := range webhooks {
+		if err := webhook.validate(); err != nil {
+			return err
+		}
+		if webhook.Secret == "" {
+			return fmt.Errorf("webhook %s has no secret to sign its payloads with", webhook.URL)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks = webhooks
+	return nil
+}
+
+// SetAllowPrivateWebhooks sets whether webhooks added through the API may target loopback,
+// private and link-local addresses. They can't by default, since any API client could
+// otherwise have conversations sent to services only reachable from the server, such as
+// cloud metadata endpoints. Webhooks in shelley.json may always target them.
+func (s *Server) SetAllowPrivateWebhooks(allowed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allowPrivateWebhooks = allowed
+}
+
+// isPrivateAddr reports whether ip is only reachable from the server or its network.
+func isPrivateAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
+
+// checkPublicHost returns an error if host is, or resolves to, a private address.
+func checkPublicHost(ctx context.Context, host string) error {
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("cannot resolve webhook host %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if isPrivateAddr(ip) {
+			return fmt.Errorf("webhook host %s is a private address (%s)", host, ip)
+		}
+	}
+	return nil
+}
+
+// publicOnlyKey marks the context of a delivery that must not connect to private addresses
+type publicOnlyKey struct{}
+
+// newWebhookClient returns the client that delivers webhooks. Deliveries whose context
+// is marked with publicOnlyKey fail to connect to private addresses, whatever the
+// webhook's host resolved to when it was added, and bypass any proxy in HTTP_PROXY or
+// HTTPS_PROXY, through which the dialer would only see the proxy's address.
+func newWebhookClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if req.Context().Value(publicOnlyKey{}) != nil {
+			return nil, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialer := net.Dialer{Timeout: webhookTimeout}
+		if ctx.Value(publicOnlyKey{}) != nil {
+			dialer.Control = func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				if isPrivateAddr(addrPort.Addr()) {
+					return fmt.Errorf("webhook connection to private address %s refused", addrPort.Addr())
+				}
+				return nil
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+// CloseWebhooks stops sending webhooks for new events and waits until those of the events so far
+// are delivered or have failed for good, or until ctx is done. Deliveries still being retried
+// then are abandoned, and logged as undelivered.
+func (s *Server) CloseWebhooks(ctx context.Context) {
+	s.mu.Lock()
+	s.webhooksClosed = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.webhookWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.stopWebhooks()
+		<-done
+	}
+}
+
+// PruneWebhookDeliveries deletes the logged webhook deliveries that are past their retention.
+func (s *Server) PruneWebhookDeliveries(ctx context.Context) {
+	deleted, err := s.db.DeleteWebhookDeliveriesBefore(ctx, time.Now().Add(-webhookDeliveryRetention))
+	if err != nil {
+		s.logger.Warn("Failed to prune webhook deliveries", "error", err)
+		return
+	}
+	if deleted > 0 {
+		s.logger.Info("Pruned webhook deliveries", "count", deleted, "retention", webhookDeliveryRetention)
+	}
+}
+
+// webhookTarget is a webhook an event is sent to
+type webhookTarget struct {
+	WebhookConfig
+	id *int64 // nil for webhooks in shelley.json
+}
+
+// webhookTargets returns the webhooks that are sent event.
+func (s *Server) webhookTargets(ctx context.Context, event WebhookEvent) ([]webhookTarget, error) {
+	var targets []webhookTarget
+	s.mu.Lock()
+	for _, webhook := range s.webhooks {
+		if webhook.wants(event) {
+			targets = append(targets, webhookTarget{WebhookConfig: webhook})
+		}
+	}
+	s.mu.Unlock()
+
+	stored, err := s.db.ListWebhooks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	for _, row := range stored {
+		webhook, err := webhookFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		if webhook.wants(event) {
+			targets = append(targets, webhookTarget{WebhookConfig: webhook, id: &row.WebhookID})
+		}
+	}
+	return targets, nil
+}
+
+// webhookFromRow converts a webhook stored in the database
+func webhookFromRow(row generated.Webhook) (WebhookConfig, error) {
+	webhook := WebhookConfig{URL: row.Url, Secret: row.Secret}
+	if err := json.Unmarshal([]byte(row.Events), &webhook.Events); err != nil {
+		return WebhookConfig{}, fmt.Errorf("webhook %d has invalid events: %w", row.WebhookID, err)
+	}
+	return webhook, nil
+}
+
+// webhookPayload describes event in the conversation as it is now.
+func (s *Server) webhookPayload(ctx context.Context, conversationID string, event WebhookEvent, detail string) (*WebhookPayload, error) {
+	conversation, err := s.db.GetConversationByID(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		messages  []generated.Message
+		usageData []*string
+	)
+	err = s.db.Queries(ctx, func(q *generated.Queries) error {
+		var err error
+		messages, err = q.ListMessages(ctx, conversationID)
+		if err != nil {
+			return err
+		}
+		usageData, err = q.ListMessageUsage(ctx, conversationID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	payload := &WebhookPayload{
+		Event:          event,
+		ConversationID: conversationID,
+		Slug:           derefString(conversation.Slug),
+		Detail:         detail,
+		Usage:          sumUsage(usageData),
+		Time:           time.Now().UTC(),
+	}
+	for _, msg := range slices.Backward(messages) {
+		if msg.Type != string(db.MessageTypeAgent) {
+			continue
+		}
+		if llmMsg, err := convertToLLMMessage(msg); err == nil && messageText(llmMsg) != "" {
+			payload.LastAgentText = messageText(llmMsg)
+			break
+		}
+	}
+	return payload, nil
+}
+
+// sendWebhooks sends event to the webhooks that want it, in the background.
+// Deliveries outlive the request that caused the event, until the webhooks are closed.
+func (s *Server) sendWebhooks(ctx context.Context, conversationID string, event WebhookEvent, detail string) {
+	s.mu.Lock()
+	if s.webhooksClosed {
+		s.mu.Unlock()
+		s.logger.Warn("Webhooks closed, not sending event", "conversationID", conversationID, "event", event)
+		return
+	}
+	// Added under the lock, so CloseWebhooks can't start waiting before this is counted
+	s.webhookWG.Add(1)
+	s.mu.Unlock()
+
+	ctx = s.webhookCtx
+	go func() {
+		defer s.webhookWG.Done()
+		targets, err := s.webhookTargets(ctx, event)
+		if err != nil {
+			s.logger.Error("Failed to find webhooks", "event", event, "error", err)
+			return
+		}
+		if len(targets) == 0 {
+			return
+		}
+		payload, err := s.webhookPayload(ctx, conversationID, event, detail)
+		if err != nil {
+			s.logger.Error("Failed to build webhook payload", "conversationID", conversationID, "event", event, "error", err)
+			return
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			s.logger.Error("Failed to encode webhook payload", "error", err)
+			return
+		}
+		for _, target := range targets {
+			s.webhookWG.Go(func() {
+				s.deliverWebhook(ctx, target, conversationID, event, body)
+			})
+		}
+	}()
+}
+
+// deliverWebhook POSTs body to a webhook, retrying with exponential backoff, and logs each attempt.
+// It gives up once ctx is done.
+func (s *Server) deliverWebhook(ctx context.Context, target webhookTarget, conversationID string, event WebhookEvent, body []byte) {
+	// The delivery log is written even once ctx is done, to record the last attempt
+	logCtx := context.WithoutCancel(ctx)
+	deliveryID, err := s.db.CreateWebhookDelivery(logCtx, generated.CreateWebhookDeliveryParams{
+		WebhookID:      target.id,
+		Url:            target.URL,
+		Event:          string(event),
+		ConversationID: conversationID,
+		Payload:        string(body),
+	})
+	if err != nil {
+		s.logger.Error("Failed to log webhook delivery", "url", target.URL, "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	publicOnly := target.id != nil && !s.allowPrivateWebhooks
+	s.mu.Unlock()
+	if publicOnly {
+		ctx = context.WithValue(ctx, publicOnlyKey{}, true)
+	}
+
+	delay := s.webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		statusCode, err := s.postWebhook(ctx, target.WebhookConfig, deliveryID, event, body)
+		var deliveredAt *time.Time
+		if err == nil {
+			now := time.Now()
+			deliveredAt = &now
+		}
+		if logErr := s.db.RecordWebhookDeliveryAttempt(logCtx, deliveryID, statusCode, err, deliveredAt); logErr != nil {
+			s.logger.Warn("Failed to log webhook delivery attempt", "deliveryID", deliveryID, "error", logErr)
+		}
+		if err == nil {
+			return
+		}
+		// Client errors other than timeouts and rate limits won't go away by retrying
+		retryable := statusCode == 0 || statusCode >= 500 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+		if !retryable || attempt == webhookMaxAttempts {
+			s.logger.Warn("Webhook delivery failed", "url", target.URL, "deliveryID", deliveryID, "attempts", attempt, "error", err)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			s.logger.Warn("Webhook delivery abandoned", "url", target.URL, "deliveryID", deliveryID, "attempts", attempt, "error", err)
+			return
+		}
+		delay *= 2
+	}
+}
+
+// postWebhook makes one attempt to deliver body and returns the response status, or 0 if there was none.
+func (s *Server) postWebhook(ctx context.Context, webhook WebhookConfig, deliveryID int64, event WebhookEvent, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Shelley-Webhook")
+	req.Header.Set("X-Shelley-Event", string(event))
+	req.Header.Set("X-Shelley-Delivery", strconv.FormatInt(deliveryID, 10))
+	if webhook.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(webhook.Secret, body))
+	}
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// APIWebhook is a webhook as the API lists it
+type APIWebhook struct {
+	// ID identifies webhooks added through the API; those in shelley.json have none
+	ID     int64          `json:"id,omitempty"`
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events,omitempty"`
+	// Secret is only returned when the webhook is created
+	Secret    string     `json:"secret,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// handleListWebhooks handles GET /api/webhooks
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks := []APIWebhook{}
+	s.mu.Lock()
+	for _, webhook := range s.webhooks {
+		webhooks = append(webhooks, APIWebhook{URL: webhook.URL, Events: webhook.Events})
+	}
+	s.mu.Unlock()
+
+	rows, err := s.db.ListWebhooks(r.Context())
+	if err != nil {
+		s.logger.Error("Failed to list webhooks", "error", err)
+		http.Error(w, "Failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+	for _, row := range rows {
+		webhook, err := webhookFromRow(row)
+		if err != nil {
+			s.logger.Error("Failed to list webhooks", "error", err)
+			http.Error(w, "Failed to list webhooks", http.StatusInternalServerError)
+			return
+		}
+		webhooks = append(webhooks, APIWebhook{ID: row.WebhookID, URL: webhook.URL, Events: webhook.Events, CreatedAt: &row.CreatedAt})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+// handleCreateWebhook handles POST /api/webhooks. A secret is generated unless one is given.
+func (s *Server) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook WebhookConfig
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := webhook.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	allowPrivate := s.allowPrivateWebhooks
+	s.mu.Unlock()
+	if !allowPrivate {
+		u, _ := url.Parse(webhook.URL)
+		if err := checkPublicHost(r.Context(), u.Hostname()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = rand.Text()
+	}
+	// Stored as [] rather than null when all events are wanted
+	events, _ := json.Marshal(append([]WebhookEvent{}, webhook.Events...))
+
+	row, err := s.db.CreateWebhook(r.Context(), generated.CreateWebhookParams{
+		Url:    webhook.URL,
+		Secret: webhook.Secret,
+		Events: string(events),
+	})
+	if err != nil {
+		s.logger.Error("Failed to create webhook", "error", err)
+		http.Error(w, "Failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIWebhook{
+		ID:        row.WebhookID,
+		URL:       row.Url,
+		Events:    webhook.Events,
+		Secret:    row.Secret,
+		CreatedAt: &row.CreatedAt,
+	})
+}
+
+// handleDeleteWebhook handles DELETE /api/webhooks/{id}
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+	err = s.db.DeleteWebhook(r.Context(), id)
+	if errors.Is(err, db.ErrWebhookNotFound) {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to delete webhook", "webhookID", id, "error", err)
+		http.Error(w, "Failed to delete webhook", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListWebhookDeliveries handles GET /api/webhooks/deliveries, newest first
+func (s *Server) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = min(l, maxWebhookDeliveries)
+		}
+	}
+	deliveries, err := s.db.ListWebhookDeliveries(r.Context(), int64(limit))
+	if err != nil {
+		s.logger.Error("Failed to list webhook deliveries", "error", err)
+		http.Error(w, "Failed to list webhook deliveries", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is a webhook endpoint that answers with the queued statuses, then 200
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	received chan *http.Request
+	bodies   chan []byte
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{statuses: statuses, received: make(chan *http.Request, 10), bodies: make(chan []byte, 10)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+		r.received <- req
+		r.bodies <- body
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// next returns the next request the receiver got and its payload
+func (r *webhookReceiver) next(t *testing.T) (*http.Request, []byte) {
+	t.Helper()
+	select {
+	case req := <-r.received:
+		return req, <-r.bodies
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a webhook")
+		return nil, nil
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+
+	configured := newWebhookReceiver(t)
+	if err := h.server.SetWebhooks([]WebhookConfig{{URL: configured.URL, Secret: "s3cret", Events: []WebhookEvent{WebhookEndOfTurn}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.server.SetWebhooks([]WebhookConfig{{URL: "ftp://example.com", Secret: "s3cret"}}); err == nil {
+		t.Error("SetWebhooks accepted a non-HTTP URL")
+	}
+	if err := h.server.SetWebhooks([]WebhookConfig{{URL: configured.URL}}); err == nil {
+		t.Error("SetWebhooks accepted a webhook without a secret")
+	}
+
+	// API clients can't have the server post to addresses only it can reach, unless allowed
+	for _, private := range []string{"http://127.0.0.1:8080/hook", "http://169.254.169.254/latest", "http://[::1]/hook", "http://10.0.0.1/hook"} {
+		body, _ := json.Marshal(WebhookConfig{URL: private})
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", "/api/webhooks", bytes.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /api/webhooks %s: status %d, want 400", private, rec.Code)
+		}
+	}
+	h.server.SetAllowPrivateWebhooks(true)
+
+	added := newWebhookReceiver(t)
+	body, _ := json.Marshal(WebhookConfig{URL: added.URL, Events: []WebhookEvent{WebhookError, WebhookCancelled}})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/api/webhooks", bytes.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /api/webhooks: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var created APIWebhook
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.ID == 0 || created.Secret == "" {
+		t.Errorf("created webhook %+v, want an ID and a generated secret", created)
+	}
+
+	h.NewConversation("echo: tests pass", "")
+	h.WaitResponse()
+	req, payloadData := configured.next(t)
+	if got, want := req.Header.Get(WebhookSignatureHeader), WebhookSignature("s3cret", payloadData); got != want {
+		t.Errorf("signature %q, want %q", got, want)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(payloadData, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != WebhookEndOfTurn || payload.ConversationID != h.ConversationID() || payload.LastAgentText != "tests pass" || payload.Usage.OutputTokens == 0 {
+		t.Errorf("payload %+v, want the end of the turn with the agent's text and usage", payload)
+	}
+
+	h.Chat("error: overloaded")
+	req, payloadData = added.next(t)
+	json.Unmarshal(payloadData, &payload)
+	if payload.Event != WebhookError || !strings.Contains(payload.Detail, "overloaded") || payload.LastAgentText != "tests pass" {
+		t.Errorf("payload %+v, want the error", payload)
+	}
+	if req.Header.Get(WebhookSignatureHeader) != WebhookSignature(created.Secret, payloadData) {
+		t.Error("payload not signed with the generated secret")
+	}
+
+	h.Chat("delay: 5")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/api/conversation/"+h.ConversationID()+"/cancel", nil))
+	// The cancelled request may be reported as an error first
+	for payload.Event != WebhookCancelled {
+		_, payloadData = added.next(t)
+		json.Unmarshal(payloadData, &payload)
+	}
+	h.server.CloseWebhooks(context.Background())
+	if len(configured.received) != 0 {
+		_, payloadData = configured.next(t)
+		t.Errorf("cancelling the turn also sent %s", payloadData)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/webhooks", nil))
+	var listed []APIWebhook
+	json.Unmarshal(rec.Body.Bytes(), &listed)
+	if len(listed) != 2 || listed[0].ID != 0 || listed[1].ID != created.ID || listed[1].Secret != "" {
+		t.Errorf("listed %+v, want the configured webhook and the added one, without secrets", listed)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{"DELETE", "/api/webhooks/" + strconv.FormatInt(created.ID, 10), http.StatusNoContent},
+		{"DELETE", "/api/webhooks/" + strconv.FormatInt(created.ID, 10), http.StatusNotFound},
+		{"DELETE", "/api/webhooks/nope", http.StatusBadRequest},
+	} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, rec.Code, tc.code)
+		}
+	}
+	for _, invalid := range []string{`{"url": "not a url"}`, `{"url": "https://example.com", "events": ["lunch"]}`, `nope`} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(invalid)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /api/webhooks %s: status %d, want 400", invalid, rec.Code)
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	h := NewTestHarness(t)
+	defer h.Close()
+	h.server.webhookRetryDelay = time.Millisecond
+
+	flaky := newWebhookReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	rejecting := newWebhookReceiver(t, http.StatusBadRequest)
+	if err := h.server.SetWebhooks([]WebhookConfig{{URL: flaky.URL, Secret: "s3cret"}, {URL: rejecting.URL, Secret: "s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.NewConversation("echo: hi", "")
+	h.WaitResponse()
+	for range 3 {
+		flaky.next(t)
+	}
+	rejecting.next(t)
+	h.server.CloseWebhooks(context.Background())
+
+	mux := http.NewServeMux()
+	h.server.RegisterRoutes(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/webhooks/deliveries", nil))
+	var deliveries []struct {
+		URL         string     `json:"url"`
+		Event       string     `json:"event"`
+		Attempts    int        `json:"attempts"`
+		StatusCode  int        `json:"status_code"`
+		Error       *string    `json:"error"`
+		DeliveredAt *time.Time `json:"delivered_at"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &deliveries); err != nil {
+		t.Fatalf("deliveries: %v: %s", err, rec.Body.String())
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("got %d deliveries, want one per webhook: %s", len(deliveries), rec.Body.String())
+	}
+	for _, d := range deliveries {
+		switch d.URL {
+		case flaky.URL:
+			if d.Attempts != 3 || d.StatusCode != http.StatusOK || d.Error != nil || d.DeliveredAt == nil {
+				t.Errorf("flaky delivery %+v, want delivered on the third attempt", d)
+			}
+		case rejecting.URL:
+			if d.Attempts != 1 || d.StatusCode != http.StatusBadRequest || d.Error == nil || d.DeliveredAt != nil {
+				t.Errorf("rejected delivery %+v, want one failed attempt", d)
+			}
+		}
+	}
+}
+
+func TestWebhookClientBypassesProxyForPublicOnlyDeliveries(t *testing.T) {
+	// Through a proxy, the dialer's check would only see the proxy's address
+	transport := newWebhookClient().Transport.(*http.Transport)
+	req := httptest.NewRequest("POST", "http://hooks.example.com/hook", nil)
+	req = req.WithContext(context.WithValue(req.Context(), publicOnlyKey{}, true))
+	if proxyURL, err := transport.Proxy(req); proxyURL != nil || err != nil {
+		t.Errorf("public-only delivery goes through proxy %v (%v), want a direct connection", proxyURL, err)
+	}
+}
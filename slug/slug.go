@@ -24,14 +24,18 @@ func GenerateSlug(ctx context.Context, llmProvider LLMServiceProvider, database
 	if err != nil {
 		return "", err
 	}
+	return SetUnique(ctx, database, logger, conversationID, baseSlug)
+}
 
+// SetUnique sets baseSlug as the conversation's slug, adding a numeric suffix if it is already taken
+func SetUnique(ctx context.Context, database *db.DB, logger *slog.Logger, conversationID, baseSlug string) (string, error) {
 	// Try to update with the base slug first, then with numeric suffixes if needed
 	slug := baseSlug
 	for attempt := 0; attempt < 100; attempt++ {
-		_, err = database.UpdateConversationSlug(ctx, conversationID, slug)
+		_, err := database.UpdateConversationSlug(ctx, conversationID, slug)
 		if err == nil {
 			// Success!
-			logger.Info("Generated slug for conversation", "conversationID", conversationID, "slug", slug)
+			logger.Info("Set slug for conversation", "conversationID", conversationID, "slug", slug)
 			return slug, nil
 		}
 
@@ -97,47 +101,50 @@ The slug should:
 - Be concise and descriptive
 - Use only lowercase letters, numbers, and hyphens
 - Capture the main topic or intent
-- Be suitable as a filename or URL path
-
-Respond with only the slug, nothing else.`, userMessage)
-
-	message := llm.Message{
-		Role: llm.MessageRoleUser,
-		Content: []llm.Content{
-			{Type: llm.ContentTypeText, Text: slugPrompt},
-		},
-	}
+- Be suitable as a filename or URL path`, userMessage)
 
 	request := &llm.Request{
-		Messages: []llm.Message{message},
+		Messages:       []llm.Message{llm.UserStringMessage(slugPrompt)},
+		ResponseFormat: &llm.ResponseFormat{Name: "conversation_slug", Schema: slugSchema},
 	}
 
 	// Make LLM request with timeout
 	ctxWithTimeout, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	response, err := llmService.Do(ctxWithTimeout, request)
-	if err != nil {
+	var result slugResult
+	if err := llm.DoJSON(ctxWithTimeout, llmService, request, &result); err != nil {
 		return "", fmt.Errorf("failed to generate slug: %w", err)
 	}
 
-	// Extract text from response
-	if len(response.Content) == 0 {
-		return "", fmt.Errorf("empty response from LLM")
-	}
-
-	slug := strings.TrimSpace(response.Content[0].Text)
-
-	// Clean and validate the slug
-	slug = Sanitize(slug)
-	if slug == "" {
-		return "", fmt.Errorf("generated slug is empty after sanitization")
-	}
-
 	// Note: We don't check for uniqueness here since we're generating for a new conversation
 	// and the database will handle any conflicts
 
-	return slug, nil
+	return Sanitize(result.Slug), nil
+}
+
+var slugSchema = llm.MustSchema(`{
+  "type": "object",
+  "required": ["slug"],
+  "properties": {
+    "slug": {
+      "type": "string",
+      "description": "2-6 lowercase words separated by hyphens, e.g. fix-login-redirect"
+    }
+  }
+}`)
+
+// slugResult is the structured response to a slug request
+type slugResult struct {
+	Slug string `json:"slug"`
+}
+
+// Validate rejects slugs that are empty once sanitized, so that the model is asked again
+func (r *slugResult) Validate() error {
+	if Sanitize(r.Slug) == "" {
+		return fmt.Errorf("slug %q has no letters or digits", r.Slug)
+	}
+	return nil
 }
 
 // Sanitize cleans a string to be a valid slug
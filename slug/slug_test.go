@@ -119,7 +119,7 @@ func TestGenerateSlug_DatabaseIntegration(t *testing.T) {
 	// Create mock LLM provider that always returns the same slug
 	mockLLM := &MockLLMProvider{
 		Service: &MockLLMService{
-			ResponseText: "test-slug", // Always return the same slug to force conflicts
+			ResponseText: `{"slug": "test-slug"}`, // Always return the same slug to force conflicts
 		},
 	}
 
@@ -180,3 +180,47 @@ func TestGenerateSlug_DatabaseIntegration(t *testing.T) {
 
 	t.Logf("Successfully generated unique slugs: %q, %q, %q", slug1, slug2, slug3)
 }
+
+// scriptedLLMService returns its responses in turn and records the requests it receives
+type scriptedLLMService struct {
+	MockLLMService
+	responses []string
+	requests  []*llm.Request
+}
+
+func (s *scriptedLLMService) Do(ctx context.Context, req *llm.Request) (*llm.Response, error) {
+	s.requests = append(s.requests, req)
+	text := s.responses[0]
+	s.responses = s.responses[1:]
+	return &llm.Response{Content: []llm.Content{{Type: llm.ContentTypeText, Text: text}}}, nil
+}
+
+func TestGenerateSlugTextStructured(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	service := &scriptedLLMService{responses: []string{
+		"Sure! I'd call it `fix-login`.",
+		"Here you go:\n```json\n{\"slug\": \"Fix Login Redirect\"}\n```",
+	}}
+
+	slug, err := generateSlugText(context.Background(), &scriptedProvider{service: service}, logger, "fix the login redirect", "")
+	if err != nil {
+		t.Fatalf("generateSlugText: %v", err)
+	}
+	if slug != "fix-login-redirect" {
+		t.Errorf("slug = %q, want fix-login-redirect", slug)
+	}
+	if len(service.requests) != 2 {
+		t.Fatalf("got %d requests, want a retry after the chatty answer", len(service.requests))
+	}
+	if service.requests[0].ResponseFormat == nil {
+		t.Error("slug request has no response format")
+	}
+}
+
+type scriptedProvider struct {
+	service *scriptedLLMService
+}
+
+func (p *scriptedProvider) GetService(modelID string) (llm.Service, error) {
+	return p.service, nil
+}
@@ -5,6 +5,14 @@ import (
 	"sync"
 )
 
+// bufferSize is the number of messages a subscriber can fall behind by before
+// Publish disconnects it. Broadcast only uses the first broadcastSlots of them,
+// so transient messages never crowd out the indexed ones.
+const (
+	bufferSize     = 10
+	broadcastSlots = bufferSize / 2
+)
+
 type SubPub[K any] struct {
 	mu          sync.Mutex
 	subscribers []*subscriber[K]
@@ -32,7 +40,7 @@ func (sp *SubPub[K]) Subscribe(ctx context.Context, idx int64) func() (K, bool)
 	subCtx, cancel := context.WithCancel(ctx)
 
 	// Buffered channel to avoid blocking publishers
-	ch := make(chan K, 10)
+	ch := make(chan K, bufferSize)
 	sub := &subscriber[K]{
 		idx:    idx,
 		ch:     ch,
@@ -106,3 +114,59 @@ func (sp *SubPub[K]) Publish(idx int64, message K) {
 	}
 	sp.subscribers = remaining
 }
+
+// Notify sends a message to all subscribers without advancing their index, for updates
+// that belong to no index, such as a change to what the messages are about. Like Publish,
+// it disconnects subscribers that are behind rather than dropping the message.
+func (sp *SubPub[K]) Notify(message K) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	remaining := sp.subscribers[:0]
+	for _, sub := range sp.subscribers {
+		select {
+		case <-sub.ctx.Done():
+			close(sub.ch)
+			continue
+		default:
+		}
+
+		select {
+		case sub.ch <- message:
+			remaining = append(remaining, sub)
+		default:
+			close(sub.ch)
+			sub.cancel()
+		}
+	}
+	sp.subscribers = remaining
+}
+
+// Broadcast sends a transient message to all subscribers without advancing their index.
+// It is meant for ephemeral updates, such as partial results, that are not part of the
+// indexed sequence. Subscribers that are not keeping up miss the message rather than
+// being disconnected, and it is never queued past broadcastSlots, leaving room for the
+// next Publish.
+func (sp *SubPub[K]) Broadcast(message K) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	remaining := sp.subscribers[:0]
+	for _, sub := range sp.subscribers {
+		select {
+		case <-sub.ctx.Done():
+			close(sub.ch)
+			continue
+		default:
+		}
+
+		if len(sub.ch) < broadcastSlots {
+			select {
+			case sub.ch <- message:
+			default:
+			}
+		}
+		remaining = append(remaining, sub)
+	}
+	sp.subscribers = remaining
+}
@@ -260,3 +260,121 @@ func TestSubPubMultiplePublishes(t *testing.T) {
 		}
 	})
 }
+
+func TestSubPubBroadcast(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		sp := New[string]()
+		ctx := context.Background()
+
+		next := sp.Subscribe(ctx, 5)
+
+		// Broadcasts reach subscribers regardless of index and do not advance it
+		sp.Broadcast("partial")
+		sp.Publish(6, "final")
+
+		for _, want := range []string{"partial", "final"} {
+			msg, ok := next()
+			if !ok {
+				t.Fatalf("Expected %q, got closed channel", want)
+			}
+			if msg != want {
+				t.Errorf("Expected %q, got %q", want, msg)
+			}
+		}
+	})
+}
+
+func TestSubPubBroadcastSlowSubscriber(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		sp := New[string]()
+		ctx := context.Background()
+
+		next := sp.Subscribe(ctx, 0)
+
+		// Overflow the subscriber's buffer; extra broadcasts are dropped
+		for i := range 20 {
+			sp.Broadcast(fmt.Sprintf("partial %d", i))
+		}
+		for range broadcastSlots {
+			if _, ok := next(); !ok {
+				t.Fatal("Expected buffered message, got closed channel")
+			}
+		}
+
+		// The subscriber is still connected
+		sp.Publish(1, "final")
+		msg, ok := next()
+		if !ok {
+			t.Fatal("Expected subscriber to remain connected")
+		}
+		if msg != "final" {
+			t.Errorf("Expected 'final', got %q", msg)
+		}
+	})
+}
+
+func TestSubPubBroadcastThenPublish(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		sp := New[string]()
+		ctx := context.Background()
+
+		next := sp.Subscribe(ctx, 0)
+
+		// A burst of broadcasts the subscriber hasn't read yet must not
+		// cost it the next published message
+		for i := range 20 {
+			sp.Broadcast(fmt.Sprintf("partial %d", i))
+		}
+		sp.Publish(1, "final")
+
+		var got []string
+		for {
+			msg, ok := next()
+			if !ok {
+				t.Fatalf("Subscriber disconnected after %v", got)
+			}
+			got = append(got, msg)
+			if msg == "final" {
+				break
+			}
+		}
+		if len(got) != broadcastSlots+1 || got[0] != "partial 0" {
+			t.Errorf("Expected %d partials then 'final', got %v", broadcastSlots, got)
+		}
+	})
+}
+
+func TestSubPubNotify(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		sp := New[string]()
+		ctx := context.Background()
+
+		next := sp.Subscribe(ctx, 1)
+
+		// A notification reaches subscribers at any index and leaves it alone,
+		// so a message published after it with an earlier index still arrives
+		sp.Notify("renamed")
+		sp.Publish(2, "second")
+
+		for _, want := range []string{"renamed", "second"} {
+			msg, ok := next()
+			if !ok {
+				t.Fatalf("Expected %q, got closed channel", want)
+			}
+			if msg != want {
+				t.Errorf("Expected %q, got %q", want, msg)
+			}
+		}
+
+		// Subscribers that are behind are disconnected rather than missing it
+		for range bufferSize {
+			sp.Notify("renamed again")
+		}
+		sp.Notify("renamed once more")
+		for {
+			if _, ok := next(); !ok {
+				break
+			}
+		}
+	})
+}
@@ -151,7 +151,7 @@ test.describe('File Upload via Paste and Drag', () => {
     await expect(messageInput).toHaveValue('Hello, this is a test message');
   });
 
-  test('simulated file drop shows loading placeholder then file path', async ({ page }) => {
+  test('simulated file drop adds an attachment chip', async ({ page }) => {
     await page.goto('/');
     await page.waitForLoadState('domcontentloaded');
 
@@ -185,14 +185,7 @@ test.describe('File Upload via Paste and Drag', () => {
       }
     });
 
-    // Wait for the upload to complete (should show loading then path)
-    await page.waitForTimeout(500);
-
-    // After upload, the input should contain a file path reference
-    const inputValue = await messageInput.inputValue();
-
-    // Either the file was uploaded successfully (contains path) or there was an error
-    // Both are acceptable as we're testing the UI flow
-    expect(inputValue).toBeTruthy();
+    // The uploaded file is shown as an attachment to send with the next message
+    await expect(page.getByTestId('attachment-chip')).toContainText('test-drop.txt');
   });
 });
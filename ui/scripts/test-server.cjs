@@ -39,14 +39,18 @@ getAvailablePort().then(port => {
   console.log(`Starting test server on port ${port}`);
   
   // Start Shelley server with test configuration
-  const serverProcess = spawn('go', [
+  const args = [
     'run', './cmd/shelley',
     '--model', 'predictable',
     '--predictable-only',
     '--db', testDb,
-    'serve',
-    '--port', port.toString()
-  ], {
+  ];
+  if (process.env.PREDICTABLE_SCRIPT) {
+    // Scenario file scripting the predictable model, relative to the repository root
+    args.push('--predictable-script', process.env.PREDICTABLE_SCRIPT);
+  }
+  args.push('serve', '--port', port.toString());
+  const serverProcess = spawn('go', args, {
     cwd: path.join(__dirname, '../..'),
     stdio: 'inherit',
     env: {
@@ -1,7 +1,7 @@
 import React, { useState, useEffect, useCallback, useRef } from "react";
 import ChatInterface from "./components/ChatInterface";
 import ConversationDrawer from "./components/ConversationDrawer";
-import { Conversation } from "./types";
+import { Attachment, Conversation, Thinking } from "./types";
 import { api } from "./services/api";
 
 // Check if a slug is a generated ID (format: cXXXX where X is alphanumeric)
@@ -175,6 +175,11 @@ function App() {
     );
   };
 
+  const handleConversationForked = (conversation: Conversation) => {
+    setConversations((prev) => [conversation, ...prev]);
+    setCurrentConversationId(conversation.conversation_id);
+  };
+
   if (loading && conversations.length === 0) {
     return (
       <div className="loading-container">
@@ -208,9 +213,21 @@ function App() {
   // Get the CWD from the most recent conversation (first in list, sorted by updated_at desc)
   const mostRecentCwd = conversations.length > 0 ? conversations[0].cwd : null;
 
-  const handleFirstMessage = async (message: string, model: string, cwd?: string) => {
+  const handleFirstMessage = async (
+    message: string,
+    model: string,
+    cwd?: string,
+    thinking?: Thinking,
+    attachments?: Attachment[],
+  ) => {
     try {
-      const response = await api.sendMessageWithNewConversation({ message, model, cwd });
+      const response = await api.sendMessageWithNewConversation({
+        message,
+        model,
+        cwd,
+        thinking,
+        attachments,
+      });
       const newConversationId = response.conversation_id;
 
       // Fetch the new conversation details
@@ -248,6 +265,7 @@ function App() {
           currentConversation={currentConversation}
           onConversationUpdate={updateConversation}
           onFirstMessage={handleFirstMessage}
+          onConversationForked={handleConversationForked}
           mostRecentCwd={mostRecentCwd}
         />
       </div>
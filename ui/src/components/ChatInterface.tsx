@@ -1,5 +1,14 @@
 import React, { useState, useEffect, useRef } from "react";
-import { Message, Conversation, StreamResponse, LLMContent } from "../types";
+import {
+  Message,
+  Conversation,
+  StreamResponse,
+  StreamDelta,
+  LLMContent,
+  Thinking,
+  CacheReport,
+  Attachment,
+} from "../types";
 import { api } from "../services/api";
 import { ThemeMode, getStoredTheme, setStoredTheme, applyTheme } from "../services/theme";
 import MessageComponent from "./Message";
@@ -17,14 +26,21 @@ import BrowserConsoleLogsTool from "./BrowserConsoleLogsTool";
 import ChangeDirTool from "./ChangeDirTool";
 import BrowserResizeTool from "./BrowserResizeTool";
 import DirectoryPickerModal from "./DirectoryPickerModal";
+import Modal from "./Modal";
 
 interface ContextUsageBarProps {
   contextWindowSize: number;
   maxContextTokens: number;
+  conversationId?: string | null;
 }
 
-function ContextUsageBar({ contextWindowSize, maxContextTokens }: ContextUsageBarProps) {
+function ContextUsageBar({
+  contextWindowSize,
+  maxContextTokens,
+  conversationId,
+}: ContextUsageBarProps) {
   const [showPopup, setShowPopup] = useState(false);
+  const [cacheReport, setCacheReport] = useState<CacheReport | null>(null);
   const barRef = useRef<HTMLDivElement>(null);
 
   const percentage = maxContextTokens > 0 ? (contextWindowSize / maxContextTokens) * 100 : 0;
@@ -58,6 +74,24 @@ function ContextUsageBar({ contextWindowSize, maxContextTokens }: ContextUsageBa
     return () => document.removeEventListener("click", handleClickOutside);
   }, [showPopup]);
 
+  // Fetch how well prompt caching works in the conversation when the popup opens
+  useEffect(() => {
+    if (!showPopup || !conversationId) {
+      setCacheReport(null);
+      return;
+    }
+    let cancelled = false;
+    api
+      .getCacheReport(conversationId)
+      .then((report) => {
+        if (!cancelled) setCacheReport(report);
+      })
+      .catch((err) => console.error("Failed to load cache report:", err));
+    return () => {
+      cancelled = true;
+    };
+  }, [showPopup, conversationId]);
+
   // Calculate fixed position when popup should be shown
   const [popupPosition, setPopupPosition] = useState<{ bottom: number; right: number } | null>(
     null,
@@ -96,6 +130,14 @@ function ContextUsageBar({ contextWindowSize, maxContextTokens }: ContextUsageBa
         >
           {formatTokens(contextWindowSize)} / {formatTokens(maxContextTokens)} (
           {percentage.toFixed(1)}%) tokens used
+          {cacheReport && cacheReport.response_count > 0 && (
+            <div title="Input tokens read from the prompt cache in this conversation">
+              Cache: {(cacheReport.hit_rate * 100).toFixed(0)}% hit (
+              {formatTokens(cacheReport.cache_read_input_tokens)} read,{" "}
+              {formatTokens(cacheReport.cache_creation_input_tokens)} written,{" "}
+              {formatTokens(cacheReport.input_tokens)} uncached)
+            </div>
+          )}
         </div>
       )}
       <div
@@ -346,13 +388,66 @@ function AnimatedWorkingStatus() {
   );
 }
 
+// Merge a partial-output delta into the in-progress blocks, keyed by block index
+function appendStreamDelta(blocks: StreamDelta[], delta: StreamDelta): StreamDelta[] {
+  const i = blocks.findIndex((b) => b.index === delta.index);
+  if (i === -1) {
+    return [...blocks, { ...delta }].sort((a, b) => a.index - b.index);
+  }
+  const next = [...blocks];
+  next[i] = {
+    ...next[i],
+    text: (next[i].text || "") + (delta.text || ""),
+    tool_name: next[i].tool_name || delta.tool_name,
+  };
+  return next;
+}
+
+// Agent response that is still being generated; replaced by the real message once it is recorded
+function StreamingMessage({ blocks }: { blocks: StreamDelta[] }) {
+  return (
+    <div className="message message-agent" data-testid="streaming-message">
+      <div className="message-content">
+        {blocks.map((block) => {
+          if (block.type === "thinking") {
+            return (
+              <div key={block.index} className="text-tertiary italic text-sm whitespace-pre-wrap">
+                {block.text}
+              </div>
+            );
+          }
+          if (block.type === "tool_use") {
+            return (
+              <div key={block.index} className="text-secondary text-sm">
+                Preparing {block.tool_name || "tool"} call...
+              </div>
+            );
+          }
+          return (
+            <div key={block.index} className="whitespace-pre-wrap break-words">
+              {block.text}
+            </div>
+          );
+        })}
+      </div>
+    </div>
+  );
+}
+
 interface ChatInterfaceProps {
   conversationId: string | null;
   onOpenDrawer: () => void;
   onNewConversation: () => void;
   currentConversation?: Conversation;
   onConversationUpdate?: (conversation: Conversation) => void;
-  onFirstMessage?: (message: string, model: string, cwd?: string) => Promise<void>;
+  onFirstMessage?: (
+    message: string,
+    model: string,
+    cwd?: string,
+    thinking?: Thinking,
+    attachments?: Attachment[],
+  ) => Promise<void>;
+  onConversationForked?: (conversation: Conversation) => void;
   mostRecentCwd?: string | null;
 }
 
@@ -363,6 +458,7 @@ function ChatInterface({
   currentConversation,
   onConversationUpdate,
   onFirstMessage,
+  onConversationForked,
   mostRecentCwd,
 }: ChatInterfaceProps) {
   const [messages, setMessages] = useState<Message[]>([]);
@@ -394,6 +490,17 @@ function ChatInterface({
     setSelectedModelState(model);
     localStorage.setItem("shelley_selected_model", model);
   };
+  // Reasoning effort for the next message; "" leaves thinking off. Sticky like the model.
+  const [thinkingEffort, setThinkingEffortState] = useState<string>(
+    () => localStorage.getItem("shelley_thinking_effort") || "",
+  );
+  const setThinkingEffort = (effort: string) => {
+    setThinkingEffortState(effort);
+    localStorage.setItem("shelley_thinking_effort", effort);
+  };
+  const thinking: Thinking | undefined = thinkingEffort
+    ? { effort: thinkingEffort as Thinking["effort"] }
+    : undefined;
   const [selectedCwd, setSelectedCwdState] = useState<string>("");
   const [cwdInitialized, setCwdInitialized] = useState(false);
   // Wrapper to persist cwd selection to localStorage
@@ -441,7 +548,11 @@ function ChatInterface({
   const [diffCommentText, setDiffCommentText] = useState("");
   const [agentWorking, setAgentWorking] = useState(false);
   const [cancelling, setCancelling] = useState(false);
+  const [editingMessage, setEditingMessage] = useState<{ sequenceId: number; text: string } | null>(
+    null,
+  );
   const [contextWindowSize, setContextWindowSize] = useState(0);
+  const [streamingBlocks, setStreamingBlocks] = useState<StreamDelta[]>([]);
   const terminalURL = window.__SHELLEY_INIT__?.terminal_url || null;
   const links = window.__SHELLEY_INIT__?.links || [];
   const hostname = window.__SHELLEY_INIT__?.hostname || "localhost";
@@ -455,9 +566,13 @@ function ChatInterface({
   const overflowMenuRef = useRef<HTMLDivElement>(null);
   const reconnectTimeoutRef = useRef<number | null>(null);
   const userScrolledRef = useRef(false);
+  // Seq of the last delta merged into streamingBlocks; 0 while the response is incomplete
+  const streamSeqRef = useRef(0);
 
   // Load messages and set up streaming
   useEffect(() => {
+    setStreamingBlocks([]);
+    streamSeqRef.current = 0;
     if (conversationId) {
       setAgentWorking(false);
       loadMessages();
@@ -500,7 +615,7 @@ function ChatInterface({
     if (!userScrolledRef.current) {
       scrollToBottom();
     }
-  }, [messages]);
+  }, [messages, streamingBlocks]);
 
   // Close overflow menu when clicking outside
   useEffect(() => {
@@ -526,6 +641,10 @@ function ChatInterface({
       const response = await api.getConversation(conversationId);
       setMessages(response.messages ?? []);
       setAgentWorking(Boolean(response.agent_working));
+      // Continue with the model the conversation last used, without changing the sticky default
+      if (response.conversation?.model) {
+        setSelectedModelState(response.conversation.model);
+      }
       // Always update context window size when loading a conversation.
       // If omitted from response (due to omitempty when 0), default to 0.
       setContextWindowSize(response.context_window_size ?? 0);
@@ -554,12 +673,43 @@ function ChatInterface({
     eventSource.onmessage = (event) => {
       try {
         const streamResponse: StreamResponse = JSON.parse(event.data);
+
+        // Partial agent output carries no messages or conversation data;
+        // accumulate it until the complete message arrives.
+        // Deltas are numbered from 1 within a response and may be dropped when the client
+        // falls behind. After a gap, or when joining part way, show nothing rather than
+        // garbled text until the complete message arrives.
+        const delta = streamResponse.delta;
+        if (delta) {
+          if (delta.seq === 1) {
+            streamSeqRef.current = 1;
+            setStreamingBlocks(appendStreamDelta([], delta));
+          } else if (streamSeqRef.current > 0 && delta.seq === streamSeqRef.current + 1) {
+            streamSeqRef.current = delta.seq;
+            setStreamingBlocks((prev) => appendStreamDelta(prev, delta));
+          } else {
+            streamSeqRef.current = 0;
+            setStreamingBlocks([]);
+          }
+          return;
+        }
+
+        // A rewind or edit hid the messages from hidden_from on
+        const hiddenFrom = streamResponse.hidden_from;
+        if (typeof hiddenFrom === "number") {
+          setMessages((prev) => prev.filter((m) => m.sequence_id < hiddenFrom));
+          setStreamingBlocks([]);
+        }
+
         const incomingMessages = Array.isArray(streamResponse.messages)
           ? streamResponse.messages
           : [];
 
         // Merge new messages without losing existing ones.
         // If no new messages (e.g., only conversation/slug update), keep existing list.
+        if (incomingMessages.some((m) => m.type === "agent" || m.type === "error")) {
+          setStreamingBlocks([]);
+        }
         if (incomingMessages.length > 0) {
           setMessages((prev) => {
             const byId = new Map<string, Message>();
@@ -582,6 +732,9 @@ function ChatInterface({
 
         if (typeof streamResponse.agent_working === "boolean") {
           setAgentWorking(streamResponse.agent_working);
+          if (!streamResponse.agent_working) {
+            setStreamingBlocks([]);
+          }
         }
 
         if (typeof streamResponse.context_window_size === "number") {
@@ -633,8 +786,8 @@ function ChatInterface({
     };
   };
 
-  const sendMessage = async (message: string) => {
-    if (!message.trim() || sending) return;
+  const sendMessage = async (message: string, attachments: Attachment[] = []) => {
+    if ((!message.trim() && attachments.length === 0) || sending) return;
 
     try {
       setSending(true);
@@ -650,11 +803,19 @@ function ChatInterface({
             throw new Error(`Invalid working directory: ${validation.error}`);
           }
         }
-        await onFirstMessage(message.trim(), selectedModel, selectedCwd || undefined);
+        await onFirstMessage(
+          message.trim(),
+          selectedModel,
+          selectedCwd || undefined,
+          thinking,
+          attachments,
+        );
       } else if (conversationId) {
         await api.sendMessage(conversationId, {
           message: message.trim(),
           model: selectedModel,
+          thinking,
+          attachments,
         });
       }
     } catch (err) {
@@ -699,6 +860,35 @@ function ChatInterface({
     }
   };
 
+  const handleEditSubmit = async () => {
+    if (!conversationId || !editingMessage || !editingMessage.text.trim()) return;
+
+    try {
+      await api.editMessage(conversationId, editingMessage.sequenceId, {
+        message: editingMessage.text.trim(),
+        model: selectedModel,
+        thinking,
+      });
+      setEditingMessage(null);
+      await loadMessages();
+    } catch (err) {
+      console.error("Failed to edit message:", err);
+      setError("Failed to edit message. Please try again.");
+    }
+  };
+
+  const handleFork = async (sequenceId: number) => {
+    if (!conversationId) return;
+
+    try {
+      const forked = await api.forkConversation(conversationId, sequenceId);
+      onConversationForked?.(forked);
+    } catch (err) {
+      console.error("Failed to fork conversation:", err);
+      setError("Failed to fork conversation. Please try again.");
+    }
+  };
+
   const getDisplayTitle = () => {
     return currentConversation?.slug || "Shelley";
   };
@@ -798,7 +988,11 @@ function ChatInterface({
         return;
       }
 
-      if (message.type === "error") {
+      if (
+        message.type === "error" ||
+        message.type === "compaction" ||
+        message.type === "model_change"
+      ) {
         coalescedItems.push({ type: "message", message });
         return;
       }
@@ -937,6 +1131,8 @@ function ChatInterface({
               setShowDiffViewer(true);
             }}
             onCommentTextChange={setDiffCommentText}
+            onFork={handleFork}
+            onEdit={(sequenceId, text) => setEditingMessage({ sequenceId, text })}
           />
         );
       } else if (item.type === "tool") {
@@ -961,6 +1157,57 @@ function ChatInterface({
     return rendered;
   };
 
+  // Model for the next message; changing it mid-conversation switches models at that message
+  const modelSelector = (
+    <div className="status-field status-field-model" title="AI model to use for the next message">
+      <span className="status-field-label">Model:</span>
+      {editingModel ? (
+        <select
+          id="model-select-status"
+          value={selectedModel}
+          onChange={(e) => setSelectedModel(e.target.value)}
+          onBlur={() => setEditingModel(false)}
+          disabled={sending}
+          className="status-select"
+          autoFocus
+        >
+          {models.map((model) => (
+            <option key={model.id} value={model.id} disabled={!model.ready}>
+              {model.id} {!model.ready ? "(not ready)" : ""}
+            </option>
+          ))}
+        </select>
+      ) : (
+        <button className="status-chip" onClick={() => setEditingModel(true)} disabled={sending}>
+          {selectedModel}
+        </button>
+      )}
+    </div>
+  );
+
+  // How much the model reasons during the turn the next message starts
+  const thinkingSelector = (
+    <div
+      className="status-field status-field-thinking"
+      title="How much the model reasons before answering the next message"
+    >
+      <span className="status-field-label">Thinking:</span>
+      <select
+        id="thinking-select-status"
+        value={thinkingEffort}
+        onChange={(e) => setThinkingEffort(e.target.value)}
+        disabled={sending}
+        className="status-select"
+        data-testid="thinking-select"
+      >
+        <option value="">off</option>
+        <option value="low">low</option>
+        <option value="medium">medium</option>
+        <option value="high">high</option>
+      </select>
+    </div>
+  );
+
   return (
     <div className="full-height flex flex-col">
       {/* Header */}
@@ -1176,6 +1423,7 @@ function ChatInterface({
           ) : (
             <div className="messages-list">
               {renderMessages()}
+              {streamingBlocks.length > 0 && <StreamingMessage blocks={streamingBlocks} />}
 
               <div ref={messagesEndRef} />
             </div>
@@ -1254,6 +1502,7 @@ function ChatInterface({
               </div>
               <ContextUsageBar
                 contextWindowSize={contextWindowSize}
+                conversationId={conversationId}
                 maxContextTokens={
                   models.find((m) => m.id === selectedModel)?.max_context_tokens || 200000
                 }
@@ -1264,37 +1513,8 @@ function ChatInterface({
             // Empty conversation - show model (left) and cwd (right)
             <div className="status-bar-new-conversation">
               {/* Model selector - far left */}
-              <div
-                className="status-field status-field-model"
-                title="AI model to use for this conversation"
-              >
-                <span className="status-field-label">Model:</span>
-                {editingModel ? (
-                  <select
-                    id="model-select-status"
-                    value={selectedModel}
-                    onChange={(e) => setSelectedModel(e.target.value)}
-                    onBlur={() => setEditingModel(false)}
-                    disabled={sending}
-                    className="status-select"
-                    autoFocus
-                  >
-                    {models.map((model) => (
-                      <option key={model.id} value={model.id} disabled={!model.ready}>
-                        {model.id} {!model.ready ? "(not ready)" : ""}
-                      </option>
-                    ))}
-                  </select>
-                ) : (
-                  <button
-                    className="status-chip"
-                    onClick={() => setEditingModel(true)}
-                    disabled={sending}
-                  >
-                    {selectedModel}
-                  </button>
-                )}
-              </div>
+              {modelSelector}
+              {thinkingSelector}
 
               {/* CWD indicator - far right */}
               <div
@@ -1312,11 +1532,14 @@ function ChatInterface({
               </div>
             </div>
           ) : (
-            // Active conversation - show Ready + context bar
+            // Active conversation - show Ready, the model for the next message, and context bar
             <div className="status-bar-active">
               <span className="status-message status-ready">Ready on {hostname}</span>
+              {modelSelector}
+              {thinkingSelector}
               <ContextUsageBar
                 contextWindowSize={contextWindowSize}
+                conversationId={conversationId}
                 maxContextTokens={
                   models.find((m) => m.id === selectedModel)?.max_context_tokens || 200000
                 }
@@ -1349,6 +1572,34 @@ function ChatInterface({
         initialPath={selectedCwd}
       />
 
+      {/* Edit Message Modal */}
+      <Modal
+        isOpen={editingMessage !== null}
+        onClose={() => setEditingMessage(null)}
+        title="Edit message"
+      >
+        <p className="text-secondary" style={{ marginBottom: "0.75rem" }}>
+          Later messages will be removed and the agent will respond to the edited message.
+        </p>
+        <textarea
+          className="message-textarea"
+          rows={6}
+          value={editingMessage?.text ?? ""}
+          onChange={(e) =>
+            setEditingMessage((prev) => (prev ? { ...prev, text: e.target.value } : prev))
+          }
+        />
+        <div style={{ display: "flex", justifyContent: "flex-end", marginTop: "0.75rem" }}>
+          <button
+            className="btn-primary"
+            onClick={handleEditSubmit}
+            disabled={!editingMessage?.text.trim()}
+          >
+            Resend
+          </button>
+        </div>
+      </Modal>
+
       {/* Diff Viewer */}
       <DiffViewer
         cwd={currentConversation?.cwd || selectedCwd}
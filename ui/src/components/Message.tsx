@@ -27,14 +27,48 @@ interface MessageProps {
   message: MessageType;
   onOpenDiffViewer?: (commit: string) => void;
   onCommentTextChange?: (text: string) => void;
+  onFork?: (sequenceId: number) => void;
+  onEdit?: (sequenceId: number, text: string) => void;
 }
 
-function Message({ message, onOpenDiffViewer, onCommentTextChange }: MessageProps) {
+function Message({ message, onOpenDiffViewer, onCommentTextChange, onFork, onEdit }: MessageProps) {
   // Hide system messages from the UI
   if (message.type === "system") {
     return null;
   }
 
+  // Render model switches as compact status updates
+  if (message.type === "model_change") {
+    let from = "";
+    let to = "";
+    if (message.user_data) {
+      try {
+        const userData =
+          typeof message.user_data === "string" ? JSON.parse(message.user_data) : message.user_data;
+        from = userData.from || "";
+        to = userData.to || "";
+      } catch (err) {
+        console.error("Failed to parse model_change user_data:", err);
+      }
+    }
+
+    return (
+      <div
+        className="message message-model-change"
+        data-testid="message-model-change"
+        style={{
+          padding: "0.4rem 1rem",
+          fontSize: "0.8rem",
+          color: "var(--text-secondary)",
+          textAlign: "center",
+          fontStyle: "italic",
+        }}
+      >
+        <span>Switched model from {from} to {to}</span>
+      </div>
+    );
+  }
+
   // Render gitinfo messages as compact status updates
   if (message.type === "gitinfo") {
     // Parse user_data which contains structured git state info
@@ -110,6 +144,51 @@ function Message({ message, onOpenDiffViewer, onCommentTextChange }: MessageProp
     );
   }
 
+  // Render compaction messages as a marker where older history was summarized
+  if (message.type === "compaction") {
+    let summary = "";
+    let summarized = 0;
+    try {
+      const llmData =
+        typeof message.llm_data === "string" ? JSON.parse(message.llm_data) : message.llm_data;
+      summary = (llmData?.Content || [])
+        .map((c: LLMContent) => c.Text || "")
+        .join("")
+        .replace(/^\[Summary of earlier conversation\]\s*/, "");
+      const userData =
+        typeof message.user_data === "string" ? JSON.parse(message.user_data) : message.user_data;
+      summarized = userData?.summarized_messages || 0;
+    } catch (err) {
+      console.error("Failed to parse compaction message:", err);
+    }
+
+    return (
+      <div
+        className="message message-compaction"
+        data-testid="message-compaction"
+        style={{
+          padding: "0.4rem 1rem",
+          fontSize: "0.8rem",
+          color: "var(--text-secondary)",
+          textAlign: "center",
+        }}
+      >
+        <details>
+          <summary style={{ cursor: "pointer", fontStyle: "italic" }}>
+            Context compacted: {summarized} earlier {summarized === 1 ? "message" : "messages"}{" "}
+            summarized
+          </summary>
+          <div
+            className="whitespace-pre-wrap break-words"
+            style={{ textAlign: "left", marginTop: "0.5rem" }}
+          >
+            {summary}
+          </div>
+        </details>
+      </div>
+    );
+  }
+
   // Context menu state
   const [contextMenu, setContextMenu] = useState<{ x: number; y: number } | null>(null);
   const [showUsageModal, setShowUsageModal] = useState(false);
@@ -241,6 +320,60 @@ function Message({ message, onOpenDiffViewer, onCommentTextChange }: MessageProp
     </svg>
   );
 
+  // Edit icon SVG
+  const EditIcon = () => (
+    <svg
+      width="20"
+      height="20"
+      viewBox="0 0 24 24"
+      fill="none"
+      stroke="currentColor"
+      strokeWidth="2"
+      strokeLinecap="round"
+      strokeLinejoin="round"
+    >
+      <path d="M12 20h9"></path>
+      <path d="M16.5 3.5a2.121 2.121 0 0 1 3 3L7 19l-4 1 1-4L16.5 3.5z"></path>
+    </svg>
+  );
+
+  // Fork icon SVG
+  const ForkIcon = () => (
+    <svg
+      width="20"
+      height="20"
+      viewBox="0 0 24 24"
+      fill="none"
+      stroke="currentColor"
+      strokeWidth="2"
+      strokeLinecap="round"
+      strokeLinejoin="round"
+    >
+      <circle cx="6" cy="3" r="2"></circle>
+      <circle cx="6" cy="21" r="2"></circle>
+      <circle cx="18" cy="6" r="2"></circle>
+      <line x1="6" y1="5" x2="6" y2="19"></line>
+      <path d="M18 8a9 9 0 0 1-9 9H6"></path>
+    </svg>
+  );
+
+  // Request icon SVG
+  const RequestIcon = () => (
+    <svg
+      width="20"
+      height="20"
+      viewBox="0 0 24 24"
+      fill="none"
+      stroke="currentColor"
+      strokeWidth="2"
+      strokeLinecap="round"
+      strokeLinejoin="round"
+    >
+      <polyline points="16 18 22 12 16 6"></polyline>
+      <polyline points="8 6 2 12 8 18"></polyline>
+    </svg>
+  );
+
   // Handle copy action
   const handleCopy = () => {
     const text = getMessageText();
@@ -300,6 +433,34 @@ function Message({ message, onOpenDiffViewer, onCommentTextChange }: MessageProp
     });
   }
 
+  // Show the raw LLM request that produced an agent or error message
+  if (message.type === "agent" || message.type === "error") {
+    contextMenuItems.push({
+      label: "LLM Request",
+      icon: <RequestIcon />,
+      onClick: () =>
+        window.open(`/debug/llm?message=${encodeURIComponent(message.message_id)}`, "_blank"),
+    });
+  }
+
+  // Replace a user message, discarding everything after it
+  if (onEdit && isUser && messageText) {
+    contextMenuItems.push({
+      label: "Edit and resend",
+      icon: <EditIcon />,
+      onClick: () => onEdit(message.sequence_id, messageText),
+    });
+  }
+
+  // Fork a new conversation that ends with this message
+  if (onFork && (message.type === "user" || message.type === "agent")) {
+    contextMenuItems.push({
+      label: "Fork from here",
+      icon: <ForkIcon />,
+      onClick: () => onFork(message.sequence_id),
+    });
+  }
+
   // Build a map of tool use IDs to their inputs for linking tool_result back to tool_use
   const toolUseMap: Record<string, { name: string; input: unknown }> = {};
   if (llmMessage && llmMessage.Content) {
@@ -338,6 +499,24 @@ function Message({ message, onOpenDiffViewer, onCommentTextChange }: MessageProp
           </div>
         );
       case "text":
+        if (content.MediaType?.startsWith("image/") && content.Data) {
+          return (
+            <img
+              src={`data:${content.MediaType};base64,${content.Data}`}
+              alt={content.Filename || "Attached image"}
+              className="message-attachment-image rounded border"
+              data-testid="message-attachment"
+            />
+          );
+        }
+        if (content.Filename) {
+          // A PDF or text file: its contents are for the model, the user sees its name
+          return (
+            <span className="attachment-chip" data-testid="message-attachment">
+              <span className="attachment-chip-name">{content.Filename}</span>
+            </span>
+          );
+        }
         return (
           <div className="whitespace-pre-wrap break-words">{linkifyText(content.Text || "")}</div>
         );
@@ -624,9 +803,17 @@ function Message({ message, onOpenDiffViewer, onCommentTextChange }: MessageProp
       }
       case "redacted_thinking":
         return <div className="text-tertiary italic text-sm">[Thinking content hidden]</div>;
-      case "thinking":
-        // Hide thinking content by default in main flow, but could be made expandable
-        return null;
+      case "thinking": {
+        // Anthropic puts reasoning in Thinking; OpenAI reasoning summaries are in Text
+        const thoughts = content.Thinking || content.Text;
+        if (!thoughts) return null;
+        return (
+          <details className="message-thinking" data-testid="message-thinking">
+            <summary>Thinking</summary>
+            <div className="text-tertiary text-sm whitespace-pre-wrap">{thoughts}</div>
+          </details>
+        );
+      }
       default: {
         // For unknown content types, show the type and try to display useful content
         const displayText = content.Text || content.Data || "";
@@ -866,13 +1053,16 @@ function Message({ message, onOpenDiffViewer, onCommentTextChange }: MessageProp
     return null;
   }
 
-  // Filter out thinking content, empty content, tool_use, and tool_result
+  // Filter out empty content, redacted thinking, tool_use, and tool_result
   const meaningfulContent =
     llmMessage?.Content?.filter((c) => {
       const contentType = c.Type;
-      // Filter out thinking (3), redacted thinking (4), tool_use (5), tool_result (6), and empty text content
+      // Keep thinking (3) that has text; filter out redacted thinking (4), tool_use (5),
+      // tool_result (6), and empty text content
+      if (contentType === 3) {
+        return !!(c.Thinking?.trim() || c.Text?.trim());
+      }
       return (
-        contentType !== 3 &&
         contentType !== 4 &&
         contentType !== 5 &&
         contentType !== 6 &&
@@ -913,6 +1103,12 @@ function Message({ message, onOpenDiffViewer, onCommentTextChange }: MessageProp
           {contentToRender.map((content, index) => (
             <div key={index}>{renderContent(content)}</div>
           ))}
+          {isUser && llmMessage?.Thinking && (
+            <div className="message-thinking-level" data-testid="message-thinking-level">
+              Thinking:{" "}
+              {llmMessage.Thinking.effort || `${llmMessage.Thinking.budget_tokens} tokens`}
+            </div>
+          )}
         </div>
       </div>
       {contextMenu && contextMenuItems.length > 0 && (
@@ -1,4 +1,5 @@
 import React, { useState, useRef, useEffect, useCallback } from "react";
+import { Attachment } from "../types";
 
 // Web Speech API types
 interface SpeechRecognitionEvent extends Event {
@@ -44,7 +45,7 @@ declare global {
 }
 
 interface MessageInputProps {
-  onSend: (message: string) => Promise<void>;
+  onSend: (message: string, attachments: Attachment[]) => Promise<void>;
   disabled?: boolean;
   autoFocus?: boolean;
   onFocus?: () => void;
@@ -74,6 +75,8 @@ function MessageInput({
   });
   const [submitting, setSubmitting] = useState(false);
   const [uploadsInProgress, setUploadsInProgress] = useState(0);
+  const [attachments, setAttachments] = useState<Attachment[]>([]);
+  const [uploadError, setUploadError] = useState<string | null>(null);
   const [dragCounter, setDragCounter] = useState(0);
   const [isListening, setIsListening] = useState(false);
   const textareaRef = useRef<HTMLTextAreaElement>(null);
@@ -170,13 +173,8 @@ function MessageInput({
     };
   }, []);
 
-  const uploadFile = async (file: File, insertPosition: number) => {
-    const textBefore = message.substring(0, insertPosition);
-    const textAfter = message.substring(insertPosition);
-
-    // Add a loading indicator
-    const loadingText = `[uploading ${file.name}...]`;
-    setMessage(`${textBefore}${loadingText}${textAfter}`);
+  const uploadFile = async (file: File) => {
+    setUploadError(null);
     setUploadsInProgress((prev) => prev + 1);
 
     try {
@@ -195,13 +193,12 @@ function MessageInput({
 
       const data = await response.json();
 
-      // Replace the loading placeholder with the actual file path
-      setMessage((currentMessage) => currentMessage.replace(loadingText, `[${data.path}]`));
+      // The file is sent with the next message
+      setAttachments((prev) => [...prev, { path: data.path, name: file.name }]);
     } catch (error) {
       console.error("Failed to upload file:", error);
-      // Replace loading indicator with error message
-      const errorText = `[upload failed: ${error instanceof Error ? error.message : "unknown error"}]`;
-      setMessage((currentMessage) => currentMessage.replace(loadingText, errorText));
+      const reason = error instanceof Error ? error.message : "unknown error";
+      setUploadError(`Failed to upload ${file.name}: ${reason}`);
     } finally {
       setUploadsInProgress((prev) => prev - 1);
     }
@@ -218,8 +215,7 @@ function MessageInput({
           const file = item.getAsFile();
           if (file) {
             event.preventDefault();
-            const cursorPos = textareaRef.current?.selectionStart ?? message.length;
-            await uploadFile(file, cursorPos);
+            await uploadFile(file);
             return;
           }
         }
@@ -252,14 +248,7 @@ function MessageInput({
     if (event.dataTransfer && event.dataTransfer.files.length > 0) {
       // Process all dropped files
       for (let i = 0; i < event.dataTransfer.files.length; i++) {
-        const file = event.dataTransfer.files[i];
-        const insertPosition =
-          i === 0 ? (textareaRef.current?.selectionStart ?? message.length) : message.length;
-        await uploadFile(file, insertPosition);
-        // Add a space between files
-        if (i < event.dataTransfer.files.length - 1) {
-          setMessage((prev) => prev + " ");
-        }
+        await uploadFile(event.dataTransfer.files[i]);
       }
     }
   };
@@ -279,7 +268,8 @@ function MessageInput({
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
-    if (message.trim() && !disabled && !submitting && uploadsInProgress === 0) {
+    const hasContent = message.trim() || attachments.length > 0;
+    if (hasContent && !disabled && !submitting && uploadsInProgress === 0) {
       // Stop listening if we were recording
       if (isListening) {
         stopListening();
@@ -288,9 +278,10 @@ function MessageInput({
       const messageToSend = message;
       setSubmitting(true);
       try {
-        await onSend(messageToSend);
+        await onSend(messageToSend, attachments);
         // Only clear on success
         setMessage("");
+        setAttachments([]);
         // Clear persisted draft on successful send
         if (persistKey) {
           localStorage.removeItem(PERSIST_KEY_PREFIX + persistKey);
@@ -348,7 +339,7 @@ function MessageInput({
   }, [autoFocus]);
 
   const isDisabled = disabled || uploadsInProgress > 0;
-  const canSubmit = message.trim() && !isDisabled && !submitting;
+  const canSubmit = (message.trim() || attachments.length > 0) && !isDisabled && !submitting;
 
   const isDraggingOver = dragCounter > 0;
   // Note: injectedText is auto-inserted via useEffect, no manual UI needed
@@ -366,6 +357,25 @@ function MessageInput({
           <div className="drag-overlay-content">Drop files here</div>
         </div>
       )}
+      {(attachments.length > 0 || uploadsInProgress > 0 || uploadError) && (
+        <div className="message-attachments" data-testid="message-attachments">
+          {attachments.map((attachment, i) => (
+            <span key={attachment.path} className="attachment-chip" data-testid="attachment-chip">
+              <span className="attachment-chip-name">{attachment.name || attachment.path}</span>
+              <button
+                type="button"
+                className="attachment-chip-remove"
+                onClick={() => setAttachments((prev) => prev.filter((_, j) => j !== i))}
+                aria-label={`Remove ${attachment.name || attachment.path}`}
+              >
+                ×
+              </button>
+            </span>
+          ))}
+          {uploadsInProgress > 0 && <span className="attachment-chip uploading">Uploading...</span>}
+          {uploadError && <span className="attachment-error">{uploadError}</span>}
+        </div>
+      )}
       <form onSubmit={handleSubmit} className="message-input-form">
         <textarea
           ref={textareaRef}
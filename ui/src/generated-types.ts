@@ -11,6 +11,11 @@ export interface Conversation {
   updated_at: string;
   cwd: string | null;
   archived: boolean;
+  budget: string | null;
+  parent_conversation_id: string | null;
+  forked_at_sequence_id: number | null;
+  model: string | null;
+  turn_owner_pid: number | null;
 }
 
 export interface Usage {
@@ -37,10 +42,20 @@ export interface ApiMessageForTS {
   end_of_turn?: boolean | null;
 }
 
+export interface StreamDeltaForTS {
+  seq: number;
+  index: number;
+  type: string;
+  text?: string;
+  tool_name?: string;
+}
+
 export interface StreamResponseForTS {
   messages: ApiMessageForTS[] | null;
   conversation: Conversation;
   agent_working: boolean;
+  delta?: StreamDeltaForTS | null;
+  hidden_from?: number | null;
 }
 
-export type MessageType = "user" | "agent" | "tool" | "error" | "system" | "gitinfo";
+export type MessageType = "user" | "agent" | "tool" | "error" | "system" | "gitinfo" | "compaction" | "model_change";
@@ -5,6 +5,7 @@ import {
   GitDiffInfo,
   GitFileInfo,
   GitFileDiff,
+  CacheReport,
 } from "../types";
 
 class ApiService {
@@ -55,10 +56,36 @@ class ApiService {
     }
   }
 
+  async getCacheReport(conversationId: string): Promise<CacheReport> {
+    const response = await fetch(`${this.baseUrl}/conversation/${conversationId}/cache`);
+    if (!response.ok) {
+      throw new Error(`Failed to get cache report: ${response.statusText}`);
+    }
+    return response.json();
+  }
+
   createMessageStream(conversationId: string): EventSource {
     return new EventSource(`${this.baseUrl}/conversation/${conversationId}/stream`);
   }
 
+  async editMessage(
+    conversationId: string,
+    sequenceId: number,
+    request: ChatRequest,
+  ): Promise<void> {
+    const response = await fetch(
+      `${this.baseUrl}/conversation/${conversationId}/edit?at=${sequenceId}`,
+      {
+        method: "POST",
+        headers: this.postHeaders,
+        body: JSON.stringify(request),
+      },
+    );
+    if (!response.ok) {
+      throw new Error(`Failed to edit message: ${response.statusText}`);
+    }
+  }
+
   async cancelConversation(conversationId: string): Promise<void> {
     const response = await fetch(`${this.baseUrl}/conversation/${conversationId}/cancel`, {
       method: "POST",
@@ -187,6 +214,25 @@ class ApiService {
     }
     return response.json();
   }
+
+  async forkConversation(
+    conversationId: string,
+    sequenceId: number,
+    cwd?: string,
+  ): Promise<Conversation> {
+    const response = await fetch(
+      `${this.baseUrl}/conversation/${conversationId}/fork?at=${sequenceId}`,
+      {
+        method: "POST",
+        headers: this.postHeaders,
+        body: JSON.stringify({ cwd }),
+      },
+    );
+    if (!response.ok) {
+      throw new Error(`Failed to fork conversation: ${response.statusText}`);
+    }
+    return response.json();
+  }
 }
 
 export const api = new ApiService();
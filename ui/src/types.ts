@@ -3,6 +3,7 @@ import {
   Conversation as GeneratedConversation,
   ApiMessageForTS,
   StreamResponseForTS,
+  StreamDeltaForTS,
   Usage as GeneratedUsage,
   MessageType as GeneratedMessageType,
 } from "./generated-types";
@@ -11,6 +12,7 @@ import {
 export type Conversation = GeneratedConversation;
 export type Usage = GeneratedUsage;
 export type MessageType = GeneratedMessageType;
+export type StreamDelta = StreamDeltaForTS;
 
 // Extend the generated Message type with parsed data
 export interface Message extends Omit<ApiMessageForTS, "type"> {
@@ -22,6 +24,13 @@ export interface LLMMessage {
   Role: number; // 0 = user, 1 = assistant
   Content: LLMContent[];
   ToolUse?: unknown;
+  Thinking?: Thinking;
+}
+
+// How much the model reasons during a turn; set effort or budget_tokens
+export interface Thinking {
+  effort?: "low" | "medium" | "high";
+  budget_tokens?: number;
 }
 
 export interface LLMContent {
@@ -36,6 +45,7 @@ export interface LLMContent {
   MediaType?: string;
   Thinking?: string;
   Data?: string;
+  Filename?: string;
   Signature?: string;
   ToolUseID?: string;
   ToolUseStartTime?: string | null;
@@ -51,10 +61,18 @@ export interface Model {
   max_context_tokens?: number;
 }
 
+// Attachment is a file uploaded through /api/upload to send along with a message
+export interface Attachment {
+  path: string;
+  name?: string;
+}
+
 export interface ChatRequest {
   message: string;
   model?: string;
   cwd?: string;
+  thinking?: Thinking;
+  attachments?: Attachment[];
 }
 // StreamResponse represents the streaming response format
 export interface StreamResponse extends Omit<StreamResponseForTS, "messages"> {
@@ -87,6 +105,34 @@ declare global {
   }
 }
 
+// Prompt caching of a conversation, from GET /api/conversation/<id>/cache
+export interface CacheUsage {
+  response_count: number;
+  input_tokens: number;
+  cache_creation_input_tokens: number;
+  cache_read_input_tokens: number;
+  output_tokens: number;
+  cost_usd: number;
+  hit_rate: number;
+}
+
+export interface CacheResponse {
+  message_id: string;
+  sequence_id: number;
+  type: string;
+  model?: string;
+  input_tokens: number;
+  cache_creation_input_tokens: number;
+  cache_read_input_tokens: number;
+  hit_rate: number;
+}
+
+export interface CacheReport extends CacheUsage {
+  conversation_id: string;
+  models: (CacheUsage & { model: string })[];
+  responses: CacheResponse[];
+}
+
 // Git diff types
 export interface GitDiffInfo {
   id: string;